@@ -0,0 +1,124 @@
+package gosns
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// AdminHandler returns a handler for the server's administrative API. It
+// exposes message contents and must only be served somewhere private, such
+// as a separate listener bound to localhost. It answers
+//
+//	GET /debug/requests[?endpoint=/orders]
+//
+// with a JSON object mapping each endpoint of a topic with DebugRequests set
+// to its RecentRequests, and
+//
+//	GET /heartbeats
+//
+// with one mapping each endpoint of a topic with a HeartbeatInterval to its
+// Heartbeat, answered with a 503 if any has lapsed so that it can serve as
+// a readiness check, and
+//
+//	GET /maintenance
+//	POST /maintenance?enabled=true
+//
+// with {"Maintenance": true} or false, after calling SetMaintenance for a
+// POST, and
+//
+//	POST /pause?endpoint=/orders
+//	POST /resume?endpoint=/orders
+//
+// calling Server.Pause or Resume and answering with {"Paused": true} or
+// false, and
+//
+//	GET /quarantine[?endpoint=/orders]
+//	POST /quarantine/requeue?endpoint=/orders&id=<MessageId>[&id=...]
+//	POST /quarantine/requeue?since=2026-01-02T15:04:05Z[&endpoint=/orders]
+//
+// listing the Quarantined messages, or calling Requeue for the given
+// messages or RequeueSince, and answering with the RequeueResult, with a
+// 409 if any failed again, and
+//
+//	GET /http
+//
+// with the HTTPStats of a server with HTTPMetrics set, the bucket bounds of
+// their durations, and the fraction of requests answered with a 5xx, and
+//
+//	GET /slo
+//
+// with an object mapping each endpoint of a topic with an SLOTarget to its
+// SLO status, and
+//
+//	GET /canary
+//	POST /canary?endpoint=/orders&percent=25
+//
+// with one mapping each endpoint of a topic with a Canary to its
+// CanaryStatus, after calling SetCanaryPercent for a POST, and
+//
+//	GET /migrations
+//
+// with one mapping each endpoint of a topic with a Migration to its
+// MigrationStatus, and
+//
+//	GET /stats
+//	POST /stats/search
+//	POST /stats/query
+//
+// with one mapping each endpoint to its Stats samples when the server has
+// a StatsInterval, and beneath it the endpoints of a Grafana JSON
+// datasource with that URL, whose targets are an endpoint and one of
+// throughput or errors (per second), or latency_avg or latency_max (in
+// milliseconds), such as "/orders:latency_avg".
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/requests", s.adminDebugRequests)
+	mux.HandleFunc("/heartbeats", s.adminHeartbeats)
+	mux.HandleFunc("/maintenance", s.adminMaintenance)
+	mux.HandleFunc("/pause", s.adminPause)
+	mux.HandleFunc("/resume", s.adminPause)
+	mux.HandleFunc("/quarantine", s.adminQuarantine)
+	mux.HandleFunc("/quarantine/requeue", s.adminQuarantine)
+	mux.HandleFunc("/http", s.adminHTTP)
+	mux.HandleFunc("/slo", s.adminSLO)
+	mux.HandleFunc("/canary", s.adminCanary)
+	mux.HandleFunc("/migrations", s.adminMigrations)
+	mux.HandleFunc("/stats", s.adminStats)
+	mux.HandleFunc("/stats/", s.adminStats)
+	return mux
+}
+
+func (s *Server) adminDebugRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	want := r.URL.Query().Get("endpoint")
+	s.topicsMu.RLock()
+	var topics []*Topic
+	for endpoint, td := range s.topics {
+		if td.DebugRequests > 0 && (want == "" || want == endpoint) {
+			topics = append(topics, td)
+		}
+	}
+	s.topicsMu.RUnlock()
+	if want != "" && len(topics) == 0 {
+		simpleResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	res := make(map[string][]DebugRequest, len(topics))
+	for _, td := range topics {
+		res[td.endpoint] = td.RecentRequests()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
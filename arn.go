@@ -0,0 +1,139 @@
+package gosns
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	arnAccountID = regexp.MustCompile(`^[0-9]{12}$`)
+	arnTopicName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}(\.fifo)?$`)
+)
+
+// ARN is an Amazon Resource Name, such as the ARN of an SNS topic,
+// "arn:aws:sns:us-east-1:123456789012:orders".
+type ARN struct {
+	Partition string // "aws", or e.g. "aws-cn" for the China regions
+	Service   string
+	Region    string
+	Account   string // the ID of the AWS account owning the resource
+	Resource  string // the topic name for SNS topics; it may contain colons
+}
+
+// ParseARN parses an ARN of the form
+// arn:partition:service:region:account:resource. It does not check that the
+// fields are valid for the service.
+func ParseARN(arn string) (ARN, error) {
+	fields, ok := splitARN(arn)
+	if !ok {
+		return ARN{}, fmt.Errorf("%w: '%s' is not of the form arn:partition:service:region:account:resource", ErrInvalidARN, arn)
+	}
+	return ARN{Partition: fields[1], Service: fields[2], Region: fields[3], Account: fields[4], Resource: fields[5]}, nil
+}
+
+// String returns the ARN in its usual colon-separated form.
+func (a ARN) String() string {
+	return strings.Join([]string{"arn", a.Partition, a.Service, a.Region, a.Account, a.Resource}, ":")
+}
+
+// TopicName returns the name of the SNS topic the ARN refers to.
+func (a ARN) TopicName() string {
+	return a.Resource
+}
+
+// FIFO reports whether the ARN is that of a FIFO topic, whose names end in
+// ".fifo".
+func (a ARN) FIFO() bool {
+	return a.Service == "sns" && strings.HasSuffix(a.Resource, ".fifo")
+}
+
+// splitARN splits an ARN of the form arn:partition:service:region:account:resource
+// into its six fields. The resource may itself contain colons.
+func splitARN(arn string) ([]string, bool) {
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) != 6 || fields[0] != "arn" {
+		return nil, false
+	}
+	return fields, true
+}
+
+// checkTopicARN returns an error unless arn is an SNS topic ARN, or a
+// pattern which matchARN accepts for one.
+func checkTopicARN(arn string) error {
+	a, err := ParseARN(arn)
+	if err != nil {
+		return err
+	}
+	for i, f := range []string{a.Partition, a.Service, a.Region, a.Account, a.Resource} {
+		if f == "" {
+			return fmt.Errorf("%w: '%s' has an empty field", ErrInvalidARN, arn)
+		}
+		if _, err := path.Match(f, ""); err != nil {
+			return fmt.Errorf("%w: '%s' has a malformed pattern in field %d", ErrInvalidARN, arn, i+2)
+		}
+	}
+	if m, _ := path.Match(a.Service, "sns"); !m {
+		return fmt.Errorf("%w: '%s' is not an SNS ARN", ErrInvalidARN, arn)
+	}
+	if !strings.ContainsAny(a.Account, "*?[") && !arnAccountID.MatchString(a.Account) {
+		return fmt.Errorf("%w: '%s' does not have a 12 digit account ID", ErrInvalidARN, arn)
+	}
+	if !strings.ContainsAny(a.Resource, "*?[") && !arnTopicName.MatchString(a.Resource) {
+		return fmt.Errorf("%w: '%s' does not have a valid topic name", ErrInvalidARN, arn)
+	}
+	return nil
+}
+
+// arnAccount returns the AWS account ID of arn, or "" if it is not an ARN.
+func arnAccount(arn string) string {
+	a, _ := ParseARN(arn)
+	return a.Account
+}
+
+// matchARN reports whether arn matches pattern. Each field of the pattern is
+// a path.Match pattern, so "arn:aws:sns:*:*:orders-*" matches the orders
+// topics of any region and account.
+func matchARN(pattern, arn string) bool {
+	if pattern == arn {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return false
+	}
+	pf, ok := splitARN(pattern)
+	if !ok {
+		return false
+	}
+	af, ok := splitARN(arn)
+	if !ok {
+		return false
+	}
+	for i := range pf {
+		if m, err := path.Match(pf[i], af[i]); err != nil || !m {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the topic is registered for arn.
+func (t *Topic) matches(arn string) bool {
+	return matchARN(t.TopicARN, arn) || t.Migration != nil && matchARN(t.Migration.OldARN, arn)
+}
+
+// accountAllowed reports whether the account owning arn may publish to the
+// topic.
+func (t *Topic) accountAllowed(arn string) bool {
+	if len(t.AllowedAccounts) == 0 {
+		return true
+	}
+	acct := arnAccount(arn)
+	for _, a := range t.AllowedAccounts {
+		if a == acct {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package gosns_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestParseARN(t *testing.T) {
+	a, err := gosns.ParseARN("arn:aws-cn:sns:cn-north-1:123456789012:orders.fifo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := gosns.ARN{Partition: "aws-cn", Service: "sns", Region: "cn-north-1", Account: "123456789012", Resource: "orders.fifo"}
+	if a != want || a.TopicName() != "orders.fifo" || !a.FIFO() {
+		t.Errorf("got %+v", a)
+	}
+	if s := a.String(); s != "arn:aws-cn:sns:cn-north-1:123456789012:orders.fifo" {
+		t.Errorf("String gave %s", s)
+	}
+
+	a, err = gosns.ParseARN("arn:aws:sqs:us-east-1:123456789012:queue:with:colons")
+	if err != nil || a.Resource != "queue:with:colons" || a.FIFO() {
+		t.Errorf("got %+v, %v", a, err)
+	}
+	for _, s := range []string{"", "orders", "arn:aws:sns:us-east-1:123456789012", "urn:aws:sns:us-east-1:123456789012:orders"} {
+		if _, err := gosns.ParseARN(s); !errors.Is(err, gosns.ErrInvalidARN) {
+			t.Errorf("ParseARN(%q) gave %v", s, err)
+		}
+	}
+}
+
+func TestAllowedAccounts(t *testing.T) {
+	const (
+		listed   = "arn:aws:sns:us-east-1:111111111111:orders"
+		unlisted = "arn:aws:sns:us-east-1:222222222222:orders"
+	)
+	got := make(chan string, 10)
+	s := &gosns.Server{}
+	restricted := s.AddTopic("arn:aws:sns:us-east-1:*:orders", "/restricted", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.TopicArn
+		}
+	})
+	restricted.AllowedAccounts = []string{"111111111111"}
+	s.AddTopic("arn:aws:sns:us-east-1:*:orders", "/open", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.TopicArn
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		endpoint, topicARN string
+		status             int
+	}{
+		{"/restricted", listed, http.StatusOK},
+		{"/restricted", unlisted, http.StatusForbidden},
+		{"/open", listed, http.StatusOK},
+		{"/open", unlisted, http.StatusOK},
+	} {
+		resp, _, err := ts.Notify(c.endpoint, c.topicARN, "", "hello")
+		if err != nil || resp.StatusCode != c.status {
+			t.Errorf("%s from %s: got %v, %v; want %d", c.endpoint, c.topicARN, resp, err, c.status)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if arn := <-got; arn != listed && arn != unlisted {
+			t.Errorf("callback got %s", arn)
+		}
+	}
+	select {
+	case arn := <-got:
+		t.Errorf("refused message from %s was handled", arn)
+	default:
+	}
+
+	conf := gosnstest.NewSubscriptionConfirmation(unlisted, "")
+	conf.SubscribeURL = ts.SubscribeURL(unlisted, conf.Token)
+	if resp, err := ts.Send("/restricted", conf); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("confirmation from an unlisted account gave %v, %v", resp, err)
+	}
+	if _, ok := ts.Confirmed(conf.Token); ok {
+		t.Error("subscription from an unlisted account was confirmed")
+	}
+}
@@ -0,0 +1,98 @@
+package gosns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// batcher accumulates messages for a batch callback.
+type batcher struct {
+	topic    *Topic
+	topicARN string
+	size     int
+	maxWait  time.Duration
+	callback func(context.Context, []*Message) error
+
+	mu      sync.Mutex
+	pending []*Message
+	timer   *time.Timer
+}
+
+// AddBatchTopic is like AddTopic, but accumulates notifications and passes
+// them to callback in batches of up to batchSize messages. A partial batch is
+// delivered once its oldest message has waited maxWait. Errors returned by
+// callback are logged; the messages have already been acknowledged to SNS.
+func (s *Server) AddBatchTopic(topicARN, endpoint string, batchSize int, maxWait time.Duration,
+	callback func(ctx context.Context, msgs []*Message) error) *Topic {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	b := &batcher{
+		topicARN: topicARN,
+		size:     batchSize,
+		maxWait:  maxWait,
+		callback: callback,
+	}
+	t := s.newTopic(topicARN, endpoint, b.add, nil)
+	t.batch = b
+	b.topic = t
+	return s.register(t)
+}
+
+func (b *batcher) add(msg *Message) {
+	if msg == nil {
+		// subscription confirmation ping
+		return
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, msg)
+	var batch []*Message
+	if len(b.pending) >= b.size {
+		batch = b.take()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.maxWait, b.expire)
+	}
+	b.mu.Unlock()
+
+	if batch != nil {
+		b.run(batch)
+	}
+}
+
+// take removes the pending batch. b.mu must be held.
+func (b *batcher) take() []*Message {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *batcher) expire() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		b.run(batch)
+	}
+}
+
+func (b *batcher) run(batch []*Message) {
+	err := traceBatch(batch, func() error {
+		return b.callback(context.Background(), batch)
+	})
+	if err != nil {
+		b.topic.logf(nil, LogError, "Batch of %d messages for topic '%s' failed: %v\n", len(batch), b.topicARN, err)
+	}
+	for _, msg := range batch {
+		if err != nil || b.topic.server == nil || b.topic.server.Outbox == nil {
+			msg.outbox = nil
+		} else {
+			b.topic.server.Outbox.commit(b.topic, msg)
+		}
+	}
+}
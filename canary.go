@@ -0,0 +1,127 @@
+package gosns
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// CanaryStatus is the traffic split of a topic with a Canary callback.
+type CanaryStatus struct {
+	Percent float64
+	Primary uint64 // messages passed to Callback since the server started
+	Canary  uint64 // messages passed to Canary
+}
+
+// canary reports whether msg is in the share of traffic passed to the
+// topic's Canary callback, counting it either way.
+func (t *Topic) canary(msg *Message) bool {
+	t.canaryMu.RLock()
+	percent := t.CanaryPercent
+	t.canaryMu.RUnlock()
+	// salted so that the share does not coincide with SampleRate's, and
+	// mixed because FNV spreads IDs which differ only at the end poorly
+	h := fnv.New64a()
+	h.Write([]byte("canary:"))
+	h.Write([]byte(msg.MessageId))
+	if float64(mix64(h.Sum64()))/math.MaxUint64*100 < percent {
+		atomic.AddUint64(&t.canaries, 1)
+		return true
+	}
+	atomic.AddUint64(&t.primaries, 1)
+	return false
+}
+
+// mix64 is the finalizer of MurmurHash3.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// SetCanaryPercent changes the topic's CanaryPercent while the server is
+// running, clamped to between 0 and 100.
+func (t *Topic) SetCanaryPercent(percent float64) {
+	percent = math.Max(0, math.Min(100, percent))
+	t.canaryMu.Lock()
+	was := t.CanaryPercent
+	t.CanaryPercent = percent
+	t.canaryMu.Unlock()
+	if was != percent {
+		t.server.logf(LogInfo, "Sending %g%% of topic '%s' at endpoint '%s' to its canary\n", percent, t.TopicARN, t.endpoint)
+	}
+}
+
+// CanaryStatus returns the topic's traffic split.
+func (t *Topic) CanaryStatus() CanaryStatus {
+	t.canaryMu.RLock()
+	defer t.canaryMu.RUnlock()
+	return CanaryStatus{
+		Percent: t.CanaryPercent,
+		Primary: atomic.LoadUint64(&t.primaries),
+		Canary:  atomic.LoadUint64(&t.canaries),
+	}
+}
+
+// SetCanaryPercent sets the CanaryPercent of the topic registered at
+// endpoint; see Topic.SetCanaryPercent. It returns ErrTopicNotFound if there
+// is none, and an error if the topic has no Canary.
+func (s *Server) SetCanaryPercent(endpoint string, percent float64) error {
+	td, ok := s.topic(endpoint)
+	if !ok {
+		return ErrTopicNotFound
+	}
+	if td.Canary == nil {
+		return errors.New("gosns: topic has no canary")
+	}
+	td.SetCanaryPercent(percent)
+	return nil
+}
+
+// canaryTopics returns the topics with a Canary by endpoint.
+func (s *Server) canaryTopics() map[string]*Topic {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	topics := make(map[string]*Topic)
+	for endpoint, td := range s.topics {
+		if td.Canary != nil {
+			topics[endpoint] = td
+		}
+	}
+	return topics
+}
+
+func (s *Server) adminCanary(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		percent, err := strconv.ParseFloat(r.FormValue("percent"), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			simpleResponse(w, http.StatusBadRequest, "percent must be a number from 0 to 100")
+			return
+		}
+		switch err := s.SetCanaryPercent(r.FormValue("endpoint"), percent); {
+		case err == ErrTopicNotFound:
+			simpleResponse(w, http.StatusNotFound, "not found")
+			return
+		case err != nil:
+			simpleResponse(w, http.StatusConflict, "topic has no canary")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := make(map[string]CanaryStatus)
+	for endpoint, td := range s.canaryTopics() {
+		res[endpoint] = td.CanaryStatus()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,81 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestCanary(t *testing.T) {
+	var mu sync.Mutex
+	route := make(map[string]string)
+	record := func(name string) func(*gosns.Message) {
+		return func(msg *gosns.Message) {
+			if msg != nil {
+				mu.Lock()
+				route[msg.MessageId] = name
+				mu.Unlock()
+			}
+		}
+	}
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", record("primary"))
+	orders.Delivery = gosns.AtLeastOnce // so that callbacks have run when Send returns
+	orders.Canary = record("canary")
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	send := func(id string) string {
+		e := gosnstest.NewNotification(ordersARN, "", "hello")
+		e.MessageId = id
+		if resp, err := ts.Send("/orders", e); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, %v", resp, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return route[id]
+	}
+
+	if got := send("m-0"); got != "primary" {
+		t.Errorf("with no canary share, went to %s", got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/canary?endpoint=/orders&percent=30", nil))
+	var res map[string]gosns.CanaryStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || rec.Code != http.StatusOK || res["/orders"].Percent != 30 {
+		t.Fatalf("admin gave %d %s", rec.Code, rec.Body)
+	}
+	first := make(map[string]string)
+	for i := 1; i <= 200; i++ {
+		id := fmt.Sprintf("m-%d", i)
+		first[id] = send(id)
+	}
+	for id, want := range first {
+		if got := send(id); got != want {
+			t.Fatalf("redelivery of %s went to %s, then %s", id, want, got)
+		}
+	}
+	st := orders.CanaryStatus()
+	if st.Canary+st.Primary != 401 || st.Canary < 2*40 || st.Canary > 2*80 {
+		t.Errorf("status %+v", st)
+	}
+
+	orders.SetCanaryPercent(150)
+	if got := send("m-1000"); got != "canary" || orders.CanaryStatus().Percent != 100 {
+		t.Errorf("with all traffic on the canary, went to %s", got)
+	}
+
+	for _, target := range []string{"/canary?endpoint=/missing&percent=5", "/canary?endpoint=/orders&percent=x"} {
+		rec := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", target, nil))
+		if rec.Code != http.StatusNotFound && rec.Code != http.StatusBadRequest {
+			t.Errorf("%s gave %d", target, rec.Code)
+		}
+	}
+}
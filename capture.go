@@ -0,0 +1,78 @@
+package gosns
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// CapturedRequest is a single incoming request as recorded to Server.Capture,
+// one JSON object per line. Credentials are not recorded: the Authorization
+// and Proxy-Authorization headers are left out of Header.
+type CapturedRequest struct {
+	Time   time.Time
+	Method string
+	URI    string
+	Host   string
+	Header http.Header
+	Body   string
+}
+
+// capture records r to s.Capture, replacing r.Body so the request can still
+// be processed normally afterwards.
+func (s *Server) capture(r *http.Request) {
+	body, err := bufferBody(r)
+	if err != nil {
+		s.logf(LogError, "error reading body for capture: %v\n", err)
+		return
+	}
+
+	data, err := json.Marshal(newCapturedRequest(r, body))
+	if err != nil {
+		s.logf(LogError, "error encoding capture: %v\n", err)
+		return
+	}
+	data = append(data, '\n')
+
+	s.captureMu.Lock()
+	defer s.captureMu.Unlock()
+	if _, err = s.Capture.Write(data); err != nil {
+		s.logf(LogError, "error writing capture: %v\n", err)
+	}
+}
+
+// newCapturedRequest records r, whose body has been read, without its
+// credentials.
+func newCapturedRequest(r *http.Request, body []byte) *CapturedRequest {
+	header := r.Header.Clone()
+	header.Del("Authorization")
+	header.Del("Proxy-Authorization")
+	return &CapturedRequest{
+		Time:   time.Now().UTC(),
+		Method: r.Method,
+		URI:    r.RequestURI,
+		Host:   r.Host,
+		Header: header,
+		Body:   string(body),
+	}
+}
+
+// bufferBody reads r's body and replaces it with a copy, so that the request
+// can still be processed normally afterwards. If reading fails, the
+// replacement hands the same failure on to whatever reads it next.
+func bufferBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		return body, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,75 @@
+package gosns_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestReplay(t *testing.T) {
+	var capture bytes.Buffer
+	recorded := &gosns.Server{Capture: &capture}
+	recorded.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	rts := gosnstest.NewServer(recorded)
+	defer rts.Close()
+	var sent []string
+	for i := 0; i < 5; i++ {
+		_, e, err := rts.Notify("/orders", ordersARN, "", fmt.Sprintf("order %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, e.MessageId)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			mu.Lock()
+			handled = append(handled, msg.MessageId)
+			mu.Unlock()
+		}
+	})
+	orders.Delivery = gosns.AtLeastOnce // so that each callback has run when its response arrives
+	orders.DuplicateWindow = time.Minute
+	orders.DropDuplicates = true
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	replay := func() {
+		t.Helper()
+		err := gosnstest.Replay(bytes.NewReader(capture.Bytes()), ts.URL, 0, func(cr *gosns.CapturedRequest, resp *http.Response) {
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("replay of %s gave %s", cr.URI, resp.Status)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	replay()
+	mu.Lock()
+	got := fmt.Sprint(handled)
+	mu.Unlock()
+	if want := fmt.Sprint(sent); got != want {
+		t.Fatalf("replay handled %s, want %s", got, want)
+	}
+
+	// the second replay repeats every MessageId within the window
+	replay()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != len(sent) {
+		t.Errorf("second replay handled %v", handled[len(sent):])
+	}
+	if st := orders.Duplicates(); st.Duplicates != uint64(len(sent)) || st.Messages != 2*uint64(len(sent)) {
+		t.Errorf("duplicate stats %+v", st)
+	}
+}
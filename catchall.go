@@ -0,0 +1,33 @@
+package gosns
+
+import "context"
+
+// catchAllEndpoint is the key of the catch-all topic, which no request path
+// can equal.
+const catchAllEndpoint = "*"
+
+// AddCatchAll adds a topic handling confirmations and notifications sent to
+// any path without a registered topic, from any SNS topic, such as for a
+// server logging every topic subscribed to it. Callbacks can find the path
+// with EndpointPath, and the topic in Message.TopicArn. Because nothing else
+// restricts what the catch-all accepts, signatures are always verified for
+// it, as with Server.VerifySignatures. The returned Topic's TopicARN may be
+// narrowed to a pattern, such as "arn:aws:sns:*:123456789012:*", which
+// unlike other topics' is honored in Strict mode.
+func (s *Server) AddCatchAll(callback func(*Message)) *Topic {
+	t := s.newTopic("arn:*:sns:*:*:*", "/", callback, nil)
+	t.endpoint = catchAllEndpoint
+	return s.register(t)
+}
+
+// EndpointPath returns the path of the request which delivered the message
+// that ctx belongs to (see Message.Context), or "" if there is none.
+func EndpointPath(ctx context.Context) string {
+	p, _ := ctx.Value(endpointPathKey).(string)
+	return p
+}
+
+// verifies reports whether requests to td must carry valid signatures.
+func (s *Server) verifies(td *Topic) bool {
+	return s.VerifySignatures || s.Strict || td.endpoint == catchAllEndpoint || td.certs != nil
+}
@@ -0,0 +1,54 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestCatchAll(t *testing.T) {
+	s := &gosns.Server{}
+	type delivery struct{ path, topic string }
+	got := make(chan delivery, 2)
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	s.AddCatchAll(func(msg *gosns.Message) {
+		if msg != nil {
+			got <- delivery{gosns.EndpointPath(msg.Context()), msg.TopicArn}
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	const audit = "arn:aws:sns:eu-west-1:210987654321:audit"
+	if resp, _, err := ts.Confirm("/anything/audit", audit); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Notify("/anything/audit", audit, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("notification gave %v, %v", resp, err)
+	}
+	select {
+	case d := <-got:
+		if d.path != "/anything/audit" || d.topic != audit {
+			t.Errorf("got %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("catch-all not called")
+	}
+
+	// registered endpoints keep refusing other topics
+	if resp, _, err := ts.Notify("/orders", audit, "", "hello"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("mismatched topic on a registered endpoint gave %v, %v", resp, err)
+	}
+
+	// and the catch-all needs a signature
+	req, err := gosnstest.NewClientRequest(ts.URL+"/elsewhere", gosnstest.NewNotification(audit, "", "unsigned"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := ts.Client().Do(req); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsigned notification gave %v, %v", resp, err)
+	}
+}
@@ -0,0 +1,318 @@
+// Package clickhouse inserts the messages a gosns server receives into a
+// ClickHouse table over its HTTP interface, so that high-volume
+// notification streams land directly in an analytics store:
+//
+//	cols, err := clickhouse.ParseColumns(`
+//		message_id = msg.id
+//		received   = msg.timestamp
+//		order_id   = msg.body.order.id
+//		total      = number(msg.body.order.total)
+//	`)
+//	ins := &clickhouse.Inserter{URL: "http://clickhouse:8123", Table: "sns.orders", Columns: cols}
+//	defer ins.Close()
+//	t := s.AddTopic(topicARN, "/orders", ins.Handle)
+//	t.Delivery = gosns.AtLeastOnce
+//
+// Each message is a row whose columns are package expr expressions, such as
+// fields of the JSON body; see DefaultColumns. Rows written at about the
+// same time are inserted together, as JSONEachRow, once MaxRows have been
+// written or the first has waited MaxWait, and Write returns once its row
+// has been inserted. With AsyncInsert, ClickHouse buffers the inserts of
+// all clients itself before writing a part, which suits many replicas each
+// inserting small batches; the insert still waits for the buffer to be
+// flushed, so that a row is only acknowledged once written. Inserts which
+// fail because the server is overloaded or unreachable are retried with
+// backoff up to MaxAttempts times.
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+	"github.com/pbnjay/gosns/internal/batcher"
+)
+
+// Defaults used when the corresponding Inserter fields are zero.
+const (
+	DefaultMaxRows      = 10000
+	DefaultMaxWait      = time.Second
+	DefaultMaxAttempts  = 5
+	DefaultRetryBackoff = 500 * time.Millisecond
+	DefaultTimeout      = time.Minute
+)
+
+// maxInsertBytes bounds the body of an insert.
+const maxInsertBytes = 16 << 20
+
+// ErrClosed is returned by Write once the Inserter is closed.
+var ErrClosed = errors.New("clickhouse: inserter is closed")
+
+// Column is a column of the table and the expression giving its value.
+type Column struct {
+	Name  string
+	Value *expr.Expr
+}
+
+// DefaultColumns are the columns of an Inserter without Columns: the
+// message's fields, with its timestamp in RFC 3339 form and its attributes
+// as a map of names to values.
+var DefaultColumns = []Column{
+	{"message_id", expr.MustCompile("msg.id")},
+	{"topic_arn", expr.MustCompile("msg.topic")},
+	{"subject", expr.MustCompile("msg.subject")},
+	{"message", expr.MustCompile("msg.message")},
+	{"timestamp", expr.MustCompile("msg.timestamp")},
+	{"attributes", expr.MustCompile("msg.attributes")},
+}
+
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseColumns parses column definitions, one per line, of the form
+//
+//	NAME = EXPR
+//
+// Blank lines and lines beginning with # are ignored.
+func ParseColumns(src string) ([]Column, error) {
+	var cols []Column
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, def, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !columnName.MatchString(name) || strings.HasPrefix(def, "=") {
+			return nil, fmt.Errorf("line %d: expected NAME = EXPR", i+1)
+		}
+		e, err := expr.Compile(strings.TrimSpace(def))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		cols = append(cols, Column{name, e})
+	}
+	return cols, nil
+}
+
+// Inserter inserts messages into a ClickHouse table. Its fields must not be
+// changed once it has been used.
+type Inserter struct {
+	// URL is the base URL of the server's HTTP interface, such as
+	// http://clickhouse:8123.
+	URL string
+
+	// Table is the table inserted into, which may be qualified by its
+	// database, and Columns its columns, or DefaultColumns if empty.
+	Table   string
+	Columns []Column
+
+	// Username and Password, if set, authenticate requests with HTTP basic
+	// authentication.
+	Username string
+	Password string
+
+	// AsyncInsert has ClickHouse buffer inserts server side; see the
+	// package documentation.
+	AsyncInsert bool
+
+	// Client sends requests; nil means an http.Client with a timeout of
+	// Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// MaxRows and MaxWait bound an insert, and MaxAttempts the inserts of a
+	// row, with RetryBackoff before the second and doubling for each after.
+	// Zero means DefaultMaxRows, DefaultMaxWait, DefaultMaxAttempts and
+	// DefaultRetryBackoff.
+	MaxRows      int
+	MaxWait      time.Duration
+	MaxAttempts  int
+	RetryBackoff time.Duration
+
+	// Logger receives notices of retried inserts, if set.
+	Logger *log.Logger
+
+	once    sync.Once
+	client  *http.Client
+	query   string
+	batcher *batcher.Batcher
+}
+
+// Error is an error response from ClickHouse.
+type Error struct {
+	StatusCode int
+	Code       int // ClickHouse's error code, such as 60 for UNKNOWN_TABLE
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("clickhouse: status %d: %s", e.StatusCode, e.Message)
+}
+
+var errorCode = regexp.MustCompile(`^Code: (\d+)\.`)
+
+// transientCodes are the error codes of inserts which may succeed later:
+// timeouts, network errors, and too many queries or parts.
+var transientCodes = map[int]bool{159: true, 202: true, 209: true, 210: true, 252: true}
+
+// retryable reports whether an insert failing with err may succeed if sent
+// again.
+func retryable(err error) bool {
+	var cerr *Error
+	if !errors.As(err, &cerr) {
+		return true
+	}
+	switch cerr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return transientCodes[cerr.Code]
+}
+
+func (ins *Inserter) init() {
+	ins.once.Do(func() {
+		ins.client = ins.Client
+		if ins.client == nil {
+			timeout := ins.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			ins.client = &http.Client{Timeout: timeout}
+		}
+		if len(ins.Columns) == 0 {
+			ins.Columns = DefaultColumns
+		}
+		names := make([]string, len(ins.Columns))
+		for i, col := range ins.Columns {
+			names[i] = "`" + col.Name + "`"
+		}
+		ins.query = "INSERT INTO " + ins.Table + " (" + strings.Join(names, ", ") + ") FORMAT JSONEachRow"
+		ins.batcher = &batcher.Batcher{
+			MaxRecords:  ins.MaxRows,
+			MaxBytes:    maxInsertBytes,
+			MaxWait:     ins.MaxWait,
+			Send:        ins.insert,
+			Retryable:   retryable,
+			MaxAttempts: ins.MaxAttempts,
+			Backoff:     ins.RetryBackoff,
+			Logf: func(format string, args ...interface{}) {
+				ins.logf("clickhouse: table %s: "+format+"\n", append([]interface{}{ins.Table}, args...)...)
+			},
+		}
+		if ins.batcher.MaxRecords <= 0 {
+			ins.batcher.MaxRecords = DefaultMaxRows
+		}
+		if ins.batcher.MaxWait <= 0 {
+			ins.batcher.MaxWait = DefaultMaxWait
+		}
+		if ins.batcher.MaxAttempts <= 0 {
+			ins.batcher.MaxAttempts = DefaultMaxAttempts
+		}
+		if ins.batcher.Backoff <= 0 {
+			ins.batcher.Backoff = DefaultRetryBackoff
+		}
+	})
+}
+
+func (ins *Inserter) logf(format string, args ...interface{}) {
+	if ins.Logger != nil {
+		ins.Logger.Printf(format, args...)
+	}
+}
+
+// Row returns the row inserted for msg, as JSON.
+func (ins *Inserter) Row(msg *gosns.Message) ([]byte, error) {
+	ins.init()
+	row := make(map[string]interface{}, len(ins.Columns))
+	for _, col := range ins.Columns {
+		v, err := col.Value.Eval(msg)
+		if err != nil {
+			return nil, fmt.Errorf("clickhouse: column %s: %w", col.Name, err)
+		}
+		row[col.Name] = v
+	}
+	return json.Marshal(row)
+}
+
+// Write inserts a row for msg, returning once it has been inserted or has
+// failed MaxAttempts times, or msg's context is done.
+func (ins *Inserter) Write(msg *gosns.Message) error {
+	row, err := ins.Row(msg)
+	if err != nil {
+		return err
+	}
+	err = ins.batcher.Write(msg.Context(), append(row, '\n'))
+	if err == batcher.ErrClosed {
+		return ErrClosed
+	}
+	return err
+}
+
+// Handle inserts msg as a Topic callback, failing the message if it could
+// not be inserted.
+func (ins *Inserter) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := ins.Write(msg); err != nil {
+		msg.Fail(err)
+	}
+}
+
+// Close inserts any pending rows and waits for the inserts in progress.
+// Later Writes fail with ErrClosed.
+func (ins *Inserter) Close() {
+	ins.init()
+	ins.batcher.Close()
+}
+
+// insert inserts batch, the rows as JSON lines.
+func (ins *Inserter) insert(batch [][]byte) ([]error, error) {
+	params := url.Values{
+		"query": {ins.query},
+		// accept RFC 3339 timestamps in DateTime columns
+		"date_time_input_format": {"best_effort"},
+	}
+	if ins.AsyncInsert {
+		params.Set("async_insert", "1")
+		params.Set("wait_for_async_insert", "1")
+	}
+	body := bytes.Join(batch, nil)
+	req, err := http.NewRequestWithContext(context.Background(), "POST", strings.TrimSuffix(ins.URL, "/")+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if ins.Username != "" || ins.Password != "" {
+		req.SetBasicAuth(ins.Username, ins.Password)
+	}
+	resp, err := ins.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		cerr := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		if m := errorCode.FindStringSubmatch(cerr.Message); m != nil {
+			cerr.Code, _ = strconv.Atoi(m[1])
+		}
+		return nil, cerr
+	}
+	return nil, nil
+}
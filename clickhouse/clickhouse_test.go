@@ -0,0 +1,118 @@
+package clickhouse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// newInserter returns an Inserter writing to a fake ClickHouse HTTP
+// interface, on which tables named missing do not exist.
+func newInserter(t *testing.T) (*Inserter, *gosnstest.Sink) {
+	s := gosnstest.NewSink(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("query"), "missing") {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "Code: 60. DB::Exception: Table default.missing does not exist. (UNKNOWN_TABLE) (version 24.8.1.1)\n")
+		}
+	})
+	t.Cleanup(s.Close)
+	return &Inserter{URL: s.URL, Table: "sns.orders", AsyncInsert: true, MaxRows: 5, MaxWait: 20 * time.Millisecond, RetryBackoff: time.Millisecond}, s
+}
+
+// inserted returns the queries and rows of the inserts s accepted.
+func inserted(t *testing.T, s *gosnstest.Sink) (queries []string, rows []map[string]interface{}) {
+	for _, req := range s.Accepted() {
+		q := req.URL.Query()
+		if q.Get("async_insert") != "1" || q.Get("wait_for_async_insert") != "1" {
+			t.Errorf("settings %v", q)
+		}
+		queries = append(queries, q.Get("query"))
+		for _, line := range strings.Split(strings.TrimSpace(string(req.Body)), "\n") {
+			var row map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Errorf("bad row %q", line)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return queries, rows
+}
+
+func TestParseColumns(t *testing.T) {
+	cols, err := ParseColumns(`
+		# the order
+		order_id = msg.body.order.id
+		total    = number(msg.body.order.total)
+	`)
+	if err != nil || len(cols) != 2 || cols[0].Name != "order_id" || cols[1].Value.String() != "number(msg.body.order.total)" {
+		t.Fatalf("got %+v, %v", cols, err)
+	}
+	for _, src := range []string{"order_id", "order id = msg.id", "x == msg.id", "x = msg.("} {
+		if _, err := ParseColumns(src); err == nil {
+			t.Errorf("%q parsed", src)
+		}
+	}
+}
+
+func TestInserter(t *testing.T) {
+	ins, s := newInserter(t)
+	s.Fail(1, http.StatusInternalServerError, "Code: 252. DB::Exception: Too many parts (300). (TOO_MANY_PARTS)\n")
+	ins.Columns, _ = ParseColumns("id = msg.id\norder_id = msg.body.order.id\ntotal = msg.body.order.total")
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := gosnstest.NewMessage(fmt.Sprint(i), "orders")
+			msg.Message = fmt.Sprintf(`{"order": {"id": "o-%d", "total": %d}}`, i, i*10)
+			if err := ins.Write(msg); err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	ins.Close()
+
+	queries, rows := inserted(t, s)
+	if len(rows) != 12 || len(queries) < 3 {
+		t.Fatalf("%d inserts of %v", len(queries), rows)
+	}
+	if queries[0] != "INSERT INTO sns.orders (`id`, `order_id`, `total`) FORMAT JSONEachRow" {
+		t.Errorf("query %q", queries[0])
+	}
+	for _, row := range rows {
+		if row["order_id"] != "o-"+row["id"].(string) || row["total"] == nil {
+			t.Errorf("row %v", row)
+		}
+	}
+}
+
+func TestInserterErrors(t *testing.T) {
+	ins, _ := newInserter(t)
+	ins.Table = "missing"
+	defer ins.Close()
+	var cerr *Error
+	if err := ins.Write(gosnstest.NewMessage("1", "orders")); !errors.As(err, &cerr) || cerr.Code != 60 {
+		t.Errorf("got %v", err)
+	}
+
+	// the default columns are the message's fields
+	ins, s := newInserter(t)
+	defer ins.Close()
+	msg := gosnstest.NewMessage("2", "orders")
+	msg.Subject = "hi"
+	if err := ins.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	_, rows := inserted(t, s)
+	if len(rows) != 1 || rows[0]["message_id"] != "2" || rows[0]["subject"] != "hi" || rows[0]["timestamp"] != gosnstest.MessageTime.Format(time.RFC3339Nano) {
+		t.Errorf("rows %v", rows)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pbnjay/gosns"
+)
+
+// regionFor picks the region from the flag value, the environment, the
+// region component of an SNS ARN, or the AWS profile, in that order.
+func regionFor(flagRegion, arn string) string {
+	if flagRegion != "" {
+		return flagRegion
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	if r := os.Getenv("AWS_DEFAULT_REGION"); r != "" {
+		return r
+	}
+	if a, _ := gosns.ParseARN(arn); a.Region != "" {
+		return a.Region
+	}
+	return gosns.LoadRegion()
+}
+
+var (
+	credsOnce sync.Once
+	awsCreds  *gosns.Credentials
+)
+
+// awsCredentials returns the credentials shared by every AWS client, which
+// assume the --role-arn if it is set.
+func awsCredentials() *gosns.Credentials {
+	credsOnce.Do(func() {
+		creds, err := gosns.LoadCredentials()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *roleARN != "" {
+			creds = gosns.AssumeRole(creds, regionFor(*region, ""), *roleARN, "gosns")
+		}
+		awsCreds = creds
+	})
+	return awsCreds
+}
+
+// endpointURL returns the SNS endpoint named by the environment, as for the
+// AWS SDKs, or "" for the regional endpoint.
+func endpointURL() string {
+	if u := os.Getenv("AWS_ENDPOINT_URL_SNS"); u != "" {
+		return u
+	}
+	return os.Getenv("AWS_ENDPOINT_URL")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+func confirmationsCmd(args []string) {
+	fs := flag.NewFlagSet("confirmations", flag.ExitOnError)
+	dir := fs.String("state-dir", "", "state `directory` of a server run with --manual-confirm")
+	redisAddr := fs.String("redis", "", "Redis server `host:port` of servers run with --manual-confirm")
+	dynamoTable := fs.String("dynamodb-table", "", "DynamoDB `table` of servers run with --manual-confirm")
+	region := fs.String("region", "", "AWS `region` of the --dynamodb-table (defaults to the environment)")
+	encState := fs.Bool("encrypt-state", false, "the servers were run with --encrypt-state, with the same key in the environment")
+	approve := fs.String("approve", "", "confirm the subscription whose token starts with `token`")
+	discard := fs.String("discard", "", "forget the subscription whose token starts with `token`")
+	fs.Parse(args)
+	if *dir == "" && *redisAddr == "" && *dynamoTable == "" || (*approve != "" && *discard != "") {
+		fmt.Fprintf(os.Stderr, "USAGE: %s confirmations (--state-dir dir | --redis host:port | --dynamodb-table name) [--approve token | --discard token]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	s := &gosns.Server{Store: &gosns.FileStore{Dir: *dir}}
+	if store := openSharedStore(*redisAddr, *dynamoTable, *region); store != nil {
+		s.Store = store
+	}
+	if *encState {
+		s.Store = &gosns.EncryptedStore{Store: s.Store, Key: stateKey(*region)}
+	}
+	s.Logger = log.New(os.Stderr, "GOSNS ", log.LstdFlags)
+	pending, err := s.PendingConfirmations()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *approve == "" && *discard == "" {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "TOKEN\tTOPIC\tENDPOINT\tRECEIVED\tSOURCE\tEXPIRES")
+		for _, pc := range pending {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", shortToken(pc.Token), pc.TopicArn, pc.Endpoint,
+				pc.Received.Local().Format(time.RFC3339), pc.Source, pc.Expires().Local().Format(time.RFC3339))
+		}
+		tw.Flush()
+		return
+	}
+
+	prefix := *approve + *discard
+	var found *gosns.PendingConfirmation
+	for _, pc := range pending {
+		if strings.HasPrefix(pc.Token, prefix) {
+			if found != nil {
+				log.Fatalf("token '%s' is ambiguous", prefix)
+			}
+			found = pc
+		}
+	}
+	if found == nil {
+		log.Fatalf("no pending confirmation with token '%s'", prefix)
+	}
+	if *discard != "" {
+		if err = s.DiscardConfirmation(found.Token); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("discarded " + shortToken(found.Token))
+		return
+	}
+	subARN, err := s.ApproveConfirmation(found.Token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("confirmed " + subARN)
+}
+
+// shortToken abbreviates a confirmation token, which is hundreds of
+// characters long, to a prefix that is still practically unique.
+func shortToken(token string) string {
+	if len(token) > 16 {
+		return token[:16]
+	}
+	return token
+}
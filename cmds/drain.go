@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// drainOnSignal drains s when the process is asked to stop with SIGTERM or
+// an interrupt, sending the result on the returned channel. A second signal
+// exits at once. On an upgrade signal (SIGHUP, where supported), ln is first
+// handed to a new copy of the process, and s drains without delay once the
+// new process is serving.
+func drainOnSignal(s *gosns.Server, ln net.Listener, delay, timeout time.Duration) <-chan error {
+	done := make(chan error, 1)
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+			case <-upgrade:
+				if err := handoff(ln); err != nil {
+					log.Printf("error handing off to a new process: %v\n", err)
+					continue
+				}
+				delay = 0
+			}
+			break
+		}
+		go func() {
+			<-stop
+			os.Exit(1)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), delay+timeout)
+		defer cancel()
+		done <- s.Drain(ctx, delay)
+	}()
+	return done
+}
+
+// serveAdmin serves the admin API on addr. While a process being replaced on
+// SIGHUP still holds the address, it keeps trying until that one exits.
+func serveAdmin(addr string, h http.Handler) {
+	for logged := false; ; logged = true {
+		err := http.ListenAndServe(addr, h)
+		if !logged {
+			log.Printf("admin API: %v, retrying\n", err)
+		}
+		time.Sleep(time.Second)
+	}
+}
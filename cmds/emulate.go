@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pbnjay/gosns/emulator"
+)
+
+func emulateCmd(args []string) {
+	fs := flag.NewFlagSet("emulate", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:9911", "`address` to serve the SNS API on")
+	publicURL := fs.String("url", "", "base `url` endpoints reach the emulator at (defaults to http://<listen address>)")
+	region := fs.String("region", emulator.DefaultRegion, "AWS `region` used in topic ARNs")
+	account := fs.String("account", emulator.DefaultAccountID, "AWS account `id` used in topic ARNs")
+	var topics []string
+	fs.Func("topic", "create the topic `name` at startup (may be repeated)", func(name string) error {
+		topics = append(topics, name)
+		return nil
+	})
+	fs.Parse(args)
+
+	e, err := emulator.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.Region, e.AccountID = *region, *account
+	e.Logger = log.New(os.Stderr, "EMULATOR ", log.LstdFlags)
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.URL = *publicURL
+	if e.URL == "" {
+		e.URL = "http://" + ln.Addr().String()
+	}
+	for _, name := range topics {
+		arn, err := e.CreateTopic(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("created topic", arn)
+	}
+
+	fmt.Printf("SNS emulator at %s\n", e.URL)
+	fmt.Printf("  clients:  AWS_ENDPOINT_URL_SNS=%s AWS_ACCESS_KEY_ID=x AWS_SECRET_ACCESS_KEY=x\n", e.URL)
+	fmt.Printf("  receiver: %s --verify --trust-cert-url %s ...\n", os.Args[0], strings.TrimSuffix(e.URL, "/")+"/")
+	log.Fatal(e.Serve(ln))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pbnjay/gosns"
+)
+
+// attrFlag collects repeated --attribute name=value flags.
+type attrFlag map[string]string
+
+func (a attrFlag) String() string {
+	var parts []string
+	for k, v := range a {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (a attrFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("attribute must be in the form name=value")
+	}
+	a[s[:i]] = s[i+1:]
+	return nil
+}
+
+// Filter decides whether a message should be printed and written out.
+type Filter struct {
+	Subject    *regexp.Regexp
+	Message    *regexp.Regexp
+	Attributes map[string]string
+}
+
+// NewFilter compiles the subject and message patterns. Empty patterns match
+// everything.
+func NewFilter(subject, message string, attrs map[string]string) (*Filter, error) {
+	f := &Filter{Attributes: attrs}
+	var err error
+	if subject != "" {
+		if f.Subject, err = regexp.Compile(subject); err != nil {
+			return nil, fmt.Errorf("invalid --subject-match: %v", err)
+		}
+	}
+	if message != "" {
+		if f.Message, err = regexp.Compile(message); err != nil {
+			return nil, fmt.Errorf("invalid --message-match: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether msg satisfies every configured condition.
+func (f *Filter) Match(msg *gosns.Message) bool {
+	if f.Subject != nil && !f.Subject.MatchString(msg.Subject) {
+		return false
+	}
+	if f.Message != nil && !f.Message.MatchString(msg.Message) {
+		return false
+	}
+	for name, want := range f.Attributes {
+		a, ok := msg.MessageAttributes[name]
+		if !ok || a.Value != want {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pbnjay/gosns"
+)
+
+func TestFilter(t *testing.T) {
+	msg := &gosns.Message{
+		Subject: "order created",
+		Message: `{"id":42,"total":10}`,
+		MessageAttributes: map[string]gosns.MessageAttribute{
+			"kind":   {Type: "String", Value: "retail"},
+			"region": {Type: "String", Value: "eu"},
+		},
+	}
+	for _, c := range []struct {
+		name             string
+		subject, message string
+		attrs            map[string]string
+		match            bool
+	}{
+		{"no conditions", "", "", nil, true},
+		{"subject", "^order", "", nil, true},
+		{"subject mismatch", "^refund", "", nil, false},
+		{"message", "", `"id":42`, nil, true},
+		{"message mismatch", "", `"id":43`, nil, false},
+		{"attribute", "", "", map[string]string{"kind": "retail"}, true},
+		{"attributes", "", "", map[string]string{"kind": "retail", "region": "eu"}, true},
+		{"attribute value mismatch", "", "", map[string]string{"kind": "wholesale"}, false},
+		{"missing attribute", "", "", map[string]string{"channel": "web"}, false},
+		{"empty value needs the attribute", "", "", map[string]string{"channel": ""}, false},
+		{"all", "created$", "total", map[string]string{"region": "eu"}, true},
+		{"all but one", "created$", "total", map[string]string{"region": "us"}, false},
+	} {
+		f, err := NewFilter(c.subject, c.message, c.attrs)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := f.Match(msg); got != c.match {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.match)
+		}
+	}
+
+	if _, err := NewFilter("(", "", nil); err == nil {
+		t.Error("invalid subject pattern was accepted")
+	}
+	if _, err := NewFilter("", "[", nil); err == nil {
+		t.Error("invalid message pattern was accepted")
+	}
+}
+
+func TestAttrFlag(t *testing.T) {
+	a := attrFlag{}
+	for _, s := range []string{"kind=retail", "expr=a=b", "empty="} {
+		if err := a.Set(s); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+	if a["kind"] != "retail" || a["expr"] != "a=b" || a["empty"] != "" || len(a) != 3 {
+		t.Errorf("got %v", a)
+	}
+	for _, s := range []string{"kind", "=retail"} {
+		if err := a.Set(s); err == nil {
+			t.Errorf("%s was accepted", s)
+		}
+	}
+}
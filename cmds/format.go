@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/template"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Printer writes messages to an output stream in one of the supported formats.
+type Printer struct {
+	mu   sync.Mutex
+	out  io.Writer
+	tmpl *template.Template
+	json bool
+	ind  bool
+}
+
+// NewPrinter returns a printer for format "json", "jsonl" or "template". The
+// text argument is the template source and is only used by "template".
+func NewPrinter(out io.Writer, format, text string) (*Printer, error) {
+	p := &Printer{out: out}
+	switch format {
+	case "json":
+		p.json, p.ind = true, true
+	case "jsonl":
+		p.json = true
+	case "template":
+		if text == "" {
+			return nil, fmt.Errorf("--format template requires --template")
+		}
+		t, err := template.New("message").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		p.tmpl = t
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+	return p, nil
+}
+
+func (p *Printer) Write(msg *gosns.Message) error {
+	var buf bytes.Buffer
+	if p.json {
+		var data []byte
+		var err error
+		if p.ind {
+			data, err = json.MarshalIndent(msg, "", "  ")
+		} else {
+			data, err = json.Marshal(msg)
+		}
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	} else if err := p.tmpl.Execute(&buf, msg); err != nil {
+		return err
+	}
+	if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.out.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+func formatMessage() *gosns.Message {
+	return &gosns.Message{
+		Subject:           "new order",
+		Message:           `{"id":1}`,
+		MessageId:         "m-1",
+		Timestamp:         time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+		TopicArn:          "arn:aws:sns:us-east-1:123456789012:orders",
+		MessageAttributes: map[string]gosns.MessageAttribute{"kind": {Type: "String", Value: "retail"}},
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	for _, c := range []struct {
+		format, text, want string
+	}{
+		{"json", "", `{
+  "Subject": "new order",
+  "Message": "{\"id\":1}",
+  "MessageId": "m-1",
+  "Timestamp": "2026-10-14T12:00:00Z",
+  "TopicArn": "arn:aws:sns:us-east-1:123456789012:orders",
+  "MessageAttributes": {
+    "kind": {
+      "Type": "String",
+      "Value": "retail"
+    }
+  }
+}
+{
+  "Subject": "new order",
+  "Message": "{\"id\":1}",
+  "MessageId": "m-1",
+  "Timestamp": "2026-10-14T12:00:00Z",
+  "TopicArn": "arn:aws:sns:us-east-1:123456789012:orders",
+  "MessageAttributes": {
+    "kind": {
+      "Type": "String",
+      "Value": "retail"
+    }
+  }
+}
+`},
+		{"jsonl", "", `{"Subject":"new order","Message":"{\"id\":1}","MessageId":"m-1","Timestamp":"2026-10-14T12:00:00Z","TopicArn":"arn:aws:sns:us-east-1:123456789012:orders","MessageAttributes":{"kind":{"Type":"String","Value":"retail"}}}
+{"Subject":"new order","Message":"{\"id\":1}","MessageId":"m-1","Timestamp":"2026-10-14T12:00:00Z","TopicArn":"arn:aws:sns:us-east-1:123456789012:orders","MessageAttributes":{"kind":{"Type":"String","Value":"retail"}}}
+`},
+		// a newline is added unless the template ends with one
+		{"template", `{{.MessageId}} {{.Subject}} {{(index .MessageAttributes "kind").Value}}`, "m-1 new order retail\nm-1 new order retail\n"},
+		{"template", "{{.Timestamp.Format \"15:04\"}}: {{.Message}}\n", "12:00: {\"id\":1}\n12:00: {\"id\":1}\n"},
+	} {
+		var out bytes.Buffer
+		p, err := NewPrinter(&out, c.format, c.text)
+		if err != nil {
+			t.Fatalf("%s: %v", c.format, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := p.Write(formatMessage()); err != nil {
+				t.Fatalf("%s: %v", c.format, err)
+			}
+		}
+		if got := out.String(); got != c.want {
+			t.Errorf("%s %q: got\n%s\nwant\n%s", c.format, c.text, got, c.want)
+		}
+	}
+}
+
+func TestPrinterErrors(t *testing.T) {
+	for _, c := range []struct{ format, text string }{
+		{"xml", ""},
+		{"template", ""},
+		{"template", "{{.MessageId"},
+	} {
+		if _, err := NewPrinter(&bytes.Buffer{}, c.format, c.text); err == nil {
+			t.Errorf("%s %q: no error", c.format, c.text)
+		}
+	}
+
+	p, _ := NewPrinter(&bytes.Buffer{}, "template", "{{.Missing}}")
+	if err := p.Write(formatMessage()); err == nil {
+		t.Error("template naming a missing field gave no error")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+var upgradeSignals []os.Signal
+
+func listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+func ready() {}
+
+func handoff(ln net.Listener) error {
+	return errors.New("socket handoff is not supported on this platform")
+}
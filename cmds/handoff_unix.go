@@ -0,0 +1,95 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// The environment variables naming the descriptors a process started by
+// handoff inherits: the listening socket, and a pipe to report readiness on.
+const (
+	listenFDEnv = "GOSNS_LISTEN_FD"
+	readyFDEnv  = "GOSNS_READY_FD"
+)
+
+// handoffTimeout is how long handoff waits for the new process to start.
+const handoffTimeout = time.Minute
+
+// upgradeSignals ask a running server to hand its socket to a new process.
+var upgradeSignals = []os.Signal{syscall.SIGHUP}
+
+// listen returns the listening socket inherited from the process this one
+// replaces, or a new one on address.
+func listen(address string) (net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(listenFDEnv))
+	if err != nil {
+		return net.Listen("tcp", address)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// ready tells the process which started this one, if any, that it has taken
+// over the listening socket and is about to serve.
+func ready() {
+	fd, err := strconv.Atoi(os.Getenv(readyFDEnv))
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+	os.Unsetenv(listenFDEnv)
+	os.Unsetenv(readyFDEnv)
+}
+
+// handoff starts a new copy of the running executable, with the same
+// arguments, which inherits ln, and waits until it is ready to serve.
+func handoff(ln net.Listener) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener cannot be handed off")
+	}
+	sock, err := tl.File()
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{sock, w} // descriptors 3 and 4
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3", readyFDEnv+"=4")
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	r.SetReadDeadline(time.Now().Add(handoffTimeout))
+	var b [1]byte
+	if n, _ := r.Read(b[:]); n != 1 {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("new process failed to start")
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// jsonLog writes one JSON object per line for each event, for log
+// collectors such as Loki or CloudWatch Logs.
+type jsonLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+type logEvent struct {
+	Time      time.Time  `json:"time"`
+	Level     string     `json:"level"`
+	Event     string     `json:"event"`
+	Topic     string     `json:"topic,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+	MessageID string     `json:"message_id,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	Sent      *time.Time `json:"sent,omitempty"`
+	Msg       string     `json:"msg,omitempty"`
+}
+
+func (l *jsonLog) write(ev *logEvent) {
+	ev.Time = time.Now().UTC()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(data, '\n'))
+}
+
+// Entry logs a line from the server, as its LogFunc.
+func (l *jsonLog) Entry(e gosns.LogEntry) {
+	ev := &logEvent{Level: e.Level.String(), Event: "log", RequestID: e.RequestID, Msg: strings.TrimSpace(e.Message)}
+	if e.Level >= gosns.LogError {
+		ev.Event = "error"
+	}
+	if e.Topic != nil {
+		ev.Topic = e.Topic.TopicARN
+	}
+	l.write(ev)
+}
+
+// Message logs the receipt of msg, or the confirmation of a subscription to
+// topicARN if msg is nil.
+func (l *jsonLog) Message(topicARN string, msg *gosns.Message) {
+	if msg == nil {
+		l.write(&logEvent{Level: "info", Event: "confirmed", Topic: topicARN})
+		return
+	}
+	sent := msg.Timestamp
+	l.write(&logEvent{
+		Level:     "info",
+		Event:     "message",
+		Topic:     msg.TopicArn,
+		RequestID: gosns.RequestID(msg.Context()),
+		MessageID: msg.MessageId,
+		Subject:   msg.Subject,
+		Sent:      &sent,
+	})
+}
+
+// Write logs each line written by the standard logger, which the command
+// only uses for errors once the server is configured.
+func (l *jsonLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSpace(string(p)), "\n") {
+		l.write(&logEvent{Level: "error", Event: "error", Msg: line})
+	}
+	return len(p), nil
+}
@@ -1,11 +1,112 @@
 package main
 
 import (
-	"github.com/pbnjay/gosns"
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/clickhouse"
+	"github.com/pbnjay/gosns/email"
+	"github.com/pbnjay/gosns/expr"
+	"github.com/pbnjay/gosns/fanout"
+	"github.com/pbnjay/gosns/firehose"
+	"github.com/pbnjay/gosns/notify"
+	"github.com/pbnjay/gosns/opensearch"
+	"github.com/pbnjay/gosns/payload"
+	"github.com/pbnjay/gosns/plugin"
+	"github.com/pbnjay/gosns/s3archive"
+	"github.com/pbnjay/gosns/shard"
+)
+
+var (
+	outputDir  = flag.String("output-dir", "", "write each message to a JSON file in this maildir-style `directory`")
+	appendFile = flag.String("append", "", "append each message as a JSON line to this `file`")
+	format     = flag.String("format", "text", "output `format`: text, json, jsonl or template")
+	tmplText   = flag.String("template", "", "text/template source used with --format template, e.g. '{{.MessageId}} {{.Subject}}'")
+
+	subjectMatch = flag.String("subject-match", "", "only handle messages whose subject matches this `regexp`")
+	messageMatch = flag.String("message-match", "", "only handle messages whose body matches this `regexp`")
+	attributes   = attrFlag{}
+	pluginCmd    = flag.String("plugin", "", "pass each message through this external processor `command` (see package plugin)")
+	shardURLs    = flag.String("shard", "", "forward each message to one of these comma-separated worker `urls`, by consistent hashing (see package shard)")
+	shardKey     = flag.String("shard-key", "", "with --shard, send messages with the same value of this message `attribute` to the same worker (default: spread by MessageId)")
+	shardHealth  = flag.String("shard-health", "", "with --shard, check each worker's health at this `path`, e.g. /healthz")
+	unwrapJSON   = flag.Bool("unwrap-message-structure", false, "replace bodies published with MessageStructure json by their https, http or default entry")
+	unwrapBody   = flag.String("unwrap", "", "decode message bodies wrapped in these comma-separated `encodings`: sqs, json-string and base64")
+	s3Archive    = flag.String("s3-archive", "", "also archive every message to S3 as gzipped JSON lines under this `s3://bucket/prefix`, partitioned by topic and date for Athena (see package s3archive)")
+	payloadSrc   = flag.String("payload", "", "with --shard or --firehose, forward this text/template's output for each message in place of the message as JSON, or with an 'expr:' prefix, this expression's as JSON, e.g. 'expr:{id: msg.id, order: msg.body.order}' (see package payload)")
+	fhStream     = flag.String("firehose", "", "also put every message as a JSON line into this Kinesis Data Firehose delivery `stream`, in batches")
+	searchURL    = flag.String("opensearch", "", "also index every message into the OpenSearch or Elasticsearch cluster at this `url`, with any basic auth credentials in it; Amazon OpenSearch Service endpoints are signed with AWS credentials")
+	chURL        = flag.String("clickhouse", "", "also insert every message as a row into --clickhouse-table on the ClickHouse server at this HTTP interface `url`, with any basic auth credentials in it")
+	chTable      = flag.String("clickhouse-table", "sns_messages", "with --clickhouse, the `table` to insert into")
+	chColumns    = flag.String("clickhouse-columns", "", "with --clickhouse, read the table's columns from this `file` of lines like 'order_id = msg.body.order.id' (default: the message's fields)")
+	fanoutFile   = flag.String("fanout", "", "also forward messages to the webhooks in this JSON `file`, each with its own filters, template, headers and retries (see package fanout)")
+	slackURL     = flag.String("slack-webhook", "", "also post every message to this Slack incoming webhook `url`, showing CloudWatch alarms by state (see package notify)")
+	teamsURL     = flag.String("teams-webhook", "", "also post every message to this Microsoft Teams workflow webhook `url` as an Adaptive Card")
+	pagerDuty    = flag.Bool("pagerduty", false, "also send every message to PagerDuty as an event for the integration key in PAGERDUTY_ROUTING_KEY, resolving incidents when their CloudWatch alarm returns to OK")
+	smtpURL      = flag.String("smtp", "", "also email messages through the SMTP server at this `url`, e.g. smtp://alerts@mail.example.com:587, with the password in SMTP_PASSWORD (see package email)")
+	mailTo       = flag.String("email-to", "", "with --smtp, the comma-separated `addresses` to email")
+	mailFrom     = flag.String("email-from", "", "with --smtp, the sender's `address` (default: the --smtp user at its host)")
+	mailDigest   = flag.Duration("email-digest", 0, "with --smtp, send at most one email per `duration`, collecting the messages in between into a digest")
+	rulesFile    = flag.String("rules", "", "apply the drop/keep/set rules in this `file` to each message (see package expr)")
+
+	discoverURL = flag.String("discover", "", "handle every topic with a confirmed subscription to an endpoint under this public base `url`")
+	region      = flag.String("region", "", "AWS `region` used with --discover, --dynamodb-table, --encrypt-state, --firehose, --opensearch, --s3-archive and --sqs-queue (defaults to the environment)")
+	roleARN     = flag.String("role-arn", "", "assume this IAM role `arn` for every AWS API call, with the credentials from the environment")
+	catchAll    = flag.Bool("catch-all", false, "also handle signed messages from any topic sent to any other path")
+	captureFile = flag.String("capture", "", "record every incoming request to this `file` for later replay")
+	strictCT    = flag.Bool("strict-content-type", false, "refuse messages whose Content-Type is not one SNS sends, rather than logging them")
+	verify      = flag.Bool("verify", false, "require valid SNS signatures on all messages, and only visit SubscribeURLs on SNS")
+	snsRegions  = flag.String("sns-regions", "", "only accept messages signed by, and SubscribeURLs on, SNS in these comma-separated `regions`, e.g. us-gov-west-1,us-gov-east-1")
+	skipTests   = flag.Bool("skip-test-events", false, "acknowledge and log the test messages AWS services send when first set up, such as s3:TestEvent, without handling them")
+	sqsRelay    = flag.Bool("sqs-envelopes", false, "also accept notifications relayed from an SQS queue, whose bodies are the SQS message wrapping the SNS envelope")
+	sqsQueue    = flag.String("sqs-queue", "", "also handle the topic's messages from the SQS queue at this `url`, as delivered by an SQS subscription")
+	atLeastOnce = flag.Bool("at-least-once", false, "acknowledge messages only once they have been handled, so that SNS retries those which fail")
+	retryAfter  = flag.Duration("retry-backoff", 0, "with --at-least-once, ask SNS to wait this `duration` before retrying a failed message, doubling for each failure")
+	maxAttempts = flag.Int("max-attempts", 0, "acknowledge and log a message once `n` of its deliveries in a row have failed, so that SNS stops retrying it")
+	quarantine  = flag.Bool("quarantine", false, "with --max-attempts, keep the messages given up for the admin API's /quarantine, to requeue once fixed")
+	hashBodies  = flag.Bool("hash-bodies", false, "log the SHA-256 of each message body as received, and include it in output as BodySHA256")
+	stateDir    = flag.String("state-dir", "", "persist subscriptions, delayed messages and held confirmations beneath this `directory`")
+	redisAddr   = flag.String("redis", "", "share subscriptions, confirmations and duplicate detection with other replicas through the Redis server at `host:port`")
+	encState    = flag.Bool("encrypt-state", false, "encrypt the messages kept in --state-dir, --redis or --dynamodb-table with AES-GCM, using the base64 key in STATE_ENCRYPTION_KEY or the KMS-encrypted one in STATE_ENCRYPTION_KEY_KMS")
+	dynamoTable = flag.String("dynamodb-table", "", "like --redis, but keep shared state in this DynamoDB `table` (see package dynamostore)")
+	authUser    = flag.String("basic-auth-user", "", "refuse requests without this basic auth `user` and the password in BASIC_AUTH_PASSWORD, as embedded in the subscribed URL")
+	secretEvery = flag.Duration("secret-refresh", 0, "resolve a BASIC_AUTH_PASSWORD held in Secrets Manager or SSM again this often, so that rotations take effect")
+	debugReqs   = flag.Int("debug-requests", 0, "keep the last `n` requests to each topic, with secrets redacted, for the admin API's /debug/requests")
+	adminAddr   = flag.String("admin-addr", "", "serve the admin API on this private `address`, e.g. localhost:8081")
+	httpStats   = flag.Bool("http-metrics", false, "count requests by route, method and status, with their durations, for the admin API's /http")
+	statsEvery  = flag.Duration("stats-interval", 0, "sample each topic's throughput and latency this often, keeping the last 360 samples for the admin API's /stats (a Grafana JSON datasource)")
+	manualConf  = flag.Bool("manual-confirm", false, "hold subscription confirmations until approved with the confirmations command")
+	logLevel    = flag.String("log-level", "debug", "lowest `level` of server log lines to write: debug, info, warn or error")
+	maxAge      = flag.Duration("max-message-age", 0, "skip messages sent longer ago than this `duration`, e.g. after an outage")
+	clockSkew   = flag.Duration("clock-skew", 0, "allow for the local clock differing from SNS's by this `duration` when judging the age of messages")
+	probePath   = flag.String("probe-path", "", "answer load balancer health checks at this `path`")
+	pingPath    = flag.String("ping-path", "", "answer uptime checks at this `path` by passing a signed test message through the server, e.g. "+gosns.DefaultPingPath+" (see the ping command)")
+	drainDelay  = flag.Duration("drain-delay", 0, "on SIGTERM, fail health checks for this `duration` before refusing connections")
+	drainWait   = flag.Duration("drain-timeout", 30*time.Second, "on SIGTERM, wait this `duration` for messages in progress to be handled")
+	dupWindow   = flag.Duration("duplicate-window", 0, "count and log messages redelivered within this `duration`, with a summary once per window")
+	sloTarget   = flag.Float64("slo-target", 0, "track the `fraction` of messages, e.g. 0.99, handled within --slo-latency over a rolling hour, for the admin API's /slo, warning when the error budget burns fast")
+	sloLatency  = flag.Duration("slo-latency", 2*time.Second, "with --slo-target, the `duration` within which messages are to be handled")
+	idemFields  = flag.String("idempotency-fields", "", "handle each event once, identified by these comma-separated top-level `fields` of its JSON body, e.g. order_id,version")
+	logFormat   = flag.String("log-format", "text", "server log `format`: text, or json for one object per event")
+	logSample   = flag.Int("log-sample", 0, "log only one in every `n` received messages; warnings and errors are always logged")
+	oldTopic    = flag.String("old-topic-arn", "", "also accept messages from this `arn`, the topic being replaced by the one given, logging once it has sent nothing for --quiet-period")
+	quietPeriod = flag.Duration("quiet-period", gosns.DefaultQuietPeriod, "with --old-topic-arn, the `duration` after which the old topic is reported quiet")
+	dryRun      = flag.Bool("dry-run", false, "verify, filter, transform and log messages without handling them or writing them to any output, such as to try out new --rules")
+	trustCert   = flag.String("trust-cert-url", "", "with --verify, also accept signing certificates under this `url` prefix, e.g. http://127.0.0.1:8443/ for testfire --sign")
 )
 
+func init() {
+	flag.Var(attributes, "attribute", "only handle messages with message attribute `name=value` (may be repeated)")
+}
+
 func JustPrint(msg *gosns.Message) {
 	if msg == nil {
 		log.Println("Topic Subscription Confirmed.")
@@ -19,13 +120,428 @@ func JustPrint(msg *gosns.Message) {
 	log.Println("-----")
 }
 
+var subcommands = map[string]func(args []string){
+	"subscribe":   subscribeCmd,
+	"unsubscribe": unsubscribeCmd,
+	"publish":     publishCmd,
+	"testfire":    testfireCmd,
+	"replay":      replayCmd,
+	"emulate":     emulateCmd,
+	"ping":        pingCmd,
+	"quarantine":  quarantineCmd,
+
+	"confirmations": confirmationsCmd,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "USAGE: %s [flags] topic:arn /web/endpoint\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] --discover https://public.host\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] --catch-all\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s subscribe|unsubscribe|publish|testfire|replay|emulate|ping|quarantine|confirmations [flags]\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nBASIC_AUTH_PASSWORD, REDIS_PASSWORD and STATE_ENCRYPTION_KEY may name a secret to\nread instead, as secretsmanager:<id>[#field] or ssm:<parameter>.\n")
+}
+
 func main() {
-	if len(os.Args) != 3 {
-		log.SetFlags(0)
-		log.Fatalf("USAGE: %s topic:arn /web/endpoint", os.Args[0])
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			log.SetFlags(0)
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 && !((*discoverURL != "" || *catchAll) && flag.NArg() == 0) {
+		usage()
+		os.Exit(2)
+	}
+
+	filter, err := NewFilter(*subjectMatch, *messageMatch, attributes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rules expr.Rules
+	if *rulesFile != "" {
+		src, err := os.ReadFile(*rulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if rules, err = expr.ParseRules(string(src)); err != nil {
+			log.Fatalf("%s: %v", *rulesFile, err)
+		}
+	}
+
+	var jlog *jsonLog
+	switch *logFormat {
+	case "text":
+	case "json":
+		jlog = &jsonLog{out: os.Stderr}
+	default:
+		log.Fatalf("unknown --log-format '%s'", *logFormat)
+	}
+
+	display := func(topicARN string, msg *gosns.Message) { JustPrint(msg) }
+	var writers []func(*gosns.Message) error
+	if *format != "text" {
+		p, err := NewPrinter(os.Stdout, *format, *tmplText)
+		if err != nil {
+			log.Fatal(err)
+		}
+		display = func(topicARN string, msg *gosns.Message) {
+			if msg == nil {
+				log.Println("Topic Subscription Confirmed.")
+			}
+		}
+		writers = append(writers, p.Write)
+	}
+	if jlog != nil {
+		display = jlog.Message
+	}
+	if *outputDir != "" {
+		spool, err := NewSpool(*outputDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writers = append(writers, spool.Write)
+	}
+	if *appendFile != "" {
+		alog, err := OpenAppendLog(*appendFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		writers = append(writers, alog.Write)
+	}
+	var encode func(*gosns.Message) ([]byte, error)
+	if *payloadSrc != "" {
+		f, err := payload.Parse(*payloadSrc)
+		if err != nil {
+			log.Fatalf("invalid --payload: %v", err)
+		}
+		encode = f.Render
+	}
+	if *shardURLs != "" {
+		pool := &shard.Pool{Workers: strings.Split(*shardURLs, ","), HealthPath: *shardHealth, Encode: encode, Logger: log.Default()}
+		if name := *shardKey; name != "" {
+			pool.Key = func(msg *gosns.Message) string { return msg.MessageAttributes[name].Value }
+		}
+		defer pool.Close()
+		writers = append(writers, pool.Write)
+	}
+	if *s3Archive != "" {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(*s3Archive, "s3://"), "/")
+		arch := &s3archive.Archiver{Bucket: bucket, Prefix: prefix, Region: regionFor(*region, flag.Arg(0)), Credentials: awsCredentials(),
+			Endpoint: os.Getenv("AWS_ENDPOINT_URL_S3"), Logger: log.Default()}
+		if arch.Prefix != "" && !strings.HasSuffix(arch.Prefix, "/") {
+			arch.Prefix += "/"
+		}
+		defer func() {
+			if err := arch.Close(); err != nil {
+				log.Println(err)
+			}
+		}()
+		writers = append(writers, arch.Write)
+	}
+	if *fhStream != "" {
+		stream := &firehose.Stream{Name: *fhStream, Region: regionFor(*region, flag.Arg(0)), Credentials: awsCredentials(),
+			Endpoint: os.Getenv("AWS_ENDPOINT_URL_FIREHOSE"), Logger: log.Default()}
+		if encode != nil {
+			stream.Encode = func(msg *gosns.Message) ([]byte, error) {
+				data, err := encode(msg)
+				return append(data, '\n'), err
+			}
+		}
+		defer stream.Close()
+		writers = append(writers, stream.Write)
+	}
+	if *searchURL != "" {
+		u, err := url.Parse(*searchURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ix := &opensearch.Indexer{Logger: log.Default()}
+		if u.User != nil {
+			ix.Username = u.User.Username()
+			ix.Password, _ = u.User.Password()
+			u.User = nil
+		} else if host := u.Hostname(); strings.HasSuffix(host, ".es.amazonaws.com") || strings.HasSuffix(host, ".aoss.amazonaws.com") {
+			ix.Region, ix.Credentials = regionFor(*region, flag.Arg(0)), awsCredentials()
+			if strings.HasSuffix(host, ".aoss.amazonaws.com") {
+				ix.Service = "aoss"
+			}
+		}
+		ix.URL = u.String()
+		defer ix.Close()
+		writers = append(writers, ix.Write)
 	}
+	if *chURL != "" {
+		u, err := url.Parse(*chURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ins := &clickhouse.Inserter{Table: *chTable, AsyncInsert: true, Logger: log.Default()}
+		if u.User != nil {
+			ins.Username = u.User.Username()
+			ins.Password, _ = u.User.Password()
+			u.User = nil
+		}
+		ins.URL = u.String()
+		if *chColumns != "" {
+			src, err := os.ReadFile(*chColumns)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if ins.Columns, err = clickhouse.ParseColumns(string(src)); err != nil {
+				log.Fatalf("%s: %v", *chColumns, err)
+			}
+		}
+		defer ins.Close()
+		writers = append(writers, ins.Write)
+	}
+	if *fanoutFile != "" {
+		router, err := fanout.LoadConfig(*fanoutFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		router.Logger = log.Default()
+		defer router.Close()
+		writers = append(writers, router.Write)
+	}
+	if *slackURL != "" || *teamsURL != "" || *pagerDuty {
+		router := &fanout.Router{Logger: log.Default()}
+		if *slackURL != "" {
+			router.Destinations = append(router.Destinations, &fanout.Destination{Name: "slack", URL: *slackURL, Encode: notify.Slack})
+		}
+		if *teamsURL != "" {
+			router.Destinations = append(router.Destinations, &fanout.Destination{Name: "teams", URL: *teamsURL, Encode: notify.Teams})
+		}
+		if *pagerDuty {
+			pd := &notify.PagerDuty{RoutingKey: os.Getenv("PAGERDUTY_ROUTING_KEY")}
+			if pd.RoutingKey == "" {
+				log.Fatal("--pagerduty requires PAGERDUTY_ROUTING_KEY")
+			}
+			router.Destinations = append(router.Destinations, &fanout.Destination{Name: "pagerduty", URL: notify.PagerDutyURL, Encode: pd.Encode})
+		}
+		writers = append(writers, router.Write)
+	}
+	if *smtpURL != "" {
+		u, err := url.Parse(*smtpURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *mailTo == "" {
+			log.Fatal("--smtp requires --email-to")
+		}
+		m := &email.Mailer{Addr: u.Host, From: *mailFrom, To: strings.Split(*mailTo, ","), Digest: *mailDigest, Logger: log.Default()}
+		if u.Port() == "" {
+			m.Addr += ":587"
+		}
+		if u.User != nil {
+			m.Username, m.Password = u.User.Username(), os.Getenv("SMTP_PASSWORD")
+		}
+		if m.From == "" {
+			if m.Username == "" {
+				log.Fatal("--smtp without a user requires --email-from")
+			}
+			m.From = m.Username + "@" + u.Hostname()
+		}
+		defer m.Close()
+		writers = append(writers, m.Write)
+	}
+
+	handler := func(topicARN string) func(*gosns.Message) {
+		return func(msg *gosns.Message) {
+			if msg != nil && !filter.Match(msg) {
+				return
+			}
+			display(topicARN, msg)
+			if msg == nil {
+				return
+			}
+			for _, w := range writers {
+				if err := w(msg); err != nil {
+					log.Printf("error writing message %s: %v\n", msg.MessageId, err)
+				}
+			}
+		}
+	}
+
 	snsServer := &gosns.Server{}
 	snsServer.Logger = log.New(os.Stderr, "GOSNS ", log.LstdFlags)
-	snsServer.AddTopic(os.Args[1], os.Args[2], JustPrint)
-	log.Fatal(snsServer.ListenAndServe(":8080"))
+	if jlog != nil {
+		snsServer.LogFunc = jlog.Entry
+		log.SetFlags(0)
+		log.SetOutput(jlog)
+	}
+	if snsServer.LogLevel, err = gosns.ParseLogLevel(*logLevel); err != nil {
+		log.Fatal(err)
+	}
+	snsServer.VerifySignatures = *verify
+	snsServer.DryRun = *dryRun
+	if *verify && *trustCert == "" {
+		snsServer.CheckSubscribeURL = gosns.ValidateSubscribeURL
+	}
+	if *snsRegions != "" {
+		snsServer.Regions = strings.Split(*snsRegions, ",")
+	}
+	snsServer.HashBodies = *hashBodies
+	snsServer.HTTPMetrics = *httpStats
+	snsServer.StatsInterval = *statsEvery
+	snsServer.SQSEnvelopes = *sqsRelay
+	if *skipTests {
+		snsServer.TestEvents = gosns.IsTestEvent
+	}
+	snsServer.ClockSkew = *clockSkew
+	if *strictCT {
+		snsServer.ContentTypeCheck = gosns.ContentTypeStrict
+	}
+	snsServer.ProbePath = *probePath
+	snsServer.PingPath = *pingPath
+	snsServer.ManualConfirm = *manualConf
+	if store := openSharedStore(*redisAddr, *dynamoTable, *region); store != nil {
+		snsServer.Store, snsServer.Dedup, snsServer.SharedStore = store, store, true
+	} else if *stateDir != "" {
+		snsServer.Store = &gosns.FileStore{Dir: *stateDir}
+	} else if *manualConf {
+		log.Fatal("--manual-confirm needs --state-dir, --redis or --dynamodb-table, so that confirmations can be approved")
+	}
+	if *encState {
+		if snsServer.Store == nil {
+			log.Fatal("--encrypt-state needs --state-dir, --redis or --dynamodb-table")
+		}
+		snsServer.Store = &gosns.EncryptedStore{Store: snsServer.Store, Key: stateKey(*region)}
+	}
+	if *trustCert != "" {
+		prefix := *trustCert
+		snsServer.Certs = &gosns.CertCache{ValidateURL: func(u *url.URL) error {
+			if strings.HasPrefix(u.String(), prefix) {
+				return nil
+			}
+			return gosns.ValidateCertURL(u)
+		}}
+	}
+	if *captureFile != "" {
+		f, err := os.OpenFile(*captureFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		snsServer.Capture = f
+	}
+	var topics []*gosns.Topic
+	if flag.NArg() == 2 {
+		topic, err := snsServer.RegisterTopic(flag.Arg(0), flag.Arg(1), handler(flag.Arg(0)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *oldTopic != "" {
+			topic.Migration = &gosns.Migration{OldARN: *oldTopic, QuietPeriod: *quietPeriod}
+		}
+		topics = append(topics, topic)
+	}
+	if *discoverURL != "" {
+		found, err := snsServer.Discover(context.Background(), newSNSClient(*region, ""), *discoverURL, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(found) == 0 && len(topics) == 0 && !*catchAll {
+			log.Fatalf("no confirmed subscriptions found under '%s'", *discoverURL)
+		}
+		topics = append(topics, found...)
+	}
+	if *catchAll {
+		topics = append(topics, snsServer.AddCatchAll(nil))
+	}
+	var unwrapper *gosns.Unwrapper
+	if *unwrapBody != "" {
+		unwrapper = &gosns.Unwrapper{}
+		for _, enc := range strings.Split(*unwrapBody, ",") {
+			switch strings.TrimSpace(enc) {
+			case "sqs":
+				unwrapper.SQSEnvelope = true
+			case "json-string":
+				unwrapper.JSONString = true
+			case "base64":
+				unwrapper.Base64 = true
+			default:
+				log.Fatalf("unknown --unwrap encoding '%s'", enc)
+			}
+		}
+	}
+	var proc *plugin.Process
+	if args := strings.Fields(*pluginCmd); len(args) > 0 {
+		proc = &plugin.Process{Command: args[0], Args: args[1:], Logger: snsServer.Logger}
+	}
+	var authPassword string
+	if *authUser != "" {
+		authPassword = secretEnv("BASIC_AUTH_PASSWORD", *region)
+	}
+	for _, topic := range topics {
+		// set here, rather than by Discover, so that every topic's
+		// confirmations are reported with its ARN
+		topic.Callback = handler(topic.TopicARN)
+		if unwrapper != nil {
+			topic.Transformers = append(topic.Transformers, unwrapper.Transform)
+		}
+		if *unwrapJSON {
+			topic.Transformers = append(topic.Transformers, gosns.UnwrapMessageStructure)
+		}
+		if rules != nil {
+			topic.Transformers = append(topic.Transformers, rules.Transform)
+		}
+		if proc != nil {
+			topic.Transformers = append(topic.Transformers, proc.Transform)
+		}
+		topic.LogSample = *logSample
+		topic.MaxMessageAge = *maxAge
+		topic.MaxAttempts = *maxAttempts
+		topic.Quarantine = *quarantine
+		if *atLeastOnce {
+			topic.Delivery = gosns.AtLeastOnce
+			topic.RetryBackoff = *retryAfter
+		}
+		topic.DuplicateWindow = *dupWindow
+		topic.SLOTarget, topic.SLOLatency = *sloTarget, *sloLatency
+		if *idemFields != "" {
+			topic.IdempotencyKey = gosns.IdempotencyKeyFields(strings.Split(*idemFields, ",")...)
+		}
+		topic.DebugRequests = *debugReqs
+		if *authUser != "" {
+			topic.Username, topic.Password = *authUser, authPassword
+		}
+	}
+	watchSecretEnv("BASIC_AUTH_PASSWORD", *region, authPassword, *secretEvery, func(password string) {
+		for _, topic := range topics {
+			topic.SetCredentials(*authUser, password)
+		}
+	})
+	ln, err := listen(":8080")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = snsServer.Start(); err != nil {
+		log.Fatal(err)
+	}
+	if *adminAddr != "" {
+		go serveAdmin(*adminAddr, snsServer.AdminHandler())
+	}
+	if *sqsQueue != "" {
+		if flag.NArg() != 2 {
+			log.Fatal("--sqs-queue needs a topic and endpoint")
+		}
+		go func() {
+			c := &gosns.SQSConsumer{QueueURL: *sqsQueue, Endpoint: flag.Arg(1), Region: *region, Credentials: awsCredentials()}
+			if err := snsServer.ConsumeSQS(context.Background(), c); err != nil && err != context.Canceled {
+				log.Fatal(err)
+			}
+		}()
+	}
+	ready()
+	drained := drainOnSignal(snsServer, ln, *drainDelay, *drainWait)
+	if err := snsServer.Serve(ln); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	if err := <-drained; err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// pingCmd checks a server started with --ping-path, exiting non-zero unless
+// its test message was handled.
+func pingCmd(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "give up after this `duration`")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "USAGE: %s ping [flags] http://localhost:8080%s\n", os.Args[0], gosns.DefaultPingPath)
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res gosns.PingResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Fatalf("%s: %s is not a gosns ping path", resp.Status, fs.Arg(0))
+	}
+	if !res.OK {
+		log.Fatalf("FAIL %s: %s", resp.Status, res.Error)
+	}
+	fmt.Printf("OK %s in %s\n", res.MessageId, res.Duration.Round(time.Microsecond))
+}
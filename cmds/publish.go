@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+)
+
+type publishResult struct {
+	MessageId string `xml:"PublishResult>MessageId"`
+}
+
+func publishCmd(args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	topic := fs.String("topic", "", "`arn` of the topic to publish to")
+	subject := fs.String("subject", "", "message `subject`")
+	message := fs.String("message", "", "message `body`, or - to read it from stdin")
+	region := fs.String("region", "", "AWS `region` (defaults to the environment, then the topic ARN)")
+	attrs := attrFlag{}
+	fs.Var(attrs, "attribute", "string message attribute `name=value` (may be repeated)")
+	fs.Parse(args)
+	if *topic == "" || *message == "" {
+		fmt.Fprintf(os.Stderr, "USAGE: %s publish --topic arn [--subject s] --message m [--attribute k=v]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	body := *message
+	if body == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		body = string(data)
+	}
+
+	params := url.Values{
+		"TopicArn": {*topic},
+		"Message":  {body},
+	}
+	if *subject != "" {
+		params.Set("Subject", *subject)
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		prefix := "MessageAttributes.entry." + strconv.Itoa(i+1) + "."
+		params.Set(prefix+"Name", name)
+		params.Set(prefix+"Value.DataType", "String")
+		params.Set(prefix+"Value.StringValue", attrs[name])
+	}
+
+	c := newSNSClient(*region, *topic)
+	var res publishResult
+	if err := c.Call(context.Background(), "Publish", params, &res); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(res.MessageId)
+}
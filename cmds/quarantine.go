@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// quarantineCmd lists the messages quarantined by a running server, or
+// requeues them, through its admin API.
+func quarantineCmd(args []string) {
+	fs := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	admin := fs.String("admin", "http://localhost:8081", "`url` of the server's --admin-addr")
+	endpoint := fs.String("endpoint", "", "only messages for the topic at this `path`")
+	requeue := fs.String("requeue", "", "requeue the messages with these comma-separated `ids`, with --endpoint")
+	since := fs.String("requeue-since", "", "requeue every message quarantined since this RFC 3339 `time`, or this long ago, e.g. 2h")
+	fs.Parse(args)
+	if fs.NArg() != 0 || (*requeue != "" && (*since != "" || *endpoint == "")) {
+		fmt.Fprintf(os.Stderr, "USAGE: %s quarantine [--admin url] [--endpoint path] [--requeue ids | --requeue-since time]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	base := strings.TrimSuffix(*admin, "/")
+
+	if *requeue == "" && *since == "" {
+		resp, err := http.Get(base + "/quarantine?" + url.Values{"endpoint": {*endpoint}}.Encode())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var msgs []gosns.QuarantinedMessage
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&msgs) != nil {
+			log.Fatalf("%s: %s is not a gosns admin API", resp.Status, *admin)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "MESSAGE ID\tENDPOINT\tQUARANTINED\tATTEMPTS\tERROR")
+		for _, qm := range msgs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", qm.Message.MessageId, qm.Endpoint,
+				qm.Time.Local().Format(time.RFC3339), qm.Attempts, qm.Error)
+		}
+		tw.Flush()
+		return
+	}
+
+	form := url.Values{}
+	if *endpoint != "" {
+		form.Set("endpoint", *endpoint)
+	}
+	if *requeue != "" {
+		for _, id := range strings.Split(*requeue, ",") {
+			form.Add("id", strings.TrimSpace(id))
+		}
+	} else if d, err := time.ParseDuration(*since); err == nil {
+		form.Set("since", time.Now().Add(-d).UTC().Format(time.RFC3339))
+	} else if _, err := time.Parse(time.RFC3339, *since); err == nil {
+		form.Set("since", *since)
+	} else {
+		log.Fatalf("--requeue-since '%s' is neither a time nor a duration", *since)
+	}
+	resp, err := http.PostForm(base+"/quarantine/requeue", form)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res gosns.RequeueResult
+	if resp.StatusCode == http.StatusNotFound {
+		log.Fatal("no such quarantined messages")
+	}
+	if json.NewDecoder(resp.Body).Decode(&res) != nil {
+		log.Fatalf("%s: %s is not a gosns admin API", resp.Status, *admin)
+	}
+	for _, id := range res.Requeued {
+		fmt.Println("requeued " + id)
+	}
+	failed := make([]string, 0, len(res.Failed))
+	for id := range res.Failed {
+		failed = append(failed, id)
+	}
+	sort.Strings(failed)
+	for _, id := range failed {
+		fmt.Printf("failed %s: %s\n", id, res.Failed[id])
+	}
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
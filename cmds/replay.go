@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func replayCmd(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "capture `file` written by --capture")
+	target := fs.String("target", "http://localhost:8080", "base `url` of the server to replay against")
+	speed := fs.Float64("speed", 1, "replay speed `multiplier`; 0 sends requests back to back")
+	fs.Parse(args)
+	if *file == "" {
+		fmt.Fprintf(os.Stderr, "USAGE: %s replay --file capture.jsonl [--target url] [--speed n]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	err = gosnstest.Replay(f, *target, *speed, func(cr *gosns.CapturedRequest, resp *http.Response) {
+		log.Printf("%s %s %s -> %s\n", cr.Time.Format("15:04:05.000"), cr.Method, cr.URI, resp.Status)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
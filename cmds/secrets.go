@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// secretSource resolves the Secrets Manager and SSM references which the
+// environment variables read by secretEnv may hold.
+func secretSource(region string) *gosns.SecretSource {
+	s := &gosns.SecretSource{
+		Region:                 regionFor(region, ""),
+		SecretsManagerEndpoint: os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"),
+		SSMEndpoint:            os.Getenv("AWS_ENDPOINT_URL_SSM"),
+		Credentials:            awsCredentials(),
+	}
+	if u := os.Getenv("AWS_ENDPOINT_URL"); u != "" {
+		if s.SecretsManagerEndpoint == "" {
+			s.SecretsManagerEndpoint = u
+		}
+		if s.SSMEndpoint == "" {
+			s.SSMEndpoint = u
+		}
+	}
+	return s
+}
+
+// secretEnv returns the value of the environment variable name, resolving
+// it if it is a reference such as secretsmanager:prod/sns#password or
+// ssm:/prod/sns/password.
+func secretEnv(name, region string) string {
+	v := os.Getenv(name)
+	if !gosns.IsSecretRef(v) {
+		return v
+	}
+	s := secretSource(region)
+	if s.Region == "" {
+		log.Fatalf("unable to determine AWS region to resolve %s, use --region or AWS_REGION", name)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	value, err := s.Resolve(ctx, v)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return value
+}
+
+// watchSecretEnv resolves the reference in the environment variable name
+// every interval, calling update with each new value.
+func watchSecretEnv(name, region, current string, interval time.Duration, update func(string)) {
+	v := os.Getenv(name)
+	if interval <= 0 || !gosns.IsSecretRef(v) {
+		return
+	}
+	go secretSource(region).Watch(context.Background(), v, current, interval, func(value string, err error) {
+		if err != nil {
+			log.Printf("refreshing %s: %v", name, err)
+			return
+		}
+		log.Printf("%s changed, updating", name)
+		update(value)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Spool writes each message to its own JSON file in a maildir-style
+// directory. Files are written into tmp/ and renamed into new/ once complete,
+// so consumers scanning new/ never see a partially written message.
+type Spool struct {
+	Dir string
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewSpool creates the tmp, new and cur subdirectories of dir if needed.
+func NewSpool(dir string) (*Spool, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Spool{Dir: dir}, nil
+}
+
+func (s *Spool) Write(msg *gosns.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.count++
+	seq := s.count
+	s.mu.Unlock()
+
+	id := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, msg.MessageId)
+	name := fmt.Sprintf("%s.%d_%d.%s.json",
+		time.Now().UTC().Format("20060102T150405.000000000Z"), os.Getpid(), seq, id)
+
+	tmpName := filepath.Join(s.Dir, "tmp", name)
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(s.Dir, "new", name))
+}
+
+// AppendLog writes each message as a single JSON line to a file.
+type AppendLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenAppendLog opens (creating if necessary) filename for appending.
+func OpenAppendLog(filename string) (*AppendLog, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AppendLog{f: f}, nil
+}
+
+func (a *AppendLog) Write(msg *gosns.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(data)
+	return err
+}
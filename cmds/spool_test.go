@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+func spoolMessages() []*gosns.Message {
+	ts := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	return []*gosns.Message{
+		{MessageId: "m-1", TopicArn: "arn:aws:sns:us-east-1:123456789012:orders", Subject: "new", Message: `{"id":1}`, Timestamp: ts},
+		{MessageId: "m/2", Message: "slash in the id", Timestamp: ts.Add(time.Second),
+			MessageAttributes: map[string]gosns.MessageAttribute{"kind": {Type: "String", Value: "refund"}}},
+	}
+}
+
+func checkMessage(t *testing.T, got, want *gosns.Message) {
+	t.Helper()
+	if got.MessageId != want.MessageId || got.TopicArn != want.TopicArn || got.Subject != want.Subject ||
+		got.Message != want.Message || !got.Timestamp.Equal(want.Timestamp) ||
+		got.MessageAttributes["kind"] != want.MessageAttributes["kind"] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := spoolMessages()
+	for _, msg := range msgs {
+		if err := sp.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("left %d files in tmp", len(tmp))
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "new", "*.json"))
+	if err != nil || len(names) != len(msgs) {
+		t.Fatalf("new holds %v, %v", names, err)
+	}
+	// names begin with the time written, so sort in order
+	sort.Strings(names)
+	if !strings.HasSuffix(names[1], ".m_2.json") {
+		t.Errorf("message m/2 was written to %s", names[1])
+	}
+	for i, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got gosns.Message
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkMessage(t, &got, msgs[i])
+	}
+}
+
+func TestAppendLogRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.jsonl")
+	msgs := spoolMessages()
+	// a second open appends to the first's lines
+	for _, msg := range msgs {
+		log, err := OpenAppendLog(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		log.f.Close()
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var n int
+	for ; sc.Scan(); n++ {
+		var got gosns.Message
+		if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+			t.Fatalf("line %d: %v", n+1, err)
+		}
+		if n < len(msgs) {
+			checkMessage(t, &got, msgs[n])
+		}
+	}
+	if n != len(msgs) {
+		t.Errorf("read %d lines, want %d", n, len(msgs))
+	}
+}
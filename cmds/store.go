@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/dynamostore"
+	"github.com/pbnjay/gosns/redisstore"
+)
+
+// sharedStore is a store replicas can share, for gosns.Server's Store and
+// Dedup fields.
+type sharedStore interface {
+	gosns.Store
+	gosns.DedupStore
+}
+
+// openSharedStore returns the Redis or DynamoDB store named by the flags,
+// or nil if neither is set.
+func openSharedStore(redisAddr, dynamoTable, region string) sharedStore {
+	switch {
+	case redisAddr != "" && dynamoTable != "":
+		log.Fatal("use only one of --redis and --dynamodb-table")
+	case redisAddr != "":
+		return &redisstore.Store{Addr: redisAddr, Password: secretEnv("REDIS_PASSWORD", region)}
+	case dynamoTable != "":
+		s := &dynamostore.Store{Table: dynamoTable, Region: regionFor(region, ""), Endpoint: os.Getenv("AWS_ENDPOINT_URL_DYNAMODB"), Credentials: awsCredentials()}
+		if s.Region == "" {
+			log.Fatal("unable to determine AWS region, use --region or AWS_REGION")
+		}
+		if s.Endpoint == "" {
+			s.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+		}
+		return s
+	}
+	return nil
+}
+
+// stateKey returns the key for --encrypt-state: the base64 key in
+// STATE_ENCRYPTION_KEY, or the data key KMS decrypts from the base64
+// ciphertext in STATE_ENCRYPTION_KEY_KMS.
+func stateKey(region string) []byte {
+	if v := secretEnv("STATE_ENCRYPTION_KEY", region); v != "" {
+		key, err := gosns.ParseEncryptionKey(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return key
+	}
+	v := secretEnv("STATE_ENCRYPTION_KEY_KMS", region)
+	if v == "" {
+		log.Fatal("--encrypt-state needs STATE_ENCRYPTION_KEY or STATE_ENCRYPTION_KEY_KMS")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		log.Fatal("STATE_ENCRYPTION_KEY_KMS is not base64: ", err)
+	}
+	c := &gosns.KMSClient{Region: regionFor(region, ""), Endpoint: os.Getenv("AWS_ENDPOINT_URL_KMS"), Credentials: awsCredentials()}
+	if c.Region == "" {
+		log.Fatal("unable to determine AWS region, use --region or AWS_REGION")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	key, err := c.Decrypt(ctx, blob)
+	if err != nil {
+		log.Fatal("decrypting state key: ", err)
+	}
+	return key
+}
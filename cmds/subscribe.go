@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pbnjay/gosns"
+)
+
+func newSNSClient(region, arn string) *gosns.SNSClient {
+	c := &gosns.SNSClient{Region: regionFor(region, arn), Credentials: awsCredentials(), Endpoint: endpointURL()}
+	if c.Region == "" {
+		log.Fatal("unable to determine AWS region, use --region or AWS_REGION")
+	}
+	return c
+}
+
+func subscribeCmd(args []string) {
+	fs := flag.NewFlagSet("subscribe", flag.ExitOnError)
+	topic := fs.String("topic", "", "`arn` of the topic to subscribe to")
+	endpoint := fs.String("endpoint", "", "public http(s) `url` that SNS should deliver to")
+	region := fs.String("region", "", "AWS `region` (defaults to the environment, then the topic ARN)")
+	fs.Parse(args)
+	if *topic == "" || *endpoint == "" {
+		fmt.Fprintf(os.Stderr, "USAGE: %s subscribe --topic arn --endpoint https://host/path\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	c := newSNSClient(*region, *topic)
+	subARN, err := c.Subscribe(context.Background(), *topic, *endpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(subARN)
+}
+
+func unsubscribeCmd(args []string) {
+	fs := flag.NewFlagSet("unsubscribe", flag.ExitOnError)
+	subARN := fs.String("subscription-arn", "", "`arn` of the subscription to remove")
+	topic := fs.String("topic", "", "`arn` of the topic, used with --endpoint to find the subscription")
+	endpoint := fs.String("endpoint", "", "subscribed http(s) `url`, used with --topic to find the subscription")
+	region := fs.String("region", "", "AWS `region` (defaults to the environment, then the topic ARN)")
+	fs.Parse(args)
+	if *subARN == "" && (*topic == "" || *endpoint == "") {
+		fmt.Fprintf(os.Stderr, "USAGE: %s unsubscribe (--subscription-arn arn | --topic arn --endpoint url)\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	arn := *subARN
+	if arn == "" {
+		arn = *topic
+	}
+	c := newSNSClient(*region, arn)
+
+	if *subARN == "" {
+		subs, err := c.ListSubscriptions(context.Background(), *topic)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, sub := range subs {
+			if sub.Endpoint == *endpoint {
+				*subARN = sub.SubscriptionArn
+			}
+		}
+		if *subARN == "" {
+			log.Fatalf("no subscription for '%s' on topic '%s'", *endpoint, *topic)
+		}
+		if *subARN == "PendingConfirmation" {
+			log.Fatal("subscription is still pending confirmation and cannot be removed yet")
+		}
+	}
+
+	if err := c.Unsubscribe(context.Background(), *subARN); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("unsubscribed " + *subARN)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func testfireCmd(args []string) {
+	fs := flag.NewFlagSet("testfire", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "`url` of the running gosns server endpoint")
+	file := fs.String("file", "", "`path` to the message body, or to a complete SNS envelope in JSON")
+	topic := fs.String("topic", "arn:aws:sns:us-east-1:123456789012:gosns-test", "topic `arn` sent in the envelope and headers")
+	subject := fs.String("subject", "", "message `subject`")
+	sign := fs.Bool("sign", false, "sign the envelope with a locally generated test certificate")
+	sigVersion := fs.String("signature-version", "1", "SNS signature `version` used with --sign (1 or 2)")
+	certAddr := fs.String("cert-addr", "127.0.0.1:8443", "`address` the test certificate is served from with --sign; start the server with --verify --trust-cert-url http://<address>/")
+	fs.Parse(args)
+	if *endpoint == "" || *file == "" {
+		fmt.Fprintf(os.Stderr, "USAGE: %s testfire --endpoint http://localhost:8080/path --file payload.json\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	env := gosnstest.NewNotification(*topic, *subject, string(data))
+	var full gosnstest.Envelope
+	if json.Unmarshal(data, &full) == nil && full.Type != "" {
+		// a complete envelope, so only fill in what it lacks
+		if full.MessageId == "" {
+			full.MessageId = env.MessageId
+		}
+		if full.TopicArn == "" {
+			full.TopicArn = env.TopicArn
+		}
+		if full.Timestamp == "" {
+			full.Timestamp = time.Now().UTC().Format(gosnstest.TimeFormat)
+		}
+		env = &full
+	}
+
+	if *sign {
+		signer, err := gosnstest.NewSigner()
+		if err != nil {
+			log.Fatal(err)
+		}
+		signer.SignatureVersion = *sigVersion
+		ln, err := net.Listen("tcp", *certAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go http.Serve(ln, signer.Handler())
+		// every run generates a new key, so give each certificate its own URL
+		// rather than let a server's CertCache hand back the previous one
+		signer.CertURL = "http://" + ln.Addr().String() + "/" + gosnstest.NewUUID() + "/SimpleNotificationService-test.pem"
+		if err = signer.Sign(env); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	req, err := gosnstest.NewClientRequest(*endpoint, env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s %s", resp.Status, respBody)
+	if resp.StatusCode/100 != 2 {
+		os.Exit(1)
+	}
+}
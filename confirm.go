@@ -0,0 +1,183 @@
+package gosns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfirmationLifetime is how long SNS accepts a subscription confirmation
+// token after sending it.
+const ConfirmationLifetime = 3 * 24 * time.Hour
+
+// ErrConfirmationExpired is returned when approving a confirmation whose
+// token is older than ConfirmationLifetime.
+var ErrConfirmationExpired = errors.New("gosns: confirmation token has expired")
+
+// PendingConfirmation is a subscription confirmation held for approval
+// because the Server's ManualConfirm option is set.
+type PendingConfirmation struct {
+	Token        string
+	TopicArn     string
+	Endpoint     string
+	SubscribeURL string
+	MessageId    string
+
+	// Timestamp is when SNS sent the confirmation, and Received is when it
+	// arrived from Source, the remote address of the request.
+	Timestamp time.Time
+	Received  time.Time
+	Source    string
+}
+
+// Expires returns the time after which SNS no longer accepts the token.
+func (pc *PendingConfirmation) Expires() time.Time {
+	if pc.Timestamp.IsZero() {
+		return pc.Received.Add(ConfirmationLifetime)
+	}
+	return pc.Timestamp.Add(ConfirmationLifetime)
+}
+
+// confirmationKey hashes the token, which is too long for some file
+// systems to use as a name.
+func confirmationKey(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return "confirmations/" + hex.EncodeToString(h[:])
+}
+
+// confirmations returns the Store holding pending confirmations.
+func (s *Server) confirmations() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return &s.pending
+}
+
+// holdConfirmation saves a verified confirmation for later approval.
+func (s *Server) holdConfirmation(td *Topic, r *http.Request, env *envelope) error {
+	if env.Token == "" {
+		td.logf(r, LogWarn, "confirmation for topic '%s' has no Token\n", td.TopicARN)
+		return errors.New("missing Token")
+	}
+	pc := &PendingConfirmation{
+		Token:        env.Token,
+		TopicArn:     env.TopicArn,
+		Endpoint:     td.endpoint,
+		SubscribeURL: env.SubscribeURL,
+		MessageId:    env.MessageId,
+		Received:     time.Now().UTC(),
+		Source:       r.RemoteAddr,
+	}
+	if ts, err := parseTimestamp(env.Timestamp, s.TimestampLayouts); err == nil {
+		pc.Timestamp = ts
+	} else {
+		// Expires counts from Received instead
+		td.logf(r, LogWarn, "confirmation for topic '%s' has an invalid Timestamp %q: %v\n", td.TopicARN, env.Timestamp, err)
+	}
+	data, err := json.Marshal(pc)
+	if err == nil {
+		err = s.confirmations().Put(confirmationKey(pc.Token), data)
+	}
+	if err != nil {
+		td.logf(r, LogError, "error saving confirmation for topic '%s': %v\n", td.TopicARN, err)
+		return err
+	}
+	td.logf(r, LogInfo, "Endpoint '%s' is holding a subscription confirmation for topic '%s' for approval\n", r.URL.Path, td.TopicARN)
+	return nil
+}
+
+// PendingConfirmations returns the subscription confirmations awaiting
+// approval, oldest first. Expired confirmations are discarded.
+func (s *Server) PendingConfirmations() ([]*PendingConfirmation, error) {
+	store := s.confirmations()
+	keys, err := store.List("confirmations/")
+	if err != nil {
+		return nil, err
+	}
+	var res []*PendingConfirmation
+	now := time.Now()
+	for _, key := range keys {
+		pc, err := s.readConfirmation(store, key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pc == nil || now.After(pc.Expires().Add(s.ClockSkew)) {
+			store.Delete(key)
+			continue
+		}
+		res = append(res, pc)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Received.Before(res[j].Received) })
+	return res, nil
+}
+
+// readConfirmation returns the confirmation saved under key, or nil if it
+// is unreadable.
+func (s *Server) readConfirmation(store Store, key string) (*PendingConfirmation, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	pc := new(PendingConfirmation)
+	if err = json.Unmarshal(data, pc); err != nil || pc.SubscribeURL == "" {
+		s.logf(LogWarn, "discarding unreadable confirmation '%s'\n", key)
+		return nil, nil
+	}
+	return pc, nil
+}
+
+// ApproveConfirmation confirms the pending subscription with the given
+// token, returning its subscription ARN. The subscription is recorded
+// against its endpoint, in the Store if the topic is not registered with
+// this server, so a running server sees approvals made by another process
+// sharing its Store.
+func (s *Server) ApproveConfirmation(token string) (string, error) {
+	store := s.confirmations()
+	key := confirmationKey(token)
+	pc, err := s.readConfirmation(store, key)
+	if err != nil {
+		return "", err
+	}
+	if pc == nil || time.Now().After(pc.Expires().Add(s.ClockSkew)) {
+		store.Delete(key)
+		if pc == nil {
+			return "", ErrNotFound
+		}
+		return "", ErrConfirmationExpired
+	}
+	subARN, err := s.confirm(pc.Endpoint, pc.TopicArn, pc.SubscribeURL)
+	if err != nil {
+		return "", err
+	}
+	s.logf(LogInfo, "Endpoint '%s' confirmed subscription for topic '%s'\n", pc.Endpoint, pc.TopicArn)
+	return subARN, store.Delete(key)
+}
+
+// DiscardConfirmation forgets the pending subscription with the given token
+// without confirming it.
+func (s *Server) DiscardConfirmation(token string) error {
+	store := s.confirmations()
+	key := confirmationKey(token)
+	if _, err := store.Get(key); err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+// storedSubscription reports whether the Store records arn as confirmed at
+// endpoint, for subscriptions approved after the server started.
+func (s *Server) storedSubscription(endpoint, arn string) bool {
+	if s.Store == nil || !strings.HasPrefix(arn, "arn:") {
+		return false
+	}
+	_, err := s.Store.Get(subscriptionKey(endpoint, arn))
+	return err == nil
+}
@@ -0,0 +1,129 @@
+package gosns_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const ordersARN = "arn:aws:sns:us-east-1:123456789012:orders"
+
+func TestManualConfirm(t *testing.T) {
+	store := &gosns.MemoryStore{}
+	s := &gosns.Server{Strict: true, ManualConfirm: true, Store: store}
+	got := make(chan *gosns.Message, 1)
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	resp, conf, err := ts.Confirm("/orders", ordersARN)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation gave %v, %v", resp, err)
+	}
+	if _, ok := ts.Confirmed(conf.Token); ok {
+		t.Fatal("subscription was confirmed without approval")
+	}
+
+	// approve from a second server sharing the store, as the CLI does
+	admin := &gosns.Server{Store: store}
+	pending, err := admin.PendingConfirmations()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending confirmations: %v, %v", pending, err)
+	}
+	pc := pending[0]
+	if pc.Token != conf.Token || pc.TopicArn != ordersARN || pc.Endpoint != "/orders" ||
+		pc.Source == "" || pc.Timestamp.IsZero() || time.Since(pc.Received) > time.Minute {
+		t.Errorf("got %+v", pc)
+	}
+
+	subARN, err := admin.ApproveConfirmation(pc.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, ok := ts.Confirmed(conf.Token); !ok || subARN != want {
+		t.Errorf("approval gave %q, SNS saw %q", subARN, want)
+	}
+	if pending, _ = admin.PendingConfirmations(); len(pending) != 0 {
+		t.Errorf("still pending after approval: %v", pending)
+	}
+
+	env := gosnstest.NewNotification(ordersARN, "", "hello")
+	env.SubscriptionArn = subARN
+	if resp, err = ts.Send("/orders", env); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("notification on the approved subscription gave %v, %v", resp, err)
+	}
+	select {
+	case msg := <-got:
+		if msg.Message != "hello" {
+			t.Errorf("got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+	}
+}
+
+func TestDiscardConfirmation(t *testing.T) {
+	s := &gosns.Server{ManualConfirm: true}
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	_, conf, err := ts.Confirm("/orders", ordersARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.DiscardConfirmation(conf.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ApproveConfirmation(conf.Token); err != gosns.ErrNotFound {
+		t.Errorf("approving a discarded confirmation gave %v", err)
+	}
+	if _, ok := ts.Confirmed(conf.Token); ok {
+		t.Error("discarded subscription was confirmed")
+	}
+}
+
+func TestExpiredConfirmation(t *testing.T) {
+	s := &gosns.Server{ManualConfirm: true}
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	conf := gosnstest.NewSubscriptionConfirmation(ordersARN, "")
+	conf.SubscribeURL = ts.SubscribeURL(ordersARN, conf.Token)
+	conf.Timestamp = time.Now().Add(-gosns.ConfirmationLifetime - time.Hour).UTC().Format(gosnstest.TimeFormat)
+	if _, err := ts.Send("/orders", conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ApproveConfirmation(conf.Token); !errors.Is(err, gosns.ErrConfirmationExpired) {
+		t.Errorf("approving an expired confirmation gave %v", err)
+	}
+	if pending, err := s.PendingConfirmations(); err != nil || len(pending) != 0 {
+		t.Errorf("expired confirmation still pending: %v, %v", pending, err)
+	}
+}
+
+func TestConfirmationTimestampLayouts(t *testing.T) {
+	s := &gosns.Server{ManualConfirm: true, TimestampLayouts: []string{time.RFC1123Z}}
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	conf := gosnstest.NewSubscriptionConfirmation(ordersARN, "")
+	conf.SubscribeURL = ts.SubscribeURL(ordersARN, conf.Token)
+	conf.Timestamp = time.Now().Add(-gosns.ConfirmationLifetime - time.Hour).Format(time.RFC1123Z)
+	if _, err := ts.Send("/orders", conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ApproveConfirmation(conf.Token); !errors.Is(err, gosns.ErrConfirmationExpired) {
+		t.Errorf("approving a confirmation expired by its RFC 1123 Timestamp gave %v", err)
+	}
+}
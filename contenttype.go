@@ -0,0 +1,65 @@
+package gosns
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultContentTypes are the media types SNS sends: text/plain for the JSON
+// envelope, or the type chosen by a subscription's delivery policy.
+var DefaultContentTypes = []string{"text/plain", "application/json", "application/xml"}
+
+// ContentTypeCheck selects what a Server does with notifications and
+// confirmations whose Content-Type is unexpected, which may mean a middlebox
+// has rewritten them.
+type ContentTypeCheck int
+
+const (
+	// ContentTypeLog accepts them, logging a warning. It is the default.
+	ContentTypeLog ContentTypeCheck = iota
+	// ContentTypeStrict refuses them with a 415.
+	ContentTypeStrict
+	// ContentTypeIgnore accepts them silently.
+	ContentTypeIgnore
+)
+
+// contentTypeOK reports whether a Content-Type header names one of types,
+// without a charset other than UTF-8.
+func contentTypeOK(header string, types []string) bool {
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	if cs, ok := params["charset"]; ok && !strings.EqualFold(cs, "utf-8") && !strings.EqualFold(cs, "utf8") {
+		return false
+	}
+	for _, t := range types {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContentType applies s.ContentTypeCheck to r, reporting whether it
+// may go on.
+func (s *Server) checkContentType(w http.ResponseWriter, r *http.Request, td *Topic) bool {
+	if s.ContentTypeCheck == ContentTypeIgnore {
+		return true
+	}
+	types := s.ContentTypes
+	if len(types) == 0 {
+		types = DefaultContentTypes
+	}
+	ct := r.Header.Get("Content-Type")
+	if contentTypeOK(ct, types) {
+		return true
+	}
+	if s.ContentTypeCheck == ContentTypeStrict {
+		s.reject(w, r, ResponseUnsupportedMediaType, ReasonBadContentType)
+		return false
+	}
+	td.logf(r, LogWarn, "request for topic '%s' has unexpected Content-Type %q\n", td.TopicARN, ct)
+	return true
+}
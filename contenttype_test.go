@@ -0,0 +1,49 @@
+package gosns_test
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestContentTypeCheck(t *testing.T) {
+	for _, c := range []struct {
+		check       gosns.ContentTypeCheck
+		contentType string
+		status      int
+		logged      bool
+	}{
+		{gosns.ContentTypeLog, "text/plain; charset=UTF-8", http.StatusOK, false},
+		{gosns.ContentTypeLog, "application/x-www-form-urlencoded", http.StatusOK, true},
+		{gosns.ContentTypeStrict, "application/json", http.StatusOK, false},
+		{gosns.ContentTypeStrict, "text/plain; charset=ISO-8859-1", http.StatusUnsupportedMediaType, false},
+		{gosns.ContentTypeStrict, "", http.StatusUnsupportedMediaType, false},
+		{gosns.ContentTypeIgnore, "", http.StatusOK, false},
+	} {
+		var buf logBuffer
+		s := &gosns.Server{Logger: log.New(&buf, "", 0), LogLevel: gosns.LogWarn, ContentTypeCheck: c.check}
+		s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+		ts := gosnstest.NewServer(s)
+		env := gosnstest.NewNotification(ordersARN, "", "hello")
+		if err := ts.Signer.Sign(env); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+		req, err := gosnstest.NewClientRequest("http://example.com/orders", env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", c.contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		logged := strings.Contains(buf.String(), "unexpected Content-Type")
+		if w.Code != c.status || logged != c.logged {
+			t.Errorf("%v %q: status %d, logged %v", c.check, c.contentType, w.Code, logged)
+		}
+	}
+}
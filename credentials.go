@@ -0,0 +1,387 @@
+package gosns
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentialsRefresh is how long before they expire temporary credentials
+// are fetched again.
+const credentialsRefresh = 5 * time.Minute
+
+// maxSourceProfiles limits the chain of profiles which assume roles using
+// each other's credentials.
+const maxSourceProfiles = 5
+
+// credentialsClient fetches temporary credentials.
+var credentialsClient = &http.Client{Timeout: 10 * time.Second}
+
+// Credentials are the AWS credentials used to sign API requests. Those
+// returned by AssumeRole and WebIdentityCredentials, and by LoadCredentials
+// for roles and containers, are temporary and fetched as they are needed,
+// so that their fields are empty; call Retrieve for the current ones. All
+// the package's AWS clients do so, refreshing them before they expire.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Expires, if set, is when temporary credentials stop working.
+	Expires time.Time
+
+	source *credentialsSource
+}
+
+// credentialsSource fetches and caches temporary credentials.
+type credentialsSource struct {
+	fetch func(ctx context.Context) (*Credentials, error)
+
+	mu      sync.Mutex
+	current *Credentials
+}
+
+func temporaryCredentials(fetch func(ctx context.Context) (*Credentials, error)) *Credentials {
+	return &Credentials{source: &credentialsSource{fetch: fetch}}
+}
+
+// Retrieve returns the credentials to sign a request with: c itself if it is
+// static, or else the current temporary credentials, fetched if they are
+// about to expire.
+func (c *Credentials) Retrieve(ctx context.Context) (*Credentials, error) {
+	if c.source == nil {
+		return c, nil
+	}
+	src := c.source
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if cur := src.current; cur != nil && (cur.Expires.IsZero() || time.Until(cur.Expires) > credentialsRefresh) {
+		return cur, nil
+	}
+	cur, err := src.fetch(ctx)
+	if err != nil {
+		if old := src.current; old != nil && time.Now().Before(old.Expires) {
+			// keep using them while they last
+			return old, nil
+		}
+		return nil, err
+	}
+	src.current = cur
+	return cur, nil
+}
+
+// LoadCredentials returns the credentials configured as for the AWS SDKs,
+// trying in turn
+//
+//   - the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+//     environment variables;
+//   - the role in AWS_ROLE_ARN, assumed with the web identity token in
+//     AWS_WEB_IDENTITY_TOKEN_FILE, as on EKS;
+//   - the container credentials endpoint in
+//     AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or _FULL_URI, as on ECS;
+//   - the AWS_PROFILE (or default) profile of the shared credentials and
+//     config files, which may assume a role_arn with the credentials of its
+//     source_profile or its web_identity_token_file.
+//
+// EC2 instance profiles are not supported.
+func LoadCredentials() (*Credentials, error) {
+	c := &Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.AccessKeyID != "" && c.SecretAccessKey != "" {
+		return c, nil
+	}
+	if role, token := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); role != "" && token != "" {
+		return WebIdentityCredentials(LoadRegion(), role, token, os.Getenv("AWS_ROLE_SESSION_NAME")), nil
+	}
+	if u := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); u != "" {
+		return containerCredentials("http://169.254.170.2" + u), nil
+	}
+	if u := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); u != "" {
+		return containerCredentials(u), nil
+	}
+
+	profiles, filename, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return profiles.credentials(awsProfile(), filename, 0)
+}
+
+// LoadRegion returns the region configured as for the AWS SDKs, in the
+// AWS_REGION or AWS_DEFAULT_REGION environment variables or the region of
+// the AWS_PROFILE (or default) profile, or "" if none is.
+func LoadRegion() string {
+	if r := envRegion(); r != "" {
+		return r
+	}
+	profiles, _, _ := loadProfiles()
+	return profiles[awsProfile()]["region"]
+}
+
+func envRegion() string {
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func awsProfile() string {
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p
+	}
+	return "default"
+}
+
+// awsProfiles maps profile names to their settings.
+type awsProfiles map[string]map[string]string
+
+// loadProfiles reads the shared config and credentials files, returning
+// the name of the credentials file for errors.
+func loadProfiles() (awsProfiles, string, error) {
+	credsFile, configFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), os.Getenv("AWS_CONFIG_FILE")
+	if credsFile == "" || configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, "", err
+		}
+		if credsFile == "" {
+			credsFile = filepath.Join(home, ".aws", "credentials")
+		}
+		if configFile == "" {
+			configFile = filepath.Join(home, ".aws", "config")
+		}
+	}
+	profiles := awsProfiles{}
+	configErr := profiles.read(configFile, true)
+	if err := profiles.read(credsFile, false); err != nil && configErr != nil {
+		return nil, "", fmt.Errorf("no AWS credentials in environment or %s", credsFile)
+	}
+	return profiles, credsFile, nil
+}
+
+// read adds the settings in an INI file to p. Sections of the config file
+// are named "profile <name>", except for the default profile.
+func (p awsProfiles) read(filename string, config bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var section map[string]string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if config && name != "default" {
+				if !strings.HasPrefix(name, "profile ") {
+					section = nil
+					continue
+				}
+				name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			}
+			if section = p[name]; section == nil {
+				section = make(map[string]string)
+				p[name] = section
+			}
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || section == nil {
+			continue
+		}
+		section[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return scan.Err()
+}
+
+// credentials returns the credentials of the named profile, depth being
+// the number of profiles which are using them as a source.
+func (p awsProfiles) credentials(name, filename string, depth int) (*Credentials, error) {
+	settings := p[name]
+	c := &Credentials{
+		AccessKeyID:     settings["aws_access_key_id"],
+		SecretAccessKey: settings["aws_secret_access_key"],
+		SessionToken:    settings["aws_session_token"],
+	}
+	if c.AccessKeyID != "" && c.SecretAccessKey != "" {
+		return c, nil
+	}
+	role := settings["role_arn"]
+	if role == "" {
+		return nil, fmt.Errorf("no credentials for profile '%s' in %s", name, filename)
+	}
+	region := envRegion()
+	if region == "" {
+		region = settings["region"]
+	}
+	if token := settings["web_identity_token_file"]; token != "" {
+		return WebIdentityCredentials(region, role, token, settings["role_session_name"]), nil
+	}
+	source := settings["source_profile"]
+	if source == "" {
+		return nil, fmt.Errorf("profile '%s' has a role_arn but no source_profile or web_identity_token_file", name)
+	}
+	if depth >= maxSourceProfiles {
+		return nil, fmt.Errorf("profile '%s': too many source profiles", name)
+	}
+	base, err := p.credentials(source, filename, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return AssumeRole(base, region, role, settings["role_session_name"]), nil
+}
+
+// stsEndpoint returns the STS endpoint URL for region, which may be "" for
+// the global endpoint.
+func stsEndpoint(region string) string {
+	if u := os.Getenv("AWS_ENDPOINT_URL_STS"); u != "" {
+		return u
+	}
+	if region == "" {
+		return "https://sts.amazonaws.com/"
+	}
+	return RegionalEndpoint("sts", region)
+}
+
+// stsCall performs an STS action returning credentials, signed with creds
+// unless they are nil.
+func stsCall(ctx context.Context, creds *Credentials, region string, params url.Values) (*Credentials, error) {
+	action := params.Get("Action")
+	params.Set("Version", "2011-06-15")
+	if params.Get("RoleSessionName") == "" {
+		params.Set("RoleSessionName", fmt.Sprintf("gosns-%d", time.Now().Unix()))
+	}
+	signing := region
+	if signing == "" {
+		signing = "us-east-1"
+	}
+	status, data, err := awsQuery(ctx, credentialsClient, creds, signing, "sts", stsEndpoint(region), params)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", action, params.Get("RoleArn"), err)
+	}
+	if status != http.StatusOK {
+		serr := &SNSError{Action: action, StatusCode: status}
+		var e snsErrorResponse
+		if xml.Unmarshal(data, &e) == nil {
+			serr.Code, serr.Message = e.Error.Code, e.Error.Message
+		}
+		return nil, fmt.Errorf("sts: %w", serr)
+	}
+	var out struct {
+		Result struct {
+			Credentials struct {
+				AccessKeyId     string
+				SecretAccessKey string
+				SessionToken    string
+				Expiration      time.Time
+			}
+		} `xml:",any"`
+	}
+	if err = xml.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", action, params.Get("RoleArn"), err)
+	}
+	sc := out.Result.Credentials
+	if sc.AccessKeyId == "" {
+		return nil, fmt.Errorf("%s %s: no credentials in response", action, params.Get("RoleArn"))
+	}
+	return &Credentials{AccessKeyID: sc.AccessKeyId, SecretAccessKey: sc.SecretAccessKey, SessionToken: sc.SessionToken, Expires: sc.Expiration}, nil
+}
+
+// AssumeRole returns temporary credentials for the role roleARN, assumed
+// with base using STS in region (or the global endpoint if it is "").
+// sessionName, if set, names the sessions in CloudTrail.
+func AssumeRole(base *Credentials, region, roleARN, sessionName string) *Credentials {
+	return temporaryCredentials(func(ctx context.Context) (*Credentials, error) {
+		return stsCall(ctx, base, region, url.Values{
+			"Action":          {"AssumeRole"},
+			"RoleArn":         {roleARN},
+			"RoleSessionName": {sessionName},
+		})
+	})
+}
+
+// WebIdentityCredentials returns temporary credentials for the role roleARN,
+// assumed with the OIDC token in tokenFile, which is read again each time
+// they are refreshed since it is rotated too.
+func WebIdentityCredentials(region, roleARN, tokenFile, sessionName string) *Credentials {
+	return temporaryCredentials(func(ctx context.Context) (*Credentials, error) {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return stsCall(ctx, nil, region, url.Values{
+			"Action":           {"AssumeRoleWithWebIdentity"},
+			"RoleArn":          {roleARN},
+			"RoleSessionName":  {sessionName},
+			"WebIdentityToken": {strings.TrimSpace(string(token))},
+		})
+	})
+}
+
+// containerCredentials returns temporary credentials from the container
+// credentials endpoint at uri, authorized by the token in
+// AWS_CONTAINER_AUTHORIZATION_TOKEN or the file named by
+// AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE.
+func containerCredentials(uri string) *Credentials {
+	return temporaryCredentials(func(ctx context.Context) (*Credentials, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := credentialsClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("container credentials: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+		var out struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			Token           string
+			Expiration      time.Time
+		}
+		if err = json.Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("container credentials: %w", err)
+		}
+		if out.AccessKeyId == "" {
+			return nil, errors.New("container credentials: no credentials in response")
+		}
+		return &Credentials{AccessKeyID: out.AccessKeyId, SecretAccessKey: out.SecretAccessKey, SessionToken: out.Token, Expires: out.Expiration}, nil
+	})
+}
@@ -0,0 +1,176 @@
+package gosns_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// fakeSTS answers AssumeRole and AssumeRoleWithWebIdentity with credentials
+// lasting ttl, numbered by call.
+type fakeSTS struct {
+	t     *testing.T
+	ttl   time.Duration
+	calls int32
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	n := atomic.AddInt32(&f.calls, 1)
+	action := r.Form.Get("Action")
+	switch action {
+	case "AssumeRole":
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDBASE/") {
+			f.t.Errorf("AssumeRole signed with %q", r.Header.Get("Authorization"))
+		}
+	case "AssumeRoleWithWebIdentity":
+		if r.Header.Get("Authorization") != "" || r.Form.Get("WebIdentityToken") != "oidc-token" {
+			f.t.Errorf("web identity request %v, %v", r.Header, r.Form)
+		}
+	}
+	if r.Form.Get("RoleArn") == "arn:aws:iam::123456789012:role/missing" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not authorized</Message></Error></ErrorResponse>`)
+		return
+	}
+	fmt.Fprintf(w, `<%sResponse><%sResult><Credentials>
+		<AccessKeyId>ASIA%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+		<SessionToken>token</SessionToken><Expiration>%s</Expiration>
+	</Credentials></%sResult></%sResponse>`, action, action, n, time.Now().Add(f.ttl).UTC().Format(time.RFC3339), action, action)
+}
+
+func clearAWSEnv(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ROLE_ARN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE"} {
+		t.Setenv(name, "")
+	}
+	dir := t.TempDir()
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+}
+
+func TestAssumeRole(t *testing.T) {
+	clearAWSEnv(t)
+	sts := &fakeSTS{t: t, ttl: time.Hour}
+	ts := httptest.NewServer(sts)
+	defer ts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", ts.URL)
+	ctx := context.Background()
+
+	base := &gosns.Credentials{AccessKeyID: "AKIDBASE", SecretAccessKey: "secret"}
+	creds := gosns.AssumeRole(base, "us-gov-west-1", "arn:aws-us-gov:iam::123456789012:role/reader", "")
+	for i := 0; i < 2; i++ {
+		c, err := creds.Retrieve(ctx)
+		if err != nil || c.AccessKeyID != "ASIA1" || c.SessionToken != "token" || c.Expires.IsZero() {
+			t.Fatalf("retrieved %+v, %v", c, err)
+		}
+	}
+
+	// credentials about to expire are refreshed
+	sts.ttl = time.Minute
+	creds = gosns.AssumeRole(base, "", "arn:aws:iam::123456789012:role/reader", "session")
+	creds.Retrieve(ctx)
+	if c, _ := creds.Retrieve(ctx); c.AccessKeyID != "ASIA3" {
+		t.Errorf("not refreshed: %+v", c)
+	}
+
+	creds = gosns.AssumeRole(base, "", "arn:aws:iam::123456789012:role/missing", "")
+	if _, err := creds.Retrieve(ctx); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	clearAWSEnv(t)
+	sts := &fakeSTS{t: t, ttl: time.Hour}
+	ts := httptest.NewServer(sts)
+	defer ts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", ts.URL)
+	ctx := context.Background()
+
+	if _, err := gosns.LoadCredentials(); err == nil {
+		t.Error("loaded credentials from nowhere")
+	}
+
+	os.WriteFile(os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), []byte("[base]\naws_access_key_id = AKIDBASE\naws_secret_access_key = secret\n"), 0600)
+	os.WriteFile(os.Getenv("AWS_CONFIG_FILE"), []byte(`[default]
+region = eu-west-1
+
+[profile dev]
+role_arn = arn:aws:iam::123456789012:role/dev
+source_profile = base
+region = us-gov-east-1
+
+[profile loop]
+role_arn = arn:aws:iam::123456789012:role/loop
+source_profile = loop
+`), 0600)
+	if r := gosns.LoadRegion(); r != "eu-west-1" {
+		t.Errorf("default region %q", r)
+	}
+	t.Setenv("AWS_PROFILE", "dev")
+	if r := gosns.LoadRegion(); r != "us-gov-east-1" {
+		t.Errorf("dev region %q", r)
+	}
+	creds, err := gosns.LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := creds.Retrieve(ctx); err != nil || !strings.HasPrefix(c.AccessKeyID, "ASIA") {
+		t.Errorf("dev profile gave %+v, %v", c, err)
+	}
+	t.Setenv("AWS_PROFILE", "loop")
+	if _, err := gosns.LoadCredentials(); err == nil {
+		t.Error("loaded a looping profile")
+	}
+
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("oidc-token\n"), 0600)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/pod")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", token)
+	if creds, err = gosns.LoadCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := creds.Retrieve(ctx); err != nil || !strings.HasPrefix(c.AccessKeyID, "ASIA") {
+		t.Errorf("web identity gave %+v, %v", c, err)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	if creds, err = gosns.LoadCredentials(); err != nil || creds.AccessKeyID != "AKIDENV" {
+		t.Errorf("environment gave %+v, %v", creds, err)
+	}
+}
+
+func TestContainerCredentials(t *testing.T) {
+	clearAWSEnv(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "container-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"AccessKeyId": "ASIACONTAINER", "SecretAccessKey": "secret", "Token": "token", "Expiration": %q}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", ts.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "container-token")
+
+	creds, err := gosns.LoadCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := creds.Retrieve(context.Background()); err != nil || c.AccessKeyID != "ASIACONTAINER" || c.SessionToken != "token" {
+		t.Errorf("got %+v, %v", c, err)
+	}
+}
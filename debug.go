@@ -0,0 +1,132 @@
+package gosns
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DebugRequest is a request recorded by a topic with DebugRequests set.
+// Secrets are redacted: the Authorization, Proxy-Authorization and Cookie
+// headers, and the confirmation token in the body and its SubscribeURL,
+// which would let anyone reading the log confirm the subscription.
+type DebugRequest struct {
+	CapturedRequest
+	RequestID  string
+	StatusCode int
+	Reason     string // why the request was rejected, if it was
+	Duration   time.Duration
+}
+
+var (
+	debugRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+	debugTokenField      = regexp.MustCompile(`("Token"\s*:\s*")[^"]*`)
+	debugTokenParam      = regexp.MustCompile(`((?:[?&]|\\u0026)Token=)[^&"\\]*`)
+)
+
+// debugRing holds a topic's most recent requests.
+type debugRing struct {
+	mu    sync.Mutex
+	items []DebugRequest
+	next  int
+	full  bool
+}
+
+func newDebugRing(size int) *debugRing {
+	return &debugRing{items: make([]DebugRequest, size)}
+}
+
+func (d *debugRing) add(req DebugRequest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items[d.next] = req
+	d.next = (d.next + 1) % len(d.items)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// list returns the recorded requests, oldest first.
+func (d *debugRing) list() []DebugRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.full {
+		return append([]DebugRequest(nil), d.items[:d.next]...)
+	}
+	return append(append([]DebugRequest(nil), d.items[d.next:]...), d.items[:d.next]...)
+}
+
+// RecentRequests returns the requests to the topic recorded because
+// DebugRequests is set, oldest first.
+func (t *Topic) RecentRequests() []DebugRequest {
+	t.startOnce.Do(t.start)
+	if t.debug == nil {
+		return nil
+	}
+	return t.debug.list()
+}
+
+// debugWriter records the outcome of a request for the topic's debug ring.
+type debugWriter struct {
+	http.ResponseWriter
+	req    DebugRequest
+	ring   *debugRing
+	status int
+	reason string
+	start  time.Time
+}
+
+// debugRequest starts recording r, returning the writer to respond through.
+// Call finish once the response has been written.
+func (t *Topic) debugRequest(w http.ResponseWriter, r *http.Request) *debugWriter {
+	body, err := bufferBody(r)
+	if err != nil {
+		t.logf(r, LogWarn, "error reading body for debugging: %v\n", err)
+	}
+	header := r.Header.Clone()
+	for _, name := range debugRedactedHeaders {
+		if _, ok := header[name]; ok {
+			header.Set(name, DefaultRedaction)
+		}
+	}
+	text := debugTokenField.ReplaceAllString(string(body), "${1}"+DefaultRedaction)
+	text = debugTokenParam.ReplaceAllString(text, "${1}"+DefaultRedaction)
+	return &debugWriter{
+		ResponseWriter: w,
+		ring:           t.debug,
+		start:          time.Now(),
+		req: DebugRequest{
+			CapturedRequest: CapturedRequest{
+				Time:   time.Now().UTC(),
+				Method: r.Method,
+				URI:    r.RequestURI,
+				Host:   r.Host,
+				Header: header,
+				Body:   text,
+			},
+			RequestID: RequestID(r.Context()),
+		},
+	}
+}
+
+func (dw *debugWriter) WriteHeader(code int) {
+	if dw.status == 0 {
+		dw.status = code
+	}
+	dw.ResponseWriter.WriteHeader(code)
+}
+
+func (dw *debugWriter) Write(p []byte) (int, error) {
+	if dw.status == 0 {
+		dw.status = http.StatusOK
+	}
+	return dw.ResponseWriter.Write(p)
+}
+
+func (dw *debugWriter) finish() {
+	dw.req.StatusCode = dw.status
+	dw.req.Reason = dw.reason
+	dw.req.Duration = time.Since(dw.start)
+	dw.ring.add(dw.req)
+}
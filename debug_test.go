@@ -0,0 +1,94 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestDebugRequests(t *testing.T) {
+	s := &gosns.Server{}
+	topic := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	topic.DebugRequests = 2
+	s.AddTopic("arn:aws:sns:us-east-1:123456789012:quiet", "/quiet", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	if _, _, err := ts.Confirm("/orders", ordersARN); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ts.Notify("/orders", "arn:aws:sns:us-east-1:123456789012:other", "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	resp, env, err := ts.Notify("/orders", ordersARN, "", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := topic.RecentRequests()
+	if len(got) != 2 {
+		t.Fatalf("kept %d requests, want 2", len(got))
+	}
+	if got[0].StatusCode != http.StatusForbidden || got[0].Reason != "topic_arn_mismatch" {
+		t.Errorf("rejected request recorded as %d %q", got[0].StatusCode, got[0].Reason)
+	}
+	last := got[1]
+	if last.StatusCode != http.StatusOK || last.Reason != "" || !strings.Contains(last.Body, env.MessageId) ||
+		last.RequestID != resp.Header.Get(gosns.RequestIDHeader) || last.Header.Get("X-Amz-Sns-Message-Type") != "Notification" {
+		t.Errorf("got %+v", last)
+	}
+
+	admin := httptest.NewServer(s.AdminHandler())
+	defer admin.Close()
+	r, err := http.Get(admin.URL + "/debug/requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	var all map[string][]gosns.DebugRequest
+	if err = json.NewDecoder(r.Body).Decode(&all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || len(all["/orders"]) != 2 {
+		t.Errorf("admin API gave %v", all)
+	}
+	if r, err = http.Get(admin.URL + "/debug/requests?endpoint=/quiet"); err != nil || r.StatusCode != http.StatusNotFound {
+		t.Errorf("topic without DebugRequests gave %v, %v", r, err)
+	}
+}
+
+func TestDebugRequestsRedacted(t *testing.T) {
+	s := &gosns.Server{}
+	topic := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	topic.DebugRequests = 4
+	topic.Username, topic.Password = "sns", "s3cret"
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	conf := gosnstest.NewSubscriptionConfirmation(ordersARN, "")
+	conf.SubscribeURL = ts.SubscribeURL(ordersARN, conf.Token)
+	data, _ := json.Marshal(conf)
+	req, _ := http.NewRequest("POST", ts.URL+"/orders", strings.NewReader(string(data)))
+	req.Header.Set("x-amz-sns-message-type", "SubscriptionConfirmation")
+	req.Header.Set("x-amz-sns-topic-arn", ordersARN)
+	req.SetBasicAuth("sns", "s3cret")
+	if _, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	got := topic.RecentRequests()
+	if len(got) != 1 {
+		t.Fatalf("kept %d requests", len(got))
+	}
+	if strings.Contains(got[0].Body, conf.Token) || got[0].Header.Get("Authorization") != gosns.DefaultRedaction {
+		t.Errorf("secrets were recorded: %+v", got[0])
+	}
+	if !strings.Contains(got[0].Body, conf.MessageId) {
+		t.Errorf("body was not recorded: %s", got[0].Body)
+	}
+}
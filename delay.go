@@ -0,0 +1,123 @@
+package gosns
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// delayedMessage is the persisted form of a message waiting for its
+// NotBefore time.
+type delayedMessage struct {
+	Endpoint string
+	Due      time.Time
+	Message  *Message
+}
+
+func delayedKey(endpoint, messageID string) string {
+	return "delayed/" + url.PathEscape(endpoint) + "/" + url.PathEscape(messageID)
+}
+
+// schedule arranges for msg to be dispatched at due. If persist is true and
+// the server has a Store, the message is saved so that it survives a restart.
+func (t *Topic) schedule(msg *Message, due time.Time, persist bool) {
+	s := t.server
+	key := delayedKey(t.endpoint, msg.MessageId)
+	if persist && s.Store != nil {
+		data, err := json.Marshal(&delayedMessage{Endpoint: t.endpoint, Due: due, Message: msg})
+		if err == nil {
+			err = s.Store.Put(key, data)
+		}
+		if err != nil {
+			s.logf(LogError, "error persisting delayed message %s: %v\n", msg.MessageId, err)
+		}
+	}
+
+	var fire func()
+	fire = func() {
+		if t.Paused() || !t.dispatch(msg) {
+			// the topic is paused or overloaded, and this message was
+			// already accepted
+			time.AfterFunc(time.Second, fire)
+			return
+		}
+		if s.Store != nil {
+			if err := s.Store.Delete(key); err != nil {
+				s.logf(LogError, "error removing delayed message %s: %v\n", msg.MessageId, err)
+			}
+		}
+	}
+	time.AfterFunc(time.Until(due), fire)
+}
+
+// restoreDelayed reschedules delayed messages persisted by a previous run.
+func (s *Server) restoreDelayed() error {
+	keys, err := s.Store.List("delayed/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, err := s.Store.Get(key)
+		if err != nil {
+			return err
+		}
+		var dm delayedMessage
+		if err = json.Unmarshal(data, &dm); err != nil || dm.Message == nil {
+			s.logf(LogWarn, "discarding unreadable delayed message '%s'\n", key)
+			s.Store.Delete(key)
+			continue
+		}
+		td, ok := s.topic(dm.Endpoint)
+		if !ok {
+			s.logf(LogWarn, "discarding delayed message %s for removed endpoint '%s'\n", dm.Message.MessageId, dm.Endpoint)
+			s.Store.Delete(key)
+			continue
+		}
+		dm.Message.ctx = td.withTenant(dm.Message.Context())
+		td.schedule(dm.Message, dm.Due, false)
+	}
+	if len(keys) > 0 {
+		s.logf(LogInfo, "Restored %d delayed messages\n", len(keys))
+	}
+	return nil
+}
+
+// parseNotBefore accepts RFC 3339 timestamps or (possibly fractional) Unix
+// seconds.
+func parseNotBefore(v string) time.Time {
+	if tm, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return tm
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second)))
+	}
+	return time.Time{}
+}
+
+// NotBeforeAttribute returns a Topic.NotBefore function which reads the time
+// from the named message attribute, as RFC 3339 or Unix seconds.
+func NotBeforeAttribute(name string) func(*Message) time.Time {
+	return func(msg *Message) time.Time {
+		return parseNotBefore(msg.MessageAttributes[name].Value)
+	}
+}
+
+// NotBeforeField returns a Topic.NotBefore function which reads the time from
+// a top-level field of a JSON message body, as an RFC 3339 string or a number
+// of Unix seconds.
+func NotBeforeField(name string) func(*Message) time.Time {
+	return func(msg *Message) time.Time {
+		var body map[string]interface{}
+		if json.Unmarshal([]byte(msg.Message), &body) != nil {
+			return time.Time{}
+		}
+		switch v := body[name].(type) {
+		case string:
+			return parseNotBefore(v)
+		case float64:
+			return time.Unix(0, int64(v*float64(time.Second)))
+		}
+		return time.Time{}
+	}
+}
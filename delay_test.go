@@ -0,0 +1,116 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// delayedNotification returns a notification carrying its due time in the
+// deliver_at attribute.
+func delayedNotification(message string, due time.Time) *gosnstest.Envelope {
+	e := gosnstest.NewNotification(ordersARN, "", message)
+	e.MessageAttributes = map[string]gosns.MessageAttribute{
+		"deliver_at": {Type: "String", Value: due.Format(time.RFC3339Nano)},
+	}
+	return e
+}
+
+func TestNotBefore(t *testing.T) {
+	got := make(chan time.Time, 4)
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- time.Now()
+		}
+	})
+	orders.NotBefore = gosns.NotBeforeAttribute("deliver_at")
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	due := time.Now().Add(300 * time.Millisecond)
+	if resp, err := ts.Send("/orders", delayedNotification("later", due)); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("delayed message gave %v, %v", resp, err)
+	}
+	select {
+	case at := <-got:
+		if at.Before(due) {
+			t.Errorf("delayed message handled %s early", due.Sub(at))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("delayed message was not handled")
+	}
+
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "now"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("message without a due time gave %v, %v", resp, err)
+	}
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message without a due time was not handled")
+	}
+}
+
+func TestNotBeforeRestored(t *testing.T) {
+	store := &gosns.MemoryStore{}
+	first := &gosns.Server{Store: store}
+	orders := first.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	orders.NotBefore = gosns.NotBeforeAttribute("deliver_at")
+	ts := gosnstest.NewServer(first)
+	defer ts.Close()
+
+	due := time.Now().Add(500 * time.Millisecond)
+	e := delayedNotification("later", due)
+	if resp, err := ts.Send("/orders", e); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("delayed message gave %v, %v", resp, err)
+	}
+	// the first server goes away before the message is due
+	orders.Pause()
+	if keys, err := store.List("delayed/"); err != nil || len(keys) != 1 {
+		t.Fatalf("stored %v, %v", keys, err)
+	}
+
+	got := make(chan *gosns.Message, 1)
+	second := &gosns.Server{Store: store}
+	second.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	}).NotBefore = gosns.NotBeforeAttribute("deliver_at")
+	if err := second.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		if msg.MessageId != e.MessageId || msg.Message != "later" || time.Now().Before(due) {
+			t.Errorf("restored %+v at %s, due %s", msg, time.Now(), due)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("restored message was not handled")
+	}
+	if !eventually(func() bool {
+		keys, _ := store.List("delayed/")
+		return len(keys) == 0
+	}) {
+		t.Error("handled message is still stored")
+	}
+}
+
+func TestNotBeforeField(t *testing.T) {
+	due := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	notBefore := gosns.NotBeforeField("deliver_at")
+	for body, want := range map[string]time.Time{
+		`{"deliver_at": "2026-10-14T12:00:00Z"}`: due,
+		`{"deliver_at": 1791979200}`:             due,
+		`{"deliver_at": "soon"}`:                 {},
+		`{}`:                                     {},
+		`not json`:                               {},
+	} {
+		if got := notBefore(&gosns.Message{Message: body}); !got.Equal(want) {
+			t.Errorf("%s: got %s, want %s", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package gosns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// maxRetryAfter limits the Retry-After sent for failed deliveries, as SNS
+// will not delay a retry by more than an hour.
+const maxRetryAfter = time.Hour
+
+// DeliveryMode is when a topic acknowledges a notification to SNS, relative
+// to handling it.
+type DeliveryMode int
+
+const (
+	// AtMostOnce acknowledges a notification as soon as it is accepted,
+	// and then calls Callback. A message whose handling is interrupted,
+	// such as by a crash, is lost, and one which fails is not retried.
+	AtMostOnce DeliveryMode = iota
+
+	// AtLeastOnce calls Callback before answering SNS, acknowledging the
+	// notification only once it returns. If it panics, or calls Fail, the
+	// delivery is refused with ResponseInternalError so that SNS retries
+	// it under the subscription's delivery policy; since SNS waits at
+	// most 15 seconds for a response, callbacks should be quick. With a
+	// Store, each message is journaled while it is handled, and any left
+	// by a crash are handled again by Start.
+	//
+	// Every option which acknowledges a message before handling it is
+	// incompatible, and Start refuses topics using NotBefore, PauseHold,
+	// Workers, QueueSize, KeyFunc or a batch callback with this mode.
+	// RateLimit refuses messages over the limit.
+	AtLeastOnce
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case AtMostOnce:
+		return "AtMostOnce"
+	case AtLeastOnce:
+		return "AtLeastOnce"
+	}
+	return fmt.Sprintf("DeliveryMode(%d)", int(m))
+}
+
+// Fail reports that the callback could not handle the message. In
+// AtLeastOnce mode its delivery is refused so that SNS retries it; in either
+// mode the failure is logged, and any actions the callback enqueued (see
+// Outbox) are discarded. Pass a CallbackError to choose the response. It
+// must be called from the callback.
+func (m *Message) Fail(err error) {
+	if err == nil {
+		err = errors.New("callback failed")
+	}
+	m.failed = err
+}
+
+// CallbackError, passed to Message.Fail, chooses the response to an
+// AtLeastOnce delivery which the callback failed, so that what SNS records
+// in its delivery status logs reflects the outcome.
+type CallbackError struct {
+	Err error
+
+	// StatusCode, if set, replaces the 500 of ResponseInternalError, such
+	// as a 400 for a message which can never be handled, or a 503 for one
+	// which failed because a dependency is down.
+	StatusCode int
+
+	// RetryAfter, if positive, is sent in a Retry-After header, replacing
+	// the topic's RetryBackoff.
+	RetryAfter time.Duration
+}
+
+func (e *CallbackError) Error() string {
+	if e.Err == nil {
+		return "callback failed"
+	}
+	return e.Err.Error()
+}
+
+func (e *CallbackError) Unwrap() error { return e.Err }
+
+// checkDelivery returns an error describing an option which t cannot be
+// used with in its DeliveryMode, if any.
+func (t *Topic) checkDelivery() error {
+	if t.Delivery != AtLeastOnce {
+		return nil
+	}
+	var option string
+	switch {
+	case t.NotBefore != nil:
+		option = "NotBefore"
+	case t.PauseMode == PauseHold:
+		option = "PauseHold"
+	case t.Workers > 0:
+		option = "Workers"
+	case t.QueueSize > 0:
+		option = "QueueSize"
+	case t.KeyFunc != nil:
+		option = "KeyFunc"
+	case t.batch != nil:
+		option = "a batch callback"
+	default:
+		return nil
+	}
+	return fmt.Errorf("gosns: topic at endpoint '%s' cannot use %s with AtLeastOnce delivery", t.endpoint, option)
+}
+
+// checkDeliveries checks the DeliveryMode of every topic.
+func (s *Server) checkDeliveries() error {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	for _, td := range s.topics {
+		if err := td.checkDelivery(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journaledMessage is the persisted form of a message being handled in
+// AtLeastOnce mode.
+type journaledMessage struct {
+	Endpoint string
+	Message  *Message
+}
+
+func journalKey(endpoint, messageID string) string {
+	return "journal/" + url.PathEscape(endpoint) + "/" + url.PathEscape(messageID)
+}
+
+// handle calls the callback for msg on the current goroutine, as
+// AtLeastOnce delivery does, returning ErrCallbackFailed if it panicked or
+// called Fail.
+func (t *Topic) handle(msg *Message) (err error) {
+	t.startOnce.Do(t.start)
+	msg.accepted = time.Now()
+	if t.limiter != nil && !t.limiter.Allow() {
+		return ErrOverloaded
+	}
+	s := t.server
+	key := journalKey(t.endpoint, msg.MessageId)
+	if s.Store != nil {
+		data, err := json.Marshal(&journaledMessage{Endpoint: t.endpoint, Message: msg})
+		if err == nil {
+			err = s.Store.Put(key, data)
+		}
+		if err != nil {
+			s.logf(LogError, "error journaling message %s: %v\n", msg.MessageId, err)
+		}
+		defer func() {
+			if err := s.Store.Delete(key); err != nil {
+				s.logf(LogError, "error removing journaled message %s: %v\n", msg.MessageId, err)
+			}
+		}()
+	}
+
+	atomic.AddInt64(&t.inflight, 1)
+	defer func() {
+		if p := recover(); p != nil {
+			// call's deferred decrement has already run
+			t.logf(nil, LogError, "callback for message %s on topic '%s' panicked: %v\n", msg.MessageId, t.TopicARN, p)
+			msg.outbox = nil
+			err = fmt.Errorf("%w: panic: %v", ErrCallbackFailed, p)
+		}
+	}()
+	t.call(msg)
+	if msg.failed != nil {
+		return fmt.Errorf("%w: %w", ErrCallbackFailed, msg.failed)
+	}
+	return nil
+}
+
+// failed counts a delivery of the message with the given id which its
+// callback or a Transformer failed, returning the number of its deliveries
+// which have failed in a row.
+func (t *Topic) failed(id string) int {
+	t.failMu.Lock()
+	defer t.failMu.Unlock()
+	now := time.Now()
+	if t.failures == nil {
+		t.failures = make(map[string]*failures)
+	}
+	if len(t.failures) >= 64 && len(t.failures) >= 2*t.failuresLast {
+		// forget messages SNS has stopped retrying
+		for id, f := range t.failures {
+			if now.Sub(f.last) > 2*maxRetryAfter {
+				delete(t.failures, id)
+			}
+		}
+		t.failuresLast = len(t.failures)
+	}
+	f := t.failures[id]
+	if f == nil {
+		f = &failures{}
+		t.failures[id] = f
+	}
+	f.count++
+	f.last = now
+	return f.count
+}
+
+// succeeded forgets the failures of the message with the given id.
+func (t *Topic) succeeded(id string) {
+	t.failMu.Lock()
+	delete(t.failures, id)
+	t.failMu.Unlock()
+	t.forgetFailures(id)
+}
+
+// failures records the failed deliveries of a message.
+type failures struct {
+	count int
+	last  time.Time
+}
+
+// retryAfter returns the Retry-After for the failed delivery of a message,
+// its attempt'th in a row: RetryBackoff doubled for each attempt after the
+// first.
+func (t *Topic) retryAfter(attempt int) time.Duration {
+	if t.RetryBackoff <= 0 || attempt < 1 {
+		return 0
+	}
+	delay := t.RetryBackoff
+	for i := 1; i < attempt && delay < maxRetryAfter; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay
+}
+
+// rejectFailed answers a delivery whose AtLeastOnce callback failed with
+// err, the attempt'th failure of the message in a row, as chosen by a
+// CallbackError or the topic's RetryBackoff.
+func (s *Server) rejectFailed(w http.ResponseWriter, r *http.Request, td *Topic, attempt int, err error) int {
+	var status int
+	var retry time.Duration
+	var ce *CallbackError
+	if errors.As(err, &ce) {
+		status, retry = ce.StatusCode, ce.RetryAfter
+	}
+	if retry <= 0 {
+		retry = td.retryAfter(attempt)
+	}
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+	}
+	if dw, ok := w.(*debugWriter); ok {
+		dw.reason = ReasonCallbackFailed
+	}
+	code := s.respondStatus(w, r, ResponseInternalError, status, ReasonCallbackFailed, err)
+	s.logReject(r, LogWarn, ReasonCallbackFailed, code, r.Header.Get("x-amz-sns-topic-arn"), "")
+	return code
+}
+
+// restoreJournal handles again the messages journaled by a previous run
+// which did not finish handling them.
+func (s *Server) restoreJournal() error {
+	keys, err := s.Store.List("journal/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, err := s.Store.Get(key)
+		if err != nil {
+			return err
+		}
+		var jm journaledMessage
+		if err = json.Unmarshal(data, &jm); err != nil || jm.Message == nil {
+			s.logf(LogWarn, "discarding unreadable journaled message '%s'\n", key)
+			s.Store.Delete(key)
+			continue
+		}
+		td, ok := s.topic(jm.Endpoint)
+		if !ok {
+			s.logf(LogWarn, "discarding journaled message %s for removed endpoint '%s'\n", jm.Message.MessageId, jm.Endpoint)
+			s.Store.Delete(key)
+			continue
+		}
+		go func(msg *Message) {
+			if err := td.handle(msg); err != nil {
+				td.logf(nil, LogError, "error handling journaled message %s: %v\n", msg.MessageId, err)
+			}
+		}(jm.Message)
+	}
+	if len(keys) > 0 {
+		s.logf(LogInfo, "Handling %d journaled messages left by the last run\n", len(keys))
+	}
+	return nil
+}
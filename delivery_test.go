@@ -0,0 +1,134 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestAtLeastOnce(t *testing.T) {
+	var handled int32
+	store := &gosns.MemoryStore{}
+	s := &gosns.Server{Store: store}
+	tp := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg == nil {
+			return
+		}
+		if keys, _ := store.List("journal/"); len(keys) != 1 {
+			t.Errorf("journal holds %q while handling", keys)
+		}
+		switch msg.Message {
+		case "fail":
+			msg.Fail(errors.New("database down"))
+		case "panic":
+			panic("bug")
+		default:
+			atomic.AddInt32(&handled, 1)
+		}
+	})
+	tp.Delivery = gosns.AtLeastOnce
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "ok")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v, %v", resp, err)
+	}
+	if atomic.LoadInt32(&handled) != 1 {
+		t.Error("acknowledged before the callback returned")
+	}
+	for _, body := range []string{"fail", "panic"} {
+		resp, _, err := ts.Notify("/orders", ordersARN, "", body)
+		if err != nil || resp.StatusCode != http.StatusInternalServerError || resp.Header.Get(gosns.ReasonHeader) != gosns.ReasonCallbackFailed {
+			t.Errorf("%s gave %v, %v", body, resp, err)
+		}
+	}
+	if keys, _ := store.List("journal/"); len(keys) != 0 {
+		t.Errorf("journal left holding %q", keys)
+	}
+}
+
+func TestCallbackOutcome(t *testing.T) {
+	var calls int32
+	s := &gosns.Server{}
+	tp := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg == nil {
+			return
+		}
+		switch n := atomic.AddInt32(&calls, 1); {
+		case msg.Message == "unavailable":
+			msg.Fail(&gosns.CallbackError{Err: errors.New("db down"), StatusCode: http.StatusServiceUnavailable, RetryAfter: 90 * time.Second})
+		case n <= 3:
+			msg.Fail(errors.New("not yet"))
+		}
+	})
+	tp.Delivery, tp.RetryBackoff = gosns.AtLeastOnce, 10*time.Second
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	e := gosnstest.NewNotification(ordersARN, "", "retried")
+	for _, want := range []string{"10", "20", "40", ""} {
+		resp, err := ts.Send("/orders", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Retry-After"); got != want {
+			t.Errorf("Retry-After %q, want %q (%s)", got, want, resp.Status)
+		}
+	}
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "unavailable")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "90" {
+		t.Errorf("%v, %v", resp, err)
+	}
+}
+
+func TestAtLeastOnceJournal(t *testing.T) {
+	store := &gosns.MemoryStore{}
+	data, _ := json.Marshal(map[string]interface{}{
+		"Endpoint": "/orders",
+		"Message":  &gosns.Message{MessageId: "m1", TopicArn: ordersARN, Message: "interrupted"},
+	})
+	store.Put("journal/%2Forders/m1", data)
+
+	got := make(chan string, 1)
+	s := &gosns.Server{Store: store}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	}).Delivery = gosns.AtLeastOnce
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "interrupted" {
+			t.Errorf("handled %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("journaled message not handled")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if keys, _ := store.List("journal/"); len(keys) == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("journal still holds %q", keys)
+		}
+	}
+}
+
+func TestAtLeastOnceOptions(t *testing.T) {
+	s := &gosns.Server{}
+	tp := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	tp.Delivery, tp.Workers = gosns.AtLeastOnce, 4
+	if err := s.Start(); err == nil || !strings.Contains(err.Error(), "Workers") {
+		t.Errorf("Start gave %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package gosns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dependencyPoll is how often a wait for dependencies checks them.
+const dependencyPoll = 100 * time.Millisecond
+
+// Dependency is something a topic or HandlerGroup waits for; see
+// Topic.DependsOn. Topics and HandlerGroups are dependencies, and ReadyFunc
+// makes one of any check.
+type Dependency interface {
+	Ready() bool
+}
+
+// ReadyFunc is a Dependency which is ready whenever the function returns
+// true, such as once a check that the database is migrated has passed.
+type ReadyFunc func() bool
+
+// Ready calls f.
+func (f ReadyFunc) Ready() bool { return f() }
+
+// Ready reports whether the topic's dependencies are ready and its Init, if
+// it has one, has succeeded.
+func (t *Topic) Ready() bool {
+	if !t.dependenciesReady() {
+		return false
+	}
+	if t.Init == nil {
+		return true
+	}
+	t.initMu.Lock()
+	defer t.initMu.Unlock()
+	return t.initDone && t.initErr == nil
+}
+
+// Ready reports whether the group is started and its dependencies are
+// ready.
+func (g *HandlerGroup) Ready() bool {
+	return g.Started() && allReady(g.DependsOn)
+}
+
+// dependenciesReady reports whether the topic's dependencies, and those of
+// its group, are ready.
+func (t *Topic) dependenciesReady() bool {
+	if !allReady(t.DependsOn) {
+		return false
+	}
+	return t.group == nil || allReady(t.group.DependsOn)
+}
+
+func allReady(deps []Dependency) bool {
+	for _, d := range deps {
+		if !d.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// waitReady waits until ready returns true or ctx is done.
+func waitReady(ctx context.Context, ready func() bool) error {
+	if ready() {
+		return nil
+	}
+	tick := time.NewTicker(dependencyPoll)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if ready() {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// confirmWhenReady confirms a subscription once the topic's dependencies
+// are ready, or gives up if the server drains or the confirmation expires.
+func (s *Server) confirmWhenReady(td *Topic, env *envelope) {
+	sent, err := parseTimestamp(env.Timestamp, s.TimestampLayouts)
+	if err != nil {
+		s.logf(LogWarn, "confirmation for topic '%s' has an invalid Timestamp %q, giving it %s from now: %v\n", env.TopicArn, env.Timestamp, ConfirmationLifetime, err)
+		sent = time.Now()
+	}
+	ctx, cancel := context.WithDeadline(s.context(), sent.Add(ConfirmationLifetime))
+	defer cancel()
+	if err := waitReady(ctx, td.dependenciesReady); err != nil {
+		s.logf(LogError, "gave up confirming subscription of '%s' to topic '%s' waiting for its dependencies: %v\n", td.endpoint, env.TopicArn, err)
+		return
+	}
+	if _, err := s.confirm(td.endpoint, env.TopicArn, env.SubscribeURL); err != nil {
+		s.logf(LogError, "error confirming subscription: %v\n", err)
+		return
+	}
+	s.logf(LogInfo, "Endpoint '%s' confirmed subscription for topic '%s' once its dependencies were ready\n", td.endpoint, td.TopicARN)
+}
+
+// dependencyName describes d in errors.
+func dependencyName(d Dependency) string {
+	switch d := d.(type) {
+	case *Topic:
+		return fmt.Sprintf("topic '%s'", d.endpoint)
+	case *HandlerGroup:
+		return fmt.Sprintf("group '%s'", d.Name)
+	}
+	return fmt.Sprintf("%T", d)
+}
+
+// dependenciesOf returns what d waits for, if it is a topic or group.
+func dependenciesOf(d Dependency) []Dependency {
+	switch d := d.(type) {
+	case *Topic:
+		if d.group != nil {
+			return append(append([]Dependency(nil), d.DependsOn...), d.group.DependsOn...)
+		}
+		return d.DependsOn
+	case *HandlerGroup:
+		return d.DependsOn
+	}
+	return nil
+}
+
+// checkDependencies returns an error if the dependencies of the server's
+// topics and groups form a cycle, which would never become ready.
+func (s *Server) checkDependencies() error {
+	s.topicsMu.RLock()
+	var all []Dependency
+	for _, td := range s.topics {
+		all = append(all, td)
+	}
+	for _, g := range s.groups {
+		all = append(all, g)
+	}
+	s.topicsMu.RUnlock()
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[Dependency]int)
+	var path []Dependency
+	var visit func(d Dependency) error
+	visit = func(d Dependency) error {
+		switch d.(type) {
+		case *Topic, *HandlerGroup:
+		default:
+			return nil // other dependencies wait for nothing we know of
+		}
+		switch state[d] {
+		case visited:
+			return nil
+		case visiting:
+			var names []string
+			for i := len(path) - 1; i >= 0; i-- {
+				names = append([]string{dependencyName(path[i])}, names...)
+				if path[i] == d {
+					break
+				}
+			}
+			return fmt.Errorf("gosns: dependency cycle: %s -> %s", strings.Join(names, " -> "), dependencyName(d))
+		}
+		state[d] = visiting
+		path = append(path, d)
+		for _, dep := range dependenciesOf(d) {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[d] = visited
+		return nil
+	}
+	for _, d := range all {
+		if err := visit(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
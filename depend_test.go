@@ -0,0 +1,106 @@
+package gosns_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// eventually waits up to five seconds for cond.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDependsOn(t *testing.T) {
+	const migrationsARN = "arn:aws:sns:us-east-1:123456789012:migrations"
+	var migrated int32
+	s := &gosns.Server{}
+	check := s.AddTopic(migrationsARN, "/migrations", func(*gosns.Message) {})
+	check.Init = func(context.Context) error { return nil }
+	orders := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	orders.DependsOn = []gosns.Dependency{check, gosns.ReadyFunc(func() bool { return atomic.LoadInt32(&migrated) != 0 })}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "early")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(gosns.ReasonHeader) != gosns.ReasonNotReady {
+		t.Errorf("topic with unready dependencies gave %v, %v", resp, err)
+	}
+	resp, e, err := ts.Confirm("/orders", ordersARN)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation gave %v, %v", resp, err)
+	}
+
+	if check.Ready() {
+		t.Error("topic ready before its Init ran")
+	}
+	if resp, _, err := ts.Confirm("/migrations", migrationsARN); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation gave %v, %v", resp, err)
+	}
+	if !eventually(check.Ready) {
+		t.Fatal("topic not ready after its Init")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := ts.Confirmed(e.Token); ok || orders.Ready() {
+		t.Fatal("subscription confirmed before the migration check passed")
+	}
+
+	atomic.StoreInt32(&migrated, 1)
+	if !eventually(func() bool { _, ok := ts.Confirmed(e.Token); return ok }) {
+		t.Fatal("subscription not confirmed once dependencies were ready")
+	}
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("ready topic gave %v, %v", resp, err)
+	}
+}
+
+func TestGroupDependsOn(t *testing.T) {
+	var ready int32
+	s := &gosns.Server{}
+	db := s.NewHandlerGroup("db")
+	db.DependsOn = []gosns.Dependency{gosns.ReadyFunc(func() bool { return atomic.LoadInt32(&ready) != 0 })}
+	db.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	reports := s.NewHandlerGroup("reports")
+	reports.DependsOn = []gosns.Dependency{db}
+	reports.AddTopic(ordersARN, "/reports", func(*gosns.Message) {})
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if db.Started() || reports.Started() {
+		t.Fatal("groups started before their dependencies were ready")
+	}
+	atomic.StoreInt32(&ready, 1)
+	if !eventually(reports.Ready) || !db.Ready() {
+		t.Error("groups not started once their dependencies were ready")
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	billing := s.NewHandlerGroup("billing")
+	billing.AddTopic(ordersARN, "/invoices", func(*gosns.Message) {})
+	orders.DependsOn = []gosns.Dependency{billing}
+	billing.DependsOn = []gosns.Dependency{orders}
+
+	err := s.Start()
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") || !strings.Contains(err.Error(), "group 'billing'") {
+		t.Errorf("got %v", err)
+	}
+}
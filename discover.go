@@ -0,0 +1,79 @@
+package gosns
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// Discover registers a topic for every confirmed http(s) subscription in
+// the client's region whose endpoint is under baseURL, the server's public
+// URL, so that the topics handled need not be repeated in code. An endpoint
+// is under baseURL if it has the same scheme, host and port, and its path is
+// baseURL's path or below it: a base of /api covers /api/orders but not
+// /apiv2. Endpoints already registered keep their topic; the subscriptions
+// found are recorded for Strict mode either way. The topics added are
+// returned so that their options can be set before the server is started.
+func (s *Server) Discover(ctx context.Context, client *SNSClient, baseURL string, callback func(*Message)) ([]*Topic, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := client.ListSubscriptions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var added []*Topic
+	for _, sub := range subs {
+		if sub.Protocol != "http" && sub.Protocol != "https" {
+			continue
+		}
+		if sub.SubscriptionArn == "PendingConfirmation" {
+			s.logf(LogInfo, "Discover: skipping unconfirmed subscription of '%s' to '%s'\n", sub.Endpoint, sub.TopicArn)
+			continue
+		}
+		u, err := url.Parse(sub.Endpoint)
+		if err != nil || !underBase(u, base) {
+			continue
+		}
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+
+		td, ok := s.topic(path)
+		if !ok {
+			td = s.AddTopic(sub.TopicArn, path, callback)
+			added = append(added, td)
+		} else if !td.matches(sub.TopicArn) {
+			s.logf(LogWarn, "Discover: endpoint '%s' is registered for '%s', ignoring subscription to '%s'\n",
+				path, td.TopicARN, sub.TopicArn)
+			continue
+		}
+		td.addSubscription(sub.SubscriptionArn)
+	}
+	return added, nil
+}
+
+// underBase reports whether u has the scheme, host and port of base, and a
+// path equal to or below its path.
+func underBase(u, base *url.URL) bool {
+	if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Hostname(), base.Hostname()) ||
+		urlPort(u) != urlPort(base) {
+		return false
+	}
+	prefix := strings.TrimSuffix(base.Path, "/")
+	return prefix == "" || u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")
+}
+
+// urlPort returns the port of u, or the default port of its scheme.
+func urlPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if strings.EqualFold(u.Scheme, "http") {
+		return "80"
+	}
+	return "443"
+}
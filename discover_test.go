@@ -0,0 +1,33 @@
+package gosns
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestUnderBase(t *testing.T) {
+	for _, c := range []struct {
+		endpoint, base string
+		want           bool
+	}{
+		{"https://hooks.example.com/a", "https://hooks.example.com", true},
+		{"https://hooks.example.com/a", "https://hooks.example.com/", true},
+		{"https://HOOKS.example.com/a", "https://hooks.example.com", true},
+		{"https://hooks.example.com:443/a", "https://hooks.example.com", true},
+		{"http://hooks.example.com:80/a", "http://hooks.example.com", true},
+		{"https://hooks.example.com/api", "https://hooks.example.com/api", true},
+		{"https://hooks.example.com/api/a", "https://hooks.example.com/api", true},
+		{"https://hooks.example.com/api/a", "https://hooks.example.com/api/", true},
+		{"https://hooks.example.com/apiv2/a", "https://hooks.example.com/api", false},
+		{"https://hooks.example.com/a", "https://hooks.example.com/api", false},
+		{"http://hooks.example.com/a", "https://hooks.example.com", false},
+		{"https://hooks.example.com:8443/a", "https://hooks.example.com", false},
+		{"https://other.example.com/a", "https://hooks.example.com", false},
+	} {
+		u, _ := url.Parse(c.endpoint)
+		base, _ := url.Parse(c.base)
+		if got := underBase(u, base); got != c.want {
+			t.Errorf("underBase(%q, %q) = %v, want %v", c.endpoint, c.base, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gosns
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Draining reports whether Drain has been called.
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// Drain prepares the server to stop without losing messages, for deploys
+// behind a load balancer. Health probes (see ProbePath) start failing with
+// ResponseDraining at once, while deliveries are still handled. After delay,
+// time for the balancer to notice, a server started with ListenAndServe or
+// Serve stops accepting connections and finishes the requests in progress.
+// Drain then delivers partial batches and waits for the callbacks of every
+// accepted message to return, or for ctx to be done, and stops any
+// HandlerGroups.
+//
+// Messages delayed by NotBefore are not waited for. They survive a restart
+// only if the server has a Store.
+func (s *Server) Drain(ctx context.Context, delay time.Duration) error {
+	atomic.StoreInt32(&s.draining, 1)
+	s.context()
+	s.cancel()
+	s.logf(LogInfo, "Draining, waiting %s for traffic to stop\n", delay)
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.srvMu.Lock()
+	srv := s.srv
+	s.srvMu.Unlock()
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.topicsMu.RLock()
+	topics := make([]*Topic, 0, len(s.topics))
+	for _, t := range s.topics {
+		topics = append(topics, t)
+	}
+	s.topicsMu.RUnlock()
+	for _, t := range topics {
+		if t.batch != nil {
+			t.batch.expire()
+		}
+	}
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for _, t := range topics {
+		for atomic.LoadInt64(&t.inflight) > 0 {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if err := s.stopGroups(ctx); err != nil {
+		return err
+	}
+	s.logf(LogInfo, "Drained\n")
+	return nil
+}
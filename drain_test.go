@@ -0,0 +1,126 @@
+package gosns_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestDrain(t *testing.T) {
+	s := &gosns.Server{ProbePath: "/health"}
+	started, release := make(chan bool, 1), make(chan bool)
+	topic := s.AddTopic("arn:aws:sns:us-east-1:123456789012:orders", "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			started <- true
+			<-release
+		}
+	})
+	batched := make(chan int, 1)
+	batch := s.AddBatchTopic("arn:aws:sns:us-east-1:123456789012:events", "/events", 10, time.Hour,
+		func(_ context.Context, msgs []*gosns.Message) error {
+			batched <- len(msgs)
+			return nil
+		})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	probe := func() int {
+		resp, err := http.Get(ts.URL + "/health")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("probe gave %d before draining", code)
+	}
+	if _, _, err := ts.Notify("/orders", topic.TopicARN, "", "slow"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	for i := 0; i < 2; i++ {
+		if _, _, err := ts.Notify("/events", batch.TopicARN, "", "event"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- s.Drain(context.Background(), 20*time.Millisecond) }()
+	time.Sleep(5 * time.Millisecond)
+	if code := probe(); code != http.StatusServiceUnavailable || !s.Draining() {
+		t.Errorf("probe gave %d while draining", code)
+	}
+	if n := <-batched; n != 2 {
+		t.Errorf("drain delivered a batch of %d", n)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drain returned %v with a callback running", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	s := &gosns.Server{}
+	started, release := make(chan bool, 1), make(chan bool)
+	defer close(release)
+	topic := s.AddTopic("arn:aws:sns:us-east-1:123456789012:orders", "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			started <- true
+			<-release
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	if _, _, err := ts.Notify("/orders", topic.TopicARN, "", "stuck"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx, 0); err != context.DeadlineExceeded {
+		t.Errorf("drain gave %v", err)
+	}
+}
+
+func TestServeDrain(t *testing.T) {
+	s := &gosns.Server{ProbePath: "/health"}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+
+	url := "http://" + ln.Addr().String() + "/health"
+	resp, err := http.Get(url)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("probe gave %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if err = s.Drain(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v", err)
+	}
+	if _, err = http.Get(url); err == nil {
+		t.Error("still accepting connections after draining")
+	}
+}
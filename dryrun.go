@@ -0,0 +1,19 @@
+package gosns
+
+import "sync/atomic"
+
+// dryRun reports whether the topic, or the whole server, is in DryRun mode,
+// counting the message about to skip its callback if so.
+func (t *Topic) dryRun() bool {
+	if !t.DryRun && (t.server == nil || !t.server.DryRun) {
+		return false
+	}
+	atomic.AddUint64(&t.dryRuns, 1)
+	return true
+}
+
+// DryRuns returns the number of messages to the topic acknowledged without
+// calling Callback because of DryRun since the server started.
+func (t *Topic) DryRuns() uint64 {
+	return atomic.LoadUint64(&t.dryRuns)
+}
@@ -0,0 +1,62 @@
+package gosns_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestDryRun(t *testing.T) {
+	got := make(chan string, 4)
+	transformed := make(chan string, 4)
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	orders.DryRun = true
+	orders.Transformers = []gosns.Transformer{func(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+		transformed <- msg.Message
+		return msg, nil
+	}}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("dry run gave %v, %v", resp, err)
+	}
+	if len(transformed) != 1 || orders.DryRuns() != 1 {
+		t.Errorf("transformed %d, dry runs %d", len(transformed), orders.DryRuns())
+	}
+
+	orders.DryRun = false
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "for real"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("notification gave %v, %v", resp, err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "for real" {
+			t.Errorf("callback got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not called")
+	}
+
+	s.DryRun = true
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "server-wide"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("dry run gave %v, %v", resp, err)
+	}
+	select {
+	case msg := <-got:
+		t.Errorf("callback got %q in a dry run", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if orders.DryRuns() != 2 {
+		t.Errorf("dry runs %d", orders.DryRuns())
+	}
+}
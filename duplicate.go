@@ -0,0 +1,137 @@
+package gosns
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DuplicateStats counts a topic's notifications, and those among them which
+// repeated a MessageId seen within the topic's DuplicateWindow.
+type DuplicateStats struct {
+	Messages   uint64
+	Duplicates uint64
+}
+
+// Rate returns the fraction of messages which were duplicates.
+func (ds DuplicateStats) Rate() float64 {
+	if ds.Messages == 0 {
+		return 0
+	}
+	return float64(ds.Duplicates) / float64(ds.Messages)
+}
+
+type seenID struct {
+	id string
+	at time.Time
+}
+
+// dupDetector remembers the MessageIds received within a sliding window.
+type dupDetector struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	order  []seenID // in arrival order, for expiry
+	total  DuplicateStats
+	period DuplicateStats // since the last report
+	start  time.Time      // of the current report period
+}
+
+func newDupDetector(window time.Duration) *dupDetector {
+	return &dupDetector{window: window, seen: make(map[string]time.Time)}
+}
+
+// observe records a delivery of id at now, returning when it was first seen
+// if it is a duplicate, and any report from count.
+func (d *dupDetector) observe(id string, now time.Time) (first time.Time, dup bool, report *DuplicateStats) {
+	d.mu.Lock()
+	for len(d.order) > 0 && now.Sub(d.order[0].at) > d.window {
+		delete(d.seen, d.order[0].id)
+		d.order[0] = seenID{}
+		d.order = d.order[1:]
+	}
+	if first, dup = d.seen[id]; !dup {
+		d.seen[id] = now
+		d.order = append(d.order, seenID{id, now})
+	}
+	d.mu.Unlock()
+	return first, dup, d.count(dup, now)
+}
+
+// count tallies a delivery. At most once per window it also returns the
+// counts for the window just ended, to be reported.
+func (d *dupDetector) count(dup bool, now time.Time) (report *DuplicateStats) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.start.IsZero() {
+		d.start = now
+	} else if now.Sub(d.start) >= d.window {
+		ended := d.period
+		report = &ended
+		d.period, d.start = DuplicateStats{}, now
+	}
+	d.total.Messages++
+	d.period.Messages++
+	if dup {
+		d.total.Duplicates++
+		d.period.Duplicates++
+	}
+	return report
+}
+
+func (d *dupDetector) stats() DuplicateStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.total
+}
+
+// Duplicates returns the topic's duplicate delivery counts since it was
+// added. They are zero unless DuplicateWindow is set.
+func (t *Topic) Duplicates() DuplicateStats {
+	t.startOnce.Do(t.start)
+	if t.dups == nil {
+		return DuplicateStats{}
+	}
+	return t.dups.stats()
+}
+
+func dedupKey(endpoint, messageID string) string {
+	return "dedup/" + url.PathEscape(endpoint) + "/" + url.PathEscape(messageID)
+}
+
+// checkDuplicate counts and logs msg if it repeats an earlier delivery, and
+// periodically logs a summary of the duplicate rate. It reports whether msg
+// is a duplicate.
+func (t *Topic) checkDuplicate(r *http.Request, msg *Message) bool {
+	t.startOnce.Do(t.start)
+	if t.dups == nil {
+		return false
+	}
+	var first time.Time
+	var dup bool
+	var report *DuplicateStats
+	now := time.Now()
+	if d := t.server.Dedup; d != nil {
+		var err error
+		if dup, err = d.Remember(dedupKey(t.endpoint, msg.MessageId), t.DuplicateWindow); err != nil {
+			t.logf(r, LogError, "error checking message %s for duplicates: %v\n", msg.MessageId, err)
+		}
+		report = t.dups.count(dup, now)
+	} else {
+		first, dup, report = t.dups.observe(msg.MessageId, now)
+	}
+
+	if report != nil && report.Messages > 0 {
+		t.logf(nil, LogInfo, "Topic '%s' had %d duplicate deliveries in %d messages (%.2f%%) over %s\n",
+			t.TopicARN, report.Duplicates, report.Messages, 100*report.Rate(), t.DuplicateWindow)
+	}
+	switch {
+	case dup && first.IsZero():
+		t.logf(r, LogWarn, "    Duplicate of message %s\n", msg.MessageId)
+	case dup:
+		t.logf(r, LogWarn, "    Duplicate of message %s first received %s ago\n", msg.MessageId, now.Sub(first).Round(time.Millisecond))
+	}
+	return dup
+}
@@ -0,0 +1,58 @@
+package gosns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDupDetector(t *testing.T) {
+	d := newDupDetector(time.Minute)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		id     string
+		at     time.Duration
+		dup    bool
+		report *DuplicateStats
+	}{
+		{"a", 0, false, nil},
+		{"b", 10 * time.Second, false, nil},
+		{"a", 30 * time.Second, true, nil},
+		{"b", 50 * time.Second, true, nil},
+		// "a" has left the window, and the first period is reported
+		{"a", 65 * time.Second, false, &DuplicateStats{Messages: 4, Duplicates: 2}},
+		{"a", 70 * time.Second, true, nil},
+	}
+	for i, step := range steps {
+		first, dup, report := d.observe(step.id, t0.Add(step.at))
+		if dup != step.dup {
+			t.Errorf("step %d: dup = %v", i, dup)
+		}
+		if dup && first.After(t0.Add(step.at)) {
+			t.Errorf("step %d: first seen at %v", i, first)
+		}
+		if (report == nil) != (step.report == nil) || report != nil && *report != *step.report {
+			t.Errorf("step %d: report %+v, want %+v", i, report, step.report)
+		}
+	}
+	if got, want := d.stats(), (DuplicateStats{Messages: 6, Duplicates: 3}); got != want {
+		t.Errorf("stats %+v, want %+v", got, want)
+	}
+	if len(d.seen) != 2 || len(d.order) != 2 {
+		t.Errorf("remembering %d ids, %d in order", len(d.seen), len(d.order))
+	}
+}
+
+func TestTopicDuplicates(t *testing.T) {
+	td := &Topic{TopicARN: "arn:aws:sns:us-east-1:123456789012:orders", server: &Server{}}
+	if (td.Duplicates() != DuplicateStats{}) {
+		t.Error("counting without a DuplicateWindow")
+	}
+	td = &Topic{TopicARN: td.TopicARN, server: td.server, DuplicateWindow: time.Minute}
+	for _, id := range []string{"1", "2", "1", "1"} {
+		td.checkDuplicate(nil, &Message{MessageId: id})
+	}
+	if ds := td.Duplicates(); ds.Messages != 4 || ds.Duplicates != 2 || ds.Rate() != 0.5 {
+		t.Errorf("got %+v, rate %v", ds, ds.Rate())
+	}
+}
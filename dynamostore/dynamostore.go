@@ -0,0 +1,246 @@
+// Package dynamostore provides a gosns.Store and gosns.DedupStore kept in an
+// Amazon DynamoDB table, for AWS deployments sharing state between replicas
+// without running Redis or a database of their own:
+//
+//	store := &dynamostore.Store{Table: "gosns", Region: "us-east-1"}
+//	s := &gosns.Server{Store: store, SharedStore: true, Dedup: store}
+//
+// The table needs a string partition key named Key. Enable DynamoDB's time
+// to live on the Expires attribute so that expired deduplication entries are
+// removed:
+//
+//	aws dynamodb create-table --table-name gosns --billing-mode PAY_PER_REQUEST \
+//	    --attribute-definitions AttributeName=Key,AttributeType=S \
+//	    --key-schema AttributeName=Key,KeyType=HASH
+//	aws dynamodb update-time-to-live --table-name gosns \
+//	    --time-to-live-specification Enabled=true,AttributeName=Expires
+//
+// List scans the table, so it suits the occasional listing gosns does at
+// startup and for pending confirmations, but not frequent use.
+package dynamostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/internal/sigv4"
+)
+
+// Store is a gosns.Store and gosns.DedupStore kept in a DynamoDB table.
+type Store struct {
+	Table  string
+	Region string
+
+	// Credentials sign each request. If nil, gosns.LoadCredentials is
+	// called on first use.
+	Credentials *gosns.Credentials
+
+	// Endpoint overrides the regional DynamoDB endpoint URL, for example to
+	// use DynamoDB Local.
+	Endpoint string
+
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Timeout limits each request. Zero means 10 seconds.
+	Timeout time.Duration
+
+	credsOnce sync.Once
+	credsErr  error
+}
+
+// Error is an error response from DynamoDB.
+type Error struct {
+	Operation  string
+	StatusCode int
+	Type       string // such as "ResourceNotFoundException"
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return "dynamodb " + e.Operation + ": " + e.Type + ": " + e.Message
+}
+
+// attr is a DynamoDB attribute value.
+type attr struct {
+	S string `json:",omitempty"`
+	N string `json:",omitempty"`
+	B []byte `json:",omitempty"`
+}
+
+type item map[string]attr
+
+func keyItem(key string) item { return item{"Key": {S: key}} }
+
+func (s *Store) Put(key string, value []byte) error {
+	it := keyItem(key)
+	it["Value"] = attr{B: value}
+	if len(value) == 0 {
+		// binary attributes may not be empty
+		it["Value"] = attr{S: "-"}
+	}
+	return s.call("PutItem", map[string]interface{}{"TableName": s.Table, "Item": it}, nil)
+}
+
+func (s *Store) Get(key string) ([]byte, error) {
+	var out struct{ Item item }
+	err := s.call("GetItem", map[string]interface{}{
+		"TableName":      s.Table,
+		"Key":            keyItem(key),
+		"ConsistentRead": true,
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := out.Item["Value"]
+	if !ok {
+		return nil, gosns.ErrNotFound
+	}
+	return v.B, nil
+}
+
+func (s *Store) Delete(key string) error {
+	return s.call("DeleteItem", map[string]interface{}{"TableName": s.Table, "Key": keyItem(key)}, nil)
+}
+
+func (s *Store) List(prefix string) ([]string, error) {
+	var keys []string
+	var start item
+	for {
+		// deduplication entries, which have no Value, are not listed
+		req := map[string]interface{}{
+			"TableName":                s.Table,
+			"ConsistentRead":           true,
+			"ProjectionExpression":     "#k",
+			"FilterExpression":         "attribute_exists(#v)",
+			"ExpressionAttributeNames": map[string]string{"#k": "Key", "#v": "Value"},
+		}
+		if prefix != "" {
+			req["FilterExpression"] = "begins_with(#k, :p) AND attribute_exists(#v)"
+			req["ExpressionAttributeValues"] = item{":p": {S: prefix}}
+		}
+		if start != nil {
+			req["ExclusiveStartKey"] = start
+		}
+		var out struct {
+			Items            []item
+			LastEvaluatedKey item
+		}
+		if err := s.call("Scan", req, &out); err != nil {
+			return nil, err
+		}
+		for _, it := range out.Items {
+			keys = append(keys, it["Key"].S)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		start = out.LastEvaluatedKey
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *Store) Remember(key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	it := keyItem(key)
+	it["Expires"] = attr{N: strconv.FormatInt(now.Add(ttl).Unix(), 10)}
+	err := s.call("PutItem", map[string]interface{}{
+		"TableName": s.Table,
+		"Item":      it,
+		// expired items linger until DynamoDB's time to live removes them
+		"ConditionExpression":       "attribute_not_exists(#k) OR #e <= :now",
+		"ExpressionAttributeNames":  map[string]string{"#k": "Key", "#e": "Expires"},
+		"ExpressionAttributeValues": item{":now": {N: strconv.FormatInt(now.Unix(), 10)}},
+	}, nil)
+	var derr *Error
+	if errors.As(err, &derr) && derr.Type == "ConditionalCheckFailedException" {
+		return true, nil
+	}
+	return false, err
+}
+
+func (s *Store) endpoint() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return gosns.RegionalEndpoint("dynamodb", s.Region)
+}
+
+// call sends a DynamoDB JSON API request and decodes the response into out,
+// if it is not nil.
+func (s *Store) call(op string, in interface{}, out interface{}) error {
+	if s.Region == "" {
+		return errors.New("dynamostore: Store has no Region")
+	}
+	s.credsOnce.Do(func() {
+		if s.Credentials == nil {
+			s.Credentials, s.credsErr = gosns.LoadCredentials()
+		}
+	})
+	if s.credsErr != nil {
+		return s.credsErr
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+op)
+	c, err := s.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, body, c.AccessKeyID, c.SecretAccessKey, c.SessionToken, s.Region, "dynamodb", time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		derr := &Error{Operation: op, StatusCode: resp.StatusCode}
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &e) == nil {
+			// types are namespaced, as in com.amazonaws.dynamodb.v20120810#Name
+			derr.Type, derr.Message = e.Type[strings.LastIndex(e.Type, "#")+1:], e.Message
+		}
+		return derr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,156 @@
+package dynamostore
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// fakeDynamo answers the operations Store uses on a single table.
+type fakeDynamo struct {
+	t     *testing.T
+	items map[string]item
+}
+
+func (f *fakeDynamo) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/us-east-1/dynamodb/aws4_request") {
+		f.t.Errorf("request signed with %q", auth)
+	}
+	var in struct {
+		TableName                 string
+		Key, Item                 item
+		ExclusiveStartKey         item
+		ConditionExpression       string
+		FilterExpression          string
+		ExpressionAttributeValues item
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.TableName != "gosns" {
+		http.Error(w, `{"__type":"com.amazon.coral.validate#ValidationException","message":"bad request"}`, 400)
+		return
+	}
+	var out interface{} = struct{}{}
+	switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+	case "PutItem":
+		if old, ok := f.items[in.Item["Key"].S]; ok && in.ConditionExpression != "" {
+			exp, _ := strconv.ParseInt(old["Expires"].N, 10, 64)
+			now, _ := strconv.ParseInt(in.ExpressionAttributeValues[":now"].N, 10, 64)
+			if exp > now {
+				w.WriteHeader(400)
+				w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`))
+				return
+			}
+		}
+		f.items[in.Item["Key"].S] = in.Item
+	case "GetItem":
+		if it, ok := f.items[in.Key["Key"].S]; ok {
+			out = map[string]item{"Item": it}
+		}
+	case "DeleteItem":
+		delete(f.items, in.Key["Key"].S)
+	case "Scan":
+		// two items a page, to exercise pagination
+		var keys []string
+		for k := range f.items {
+			if k > in.ExclusiveStartKey["Key"].S {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		res := struct {
+			Items            []item
+			LastEvaluatedKey item `json:",omitempty"`
+		}{}
+		if len(keys) > 2 {
+			keys = keys[:2]
+			res.LastEvaluatedKey = keyItem(keys[1])
+		}
+		for _, k := range keys {
+			_, hasValue := f.items[k]["Value"]
+			if hasValue && strings.HasPrefix(k, in.ExpressionAttributeValues[":p"].S) {
+				res.Items = append(res.Items, keyItem(k))
+			}
+		}
+		out = res
+	default:
+		f.t.Errorf("unexpected operation %s", op)
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func newStore(t *testing.T) *Store {
+	f := &fakeDynamo{t: t, items: map[string]item{}}
+	ts := gosnstest.NewSink(f.serveHTTP)
+	t.Cleanup(ts.Close)
+	return &Store{Table: "gosns", Region: "us-east-1", Endpoint: ts.URL,
+		Credentials: &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}
+}
+
+func TestStore(t *testing.T) {
+	s := newStore(t)
+	if _, err := s.Get("subscriptions/a"); err != gosns.ErrNotFound {
+		t.Errorf("missing key gave %v", err)
+	}
+	keys := []string{"subscriptions/%2Forders/arn:1", "subscriptions/%2Forders/arn:2", "subscriptions/%2Fx/arn:3", "delayed/x"}
+	for _, k := range keys {
+		if err := s.Put(k, []byte("value of "+k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Remember("subscriptions/not-a-value", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("delayed/x"); err != nil || string(v) != "value of delayed/x" {
+		t.Errorf("got %q, %v", v, err)
+	}
+	got, err := s.List("subscriptions/")
+	if err != nil || !reflect.DeepEqual(got, keys[:3]) {
+		t.Errorf("List gave %q, %v; want %q", got, err, keys[:3])
+	}
+	if err = s.Delete("delayed/x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get("delayed/x"); err != gosns.ErrNotFound {
+		t.Errorf("deleted key gave %v", err)
+	}
+}
+
+func TestRemember(t *testing.T) {
+	s := newStore(t)
+	for i, want := range []bool{false, true} {
+		if seen, err := s.Remember("dedup/orders/m-1", time.Hour); err != nil || seen != want {
+			t.Errorf("call %d: %v, %v", i, seen, err)
+		}
+	}
+	if seen, _ := s.Remember("dedup/orders/m-2", -time.Second); seen {
+		t.Error("new key seen")
+	}
+	if seen, _ := s.Remember("dedup/orders/m-2", time.Hour); seen {
+		t.Error("expired key seen")
+	}
+}
+
+func TestError(t *testing.T) {
+	s := newStore(t)
+	s.Table = "missing"
+	err := s.Put("x", []byte("1"))
+	if derr, ok := err.(*Error); !ok || derr.Type != "ValidationException" || derr.StatusCode != 400 {
+		t.Errorf("got %#v", err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	if got := (&Store{Region: "us-gov-west-1"}).endpoint(); got != "https://dynamodb.us-gov-west-1.amazonaws.com/" {
+		t.Errorf("got %s", got)
+	}
+	if got := (&Store{Region: "cn-northwest-1"}).endpoint(); got != "https://dynamodb.cn-northwest-1.amazonaws.com.cn/" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,262 @@
+// Package email sends the messages a gosns server receives by email, for
+// low-volume critical topics whose notifications people still want in
+// their inbox:
+//
+//	m := &email.Mailer{
+//		Addr:     "smtp.example.com:587",
+//		Username: "alerts",
+//		Password: os.Getenv("SMTP_PASSWORD"),
+//		From:     "alerts@example.com",
+//		To:       []string{"oncall@example.com"},
+//		Digest:   15 * time.Minute,
+//	}
+//	defer m.Close()
+//	s.AddTopic(topicARN, "/critical", m.Handle)
+//
+// Each message is an email whose subject and body are payload templates, so
+// that {{.subject}}, {{.attributes.env}}, {{.body.order.id}} and
+// {{.topicName}} are its subject, an attribute, a field of its JSON body and
+// its topic's name.
+//
+// With Digest, at most one email is sent each Digest: the first message
+// after a quiet period is sent at once, and the messages which follow it
+// within Digest are collected and sent together in one email when it ends,
+// so that a burst of notifications does not flood the inbox.
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/payload"
+)
+
+// Defaults used when the corresponding Mailer fields are zero.
+const (
+	DefaultSubject   = "[{{.topicName}}] {{or .subject \"Notification\"}}"
+	DefaultBody      = "{{.message}}\n\nTopic: {{.topic}}\nMessageId: {{.id}}\nSent: {{.timestamp}}\n"
+	DefaultMaxDigest = 50
+)
+
+var (
+	defaultSubject = payload.MustTemplate("subject", DefaultSubject)
+	defaultBody    = payload.MustTemplate("body", DefaultBody)
+)
+
+// ErrClosed is returned by Write once the Mailer is closed.
+var ErrClosed = errors.New("email: mailer is closed")
+
+// Mailer emails messages to a list of addresses. Its fields must not be
+// changed once it has been used.
+type Mailer struct {
+	// Addr is the host:port of the SMTP server. Connections are upgraded to
+	// TLS with STARTTLS when the server offers it.
+	Addr string
+
+	// Username and Password, if set, authenticate with PLAIN
+	// authentication, which net/smtp only allows over TLS or to localhost.
+	// Auth, if set, is used instead.
+	Username string
+	Password string
+	Auth     smtp.Auth
+
+	// From is the sender's address and To the recipients'.
+	From string
+	To   []string
+
+	// Subject and Body render the email for a message; nil means
+	// DefaultSubject and DefaultBody.
+	Subject *payload.Template
+	Body    *payload.Template
+
+	// Digest, if set, is the least time between emails; see the package
+	// documentation. A digest lists at most MaxDigest messages (zero means
+	// DefaultMaxDigest), counting the rest.
+	Digest    time.Duration
+	MaxDigest int
+
+	// SendMail sends an email; nil means smtp.SendMail.
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	// Logger receives notices of digests which could not be sent, if set.
+	Logger *log.Logger
+
+	mu      sync.Mutex
+	pending []*gosns.Message
+	extra   int         // messages beyond MaxDigest
+	timer   *time.Timer // running while a digest period lasts
+	closed  bool
+	flushMu sync.Mutex // held while a digest is sent
+}
+
+func (m *Mailer) logf(format string, args ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, args...)
+	}
+}
+
+func (m *Mailer) maxDigest() int {
+	if m.MaxDigest > 0 {
+		return m.MaxDigest
+	}
+	return DefaultMaxDigest
+}
+
+// Write emails msg, or with Digest, adds it to the next digest if an email
+// was sent less than Digest ago. Messages in a digest succeed once added;
+// failures to send it are logged.
+func (m *Mailer) Write(msg *gosns.Message) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	if m.Digest > 0 {
+		if m.timer != nil {
+			if len(m.pending) < m.maxDigest() {
+				m.pending = append(m.pending, msg)
+			} else {
+				m.extra++
+			}
+			m.mu.Unlock()
+			return nil
+		}
+		m.timer = time.AfterFunc(m.Digest, m.flush)
+	}
+	m.mu.Unlock()
+	return m.send([]*gosns.Message{msg}, 0)
+}
+
+// Handle emails msg as a Topic callback, failing the message if it could
+// not be sent.
+func (m *Mailer) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := m.Write(msg); err != nil {
+		msg.Fail(err)
+	}
+}
+
+// Close sends the pending digest, if any. Later Writes fail with ErrClosed.
+func (m *Mailer) Close() {
+	m.mu.Lock()
+	m.closed = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.mu.Unlock()
+	m.flush()
+}
+
+// flush sends the pending digest once Digest has passed since the last
+// email, and starts another period if it was not empty.
+func (m *Mailer) flush() {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+	m.mu.Lock()
+	msgs, extra := m.pending, m.extra
+	m.pending, m.extra, m.timer = nil, 0, nil
+	if len(msgs) > 0 && !m.closed {
+		m.timer = time.AfterFunc(m.Digest, m.flush)
+	}
+	m.mu.Unlock()
+	if len(msgs) == 0 {
+		return
+	}
+	if err := m.send(msgs, extra); err != nil {
+		m.logf("email: sending a digest of %d messages: %v\n", len(msgs)+extra, err)
+	}
+}
+
+// send emails msgs, one message or a digest of several and extra more.
+func (m *Mailer) send(msgs []*gosns.Message, extra int) error {
+	subjectTmpl, bodyTmpl := m.Subject, m.Body
+	if subjectTmpl == nil {
+		subjectTmpl = defaultSubject
+	}
+	if bodyTmpl == nil {
+		bodyTmpl = defaultBody
+	}
+	var subject string
+	var body bytes.Buffer
+	for i, msg := range msgs {
+		line, err := subjectTmpl.Render(msg)
+		if err != nil {
+			return fmt.Errorf("email: subject: %w", err)
+		}
+		s := strings.Join(strings.Fields(string(line)), " ")
+		if len(msgs) > 1 {
+			fmt.Fprintf(&body, "%s\n%s\n\n", s, strings.Repeat("=", len([]rune(s))))
+		}
+		if i == 0 {
+			subject = s
+		}
+		text, err := bodyTmpl.Render(msg)
+		if err != nil {
+			return fmt.Errorf("email: body: %w", err)
+		}
+		body.Write(text)
+		if len(msgs) > 1 {
+			body.WriteString("\n\n")
+		}
+	}
+	if n := len(msgs) + extra; n > 1 {
+		subject = fmt.Sprintf("%d notifications: %s", n, subject)
+	}
+	if extra > 0 {
+		fmt.Fprintf(&body, "... and %d more.\n", extra)
+	}
+
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", m.From)
+	fmt.Fprintf(&email, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&email, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&email, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&email, "Message-ID: <%s@%s>\r\n", randomID(), domain(m.From))
+	email.WriteString("MIME-Version: 1.0\r\n")
+	email.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	email.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&email)
+	qp.Write(body.Bytes())
+	qp.Close()
+
+	auth := m.Auth
+	if auth == nil && m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	sendMail := m.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	if err := sendMail(m.Addr, auth, m.From, m.To, email.Bytes()); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+func domain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return strings.Trim(addr[i+1:], "> ")
+	}
+	return "gosns"
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/payload"
+)
+
+// outbox records the emails a Mailer sends.
+type outbox struct {
+	mu     sync.Mutex
+	fail   error
+	emails []*mail.Message
+	bodies []string
+}
+
+func (o *outbox) send(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.fail != nil {
+		return o.fail
+	}
+	m, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		return err
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(m.Body))
+	o.emails = append(o.emails, m)
+	o.bodies = append(o.bodies, strings.ReplaceAll(string(body), "\r\n", "\n"))
+	return nil
+}
+
+func (o *outbox) subjects() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var subjects []string
+	for _, m := range o.emails {
+		s, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+		subjects = append(subjects, s)
+	}
+	return subjects
+}
+
+func message(id, subject string) *gosns.Message {
+	return &gosns.Message{
+		MessageId: id,
+		TopicArn:  "arn:aws:sns:us-east-1:123456789012:critical",
+		Subject:   subject,
+		Message:   `{"host": "db-1"}`,
+		Timestamp: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestMailer(t *testing.T) {
+	o := &outbox{}
+	m := &Mailer{From: "Alerts <alerts@example.com>", To: []string{"a@example.com", "b@example.com"}, SendMail: o.send}
+	defer m.Close()
+	if err := m.Write(message("1", "Disk full")); err != nil {
+		t.Fatal(err)
+	}
+	m.Subject, _ = payload.NewTemplate("subject", "{{.subject}} on {{.body.host}} — ünïcode")
+	if err := m.Write(message("2", "Disk full")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := o.subjects(); len(got) != 2 || got[0] != "[critical] Disk full" || got[1] != "Disk full on db-1 — ünïcode" {
+		t.Errorf("subjects %q", got)
+	}
+	e := o.emails[0]
+	if e.Header.Get("To") != "a@example.com, b@example.com" || !strings.HasSuffix(e.Header.Get("Message-ID"), "@example.com>") {
+		t.Errorf("headers %v", e.Header)
+	}
+	if !strings.Contains(o.bodies[0], "MessageId: 1") {
+		t.Errorf("body %q", o.bodies[0])
+	}
+
+	o.fail = errors.New("451 try again later")
+	if err := m.Write(message("3", "")); err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMailerDigest(t *testing.T) {
+	o := &outbox{}
+	m := &Mailer{From: "alerts@example.com", To: []string{"oncall@example.com"}, SendMail: o.send, Digest: 50 * time.Millisecond, MaxDigest: 3}
+	for i := 1; i <= 5; i++ {
+		if err := m.Write(message(fmt.Sprint(i), fmt.Sprintf("Alarm %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first is sent at once, the rest in a digest
+	if got := o.subjects(); len(got) != 1 {
+		t.Fatalf("subjects %q", got)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if got := o.subjects(); len(got) != 2 || got[1] != "4 notifications: [critical] Alarm 2" {
+		t.Fatalf("subjects %q", got)
+	}
+	if body := o.bodies[1]; !strings.Contains(body, "[critical] Alarm 4\n") || strings.Contains(body, "Alarm 5") || !strings.Contains(body, "and 1 more") {
+		t.Errorf("digest %q", body)
+	}
+
+	// within the next period messages wait for Close
+	m.Write(message("6", "Alarm 6"))
+	m.Close()
+	if got := o.subjects(); len(got) != 3 || got[2] != "[critical] Alarm 6" {
+		t.Errorf("subjects %q", got)
+	}
+	if err := m.Write(message("7", "")); err != ErrClosed {
+		t.Errorf("got %v", err)
+	}
+}
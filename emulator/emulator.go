@@ -0,0 +1,565 @@
+// Package emulator implements enough of the Amazon SNS HTTP API to develop
+// against locally, without AWS. It handles CreateTopic, DeleteTopic,
+// ListTopics, Subscribe, ConfirmSubscription, Unsubscribe, ListSubscriptions,
+// ListSubscriptionsByTopic, Publish and PublishBatch for http and https
+// subscriptions, and delivers signed SubscriptionConfirmation and
+// Notification requests to the subscribed endpoints, so a gosns.Server can
+// run unchanged against it:
+//
+//	e, _ := emulator.New()
+//	go e.ListenAndServe("localhost:9911")
+//	e.Trust(snsServer)
+//	client := e.SNSClient() // or point any SNS client at e.URL
+//
+// Requests are not authenticated; any credentials are accepted. State is
+// kept in memory and lost when the emulator stops.
+package emulator
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// Defaults used when the corresponding Emulator fields are empty.
+const (
+	DefaultRegion    = "us-east-1"
+	DefaultAccountID = "000000000000"
+)
+
+// certPath is where the emulator serves its signing certificate.
+const certPath = "/SimpleNotificationService-emulator.pem"
+
+// listPageSize is the number of items returned per List call, as for SNS.
+const listPageSize = 100
+
+var topicNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}(\.fifo)?$`)
+
+// Emulator is an in-memory SNS. It is an http.Handler serving the SNS Query
+// API at its root.
+type Emulator struct {
+	// URL is the emulator's base URL as subscribed endpoints reach it. It is
+	// used in the SubscribeURL, UnsubscribeURL and SigningCertURL of the
+	// requests delivered. Serve and ListenAndServe set it from the listening
+	// address if it is empty.
+	URL string
+
+	// Region and AccountID are used in the ARNs created. They default to
+	// DefaultRegion and DefaultAccountID.
+	Region    string
+	AccountID string
+
+	// Client delivers requests to endpoints. New sets a client with a ten
+	// second timeout.
+	Client *http.Client
+
+	// Retry controls redelivery of failed notifications. As for SNS, only
+	// network errors and responses outside the 200-499 range are retried.
+	Retry gosnstest.RetryPolicy
+
+	// Logger, if set, receives a line for every delivery.
+	Logger *log.Logger
+
+	signer *gosnstest.Signer
+
+	mu     sync.Mutex
+	topics map[string]*topic
+	subs   map[string]*subscription
+	tokens map[string]*subscription
+	seq    int64
+}
+
+type topic struct {
+	arn          string
+	subs         []*subscription
+	contentDedup bool
+	dedup        map[string]dedupEntry // by FIFO deduplication ID
+}
+
+type subscription struct {
+	arn       string
+	topicARN  string
+	protocol  string
+	endpoint  string
+	raw       bool
+	confirmed bool
+}
+
+// New returns an emulator with a new signing certificate.
+func New() (*Emulator, error) {
+	signer, err := gosnstest.NewSigner()
+	if err != nil {
+		return nil, err
+	}
+	return &Emulator{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Retry:  gosnstest.RetryPolicy{Attempts: 4, MinDelay: time.Second, MaxDelay: 5 * time.Second},
+		signer: signer,
+		topics: make(map[string]*topic),
+		subs:   make(map[string]*subscription),
+		tokens: make(map[string]*subscription),
+	}, nil
+}
+
+// ListenAndServe serves the emulator on addr. See Serve.
+func (e *Emulator) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return e.Serve(ln)
+}
+
+// Serve serves the emulator on ln, setting URL from its address if empty.
+func (e *Emulator) Serve(ln net.Listener) error {
+	if e.URL == "" {
+		e.URL = "http://" + ln.Addr().String()
+	}
+	return http.Serve(ln, e)
+}
+
+// CertURL returns the URL of the emulator's signing certificate.
+func (e *Emulator) CertURL() string {
+	return strings.TrimSuffix(e.URL, "/") + certPath
+}
+
+// Trust configures s to accept the emulator's signing certificate, in
+// addition to genuine SNS certificates.
+func (e *Emulator) Trust(s *gosns.Server) {
+	if s.Certs == nil {
+		s.Certs = &gosns.CertCache{}
+	}
+	next := s.Certs.ValidateURL
+	if next == nil {
+		next = gosns.ValidateCertURL
+	}
+	certURL := e.CertURL()
+	s.Certs.ValidateURL = func(u *url.URL) error {
+		if u.String() == certURL {
+			return nil
+		}
+		return next(u)
+	}
+}
+
+// SNSClient returns a client for the emulator.
+func (e *Emulator) SNSClient() *gosns.SNSClient {
+	return &gosns.SNSClient{
+		Region:      e.region(),
+		Credentials: &gosns.Credentials{AccessKeyID: "AKIDEMULATOR", SecretAccessKey: "emulator"},
+		Endpoint:    strings.TrimSuffix(e.URL, "/") + "/",
+	}
+}
+
+func (e *Emulator) region() string {
+	if e.Region == "" {
+		return DefaultRegion
+	}
+	return e.Region
+}
+
+func (e *Emulator) accountID() string {
+	if e.AccountID == "" {
+		return DefaultAccountID
+	}
+	return e.AccountID
+}
+
+func (e *Emulator) logf(format string, args ...interface{}) {
+	if e.Logger != nil {
+		e.Logger.Printf(format, args...)
+	}
+}
+
+// apiError is an SNS error response.
+type apiError struct {
+	status int
+	Code   string
+	Msg    string
+}
+
+func (err *apiError) Error() string { return err.Code + ": " + err.Msg }
+
+func invalidParameter(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, "InvalidParameter", fmt.Sprintf(format, args...)}
+}
+
+func notFound(what string) error {
+	return &apiError{http.StatusNotFound, "NotFound", what + " does not exist"}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestId string
+}
+
+type responseMetadata struct {
+	RequestId string `xml:"ResponseMetadata>RequestId"`
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && r.URL.Path == certPath {
+		e.signer.Handler().ServeHTTP(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		e.writeError(w, &apiError{http.StatusBadRequest, "MalformedQueryString", err.Error()})
+		return
+	}
+	action := r.Form.Get("Action")
+	var result interface{}
+	var err error
+	switch action {
+	case "CreateTopic":
+		result, err = e.createTopic(r.Form)
+	case "DeleteTopic":
+		result, err = e.deleteTopic(r.Form)
+	case "ListTopics":
+		result, err = e.listTopics(r.Form)
+	case "Subscribe":
+		result, err = e.subscribe(r.Form)
+	case "ConfirmSubscription":
+		result, err = e.confirmSubscription(r.Form)
+	case "Unsubscribe":
+		result, err = e.unsubscribe(r.Form)
+	case "ListSubscriptions", "ListSubscriptionsByTopic":
+		result, err = e.listSubscriptions(action, r.Form)
+	case "Publish":
+		result, err = e.publish(r.Form)
+	case "PublishBatch":
+		result, err = e.publishBatch(r.Form)
+	default:
+		err = &apiError{http.StatusBadRequest, "InvalidAction", fmt.Sprintf("the emulator does not support %q", action)}
+	}
+	if err != nil {
+		e.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (e *Emulator) writeError(w http.ResponseWriter, err error) {
+	var aerr *apiError
+	if !errors.As(err, &aerr) {
+		aerr = &apiError{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(aerr.status)
+	typ := "Sender"
+	if aerr.status >= 500 {
+		typ = "Receiver"
+	}
+	xml.NewEncoder(w).Encode(errorResponse{Type: typ, Code: aerr.Code, Message: aerr.Msg, RequestId: gosnstest.NewUUID()})
+}
+
+// attributes returns the Attributes.entry.N.key/value parameters of form.
+func attributes(form url.Values) map[string]string {
+	attrs := map[string]string{}
+	for i := 1; ; i++ {
+		prefix := "Attributes.entry." + strconv.Itoa(i) + "."
+		key := form.Get(prefix + "key")
+		if key == "" {
+			return attrs
+		}
+		attrs[key] = form.Get(prefix + "value")
+	}
+}
+
+// CreateTopic creates the topic name, if it does not already exist, and
+// returns its ARN. Names ending in ".fifo" create FIFO topics.
+func (e *Emulator) CreateTopic(name string) (string, error) {
+	form := url.Values{"Name": {name}}
+	if strings.HasSuffix(name, ".fifo") {
+		form.Set("Attributes.entry.1.key", "FifoTopic")
+		form.Set("Attributes.entry.1.value", "true")
+	}
+	resp, err := e.createTopic(form)
+	if err != nil {
+		return "", err
+	}
+	return resp.(createTopicResponse).TopicArn, nil
+}
+
+type createTopicResponse struct {
+	XMLName  xml.Name `xml:"CreateTopicResponse"`
+	TopicArn string   `xml:"CreateTopicResult>TopicArn"`
+	responseMetadata
+}
+
+func (e *Emulator) createTopic(form url.Values) (interface{}, error) {
+	name := form.Get("Name")
+	if !topicNamePattern.MatchString(name) {
+		return nil, invalidParameter("Invalid parameter: Topic Name")
+	}
+	attrs := attributes(form)
+	if (attrs["FifoTopic"] == "true") != strings.HasSuffix(name, ".fifo") {
+		return nil, invalidParameter("Invalid parameter: Fifo Topic names must end with .fifo and have FifoTopic set")
+	}
+	arn := "arn:aws:sns:" + e.region() + ":" + e.accountID() + ":" + name
+	e.mu.Lock()
+	if e.topics[arn] == nil {
+		e.topics[arn] = &topic{
+			arn:          arn,
+			contentDedup: attrs["ContentBasedDeduplication"] == "true",
+			dedup:        make(map[string]dedupEntry),
+		}
+	}
+	e.mu.Unlock()
+	return createTopicResponse{TopicArn: arn, responseMetadata: newMetadata()}, nil
+}
+
+func newMetadata() responseMetadata {
+	return responseMetadata{RequestId: gosnstest.NewUUID()}
+}
+
+type deleteTopicResponse struct {
+	XMLName xml.Name `xml:"DeleteTopicResponse"`
+	responseMetadata
+}
+
+func (e *Emulator) deleteTopic(form url.Values) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t := e.topics[form.Get("TopicArn")]
+	if t == nil {
+		return nil, notFound("Topic")
+	}
+	for _, sub := range t.subs {
+		delete(e.subs, sub.arn)
+	}
+	delete(e.topics, t.arn)
+	return deleteTopicResponse{responseMetadata: newMetadata()}, nil
+}
+
+type listTopicsResponse struct {
+	XMLName   xml.Name `xml:"ListTopicsResponse"`
+	Topics    []string `xml:"ListTopicsResult>Topics>member>TopicArn"`
+	NextToken string   `xml:"ListTopicsResult>NextToken,omitempty"`
+	responseMetadata
+}
+
+func (e *Emulator) listTopics(form url.Values) (interface{}, error) {
+	e.mu.Lock()
+	arns := make([]string, 0, len(e.topics))
+	for arn := range e.topics {
+		arns = append(arns, arn)
+	}
+	e.mu.Unlock()
+	sort.Strings(arns)
+	page, next, err := paginate(len(arns), form.Get("NextToken"))
+	if err != nil {
+		return nil, err
+	}
+	return listTopicsResponse{Topics: arns[page[0]:page[1]], NextToken: next, responseMetadata: newMetadata()}, nil
+}
+
+// paginate returns the bounds of the page of n items starting at token,
+// and the token of the next page.
+func paginate(n int, token string) ([2]int, string, error) {
+	start := 0
+	if token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > n {
+			return [2]int{}, "", invalidParameter("Invalid parameter: NextToken")
+		}
+	}
+	end := start + listPageSize
+	if end >= n {
+		return [2]int{start, n}, "", nil
+	}
+	return [2]int{start, end}, strconv.Itoa(end), nil
+}
+
+type subscribeResponse struct {
+	XMLName         xml.Name `xml:"SubscribeResponse"`
+	SubscriptionArn string   `xml:"SubscribeResult>SubscriptionArn"`
+	responseMetadata
+}
+
+func (e *Emulator) subscribe(form url.Values) (interface{}, error) {
+	protocol, endpoint := form.Get("Protocol"), form.Get("Endpoint")
+	if protocol != "http" && protocol != "https" {
+		return nil, invalidParameter("Invalid parameter: the emulator only supports http and https subscriptions")
+	}
+	if u, err := url.Parse(endpoint); err != nil || u.Scheme != protocol || u.Host == "" {
+		return nil, invalidParameter("Invalid parameter: Endpoint must match the specified protocol")
+	}
+	raw := attributes(form)["RawMessageDelivery"] == "true"
+
+	e.mu.Lock()
+	t := e.topics[form.Get("TopicArn")]
+	if t == nil {
+		e.mu.Unlock()
+		return nil, notFound("Topic")
+	}
+	for _, sub := range t.subs {
+		if sub.protocol == protocol && sub.endpoint == endpoint {
+			// subscribing again is idempotent, as for SNS
+			e.mu.Unlock()
+			return e.subscribeResult(sub, form), nil
+		}
+	}
+	sub := &subscription{
+		arn:      t.arn + ":" + gosnstest.NewUUID(),
+		topicARN: t.arn,
+		protocol: protocol,
+		endpoint: endpoint,
+		raw:      raw,
+	}
+	t.subs = append(t.subs, sub)
+	e.subs[sub.arn] = sub
+	conf := gosnstest.NewSubscriptionConfirmation(t.arn, "")
+	e.tokens[conf.Token] = sub
+	e.mu.Unlock()
+
+	conf.SubscribeURL = strings.TrimSuffix(e.URL, "/") + "/?" + url.Values{
+		"Action":   {"ConfirmSubscription"},
+		"TopicArn": {t.arn},
+		"Token":    {conf.Token},
+	}.Encode()
+	go e.deliver(sub, conf)
+	return e.subscribeResult(sub, form), nil
+}
+
+func (e *Emulator) subscribeResult(sub *subscription, form url.Values) subscribeResponse {
+	arn := sub.arn
+	e.mu.Lock()
+	confirmed := sub.confirmed
+	e.mu.Unlock()
+	if !confirmed && form.Get("ReturnSubscriptionArn") != "true" {
+		arn = "pending confirmation"
+	}
+	return subscribeResponse{SubscriptionArn: arn, responseMetadata: newMetadata()}
+}
+
+type confirmSubscriptionResponse struct {
+	XMLName         xml.Name `xml:"ConfirmSubscriptionResponse"`
+	SubscriptionArn string   `xml:"ConfirmSubscriptionResult>SubscriptionArn"`
+	responseMetadata
+}
+
+func (e *Emulator) confirmSubscription(form url.Values) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sub := e.tokens[form.Get("Token")]
+	if sub == nil || sub.topicARN != form.Get("TopicArn") {
+		return nil, invalidParameter("Invalid token")
+	}
+	if e.subs[sub.arn] != sub {
+		return nil, notFound("Subscription")
+	}
+	sub.confirmed = true
+	e.logf("Confirmed subscription of '%s' to '%s'\n", sub.endpoint, sub.topicARN)
+	return confirmSubscriptionResponse{SubscriptionArn: sub.arn, responseMetadata: newMetadata()}, nil
+}
+
+type unsubscribeResponse struct {
+	XMLName xml.Name `xml:"UnsubscribeResponse"`
+	responseMetadata
+}
+
+func (e *Emulator) unsubscribe(form url.Values) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	sub := e.subs[form.Get("SubscriptionArn")]
+	if sub == nil {
+		return nil, notFound("Subscription")
+	}
+	delete(e.subs, sub.arn)
+	if t := e.topics[sub.topicARN]; t != nil {
+		for i, s := range t.subs {
+			if s == sub {
+				t.subs = append(t.subs[:i:i], t.subs[i+1:]...)
+				break
+			}
+		}
+	}
+	return unsubscribeResponse{responseMetadata: newMetadata()}, nil
+}
+
+type subscriptionMember struct {
+	SubscriptionArn string
+	Owner           string
+	Protocol        string
+	Endpoint        string
+	TopicArn        string
+}
+
+type listSubscriptionsResponse struct {
+	XMLName       xml.Name             `xml:"ListSubscriptionsResponse"`
+	Subscriptions []subscriptionMember `xml:"ListSubscriptionsResult>Subscriptions>member"`
+	NextToken     string               `xml:"ListSubscriptionsResult>NextToken,omitempty"`
+	responseMetadata
+}
+
+type listSubscriptionsByTopicResponse struct {
+	XMLName       xml.Name             `xml:"ListSubscriptionsByTopicResponse"`
+	Subscriptions []subscriptionMember `xml:"ListSubscriptionsByTopicResult>Subscriptions>member"`
+	NextToken     string               `xml:"ListSubscriptionsByTopicResult>NextToken,omitempty"`
+	responseMetadata
+}
+
+func (e *Emulator) listSubscriptions(action string, form url.Values) (interface{}, error) {
+	var members []subscriptionMember
+	e.mu.Lock()
+	var subs []*subscription
+	if action == "ListSubscriptionsByTopic" {
+		t := e.topics[form.Get("TopicArn")]
+		if t == nil {
+			e.mu.Unlock()
+			return nil, notFound("Topic")
+		}
+		subs = t.subs
+	} else {
+		for _, sub := range e.subs {
+			subs = append(subs, sub)
+		}
+	}
+	for _, sub := range subs {
+		m := subscriptionMember{
+			SubscriptionArn: sub.arn,
+			Owner:           e.accountID(),
+			Protocol:        sub.protocol,
+			Endpoint:        sub.endpoint,
+			TopicArn:        sub.topicARN,
+		}
+		if !sub.confirmed {
+			m.SubscriptionArn = "PendingConfirmation"
+		}
+		members = append(members, m)
+	}
+	e.mu.Unlock()
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].TopicArn != members[j].TopicArn {
+			return members[i].TopicArn < members[j].TopicArn
+		}
+		return members[i].Endpoint < members[j].Endpoint
+	})
+
+	page, next, err := paginate(len(members), form.Get("NextToken"))
+	if err != nil {
+		return nil, err
+	}
+	members = members[page[0]:page[1]]
+	if action == "ListSubscriptionsByTopic" {
+		return listSubscriptionsByTopicResponse{Subscriptions: members, NextToken: next, responseMetadata: newMetadata()}, nil
+	}
+	return listSubscriptionsResponse{Subscriptions: members, NextToken: next, responseMetadata: newMetadata()}, nil
+}
@@ -0,0 +1,171 @@
+package emulator_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/emulator"
+)
+
+type createTopicResult struct {
+	TopicArn string `xml:"CreateTopicResult>TopicArn"`
+}
+
+// setup runs an emulator and a strict gosns server with one topic, named
+// name, subscribed at /orders.
+func setup(t *testing.T, name string, attrs url.Values) (*emulator.Emulator, *gosns.SNSClient, string, chan *gosns.Message, string) {
+	t.Helper()
+	e, err := emulator.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Retry.Attempts = 1
+	es := httptest.NewServer(e)
+	t.Cleanup(es.Close)
+	e.URL = es.URL
+	client := e.SNSClient()
+
+	params := url.Values{"Name": {name}}
+	for k, v := range attrs {
+		params[k] = v
+	}
+	var res createTopicResult
+	if err := client.Call(context.Background(), "CreateTopic", params, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &gosns.Server{Strict: true}
+	e.Trust(s)
+	got := make(chan *gosns.Message, 10)
+	s.AddTopic(res.TopicArn, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+
+	subARN, err := client.Subscribe(context.Background(), res.TopicArn, hs.URL+"/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConfirmed(t, client, res.TopicArn)
+	return e, client, res.TopicArn, got, subARN
+}
+
+func waitConfirmed(t *testing.T, client *gosns.SNSClient, topicARN string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		subs, err := client.ListSubscriptions(context.Background(), topicARN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(subs) == 1 && subs[0].SubscriptionArn != "PendingConfirmation" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("subscription was not confirmed")
+}
+
+func receive(t *testing.T, got chan *gosns.Message) *gosns.Message {
+	t.Helper()
+	select {
+	case msg := <-got:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+		return nil
+	}
+}
+
+func TestPublishToServer(t *testing.T) {
+	_, client, topicARN, got, _ := setup(t, "orders", nil)
+	p := &gosns.Publisher{Client: client}
+	attrs := map[string]gosns.MessageAttribute{"tenant": {Type: "String", Value: "acme"}}
+	id, err := p.Publish(context.Background(), topicARN, "new order", `{"id":1}`, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := receive(t, got)
+	if msg.MessageId != id || msg.Subject != "new order" || msg.Message != `{"id":1}` ||
+		msg.TopicArn != topicARN || msg.MessageAttributes["tenant"].Value != "acme" {
+		t.Errorf("got %+v", msg)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	_, client, topicARN, got, subARN := setup(t, "orders", nil)
+	if err := client.Unsubscribe(context.Background(), subARN); err != nil {
+		t.Fatal(err)
+	}
+	if subs, err := client.ListSubscriptions(context.Background(), ""); err != nil || len(subs) != 0 {
+		t.Fatalf("after unsubscribing: %v, %v", subs, err)
+	}
+	p := &gosns.Publisher{Client: client}
+	if _, err := p.Publish(context.Background(), topicARN, "", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-got:
+		t.Errorf("delivered %+v after unsubscribing", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFIFODeduplication(t *testing.T) {
+	_, client, topicARN, got, _ := setup(t, "orders.fifo", url.Values{
+		"Attributes.entry.1.key":   {"FifoTopic"},
+		"Attributes.entry.1.value": {"true"},
+	})
+	p := &gosns.Publisher{Client: client}
+	pub := &gosns.Publication{TopicArn: topicARN, Message: "once", MessageGroupId: "g"}
+	if _, err := p.Send(context.Background(), pub); err == nil {
+		t.Error("accepted a FIFO message without a deduplication ID")
+	}
+	pub.MessageDeduplicationId = "d-1"
+	first, err := p.Send(context.Background(), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := p.Send(context.Background(), pub); err != nil || again != first {
+		t.Errorf("duplicate publish gave %q, %v; want %q", again, err, first)
+	}
+	receive(t, got)
+	select {
+	case msg := <-got:
+		t.Errorf("duplicate delivered: %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestErrors(t *testing.T) {
+	e, err := emulator.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := httptest.NewServer(e)
+	defer es.Close()
+	e.URL = es.URL
+	client := e.SNSClient()
+
+	var serr *gosns.SNSError
+	p := &gosns.Publisher{Client: client, MaxAttempts: 1}
+	_, err = p.Publish(context.Background(), "arn:aws:sns:us-east-1:000000000000:missing", "", "hello", nil)
+	if !errors.As(err, &serr) || serr.Code != "NotFound" {
+		t.Errorf("publish to a missing topic gave %v", err)
+	}
+	err = client.Call(context.Background(), "CreateTopic", url.Values{"Name": {"bad name"}}, nil)
+	if !errors.As(err, &serr) || serr.Code != "InvalidParameter" {
+		t.Errorf("bad topic name gave %v", err)
+	}
+	if _, err = client.Subscribe(context.Background(), "arn:aws:sns:us-east-1:000000000000:missing", "http://localhost/x"); err == nil {
+		t.Error("subscribed to a missing topic")
+	}
+}
@@ -0,0 +1,276 @@
+package emulator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// dedupWindow is how long SNS remembers FIFO deduplication IDs.
+const dedupWindow = 5 * time.Minute
+
+type dedupEntry struct {
+	messageID string
+	expires   time.Time
+}
+
+type publishResponse struct {
+	XMLName        xml.Name `xml:"PublishResponse"`
+	MessageId      string   `xml:"PublishResult>MessageId"`
+	SequenceNumber string   `xml:"PublishResult>SequenceNumber,omitempty"`
+	responseMetadata
+}
+
+func (e *Emulator) publish(form url.Values) (interface{}, error) {
+	topicARN := form.Get("TopicArn")
+	if topicARN == "" {
+		topicARN = form.Get("TargetArn")
+	}
+	id, seq, err := e.publishEntry(topicARN, form, "")
+	if err != nil {
+		return nil, err
+	}
+	return publishResponse{MessageId: id, SequenceNumber: seq, responseMetadata: newMetadata()}, nil
+}
+
+type batchSuccess struct {
+	Id             string
+	MessageId      string
+	SequenceNumber string `xml:",omitempty"`
+}
+
+type batchFailure struct {
+	Id          string
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+type publishBatchResponse struct {
+	XMLName    xml.Name       `xml:"PublishBatchResponse"`
+	Successful []batchSuccess `xml:"PublishBatchResult>Successful>member"`
+	Failed     []batchFailure `xml:"PublishBatchResult>Failed>member"`
+	responseMetadata
+}
+
+func (e *Emulator) publishBatch(form url.Values) (interface{}, error) {
+	topicARN := form.Get("TopicArn")
+	e.mu.Lock()
+	exists := e.topics[topicARN] != nil
+	e.mu.Unlock()
+	if !exists {
+		return nil, notFound("Topic")
+	}
+	resp := publishBatchResponse{responseMetadata: newMetadata()}
+	for i := 1; ; i++ {
+		prefix := "PublishBatchRequestEntries.member." + strconv.Itoa(i) + "."
+		if _, ok := form[prefix+"Id"]; !ok {
+			break
+		}
+		if i > 10 {
+			return nil, &apiError{http.StatusBadRequest, "TooManyEntriesInBatchRequest", "The batch request contains more entries than permissible"}
+		}
+		entryID := form.Get(prefix + "Id")
+		id, seq, err := e.publishEntry(topicARN, form, prefix)
+		if err != nil {
+			code, msg := "InternalError", err.Error()
+			if aerr, ok := err.(*apiError); ok {
+				code, msg = aerr.Code, aerr.Msg
+			}
+			resp.Failed = append(resp.Failed, batchFailure{entryID, code, msg, true})
+			continue
+		}
+		resp.Successful = append(resp.Successful, batchSuccess{entryID, id, seq})
+	}
+	return resp, nil
+}
+
+// publishEntry publishes the message whose parameters start with prefix,
+// returning its MessageId and, for FIFO topics, its sequence number.
+func (e *Emulator) publishEntry(topicARN string, form url.Values, prefix string) (string, string, error) {
+	message := form.Get(prefix + "Message")
+	if message == "" {
+		return "", "", invalidParameter("Invalid parameter: Empty message")
+	}
+	if form.Get(prefix+"MessageStructure") == "json" {
+		var structured map[string]string
+		if json.Unmarshal([]byte(message), &structured) != nil || structured["default"] == "" {
+			return "", "", invalidParameter("Invalid parameter: Message Structure - JSON message body failed to parse or has no default")
+		}
+		message = structured["default"]
+		if m, ok := structured["http"]; ok {
+			message = m
+		}
+	}
+	attrs, err := messageAttributes(form, prefix)
+	if err != nil {
+		return "", "", err
+	}
+
+	env := gosnstest.NewNotification(topicARN, form.Get(prefix+"Subject"), message)
+	env.MessageAttributes = attrs
+	env.UnsubscribeURL = ""
+
+	e.mu.Lock()
+	t := e.topics[topicARN]
+	if t == nil {
+		e.mu.Unlock()
+		return "", "", notFound("Topic")
+	}
+	seq := ""
+	if strings.HasSuffix(t.arn, ".fifo") {
+		if form.Get(prefix+"MessageGroupId") == "" {
+			e.mu.Unlock()
+			return "", "", invalidParameter("Invalid parameter: The MessageGroupId parameter is required for FIFO topics")
+		}
+		dedup := form.Get(prefix + "MessageDeduplicationId")
+		if dedup == "" && t.contentDedup {
+			h := sha256.Sum256([]byte(message))
+			dedup = hex.EncodeToString(h[:])
+		}
+		if dedup == "" {
+			e.mu.Unlock()
+			return "", "", invalidParameter("Invalid parameter: The topic should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+		}
+		e.seq++
+		seq = fmt.Sprintf("%020d", e.seq)
+		now := time.Now()
+		if d, ok := t.dedup[dedup]; ok && now.Before(d.expires) {
+			e.mu.Unlock()
+			return d.messageID, seq, nil
+		}
+		t.dedup[dedup] = dedupEntry{env.MessageId, now.Add(dedupWindow)}
+	}
+	var subs []*subscription
+	for _, sub := range t.subs {
+		if sub.confirmed {
+			subs = append(subs, sub)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, sub := range subs {
+		se := *env
+		se.SubscriptionArn = sub.arn
+		se.UnsubscribeURL = strings.TrimSuffix(e.URL, "/") + "/?" + url.Values{
+			"Action":          {"Unsubscribe"},
+			"SubscriptionArn": {sub.arn},
+		}.Encode()
+		go e.deliver(sub, &se)
+	}
+	return env.MessageId, seq, nil
+}
+
+// messageAttributes returns the MessageAttributes.entry.N parameters of the
+// message whose parameters start with prefix.
+func messageAttributes(form url.Values, prefix string) (map[string]gosns.MessageAttribute, error) {
+	var attrs map[string]gosns.MessageAttribute
+	for i := 1; ; i++ {
+		ap := prefix + "MessageAttributes.entry." + strconv.Itoa(i) + "."
+		name := form.Get(ap + "Name")
+		if name == "" {
+			return attrs, nil
+		}
+		attr := gosns.MessageAttribute{Type: form.Get(ap + "Value.DataType")}
+		switch {
+		case attr.Type == "Binary":
+			attr.Value = form.Get(ap + "Value.BinaryValue")
+		case attr.Type == "String" || attr.Type == "String.Array" || strings.HasPrefix(attr.Type, "Number"):
+			attr.Value = form.Get(ap + "Value.StringValue")
+		default:
+			return nil, invalidParameter("Invalid parameter: The message attribute '%s' has an invalid message attribute type", name)
+		}
+		if attrs == nil {
+			attrs = make(map[string]gosns.MessageAttribute)
+		}
+		attrs[name] = attr
+	}
+}
+
+// deliver signs env and sends it to sub's endpoint, retrying notifications
+// according to Retry while the subscription exists.
+func (e *Emulator) deliver(sub *subscription, env *gosnstest.Envelope) {
+	signer := *e.signer
+	signer.CertURL = e.CertURL()
+	if err := signer.Sign(env); err != nil {
+		e.logf("Signing %s for '%s' failed: %v\n", env.MessageId, sub.endpoint, err)
+		return
+	}
+	attempts := e.Retry.Attempts
+	if attempts < 1 || env.Type != "Notification" {
+		attempts = 1
+	}
+	delay := e.Retry.MinDelay
+	for i := 1; i <= attempts; i++ {
+		code, err := e.send(sub, env)
+		switch {
+		case err == nil && code >= 200 && code < 300:
+			e.logf("Delivered %s %s to '%s'\n", env.Type, env.MessageId, sub.endpoint)
+			return
+		case err == nil && code >= 300 && code < 500:
+			e.logf("Delivery of %s %s to '%s' failed permanently: status %d\n", env.Type, env.MessageId, sub.endpoint, code)
+			return
+		case err != nil:
+			e.logf("Delivery of %s %s to '%s' failed: attempt %d: %v\n", env.Type, env.MessageId, sub.endpoint, i, err)
+		default:
+			e.logf("Delivery of %s %s to '%s' failed: attempt %d: status %d\n", env.Type, env.MessageId, sub.endpoint, i, code)
+		}
+		if i < attempts {
+			time.Sleep(delay)
+			delay += e.Retry.MinDelay
+			if e.Retry.MaxDelay > 0 && delay > e.Retry.MaxDelay {
+				delay = e.Retry.MaxDelay
+			}
+			e.mu.Lock()
+			live := e.subs[sub.arn] == sub
+			e.mu.Unlock()
+			if !live {
+				return
+			}
+		}
+	}
+}
+
+// send makes one delivery attempt, returning the response status.
+func (e *Emulator) send(sub *subscription, env *gosnstest.Envelope) (int, error) {
+	var req *http.Request
+	var err error
+	if sub.raw && env.Type == "Notification" {
+		req, err = http.NewRequest("POST", sub.endpoint, bytes.NewReader([]byte(env.Message)))
+		if err == nil {
+			req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+			req.Header.Set("User-Agent", "Amazon Simple Notification Service Agent")
+			req.Header.Set("x-amz-sns-message-type", env.Type)
+			req.Header.Set("x-amz-sns-message-id", env.MessageId)
+			req.Header.Set("x-amz-sns-topic-arn", env.TopicArn)
+			req.Header.Set("x-amz-sns-subscription-arn", sub.arn)
+			req.Header.Set("x-amz-sns-rawdelivery", "true")
+		}
+	} else {
+		req, err = gosnstest.NewClientRequest(sub.endpoint, env)
+	}
+	if err != nil {
+		return 0, err
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
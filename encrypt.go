@@ -0,0 +1,94 @@
+package gosns
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDecryptFailed is returned by an EncryptedStore for a value which none of
+// its keys can decrypt, such as one altered in the underlying Store.
+var ErrDecryptFailed = errors.New("gosns: stored value could not be decrypted")
+
+// EncryptedStore is a Store which encrypts values with AES-GCM before saving
+// them in another, so that the messages it holds (journaled, delayed,
+// quarantined and so on) are encrypted at rest. Keys are not encrypted, and
+// include endpoints and MessageIds. Each value is bound to its key, so that
+// values cannot be swapped between keys undetected.
+type EncryptedStore struct {
+	Store Store
+
+	// Key is the AES key, of 16, 24 or 32 bytes, used to encrypt values.
+	Key []byte
+
+	// OldKeys are tried in turn for values Key cannot decrypt, so that keys
+	// can be rotated; values are encrypted with Key when next saved.
+	OldKeys [][]byte
+}
+
+// ParseEncryptionKey decodes a base64 AES key of 16, 24 or 32 bytes, such as
+// one generated with "openssl rand -base64 32".
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("gosns: encryption key is not base64: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, fmt.Errorf("gosns: encryption key is %d bytes, not 16, 24 or 32", len(key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *EncryptedStore) Put(key string, value []byte) error {
+	gcm, err := newGCM(e.Key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(value)+gcm.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	return e.Store.Put(key, gcm.Seal(nonce, nonce, value, []byte(key)))
+}
+
+func (e *EncryptedStore) Get(key string) ([]byte, error) {
+	data, err := e.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range append([][]byte{e.Key}, e.OldKeys...) {
+		gcm, err := newGCM(k)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < gcm.NonceSize() {
+			break
+		}
+		n := gcm.NonceSize()
+		if value, err := gcm.Open(nil, data[:n], data[n:], []byte(key)); err == nil {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDecryptFailed, key)
+}
+
+func (e *EncryptedStore) Delete(key string) error {
+	return e.Store.Delete(key)
+}
+
+func (e *EncryptedStore) List(prefix string) ([]string, error) {
+	return e.Store.List(prefix)
+}
@@ -0,0 +1,99 @@
+package gosns_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	raw := &gosns.MemoryStore{}
+	oldKey, key := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32)
+	old := &gosns.EncryptedStore{Store: raw, Key: oldKey}
+	if err := old.Put("journal/orders/m-1", []byte("card 4111")); err != nil {
+		t.Fatal(err)
+	}
+
+	es := &gosns.EncryptedStore{Store: raw, Key: key, OldKeys: [][]byte{oldKey}}
+	if v, err := es.Get("journal/orders/m-1"); err != nil || string(v) != "card 4111" {
+		t.Fatalf("old value read as %q, %v", v, err)
+	}
+	if err := es.Put("journal/orders/m-2", []byte("card 5500")); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := raw.Get("journal/orders/m-2")
+	if bytes.Contains(data, []byte("5500")) {
+		t.Error("value stored in plaintext")
+	}
+	if v, err := es.Get("journal/orders/m-2"); err != nil || string(v) != "card 5500" {
+		t.Errorf("read %q, %v", v, err)
+	}
+	if _, err := old.Get("journal/orders/m-2"); !errors.Is(err, gosns.ErrDecryptFailed) {
+		t.Errorf("old key read new value: %v", err)
+	}
+
+	// values are bound to their keys
+	raw.Put("journal/orders/m-3", data)
+	if _, err := es.Get("journal/orders/m-3"); !errors.Is(err, gosns.ErrDecryptFailed) {
+		t.Errorf("moved value gave %v", err)
+	}
+	raw.Put("journal/orders/m-4", []byte("x"))
+	if _, err := es.Get("journal/orders/m-4"); !errors.Is(err, gosns.ErrDecryptFailed) {
+		t.Errorf("short value gave %v", err)
+	}
+	if _, err := es.Get("journal/orders/none"); err != gosns.ErrNotFound {
+		t.Errorf("missing value gave %v", err)
+	}
+	if keys, _ := es.List("journal/"); len(keys) != 4 {
+		t.Errorf("listed %q", keys)
+	}
+}
+
+func TestParseEncryptionKey(t *testing.T) {
+	for s, ok := range map[string]bool{
+		base64.StdEncoding.EncodeToString(make([]byte, 32)) + "\n": true,
+		base64.StdEncoding.EncodeToString(make([]byte, 16)):        true,
+		base64.StdEncoding.EncodeToString(make([]byte, 20)):        false,
+		"not base64!": false,
+	} {
+		if _, err := gosns.ParseEncryptionKey(s); (err == nil) != ok {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+}
+
+func TestKMSDecrypt(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var in struct{ CiphertextBlob []byte }
+		json.Unmarshal(body, &in)
+		if r.Header.Get("X-Amz-Target") != "TrentService.Decrypt" || r.Header.Get("Authorization") == "" {
+			t.Errorf("request headers %v", r.Header)
+		}
+		if string(in.CiphertextBlob) != "wrapped" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "com.amazonaws.kms#InvalidCiphertextException", "message": "bad blob"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": []byte("data key")})
+	}))
+	defer ts.Close()
+
+	c := &gosns.KMSClient{Region: "us-gov-west-1", Endpoint: ts.URL, Credentials: &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}
+	if key, err := c.Decrypt(context.Background(), []byte("wrapped")); err != nil || string(key) != "data key" {
+		t.Errorf("got %q, %v", key, err)
+	}
+	_, err := c.Decrypt(context.Background(), []byte("other"))
+	var kerr *gosns.KMSError
+	if !errors.As(err, &kerr) || kerr.Type != "InvalidCiphertextException" || kerr.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package gosns
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// envelope is the JSON document SNS posts to HTTP(S) endpoints.
+type envelope struct {
+	Type              string
+	MessageId         string
+	Token             string
+	TopicArn          string
+	Subject           string
+	Message           string
+	Timestamp         string
+	SignatureVersion  string
+	Signature         string
+	SigningCertURL    string
+	SubscribeURL      string
+	UnsubscribeURL    string
+	SequenceNumber    string
+	MessageAttributes map[string]MessageAttribute
+
+	bodySHA256 string // with Server.HashBodies
+}
+
+// bufPool holds the body buffers of raw message deliveries for reuse
+// between requests. JSON envelopes are decoded without a body buffer.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer keeps unusually large buffers from being pinned by the pool.
+const maxPooledBuffer = 1 << 20
+
+// readEnvelope decodes the request body. JSON envelopes are decoded as they
+// are read, so that hostile bodies can be rejected without buffering them in
+// full. Raw message deliveries are read into a pooled buffer and wrapped in
+// an envelope built from the request headers.
+func (s *Server) readEnvelope(r *http.Request) (*envelope, error) {
+	defer r.Body.Close()
+	env := &envelope{}
+	body := io.Reader(r.Body)
+	if s.HashBodies {
+		h := sha256.New()
+		body = io.TeeReader(r.Body, h)
+		defer func() {
+			env.bodySHA256 = hex.EncodeToString(h.Sum(nil))
+		}()
+	}
+
+	if r.Header.Get("x-amz-sns-rawdelivery") == "true" {
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer func() {
+			if buf.Cap() <= maxPooledBuffer {
+				bufPool.Put(buf)
+			}
+		}()
+		if r.ContentLength > 0 && r.ContentLength <= maxPooledBuffer {
+			buf.Grow(int(r.ContentLength))
+		}
+		if _, err := buf.ReadFrom(body); err != nil {
+			return nil, bodyError(err)
+		}
+
+		env.Type = r.Header.Get("x-amz-sns-message-type")
+		env.TopicArn = r.Header.Get("x-amz-sns-topic-arn")
+		env.Message = buf.String()
+		env.MessageId = r.Header.Get("x-amz-sns-message-id")
+		env.Timestamp = time.Now().In(time.UTC).Format(amzTimeFormat)
+		return env, nil
+	}
+
+	dec := json.NewDecoder(body)
+	if s.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(env); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty body")
+		}
+		return nil, bodyError(err)
+	}
+	if s.HashBodies {
+		// the decoder stops at the end of the envelope, so hash the rest
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return nil, bodyError(err)
+		}
+	}
+	return env, nil
+}
+
+// bodyError maps the error from an http.MaxBytesReader to ErrBodyTooLarge.
+func bodyError(err error) error {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return ErrBodyTooLarge
+	}
+	return err
+}
+
+// message builds the handler's view of a notification envelope, parsing
+// its Timestamp with layouts (see Server.TimestampLayouts).
+func (env *envelope) message(layouts []string) *Message {
+	msg := &Message{
+		Subject:           env.Subject,
+		Message:           env.Message,
+		MessageId:         env.MessageId,
+		TopicArn:          env.TopicArn,
+		MessageAttributes: env.MessageAttributes,
+		BodySHA256:        env.bodySHA256,
+		RawTimestamp:      env.Timestamp,
+	}
+	tm, err := parseTimestamp(env.Timestamp, layouts)
+	if err != nil {
+		tm, msg.TimestampFallback = time.Now().UTC(), true
+		msg.TimestampError = fmt.Errorf("gosns: invalid Timestamp %q: %w", env.Timestamp, err)
+	}
+	msg.Timestamp = tm
+	return msg
+}
+
+// parseTimestamp parses ts with the first of layouts, or of
+// DefaultTimestampLayouts if there are none, which accepts it, returning
+// the time in UTC.
+func parseTimestamp(ts string, layouts []string) (time.Time, error) {
+	if len(layouts) == 0 {
+		layouts = DefaultTimestampLayouts
+	}
+	var err error
+	for _, layout := range layouts {
+		var tm time.Time
+		if tm, err = time.Parse(layout, ts); err == nil {
+			return tm.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,222 @@
+package gosns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const benchTopic = "arn:aws:sns:us-east-1:123456789012:bench"
+
+var benchBody = `{
+  "Type" : "Notification",
+  "MessageId" : "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+  "TopicArn" : "` + benchTopic + `",
+  "Subject" : "My First Message",
+  "Message" : "{\"order\":12345,\"items\":[{\"sku\":\"A-1\",\"qty\":2},{\"sku\":\"B-7\",\"qty\":1}],\"note\":\"` + strings.Repeat("x", 512) + `\"}",
+  "Timestamp" : "2012-05-02T00:54:06.655Z",
+  "SignatureVersion" : "1",
+  "Signature" : "EXAMPLEw6JRNwm1LFQL4ICB0bnXrdB8ClRMTQFGBqwLpGbM78tJ4etTwC5zU7O3tS6tGpey3ejedNdOJ+1fkIp9F2/LmNVKb5aFlYq+9rk9ZiPph5YlLmWsDcyC5T+Sy9/umic5S0UQc2PEtgdpVBahwNOdMW4JPwk0kAJJztnc=",
+  "SigningCertURL" : "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-f3ecfb7224c7233fe7bb5f59f96de52f.pem",
+  "UnsubscribeURL" : "https://sns.us-east-1.amazonaws.com/?Action=Unsubscribe&SubscriptionArn=` + benchTopic + `:c9135db0",
+  "MessageAttributes" : {
+    "tenant" : {"Type":"String","Value":"acme"},
+    "priority" : {"Type":"Number","Value":"3"}
+  }
+}`
+
+func benchRequest(body string) *http.Request {
+	r := httptest.NewRequest("POST", "/bench", strings.NewReader(body))
+	r.Header.Set("x-amz-sns-message-type", "Notification")
+	r.Header.Set("x-amz-sns-topic-arn", benchTopic)
+	r.Header.Set("x-amz-sns-message-id", "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return r
+}
+
+// decodeMap is the decoder readEnvelope replaced: the whole body is read and
+// then unmarshalled into a map, from which the message is picked out.
+func decodeMap(r *http.Request) *Message {
+	nbytes, _ := strconv.Atoi(r.Header.Get("Content-Length"))
+	jsonBytes := make([]byte, nbytes)
+	if _, err := io.ReadFull(r.Body, jsonBytes); err != nil {
+		return nil
+	}
+	data := make(map[string]interface{})
+	if json.Unmarshal(jsonBytes, &data) != nil {
+		return nil
+	}
+	tm, _ := time.Parse(amzTimeFormat, data["Timestamp"].(string))
+	msg := &Message{
+		Message:   data["Message"].(string),
+		MessageId: data["MessageId"].(string),
+		Timestamp: tm,
+	}
+	if data["Subject"] != nil {
+		msg.Subject = data["Subject"].(string)
+	}
+	if attrs, ok := data["MessageAttributes"].(map[string]interface{}); ok {
+		msg.MessageAttributes = make(map[string]MessageAttribute, len(attrs))
+		for name, a := range attrs {
+			am, _ := a.(map[string]interface{})
+			typ, _ := am["Type"].(string)
+			val, _ := am["Value"].(string)
+			msg.MessageAttributes[name] = MessageAttribute{Type: typ, Value: val}
+		}
+	}
+	return msg
+}
+
+func BenchmarkDecode(b *testing.B) {
+	s := &Server{}
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if decodeMap(benchRequest(benchBody)) == nil {
+				b.Fatal("decode failed")
+			}
+		}
+	})
+	b.Run("envelope", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			env, err := s.readEnvelope(benchRequest(benchBody))
+			if err != nil {
+				b.Fatal(err)
+			}
+			env.message(nil)
+		}
+	})
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	s := &Server{}
+	s.AddTopic(benchTopic, "/bench", func(*Message) {})
+	for _, c := range []struct {
+		name, body string
+		raw        bool
+	}{
+		{"json", benchBody, false},
+		{"raw", `{"order":12345}`, true},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := benchRequest(c.body)
+				if c.raw {
+					r.Header.Set("x-amz-sns-rawdelivery", "true")
+				}
+				w := httptest.NewRecorder()
+				s.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					b.Fatalf("status %d: %s", w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestReadEnvelope(t *testing.T) {
+	r := benchRequest(benchBody)
+	env, err := (&Server{}).readEnvelope(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := env.message(nil)
+	want := decodeMap(benchRequest(benchBody))
+	if msg.MessageId != want.MessageId || msg.Subject != want.Subject || msg.Message != want.Message ||
+		!msg.Timestamp.Equal(want.Timestamp) || len(msg.MessageAttributes) != 2 ||
+		msg.MessageAttributes["tenant"] != want.MessageAttributes["tenant"] {
+		t.Errorf("got %+v, want %+v", msg, want)
+	}
+	if msg.TopicArn != benchTopic {
+		t.Errorf("TopicArn %q", msg.TopicArn)
+	}
+
+	r = benchRequest("hello, raw")
+	r.Header.Set("x-amz-sns-rawdelivery", "true")
+	if env, err = (&Server{}).readEnvelope(r); err != nil {
+		t.Fatal(err)
+	}
+	if env.Message != "hello, raw" || env.TopicArn != benchTopic || env.MessageId == "" || env.Type != "Notification" {
+		t.Errorf("raw delivery gave %+v", env)
+	}
+
+	for _, c := range []struct {
+		name   string
+		server *Server
+		body   string
+	}{
+		{"empty", &Server{}, ""},
+		{"malformed", &Server{}, `{"Type":`},
+		{"unknown field", &Server{DisallowUnknownFields: true}, `{"Type":"Notification","Extra":1}`},
+	} {
+		if _, err := c.server.readEnvelope(benchRequest(c.body)); err == nil {
+			t.Errorf("%s body was accepted", c.name)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r = benchRequest(benchBody)
+	r.Body = http.MaxBytesReader(w, r.Body, 100)
+	if _, err := (&Server{}).readEnvelope(r); err != ErrBodyTooLarge {
+		t.Errorf("oversized body gave %v", err)
+	}
+}
+
+func TestHashBodies(t *testing.T) {
+	s := &Server{HashBodies: true}
+	for _, body := range []string{benchBody + "\n\n", "hello, raw"} {
+		r := benchRequest(body)
+		if !strings.HasPrefix(body, "{") {
+			r.Header.Set("x-amz-sns-rawdelivery", "true")
+		}
+		env, err := s.readEnvelope(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(body))
+		if got := env.message(nil).BodySHA256; got != hex.EncodeToString(sum[:]) {
+			t.Errorf("hash of %.20q was %s", body, got)
+		}
+	}
+	env, _ := (&Server{}).readEnvelope(benchRequest(benchBody))
+	if env.message(nil).BodySHA256 != "" {
+		t.Error("body hashed without HashBodies")
+	}
+}
+
+func TestMessageTimestamp(t *testing.T) {
+	want := time.Date(2026, 10, 14, 12, 0, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		ts      string
+		layouts []string
+		ok      bool
+	}{
+		{"2026-10-14T12:00:05.000Z", nil, true},
+		{"2026-10-14T12:00:05Z", nil, true},
+		{"2026-10-14T14:00:05+02:00", nil, true},
+		{"2026-10-14T12:00:05.000Z", []string{SNSTimestampLayout}, true},
+		{"2026-10-14T12:00:05Z", []string{SNSTimestampLayout}, false},
+		{"2026-10-14 12:00:05", nil, false},
+		{"", nil, false},
+	} {
+		msg := (&envelope{Timestamp: tc.ts}).message(tc.layouts)
+		if msg.RawTimestamp != tc.ts || tc.ok && msg.TimestampError != nil {
+			t.Errorf("%q: raw %q, error %v", tc.ts, msg.RawTimestamp, msg.TimestampError)
+		}
+		switch {
+		case tc.ok && (msg.TimestampFallback || !msg.Timestamp.Equal(want) || msg.Timestamp.Location() != time.UTC):
+			t.Errorf("%q: got %v, fallback %v", tc.ts, msg.Timestamp, msg.TimestampFallback)
+		case !tc.ok && (!msg.TimestampFallback || time.Since(msg.Timestamp) > time.Minute || msg.TimestampError == nil):
+			t.Errorf("%q: got %v, fallback %v", tc.ts, msg.Timestamp, msg.TimestampFallback)
+		}
+	}
+}
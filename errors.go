@@ -0,0 +1,80 @@
+package gosns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors for requests the server refused or did not hand to a callback.
+// They are passed to Server.OnResponse in Response.Err, possibly wrapped with
+// more detail, so match them with errors.Is.
+var (
+	ErrTopicNotFound       = errors.New("gosns: no topic registered for endpoint")
+	ErrUnauthorized        = errors.New("gosns: missing or wrong credentials")
+	ErrVerificationFailed  = errors.New("gosns: signature verification failed")
+	ErrBodyTooLarge        = errors.New("gosns: request body too large")
+	ErrHeadersTooLarge     = errors.New("gosns: request headers too large")
+	ErrUnknownSubscription = errors.New("gosns: unknown subscription")
+	ErrAccountNotAllowed   = errors.New("gosns: topic account not allowed")
+	ErrOverloaded          = errors.New("gosns: topic overloaded")
+	ErrTransformFailed     = errors.New("gosns: transform failed")
+	ErrDuplicate           = errors.New("gosns: duplicate message dropped")
+	ErrMaintenance         = errors.New("gosns: server in maintenance mode")
+	ErrPaused              = errors.New("gosns: topic paused")
+	ErrNotReady            = errors.New("gosns: topic dependencies not ready")
+	ErrCallbackFailed      = errors.New("gosns: callback failed")
+	ErrDeadLettered        = errors.New("gosns: message dead-lettered")
+)
+
+// Errors returned by Server.RegisterTopic, and ErrInvalidARN by ParseARN.
+var (
+	ErrInvalidARN      = errors.New("gosns: invalid ARN")
+	ErrInvalidEndpoint = errors.New("gosns: invalid endpoint")
+	ErrEndpointInUse   = errors.New("gosns: endpoint already has a topic")
+	ErrNilCallback     = errors.New("gosns: nil callback")
+)
+
+// reasonErrors are the errors set in Response.Err for rejections whose
+// cause is not otherwise known.
+var reasonErrors = map[string]error{
+	ReasonNotFound:            ErrTopicNotFound,
+	ReasonUnauthorized:        ErrUnauthorized,
+	ReasonBadSignature:        ErrVerificationFailed,
+	ReasonBodyTooLarge:        ErrBodyTooLarge,
+	ReasonHeadersTooLarge:     ErrHeadersTooLarge,
+	ReasonUnknownSubscription: ErrUnknownSubscription,
+	ReasonAccountNotAllowed:   ErrAccountNotAllowed,
+	ReasonOverloaded:          ErrOverloaded,
+	ReasonTransformFailed:     ErrTransformFailed,
+	ReasonMaintenance:         ErrMaintenance,
+	ReasonPaused:              ErrPaused,
+	ReasonNotReady:            ErrNotReady,
+	ReasonCallbackFailed:      ErrCallbackFailed,
+}
+
+// TopicMismatchError is the error for a request naming a topic other than
+// its endpoint's.
+type TopicMismatchError struct {
+	Endpoint string
+	Expected string // the endpoint's TopicARN, which may be a pattern
+	TopicArn string // the topic the request named
+}
+
+func (e *TopicMismatchError) Error() string {
+	return fmt.Sprintf("gosns: endpoint '%s' is for topic '%s', not '%s'", e.Endpoint, e.Expected, e.TopicArn)
+}
+
+// ConfirmationError is returned when a subscription could not be
+// confirmed, because SNS could not be reached or refused the token.
+type ConfirmationError struct {
+	TopicArn   string
+	Endpoint   string
+	StatusCode int // the status SNS answered with, or 0 if it was not reached
+	Err        error
+}
+
+func (e *ConfirmationError) Error() string {
+	return fmt.Sprintf("gosns: confirming subscription of '%s' to topic '%s': %v", e.Endpoint, e.TopicArn, e.Err)
+}
+
+func (e *ConfirmationError) Unwrap() error { return e.Err }
@@ -0,0 +1,116 @@
+package gosns_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestResponseErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	s := &gosns.Server{VerifySignatures: true, OnResponse: func(r *http.Request, kind gosns.ResponseKind, resp *gosns.Response) {
+		errs <- resp.Err
+	}}
+	topic := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	topic.DuplicateWindow, topic.DropDuplicates = time.Minute, true
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	next := func() error {
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no response")
+			return nil
+		}
+	}
+
+	ts.Notify("/missing", ordersARN, "", "hello")
+	if err := next(); !errors.Is(err, gosns.ErrTopicNotFound) {
+		t.Errorf("unknown endpoint gave %v", err)
+	}
+
+	req, _ := gosnstest.NewClientRequest(ts.URL+"/orders", gosnstest.NewNotification(ordersARN, "", "unsigned"))
+	if _, err := ts.Client().Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := next(); !errors.Is(err, gosns.ErrVerificationFailed) {
+		t.Errorf("unsigned notification gave %v", err)
+	}
+
+	ts.Notify("/orders", "arn:aws:sns:us-east-1:123456789012:other", "", "hello")
+	var mismatch *gosns.TopicMismatchError
+	if err := next(); !errors.As(err, &mismatch) || mismatch.Endpoint != "/orders" || mismatch.Expected != ordersARN ||
+		mismatch.TopicArn != "arn:aws:sns:us-east-1:123456789012:other" {
+		t.Errorf("mismatched topic gave %v", err)
+	}
+
+	env := gosnstest.NewNotification(ordersARN, "", "hello")
+	for i, want := range []error{nil, gosns.ErrDuplicate} {
+		if _, err := ts.Send("/orders", env); err != nil {
+			t.Fatal(err)
+		}
+		if err := next(); err != want {
+			t.Errorf("delivery %d gave %v, want %v", i, err, want)
+		}
+	}
+}
+
+func TestConfirmationError(t *testing.T) {
+	sns := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "token expired", http.StatusForbidden)
+	}))
+	defer sns.Close()
+	s := &gosns.Server{ManualConfirm: true}
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	conf := gosnstest.NewSubscriptionConfirmation(ordersARN, "")
+	conf.SubscribeURL = sns.URL + "/?Action=ConfirmSubscription"
+	if _, err := ts.Send("/orders", conf); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.ApproveConfirmation(conf.Token)
+	var ce *gosns.ConfirmationError
+	if !errors.As(err, &ce) || ce.StatusCode != http.StatusForbidden || ce.TopicArn != ordersARN || ce.Endpoint != "/orders" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRegisterTopic(t *testing.T) {
+	s := &gosns.Server{}
+	cb := func(*gosns.Message) {}
+	if _, err := s.RegisterTopic(ordersARN, "/orders", cb); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		arn, endpoint string
+		cb            func(*gosns.Message)
+		want          error
+	}{
+		{"arn:aws:sns:us-east-1:123456789012:orders-*", "/orders/{tenant}", cb, nil},
+		{"arn:aws:sns:*:*:*", "/all", cb, nil},
+		{ordersARN, "/orders", cb, gosns.ErrEndpointInUse},
+		{ordersARN, "orders", cb, gosns.ErrEndpointInUse},
+		{"orders", "/a", cb, gosns.ErrInvalidARN},
+		{"arn:aws:sqs:us-east-1:123456789012:orders", "/b", cb, gosns.ErrInvalidARN},
+		{"arn:aws:sns:us-east-1:12345:orders", "/c", cb, gosns.ErrInvalidARN},
+		{"arn:aws:sns:us-east-1:123456789012:orders.json", "/d", cb, gosns.ErrInvalidARN},
+		{"arn:aws:sns:us-east-1:123456789012:[orders", "/e", cb, gosns.ErrInvalidARN},
+		{"arn:aws:sns::123456789012:orders", "/f", cb, gosns.ErrInvalidARN},
+		{ordersARN, "/g?secret=1", cb, gosns.ErrInvalidEndpoint},
+		{ordersARN, "", cb, gosns.ErrInvalidEndpoint},
+		{ordersARN, "/h", nil, gosns.ErrNilCallback},
+	} {
+		topic, err := s.RegisterTopic(tc.arn, tc.endpoint, tc.cb)
+		if !errors.Is(err, tc.want) || (err == nil) != (topic != nil) {
+			t.Errorf("RegisterTopic(%q, %q) gave %v, want %v", tc.arn, tc.endpoint, err, tc.want)
+		}
+	}
+}
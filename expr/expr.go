@@ -0,0 +1,139 @@
+// Package expr is a small expression language for filtering, routing and
+// rewriting SNS messages from configuration, without recompiling.
+//
+// Expressions see the message as msg, with the fields subject, message, id,
+// topic, topicName (the name of the topic), timestamp (RFC 3339),
+// attributes (by name; Number attributes are numbers) and body (the message
+// parsed as JSON, or nil). For example:
+//
+//	msg.attributes.env == 'prod' && msg.body.order.total >= 100
+//
+// Operators are the usual comparisons, && (and), || (or), ! (not), + - * / %
+// and =~ for regexp matches. || gives the first operand which is true, so
+// msg.body.note || msg.subject picks a default. The functions lower, upper,
+// contains, startsWith, endsWith, matches, len, keys, number and string are
+// available.
+//
+// Lists and objects are written [a, b] and {key: value, 'other key': value},
+// and [*] applies the field accesses and indexes after it to each element
+// of a list, giving a list of the results, so that a body can be built for
+// another system (see package payload):
+//
+//	{order: msg.body.order.id, skus: msg.body.order.items[*].sku}
+//
+// Rules (see ParseRules) build a gosns.Transformer out of expressions:
+//
+//	# only production traffic
+//	if msg.attributes.env != 'prod' then drop
+//	if msg.subject == '' then set subject = 'order ' + msg.body.order.id
+package expr
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses an expression.
+func Compile(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid.
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression for msg. The result is nil, a bool, a
+// float64, a string, a []interface{} or a map[string]interface{}.
+func (e *Expr) Eval(msg *gosns.Message) (interface{}, error) {
+	return e.root(Env(msg))
+}
+
+// Match evaluates the expression for msg and reports whether the result is
+// true. false, nil, 0 and "" are false; everything else is true.
+func (e *Expr) Match(msg *gosns.Message) (bool, error) {
+	v, err := e.Eval(msg)
+	return truthy(v), err
+}
+
+// Env returns the variables an expression sees for msg.
+func Env(msg *gosns.Message) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(msg.MessageAttributes))
+	for name, attr := range msg.MessageAttributes {
+		if attr.Type == "Number" {
+			if n, err := strconv.ParseFloat(attr.Value, 64); err == nil {
+				attrs[name] = n
+				continue
+			}
+		}
+		attrs[name] = attr.Value
+	}
+	var body interface{}
+	if s := strings.TrimSpace(msg.Message); strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+		if json.Unmarshal([]byte(s), &body) != nil {
+			body = nil
+		}
+	}
+	topicName := msg.TopicArn
+	if arn, err := gosns.ParseARN(msg.TopicArn); err == nil {
+		topicName = arn.Resource
+	}
+	ts := ""
+	if !msg.Timestamp.IsZero() {
+		ts = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"msg": map[string]interface{}{
+			"subject":    msg.Subject,
+			"message":    msg.Message,
+			"id":         msg.MessageId,
+			"topic":      msg.TopicArn,
+			"topicName":  topicName,
+			"timestamp":  ts,
+			"attributes": attrs,
+			"body":       body,
+		},
+	}
+}
+
+// Filter returns a Transformer which drops messages for which e is not
+// true.
+func Filter(e *Expr) gosns.Transformer {
+	return Rules{{Cond: &Expr{src: "!(" + e.src + ")", root: not(e.root)}, Action: Drop}}.Transform
+}
+
+func not(n node) node {
+	return func(env map[string]interface{}) (interface{}, error) {
+		v, err := n(env)
+		return !truthy(v), err
+	}
+}
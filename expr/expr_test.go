@@ -0,0 +1,261 @@
+package expr
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+var testMsg = &gosns.Message{
+	Subject:   "Hello",
+	Message:   `{"order":{"total":150,"id":"A1"},"tags":["x","y"],"n":7}`,
+	MessageId: "m-1",
+	TopicArn:  "arn:aws:sns:us-east-1:123456789012:orders",
+	Timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	MessageAttributes: map[string]gosns.MessageAttribute{
+		"env":   {Type: "String", Value: "prod"},
+		"count": {Type: "Number", Value: "3"},
+		"x-y":   {Type: "String", Value: "q"},
+	},
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"-2 - -3", 1.0},
+		{"7 % 4", 3.0},
+		{"msg.body.n % 0.5", 0.0},
+		{"5.5 % 2", 1.5},
+		{"1e-5 * 1e5", 1.0},
+		{"2E+2", 200.0},
+		{"'a' + 1", "a1"},
+		{`"tab\tquote\""`, "tab\tquote\""},
+		{"msg.subject", "Hello"},
+		{"msg.id", "m-1"},
+		{"msg.topic", "arn:aws:sns:us-east-1:123456789012:orders"},
+		{"msg.timestamp", "2024-01-15T10:00:00Z"},
+		{"msg.topicName", "orders"},
+		{"msg.attributes.count * 2", 6.0},
+		{"msg.attributes['x-y']", "q"},
+		{"msg.body.order.id", "A1"},
+		{"msg.body.tags[1]", "y"},
+		{"msg.body.tags[5]", nil},
+		{"msg.body.missing.deep", nil},
+		{"lower(msg.subject)", "hello"},
+		{"upper('a')", "A"},
+		{"len(msg.body.tags)", 2.0},
+		{"number('42')", 42.0},
+		{"number('x')", nil},
+		{"string(1.5)", "1.5"},
+		{"nil", nil},
+		{"0 || 'fallback'", "fallback"},
+	}
+	for _, tt := range tests {
+		e, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := e.Eval(testMsg)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"msg.attributes.env == 'prod' && msg.body.order.total >= 100", true},
+		{"msg.attributes.env != 'prod'", false},
+		{"msg.attributes.env == 'prod' and not (msg.subject =~ '^h')", true},
+		{"msg.subject =~ '(?i)^h'", true},
+		{"contains(msg.body.tags, 'y')", true},
+		{"contains(msg.subject, 'ell')", true},
+		{"startsWith(msg.topic, 'arn:aws:sns:')", true},
+		{"endsWith(msg.topic, ':orders')", true},
+		{"matches(msg.id, '^m-[0-9]+$')", true},
+		{"true || 1 / 0", true},
+		{"false && 1 / 0", false},
+		{"'10' < '9'", true},
+		{"10 < 9", false},
+		{"1 == '1'", false},
+		{"1 != '1'", true},
+		{"nil == nil", true},
+		{"msg.body.missing == nil", true},
+		{"!msg.body.missing", true},
+		{"''", false},
+		{"0", false},
+	}
+	for _, tt := range tests {
+		got, err := MustCompile(tt.src).Match(testMsg)
+		if err != nil {
+			t.Errorf("Match(%q): %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalStructures(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"[]", `[]`},
+		{"[1, 'a', nil, [true]]", `[1,"a",null,[true]]`},
+		{"{}", `{}`},
+		{"{id: msg.id, 'total due': msg.body.order.total, n: {x: msg.body.n + 1}}", `{"id":"m-1","n":{"x":8},"total due":150}`},
+		{"{a: 1}.a", `1`},
+		{"[1, 2][1]", `2`},
+		{"msg.body.tags[*]", `["x","y"]`},
+		{"msg.body.order[*]", `["A1",150]`},
+		{"msg.subject[*]", `[]`},
+		{"[{sku: 'A'}, {sku: 'B'}, {}][*].sku", `["A","B",null]`},
+		{"[[1, 2], [3]][*][0]", `[1,3]`},
+		{"keys(msg.body.order)", `["id","total"]`},
+		{"keys(msg.subject)", `[]`},
+		{"len(keys(msg.attributes))", `3`},
+		{"contains(msg.body.tags[*], 'x')", `true`},
+		{"msg.body.missing || {a: []}", `{"a":[]}`},
+	}
+	for _, tt := range tests {
+		e, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		v, err := e.Eval(testMsg)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.src, err)
+			continue
+		}
+		if got, _ := json.Marshal(v); string(got) != tt.want {
+			t.Errorf("Eval(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1",
+		"msg.",
+		"msg.attributes[1",
+		"'unterminated",
+		"frob(1)",
+		"a =~ b",
+		"a =~ '('",
+		"1 2",
+		"@",
+		"1e",
+		"[1,",
+		"[1 2]",
+		"{a 1}",
+		"{1: 2}",
+		"{a: }",
+		"{",
+		"msg.body.tags[*",
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, src := range []string{
+		"1 / 0",
+		"1 % 0",
+		"'a' - 1",
+		"-'a'",
+		"lower()",
+		"matches('a', '(')",
+	} {
+		if _, err := MustCompile(src).Eval(testMsg); err == nil {
+			t.Errorf("Eval(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules, err := ParseRules(`
+# only production traffic
+if msg.attributes.env != 'prod' then drop
+if msg.subject == 'Hello' then set subject = 'order ' + msg.body.order.id
+set attributes.total = msg.body.order.total  # numbers stay numbers
+set attributes['x-y'] = nil
+if msg.subject == 'order A1' then keep
+drop
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := *testMsg
+	msg.MessageAttributes = map[string]gosns.MessageAttribute{}
+	for k, v := range testMsg.MessageAttributes {
+		msg.MessageAttributes[k] = v
+	}
+	out, err := rules.Transform(nil, &msg)
+	if err != nil || out == nil {
+		t.Fatalf("Transform = %v, %v", out, err)
+	}
+	if out.Subject != "order A1" {
+		t.Errorf("Subject = %q", out.Subject)
+	}
+	if a := out.MessageAttributes["total"]; a.Type != "Number" || a.Value != "150" {
+		t.Errorf("total attribute = %+v", a)
+	}
+	if _, ok := out.MessageAttributes["x-y"]; ok {
+		t.Errorf("x-y attribute not deleted")
+	}
+
+	msg.MessageAttributes["env"] = gosns.MessageAttribute{Type: "String", Value: "dev"}
+	if out, _ := rules.Transform(nil, &msg); out != nil {
+		t.Errorf("non-production message not dropped")
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, src := range []string{
+		"if x then frob",
+		"if x drop",
+		"set body = 1",
+		"set subject 1",
+		"drop now",
+		"keep\nset attributes. = 1",
+	} {
+		_, err := ParseRules(src)
+		if err == nil {
+			t.Errorf("ParseRules(%q) succeeded, want error", src)
+		} else if !strings.HasPrefix(err.Error(), "line ") {
+			t.Errorf("ParseRules(%q) error %q has no line number", src, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := Filter(MustCompile("msg.subject == 'keep'"))
+	if out, _ := f(nil, &gosns.Message{Subject: "keep"}); out == nil {
+		t.Error("matching message dropped")
+	}
+	if out, _ := f(nil, &gosns.Message{Subject: "other"}); out != nil {
+		t.Error("other message kept")
+	}
+}
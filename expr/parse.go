@@ -0,0 +1,526 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// node is a compiled expression.
+type node func(env map[string]interface{}) (interface{}, error)
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	near := t.text
+	if t.kind == tokEOF {
+		near = "end of input"
+	}
+	return fmt.Errorf("expr: %s near %q at %d", fmt.Sprintf(format, args...), near, t.pos)
+}
+
+// parseExpr parses: or := and (("||" | "or") and)*
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") || p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env map[string]interface{}) (interface{}, error) {
+			v, err := l(env)
+			if err != nil || truthy(v) {
+				return v, err
+			}
+			return right(env)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") || p.accept("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env map[string]interface{}) (interface{}, error) {
+			v, err := l(env)
+			if err != nil || !truthy(v) {
+				return v, err
+			}
+			return right(env)
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") || p.accept("not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			v, err := operand(env)
+			return !truthy(v), err
+		}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	case "=~":
+		p.next()
+		pat := p.next()
+		if pat.kind != tokString {
+			return nil, p.errorf("=~ needs a string pattern")
+		}
+		re, err := regexp.Compile(pat.text)
+		if err != nil {
+			return nil, fmt.Errorf("expr: bad pattern %q: %v", pat.text, err)
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			v, err := left(env)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(toString(v)), nil
+		}, nil
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	op := t.text
+	return func(env map[string]interface{}) (interface{}, error) {
+		a, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		return compare(op, a, b), nil
+	}, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(t.text, left, right)
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(t.text, left, right)
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			v, err := operand(env)
+			if err != nil {
+				return nil, err
+			}
+			n, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("expr: cannot negate %s", typeName(v))
+			}
+			return -n, nil
+		}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	rest, err := p.parseAccess()
+	if err != nil || rest == nil {
+		return n, err
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		v, err := n(env)
+		if err != nil {
+			return nil, err
+		}
+		return rest(v, env)
+	}, nil
+}
+
+// access continues an expression from the value v.
+type access func(v interface{}, env map[string]interface{}) (interface{}, error)
+
+// parseAccess parses the field accesses, indexes and projections following
+// a primary expression, or returns nil if there are none. A projection,
+// [*], applies the rest of them to each element of a list, or value of an
+// object in key order, giving a list of the results.
+func (p *parser) parseAccess() (access, error) {
+	var step access
+	switch {
+	case p.accept("."):
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, p.errorf("expected field name")
+		}
+		step = func(v interface{}, _ map[string]interface{}) (interface{}, error) {
+			return lookup(v, t.text), nil
+		}
+	case p.pos+2 < len(p.toks) && p.peek().text == "[" && p.toks[p.pos+1].text == "*" && p.toks[p.pos+2].text == "]":
+		p.pos += 3
+		rest, err := p.parseAccess()
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}, env map[string]interface{}) (interface{}, error) {
+			elems := elements(v)
+			out := make([]interface{}, 0, len(elems))
+			for _, e := range elems {
+				if rest != nil {
+					var err error
+					if e, err = rest(e, env); err != nil {
+						return nil, err
+					}
+				}
+				out = append(out, e)
+			}
+			return out, nil
+		}, nil
+	case p.accept("["):
+		key, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+		step = func(v interface{}, env map[string]interface{}) (interface{}, error) {
+			k, err := key(env)
+			if err != nil {
+				return nil, err
+			}
+			return lookup(v, k), nil
+		}
+	default:
+		return nil, nil
+	}
+	rest, err := p.parseAccess()
+	if err != nil || rest == nil {
+		return step, err
+	}
+	return func(v interface{}, env map[string]interface{}) (interface{}, error) {
+		v, err := step(v, env)
+		if err != nil {
+			return nil, err
+		}
+		return rest(v, env)
+	}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return constant(t.num), nil
+	case tokString:
+		return constant(t.text), nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "nil", "null":
+			return constant(nil), nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t.text)
+		}
+		name := t.text
+		return func(env map[string]interface{}) (interface{}, error) {
+			return env[name], nil
+		}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			return p.parseList()
+		case "{":
+			return p.parseObject()
+		}
+	}
+	if t.kind != tokEOF {
+		p.pos--
+	}
+	return nil, p.errorf("unexpected token")
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	fn, ok := funcs[name]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown function %q", name)
+	}
+	var args []node
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		vals := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(env)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		}
+		return fn(vals)
+	}, nil
+}
+
+// parseList parses the rest of [a, b, ...].
+func (p *parser) parseList() (node, error) {
+	var elems []node
+	for !p.accept("]") {
+		if len(elems) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, e)
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		list := make([]interface{}, len(elems))
+		for i, e := range elems {
+			v, err := e(env)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	}, nil
+}
+
+// parseObject parses the rest of {key: value, 'other key': value, ...}.
+func (p *parser) parseObject() (node, error) {
+	var keys []string
+	var values []node
+	for !p.accept("}") {
+		if len(keys) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.next()
+		if t.kind != tokIdent && t.kind != tokString {
+			if t.kind != tokEOF {
+				p.pos--
+			}
+			return nil, p.errorf("expected object key")
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		keys, values = append(keys, t.text), append(values, v)
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		obj := make(map[string]interface{}, len(keys))
+		for i, v := range values {
+			x, err := v(env)
+			if err != nil {
+				return nil, err
+			}
+			obj[keys[i]] = x
+		}
+		return obj, nil
+	}, nil
+}
+
+func constant(v interface{}) node {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
+
+// lookup returns the field k of an object or element k of a list, or nil.
+func lookup(c, k interface{}) interface{} {
+	switch c := c.(type) {
+	case map[string]interface{}:
+		return c[toString(k)]
+	case []interface{}:
+		i, ok := k.(float64)
+		if !ok || i < 0 || int(i) >= len(c) {
+			return nil
+		}
+		return c[int(i)]
+	}
+	return nil
+}
+
+// elements returns the elements of a list or values of an object, in key
+// order.
+func elements(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := sortedKeys(v)
+		out := make([]interface{}, len(keys))
+		for i, k := range keys {
+			out[i] = v[k]
+		}
+		return out
+	}
+	return nil
+}
+
+func binary(op string, left, right node) node {
+	return func(env map[string]interface{}) (interface{}, error) {
+		a, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		if op == "+" {
+			if as, ok := a.(string); ok {
+				return as + toString(b), nil
+			}
+		}
+		x, ok1 := a.(float64)
+		y, ok2 := b.(float64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("expr: cannot apply %s to %s and %s", op, typeName(a), typeName(b))
+		}
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/":
+			if y == 0 {
+				return nil, fmt.Errorf("expr: division by zero")
+			}
+			return x / y, nil
+		}
+		if y == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return math.Mod(x, y), nil
+	}
+}
@@ -0,0 +1,189 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Action is what a Rule does when its condition holds.
+type Action int
+
+const (
+	// Drop acknowledges the message without handling it.
+	Drop Action = iota
+	// Keep stops evaluating rules and handles the message.
+	Keep
+	// Set assigns Value to the message field named by Target.
+	Set
+)
+
+// Rule is a single line of a rule set.
+type Rule struct {
+	// Cond is the rule's condition. A nil Cond always holds.
+	Cond   *Expr
+	Action Action
+
+	// Target is "subject", "message" or "attributes.NAME", for Set.
+	Target string
+	Value  *Expr
+}
+
+// Rules is a rule set, applied in order to each message.
+type Rules []Rule
+
+// ParseRules parses a rule set, one rule per line, of the forms
+//
+//	[if COND then] drop
+//	[if COND then] keep
+//	[if COND then] set subject|message|attributes.NAME = EXPR
+//
+// Blank lines and text following a # are ignored.
+func ParseRules(src string) (Rules, error) {
+	var rules Rules
+	for i, line := range strings.Split(src, "\n") {
+		toks, err := lex(line)
+		if err != nil {
+			return nil, lineError(i+1, err)
+		}
+		if toks[0].kind == tokEOF {
+			continue
+		}
+		r, err := parseRule(&parser{toks: toks})
+		if err != nil {
+			return nil, lineError(i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func lineError(line int, err error) error {
+	return fmt.Errorf("line %d: %v", line, err)
+}
+
+func parseRule(p *parser) (Rule, error) {
+	var r Rule
+	if p.accept("if") {
+		start := p.peek().pos
+		cond, err := p.parseExpr()
+		if err != nil {
+			return r, err
+		}
+		r.Cond = &Expr{src: fmt.Sprintf("<condition at %d>", start), root: cond}
+		if err = p.expect("then"); err != nil {
+			return r, err
+		}
+	}
+	switch {
+	case p.accept("drop"):
+		r.Action = Drop
+	case p.accept("keep"):
+		r.Action = Keep
+	case p.accept("set"):
+		r.Action = Set
+		t := p.next()
+		switch {
+		case t.kind == tokIdent && (t.text == "subject" || t.text == "message"):
+			r.Target = t.text
+		case t.kind == tokIdent && t.text == "attributes":
+			name, err := parseAttrName(p)
+			if err != nil {
+				return r, err
+			}
+			r.Target = "attributes." + name
+		default:
+			p.pos--
+			return r, p.errorf("expected subject, message or attributes.NAME")
+		}
+		if err := p.expect("="); err != nil {
+			return r, err
+		}
+		start := p.peek().pos
+		value, err := p.parseExpr()
+		if err != nil {
+			return r, err
+		}
+		r.Value = &Expr{src: fmt.Sprintf("<value at %d>", start), root: value}
+	default:
+		return r, p.errorf("expected drop, keep or set")
+	}
+	if p.peek().kind != tokEOF {
+		return r, p.errorf("unexpected token")
+	}
+	return r, nil
+}
+
+// parseAttrName parses the .NAME or ['NAME'] following "attributes".
+func parseAttrName(p *parser) (string, error) {
+	if p.accept(".") {
+		t := p.next()
+		if t.kind != tokIdent {
+			return "", p.errorf("expected attribute name")
+		}
+		return t.text, nil
+	}
+	if p.accept("[") {
+		t := p.next()
+		if t.kind != tokString {
+			return "", p.errorf("expected quoted attribute name")
+		}
+		return t.text, p.expect("]")
+	}
+	return "", p.errorf("expected .NAME or ['NAME']")
+}
+
+// Transform is a gosns.Transformer applying the rules to msg.
+func (rs Rules) Transform(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+	env := Env(msg)
+	for _, r := range rs {
+		if r.Cond != nil {
+			v, err := r.Cond.root(env)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(v) {
+				continue
+			}
+		}
+		switch r.Action {
+		case Drop:
+			return nil, nil
+		case Keep:
+			return msg, nil
+		case Set:
+			v, err := r.Value.root(env)
+			if err != nil {
+				return nil, err
+			}
+			setField(msg, r.Target, v)
+			env = Env(msg)
+		}
+	}
+	return msg, nil
+}
+
+func setField(msg *gosns.Message, target string, v interface{}) {
+	switch target {
+	case "subject":
+		msg.Subject = toString(v)
+	case "message":
+		msg.Message = toString(v)
+	default:
+		name := strings.TrimPrefix(target, "attributes.")
+		if v == nil {
+			delete(msg.MessageAttributes, name)
+			return
+		}
+		attr := gosns.MessageAttribute{Type: "String", Value: toString(v)}
+		if _, ok := v.(float64); ok {
+			attr.Type = "Number"
+		}
+		if msg.MessageAttributes == nil {
+			msg.MessageAttributes = make(map[string]gosns.MessageAttribute)
+		}
+		msg.MessageAttributes[name] = attr
+	}
+}
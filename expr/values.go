@@ -0,0 +1,219 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// truthy reports whether v counts as true: false, nil, 0 and "" do not.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// compare applies a comparison operator. Numbers and strings are ordered
+// among themselves; values of different types are never equal.
+func compare(op string, a, b interface{}) bool {
+	var c int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return op == "!="
+		}
+		switch {
+		case x < y:
+			c = -1
+		case x > y:
+			c = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return op == "!="
+		}
+		c = strings.Compare(x, y)
+	default:
+		eq := typeName(a) == typeName(b) && (a == nil || isScalar(a) && a == b)
+		switch op {
+		case "==":
+			return eq
+		case "!=":
+			return !eq
+		}
+		return false
+	}
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case bool, float64, string:
+		return true
+	}
+	return false
+}
+
+// funcs are the functions callable from expressions.
+var funcs = map[string]func(args []interface{}) (interface{}, error){
+	"lower": func(args []interface{}) (interface{}, error) {
+		if err := arity("lower", args, 1); err != nil {
+			return nil, err
+		}
+		return strings.ToLower(toString(args[0])), nil
+	},
+	"upper": func(args []interface{}) (interface{}, error) {
+		if err := arity("upper", args, 1); err != nil {
+			return nil, err
+		}
+		return strings.ToUpper(toString(args[0])), nil
+	},
+	"contains": func(args []interface{}) (interface{}, error) {
+		if err := arity("contains", args, 2); err != nil {
+			return nil, err
+		}
+		if list, ok := args[0].([]interface{}); ok {
+			for _, v := range list {
+				if compare("==", v, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return strings.Contains(toString(args[0]), toString(args[1])), nil
+	},
+	"startsWith": func(args []interface{}) (interface{}, error) {
+		if err := arity("startsWith", args, 2); err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+	},
+	"endsWith": func(args []interface{}) (interface{}, error) {
+		if err := arity("endsWith", args, 2); err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+	},
+	"matches": func(args []interface{}) (interface{}, error) {
+		if err := arity("matches", args, 2); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(toString(args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("expr: bad pattern: %v", err)
+		}
+		return re.MatchString(toString(args[0])), nil
+	},
+	"len": func(args []interface{}) (interface{}, error) {
+		if err := arity("len", args, 1); err != nil {
+			return nil, err
+		}
+		switch v := args[0].(type) {
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return float64(len(toString(args[0]))), nil
+	},
+	"keys": func(args []interface{}) (interface{}, error) {
+		if err := arity("keys", args, 1); err != nil {
+			return nil, err
+		}
+		m, _ := args[0].(map[string]interface{})
+		keys := make([]interface{}, 0, len(m))
+		for _, k := range sortedKeys(m) {
+			keys = append(keys, k)
+		}
+		return keys, nil
+	},
+	"number": func(args []interface{}) (interface{}, error) {
+		if err := arity("number", args, 1); err != nil {
+			return nil, err
+		}
+		if n, ok := args[0].(float64); ok {
+			return n, nil
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(toString(args[0])), 64)
+		if err != nil {
+			return nil, nil
+		}
+		return n, nil
+	},
+	"string": func(args []interface{}) (interface{}, error) {
+		if err := arity("string", args, 1); err != nil {
+			return nil, err
+		}
+		return toString(args[0]), nil
+	},
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func arity(name string, args []interface{}, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expr: %s takes %d arguments, got %d", name, n, len(args))
+	}
+	return nil
+}
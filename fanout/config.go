@@ -0,0 +1,223 @@
+package fanout
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+	"github.com/pbnjay/gosns/notify"
+	"github.com/pbnjay/gosns/payload"
+	"github.com/pbnjay/gosns/plugin"
+)
+
+// Config is the JSON form of a Router, as read by ParseConfig:
+//
+//	{
+//		"destinations": [
+//			{
+//				"name": "billing",
+//				"url": "https://billing.internal/hooks/orders",
+//				"topics": ["orders-*"],
+//				"filter": "msg.attributes.env == 'prod' && msg.body.order.total >= 100",
+//				"template": "{\"order\": {{json .body.order.id}}, \"total\": {{json .body.order.total}}}",
+//				"headers": {"Authorization": "Bearer ${BILLING_TOKEN}"},
+//				"max_attempts": 5,
+//				"retry_backoff": "2s",
+//				"dead_letter": "/var/spool/gosns/billing.jsonl"
+//			},
+//			{"name": "audit", "url": "https://audit.internal/sns", "subject": "^(Created|Deleted) ", "query": "{id, subject, changes: .body.changes}"},
+//			{"name": "ops", "type": "slack", "url": "${SLACK_WEBHOOK_URL}", "topics": ["alarms-*"]},
+//			{"name": "oncall", "type": "pagerduty", "routing_key": "${PAGERDUTY_ROUTING_KEY}", "topics": ["alarms-critical"]}
+//		]
+//	}
+//
+// References to environment variables in URLs, header values and routing
+// keys, such as ${BILLING_TOKEN}, are replaced by their values, so that
+// secrets need not be kept in the file.
+type Config struct {
+	Destinations []DestinationConfig `json:"destinations"`
+}
+
+// DestinationConfig is the JSON form of a Destination. Type is "webhook",
+// the default, or one of the formats of package notify: "slack", "teams" or
+// "pagerduty", whose URL defaults to notify.PagerDutyURL and which requires
+// a RoutingKey, and takes a Severity. Subject is a regexp, Filter a package
+// expr expression, Template and Query the payload.Template or payload.Query
+// giving the body and RetryBackoff a time.ParseDuration duration. DeadLetter
+// is a file the messages the destination did not accept are appended to, as
+// JSON lines of the destination's name, the error, the time and the message
+// in the wire form of package plugin.
+type DestinationConfig struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type,omitempty"`
+	URL          string            `json:"url"`
+	Topics       []string          `json:"topics,omitempty"`
+	Subject      string            `json:"subject,omitempty"`
+	Filter       string            `json:"filter,omitempty"`
+	Template     string            `json:"template,omitempty"`
+	Query        string            `json:"query,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	MaxAttempts  int               `json:"max_attempts,omitempty"`
+	RetryBackoff string            `json:"retry_backoff,omitempty"`
+	DeadLetter   string            `json:"dead_letter,omitempty"`
+	RoutingKey   string            `json:"routing_key,omitempty"`
+	Severity     string            `json:"severity,omitempty"`
+}
+
+// LoadConfig reads a Router's Config from a JSON file.
+func LoadConfig(filename string) (*Router, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return r, nil
+}
+
+// ParseConfig returns the Router of a JSON Config. The Router's Close
+// closes the dead letter files it opened.
+func ParseConfig(data []byte) (*Router, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	r := &Router{}
+	files := make(map[string]*deadLetterFile)
+	for i, dc := range c.Destinations {
+		d, err := dc.destination(files)
+		if err != nil {
+			for _, f := range files {
+				f.f.Close()
+			}
+			if dc.Name == "" {
+				return nil, fmt.Errorf("destination %d: %w", i+1, err)
+			}
+			return nil, fmt.Errorf("destination '%s': %w", dc.Name, err)
+		}
+		r.Destinations = append(r.Destinations, d)
+	}
+	for _, f := range files {
+		r.closers = append(r.closers, f.f)
+	}
+	return r, nil
+}
+
+func (dc *DestinationConfig) destination(files map[string]*deadLetterFile) (*Destination, error) {
+	d := &Destination{
+		Name:        dc.Name,
+		URL:         os.ExpandEnv(dc.URL),
+		Topics:      dc.Topics,
+		ContentType: dc.ContentType,
+		MaxAttempts: dc.MaxAttempts,
+	}
+	switch dc.Type {
+	case "", "webhook":
+	case "slack":
+		d.Encode = notify.Slack
+	case "teams":
+		d.Encode = notify.Teams
+	case "pagerduty":
+		pd := &notify.PagerDuty{RoutingKey: os.ExpandEnv(dc.RoutingKey), Severity: dc.Severity}
+		if pd.RoutingKey == "" {
+			return nil, fmt.Errorf("routing_key is required")
+		}
+		d.Encode = pd.Encode
+		if d.URL == "" {
+			d.URL = notify.PagerDutyURL
+		}
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", dc.Type)
+	}
+	if dc.Template != "" && dc.Query != "" {
+		return nil, fmt.Errorf("only one of template and query can be set")
+	}
+	if d.Encode != nil && (dc.Template != "" || dc.Query != "") {
+		return nil, fmt.Errorf("a payload cannot be used with type '%s'", dc.Type)
+	}
+	if d.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if d.Name == "" {
+		d.Name = d.URL
+	}
+	var err error
+	if dc.Subject != "" {
+		if d.Subject, err = regexp.Compile(dc.Subject); err != nil {
+			return nil, fmt.Errorf("subject: %w", err)
+		}
+	}
+	if dc.Filter != "" {
+		if d.Filter, err = expr.Compile(dc.Filter); err != nil {
+			return nil, fmt.Errorf("filter: %w", err)
+		}
+	}
+	if dc.Template != "" {
+		t, err := payload.NewTemplate(d.Name, dc.Template)
+		if err != nil {
+			return nil, fmt.Errorf("template: %w", err)
+		}
+		d.Encode = t.Render
+	}
+	if dc.Query != "" {
+		q, err := payload.ParseQuery(dc.Query)
+		if err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+		d.Encode = q.Render
+	}
+	if dc.RetryBackoff != "" {
+		if d.RetryBackoff, err = time.ParseDuration(dc.RetryBackoff); err != nil {
+			return nil, fmt.Errorf("retry_backoff: %w", err)
+		}
+	}
+	if len(dc.Headers) > 0 {
+		d.Header = make(http.Header, len(dc.Headers))
+		for name, value := range dc.Headers {
+			d.Header.Set(name, os.ExpandEnv(value))
+		}
+	}
+	if dc.DeadLetter != "" {
+		f := files[dc.DeadLetter]
+		if f == nil {
+			file, err := os.OpenFile(dc.DeadLetter, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+			if err != nil {
+				return nil, err
+			}
+			f = &deadLetterFile{f: file}
+			files[dc.DeadLetter] = f
+		}
+		name := d.Name
+		d.DeadLetter = func(msg *gosns.Message, err error) { f.write(name, msg, err) }
+	}
+	return d, nil
+}
+
+// deadLetterFile appends dead-lettered messages to a file as JSON lines.
+type deadLetterFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+type deadLetter struct {
+	Destination string          `json:"destination"`
+	Error       string          `json:"error"`
+	Time        time.Time       `json:"time"`
+	Message     *plugin.Message `json:"message"`
+}
+
+func (f *deadLetterFile) write(destination string, msg *gosns.Message, err error) {
+	line, _ := json.Marshal(deadLetter{destination, err.Error(), time.Now().UTC(), plugin.NewMessage(msg)})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.f.Write(append(line, '\n'))
+}
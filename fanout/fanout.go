@@ -0,0 +1,313 @@
+// Package fanout forwards the messages a gosns server receives to several
+// webhooks, each receiving only the messages its filters select, in the
+// shape its payload template gives them:
+//
+//	r, err := fanout.LoadConfig("fanout.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer r.Close()
+//	t := s.AddTopic(topicARN, "/events", r.Handle)
+//	t.Delivery = gosns.AtLeastOnce
+//
+// A message is POSTed to every Destination it matches at the same time. By
+// default the body is the JSON wire form of package plugin; an Encode
+// function, such as a template or query of package payload or the Slack,
+// Teams and PagerDuty formats of package notify, gives it any other shape. A
+// destination which cannot be reached or answers with a 429 or a 5xx is
+// tried again with backoff up to MaxAttempts times, and a message it still
+// refuses goes to its DeadLetter, if set. Write fails if a destination
+// without one did not accept the message, so that with gosns.AtLeastOnce SNS
+// delivers it again, to every destination it matches: destinations are to
+// tolerate duplicates, for which the MessageIDHeader header carries the
+// MessageId.
+package fanout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+	"github.com/pbnjay/gosns/plugin"
+)
+
+// Defaults used when the corresponding Router and Destination fields are
+// zero.
+const (
+	DefaultMaxAttempts  = 3
+	DefaultRetryBackoff = time.Second
+	DefaultTimeout      = 10 * time.Second
+)
+
+// MessageIDHeader carries a forwarded message's MessageId.
+const MessageIDHeader = "X-Gosns-Message-Id"
+
+// Destination is a webhook and the messages sent to it. Its fields must not
+// be changed once its Router has been used.
+type Destination struct {
+	// Name identifies the destination in logs and errors.
+	Name string
+
+	// URL is where messages are POSTed.
+	URL string
+
+	// Topics, if set, are patterns in the syntax of path.Match, such as
+	// "orders-*", for the topics whose messages are sent: a message matches
+	// when one of them matches its topic's name or ARN. Subject, if set,
+	// must match the message's subject, and Filter, if set, be true for it.
+	Topics  []string
+	Subject *regexp.Regexp
+	Filter  *expr.Expr
+
+	// Encode, if set, returns the body sent, with ContentType (default
+	// application/json): a payload.Format's Render, for a template or
+	// query giving the body the shape the destination expects, or one of
+	// the formats of package notify.
+	Encode      func(*gosns.Message) ([]byte, error)
+	ContentType string
+
+	// Header is added to every request, such as an Authorization header.
+	Header http.Header
+
+	// MaxAttempts bounds the sends of a message, with RetryBackoff before
+	// the second and doubling for each after. Zero means DefaultMaxAttempts
+	// and DefaultRetryBackoff.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+
+	// DeadLetter, if set, is called with the messages the destination did
+	// not accept, which then no longer fail the Write.
+	DeadLetter func(msg *gosns.Message, err error)
+}
+
+// Match reports whether msg is sent to d.
+func (d *Destination) Match(msg *gosns.Message) (bool, error) {
+	if len(d.Topics) > 0 {
+		name := msg.TopicArn
+		if arn, err := gosns.ParseARN(msg.TopicArn); err == nil {
+			name = arn.Resource
+		}
+		found := false
+		for _, pattern := range d.Topics {
+			if ok, _ := path.Match(pattern, name); ok {
+				found = true
+				break
+			}
+			if ok, _ := path.Match(pattern, msg.TopicArn); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if d.Subject != nil && !d.Subject.MatchString(msg.Subject) {
+		return false, nil
+	}
+	if d.Filter != nil {
+		return d.Filter.Match(msg)
+	}
+	return true, nil
+}
+
+// Body returns the body sent to d for msg, and its content type.
+func (d *Destination) Body(msg *gosns.Message) ([]byte, string, error) {
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if d.Encode != nil {
+		body, err := d.Encode(msg)
+		return body, contentType, err
+	}
+	body, err := json.Marshal(plugin.NewMessage(msg))
+	return body, contentType, err
+}
+
+// Error is a destination's refusal of a message.
+type Error struct {
+	Destination string
+	StatusCode  int // zero if the destination could not be reached
+	Err         error
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("fanout: destination '%s' answered with status %d", e.Destination, e.StatusCode)
+	}
+	return fmt.Sprintf("fanout: destination '%s': %v", e.Destination, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// retryable reports whether a send failing with err may succeed later.
+func retryable(err error) bool {
+	var ferr *Error
+	if !errors.As(err, &ferr) || ferr.StatusCode == 0 {
+		return true
+	}
+	return ferr.StatusCode == http.StatusTooManyRequests || ferr.StatusCode >= 500
+}
+
+// Router sends messages to the Destinations they match. Its fields must not
+// be changed once it has been used.
+type Router struct {
+	Destinations []*Destination
+
+	// Client sends requests; nil means an http.Client with a timeout of
+	// Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// Logger receives notices of retried and dead-lettered messages, if set.
+	Logger *log.Logger
+
+	once    sync.Once
+	client  *http.Client
+	closers []io.Closer
+}
+
+func (r *Router) init() {
+	r.once.Do(func() {
+		r.client = r.Client
+		if r.client == nil {
+			timeout := r.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			r.client = &http.Client{Timeout: timeout}
+		}
+	})
+}
+
+func (r *Router) logf(format string, args ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, args...)
+	}
+}
+
+// Write sends msg to every destination it matches, returning once each has
+// accepted it or given up. The error joins those of the destinations
+// without a DeadLetter which did not accept it.
+func (r *Router) Write(msg *gosns.Message) error {
+	r.init()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, d := range r.Destinations {
+		ok, err := d.Match(msg)
+		if err != nil {
+			err = fmt.Errorf("fanout: destination '%s': filter: %w", d.Name, err)
+		} else if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(d *Destination, err error) {
+			defer wg.Done()
+			if err == nil {
+				err = r.deliver(msg, d)
+			}
+			if err == nil {
+				return
+			}
+			if d.DeadLetter != nil {
+				r.logf("fanout: dead-lettering message %s for destination '%s': %v\n", msg.MessageId, d.Name, err)
+				d.DeadLetter(msg, err)
+				return
+			}
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}(d, err)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Handle sends msg as a Topic callback, failing the message if a
+// destination did not accept it.
+func (r *Router) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := r.Write(msg); err != nil {
+		msg.Fail(err)
+	}
+}
+
+// Close closes the dead letter files of a Router from ParseConfig.
+func (r *Router) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// deliver sends msg to d, retrying as d allows.
+func (r *Router) deliver(msg *gosns.Message, d *Destination) error {
+	body, contentType, err := d.Body(msg)
+	if err != nil {
+		return fmt.Errorf("fanout: destination '%s': body: %w", d.Name, err)
+	}
+	attempts, backoff := d.MaxAttempts, d.RetryBackoff
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	ctx := msg.Context()
+	for attempt := 1; ; attempt++ {
+		err = r.send(ctx, msg, d, contentType, body)
+		if err == nil || !retryable(err) || attempt >= attempts {
+			return err
+		}
+		r.logf("fanout: retrying message %s for destination '%s' after attempt %d: %v\n", msg.MessageId, d.Name, attempt, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return &Error{Destination: d.Name, Err: ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+func (r *Router) send(ctx context.Context, msg *gosns.Message, d *Destination, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return &Error{Destination: d.Name, Err: err}
+	}
+	for name, values := range d.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(MessageIDHeader, msg.MessageId)
+	if id := gosns.RequestID(ctx); id != "" {
+		req.Header.Set(gosns.RequestIDHeader, id)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &Error{Destination: d.Name, Err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &Error{Destination: d.Name, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
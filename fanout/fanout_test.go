@@ -0,0 +1,207 @@
+package fanout
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+	"github.com/pbnjay/gosns/gosnstest"
+	"github.com/pbnjay/gosns/notify"
+	"github.com/pbnjay/gosns/payload"
+)
+
+// newHook returns a fake webhook accepting every request.
+func newHook(t *testing.T) *gosnstest.Sink {
+	h := gosnstest.NewSink(nil)
+	t.Cleanup(h.Close)
+	return h
+}
+
+// received returns the bodies h accepted.
+func received(h *gosnstest.Sink) []string {
+	var bodies []string
+	for _, req := range h.Accepted() {
+		bodies = append(bodies, string(req.Body))
+	}
+	return bodies
+}
+
+func message(id, topic, subject, body string) *gosns.Message {
+	msg := gosnstest.NewMessage(id, topic)
+	msg.Subject, msg.Message = subject, body
+	msg.MessageAttributes = map[string]gosns.MessageAttribute{"env": {Type: "String", Value: "prod"}}
+	return msg
+}
+
+func TestRouter(t *testing.T) {
+	all, orders, big := newHook(t), newHook(t), newHook(t)
+	tmpl, err := payload.NewTemplate("big", `{"order": {{json .body.order.id}}, "env": "{{.attributes.env}}"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Router{Destinations: []*Destination{
+		{Name: "all", URL: all.URL},
+		{Name: "orders", URL: orders.URL, Topics: []string{"orders-*"}, Subject: regexp.MustCompile("^Created"),
+			Header: http.Header{"Authorization": {"Bearer token"}}},
+		{Name: "big", URL: big.URL, Filter: expr.MustCompile("msg.body.order.total >= 100"), Encode: tmpl.Render},
+	}}
+	msgs := []*gosns.Message{
+		message("1", "orders-eu", "Created", `{"order": {"id": "o-1", "total": 150}}`),
+		message("2", "orders-us", "Deleted", `{"order": {"id": "o-2", "total": 5}}`),
+		message("3", "refunds", "Created", `{"order": {"id": "o-3", "total": 500}}`),
+	}
+	for _, msg := range msgs {
+		if err := r.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := received(all); len(got) != 3 || !strings.Contains(got[0], `"messageId":"1"`) {
+		t.Errorf("all got %v", got)
+	}
+	if got := received(orders); len(got) != 1 || !strings.Contains(got[0], `"messageId":"1"`) {
+		t.Errorf("orders got %v", got)
+	}
+	if h := orders.Accepted()[0].Header; h.Get("Authorization") != "Bearer token" || h.Get(MessageIDHeader) != "1" {
+		t.Errorf("orders headers %v", h)
+	}
+	if got := received(big); len(got) != 2 || got[1] != `{"order": "o-3", "env": "prod"}` {
+		t.Errorf("big got %q", got)
+	}
+}
+
+func TestRouterRetries(t *testing.T) {
+	flaky, broken, refusing := newHook(t), newHook(t), newHook(t)
+	flaky.Fail(2, 503, "")
+	broken.Fail(100, 502, "")
+	refusing.Fail(100, 400, "")
+	var dead []string
+	r := &Router{Destinations: []*Destination{
+		{Name: "flaky", URL: flaky.URL, RetryBackoff: time.Millisecond},
+		{Name: "broken", URL: broken.URL, MaxAttempts: 2, RetryBackoff: time.Millisecond},
+		{Name: "refusing", URL: refusing.URL, RetryBackoff: time.Millisecond,
+			DeadLetter: func(msg *gosns.Message, err error) { dead = append(dead, msg.MessageId+": "+err.Error()) }},
+	}}
+	err := r.Write(message("1", "orders", "", "{}"))
+	var ferr *Error
+	if !errors.As(err, &ferr) || ferr.Destination != "broken" || ferr.StatusCode != 502 {
+		t.Errorf("got %v", err)
+	}
+	if len(received(flaky)) != 1 || broken.Failures() != 98 {
+		t.Errorf("flaky got %d, broken was sent %d", len(received(flaky)), 100-broken.Failures())
+	}
+	// refusals are not retried
+	if refusing.Failures() != 99 || len(dead) != 1 || dead[0] != "1: fanout: destination 'refusing' answered with status 400" {
+		t.Errorf("refusing was sent %d, dead letters %v", 100-refusing.Failures(), dead)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	h, broken, q := newHook(t), newHook(t), newHook(t)
+	broken.Fail(100, 500, "")
+	dir := t.TempDir()
+	deadFile := filepath.Join(dir, "dead.jsonl")
+	t.Setenv("HOOK_TOKEN", "s3cret")
+	config := fmt.Sprintf(`{"destinations": [
+		{"name": "orders", "url": %q, "topics": ["orders"], "filter": "msg.attributes.env == 'prod'",
+		 "template": "{{.body.order.id}}", "content_type": "text/plain", "headers": {"authorization": "Bearer ${HOOK_TOKEN}"}},
+		{"name": "broken", "url": %q, "max_attempts": 1, "dead_letter": %q},
+		{"name": "query", "url": %q, "query": "{order: msg.body.order.id, topic: msg.topicName}"}
+	]}`, h.URL, broken.URL, deadFile, q.URL)
+	r, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Write(message("7", "orders", "", `{"order": {"id": "o-7"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Accepted(); len(got) != 1 || string(got[0].Body) != "o-7" || got[0].Header.Get("Authorization") != "Bearer s3cret" || got[0].Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("got %v", got)
+	}
+
+	if got := received(q); len(got) != 1 || got[0] != `{"order":"o-7","topic":"orders"}` {
+		t.Errorf("query got %q", got)
+	}
+
+	f, err := os.Open(deadFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var lines []deadLetter
+	for sc.Scan() {
+		var dl deadLetter
+		if err := json.Unmarshal(sc.Bytes(), &dl); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, dl)
+	}
+	if len(lines) != 1 || lines[0].Destination != "broken" || lines[0].Message.MessageId != "7" || !strings.Contains(lines[0].Error, "500") {
+		t.Errorf("dead letters %+v", lines)
+	}
+
+	for _, bad := range []string{
+		`{"destinations": [{"name": "x"}]}`,
+		`{"destinations": [{"url": "http://x", "filter": "msg.("}]}`,
+		`{"destinations": [{"url": "http://x", "subject": "("}]}`,
+		`{"destinations": [{"url": "http://x", "template": "{{"}]}`,
+		`{"destinations": [{"url": "http://x", "retry_backoff": "soon"}]}`,
+		`{"destinations": {}}`,
+	} {
+		if _, err := ParseConfig([]byte(bad)); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
+
+func TestParseConfigTypes(t *testing.T) {
+	slack := newHook(t)
+	t.Setenv("PD_KEY", "routing-key")
+	r, err := ParseConfig([]byte(fmt.Sprintf(`{"destinations": [
+		{"name": "ops", "type": "slack", "url": %q},
+		{"name": "oncall", "type": "pagerduty", "routing_key": "${PD_KEY}", "severity": "critical"}
+	]}`, slack.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pd := r.Destinations[1]; pd.URL != notify.PagerDutyURL {
+		t.Errorf("PagerDuty URL %q", pd.URL)
+	}
+	body, _, err := r.Destinations[1].Body(message("1", "alarms", "disk full", ""))
+	if err != nil || !strings.Contains(string(body), `"routing_key":"routing-key"`) || !strings.Contains(string(body), `"severity":"critical"`) {
+		t.Errorf("got %s, %v", body, err)
+	}
+	r.Destinations = r.Destinations[:1]
+	if err := r.Write(message("2", "alarms", "disk full", "on db-1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := received(slack); len(got) != 1 || !strings.Contains(got[0], `"attachments"`) {
+		t.Errorf("slack got %v", got)
+	}
+
+	for _, bad := range []string{
+		`{"destinations": [{"type": "pagerduty"}]}`,
+		`{"destinations": [{"type": "slack", "url": "http://x", "template": "hi"}]}`,
+		`{"destinations": [{"url": "http://x", "template": "hi", "query": "msg"}]}`,
+		`{"destinations": [{"url": "http://x", "query": "{"}]}`,
+		`{"destinations": [{"type": "carrier-pigeon", "url": "http://x"}]}`,
+	} {
+		if _, err := ParseConfig([]byte(bad)); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package firehose forwards the messages a gosns server receives to an
+// Amazon Kinesis Data Firehose delivery stream, for data lakes whose
+// ingestion is standardized on Firehose:
+//
+//	stream := &firehose.Stream{Name: "sns-messages", Region: "us-east-1"}
+//	defer stream.Close()
+//	t := s.AddTopic(topicARN, "/orders", func(msg *gosns.Message) {
+//		if err := stream.Write(msg); err != nil {
+//			msg.Fail(err)
+//		}
+//	})
+//	t.Delivery = gosns.AtLeastOnce
+//
+// Each message is a record holding the message as a line of JSON. Records
+// written at about the same time, such as by the callbacks of concurrent
+// deliveries, are sent together with PutRecordBatch once MaxRecords have
+// been written or the first has waited MaxWait. Write returns once its
+// record has been put, so that an AtLeastOnce topic only acknowledges
+// messages Firehose accepted. Records Firehose fails, and whole requests
+// which are throttled or fail with a 5xx, are retried with backoff up to
+// MaxAttempts times.
+package firehose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/internal/batcher"
+	"github.com/pbnjay/gosns/internal/sigv4"
+)
+
+// Defaults used when the corresponding Stream fields are zero.
+const (
+	DefaultMaxRecords   = 500 // the most PutRecordBatch accepts
+	DefaultMaxWait      = 200 * time.Millisecond
+	DefaultMaxAttempts  = 5
+	DefaultRetryBackoff = 100 * time.Millisecond
+	DefaultTimeout      = 10 * time.Second
+)
+
+// Limits of PutRecordBatch.
+const (
+	MaxRecordSize = 1000 << 10
+	maxBatchBytes = 4 << 20
+)
+
+// ErrRecordTooLarge is returned by Write for a message whose record is
+// larger than MaxRecordSize.
+var ErrRecordTooLarge = errors.New("firehose: record is larger than 1000 KiB")
+
+// ErrClosed is returned by Write once the Stream is closed.
+var ErrClosed = errors.New("firehose: stream is closed")
+
+// Stream sends records to a Firehose delivery stream. Its fields must not be
+// changed once it has been used.
+type Stream struct {
+	Name   string // of the delivery stream
+	Region string
+
+	// Credentials sign each request. If nil, gosns.LoadCredentials is
+	// called on first use.
+	Credentials *gosns.Credentials
+
+	// Endpoint overrides the regional Firehose endpoint URL.
+	Endpoint string
+
+	// Client sends requests; nil means an http.Client with a timeout of
+	// Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// Encode returns the record for a message, such as the Render of a
+	// payload.Format followed by a newline. If nil, the message is encoded
+	// as JSON followed by a newline, so that the objects Firehose delivers
+	// hold one message per line.
+	Encode func(*gosns.Message) ([]byte, error)
+
+	// MaxRecords and MaxWait bound a batch, and MaxAttempts the puts of a
+	// record, with RetryBackoff before the second and doubling for each
+	// after. Zero means DefaultMaxRecords, DefaultMaxWait,
+	// DefaultMaxAttempts and DefaultRetryBackoff.
+	MaxRecords   int
+	MaxWait      time.Duration
+	MaxAttempts  int
+	RetryBackoff time.Duration
+
+	// Logger receives notices of retried puts, if set.
+	Logger *log.Logger
+
+	once     sync.Once
+	client   *http.Client
+	credsErr error
+	batcher  *batcher.Batcher
+}
+
+// Error is an error response from Firehose, or the failure of one record of
+// a PutRecordBatch.
+type Error struct {
+	Operation  string
+	StatusCode int
+	Type       string // such as "ServiceUnavailableException"
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return "firehose " + e.Operation + ": " + e.Type + ": " + e.Message
+}
+
+// retryable reports whether a put failing with err may succeed if retried.
+func retryable(err error) bool {
+	var ferr *Error
+	if !errors.As(err, &ferr) {
+		// the request could not be sent or answered
+		return true
+	}
+	return ferr.StatusCode >= 500 || ferr.Type == "ServiceUnavailableException" || ferr.Type == "ThrottlingException" ||
+		ferr.Type == "InternalFailure"
+}
+
+func (st *Stream) init() {
+	st.once.Do(func() {
+		st.client = st.Client
+		if st.client == nil {
+			timeout := st.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			st.client = &http.Client{Timeout: timeout}
+		}
+		if st.Credentials == nil {
+			st.Credentials, st.credsErr = gosns.LoadCredentials()
+		}
+		st.batcher = &batcher.Batcher{
+			MaxRecords:  st.MaxRecords,
+			MaxBytes:    maxBatchBytes,
+			MaxWait:     st.MaxWait,
+			Send:        st.putBatch,
+			Retryable:   retryable,
+			MaxAttempts: st.MaxAttempts,
+			Backoff:     st.RetryBackoff,
+			Logf: func(format string, args ...interface{}) {
+				st.logf("firehose: stream '%s': "+format+"\n", append([]interface{}{st.Name}, args...)...)
+			},
+		}
+		if st.batcher.MaxRecords <= 0 || st.batcher.MaxRecords > DefaultMaxRecords {
+			st.batcher.MaxRecords = DefaultMaxRecords
+		}
+		if st.batcher.MaxWait <= 0 {
+			st.batcher.MaxWait = DefaultMaxWait
+		}
+		if st.batcher.MaxAttempts <= 0 {
+			st.batcher.MaxAttempts = DefaultMaxAttempts
+		}
+		if st.batcher.Backoff <= 0 {
+			st.batcher.Backoff = DefaultRetryBackoff
+		}
+	})
+}
+
+func (st *Stream) logf(format string, args ...interface{}) {
+	if st.Logger != nil {
+		st.Logger.Printf(format, args...)
+	}
+}
+
+// Write puts a record of msg in the stream, returning once it has been
+// accepted or has failed MaxAttempts times, or msg's context is done.
+func (st *Stream) Write(msg *gosns.Message) error {
+	st.init()
+	var data []byte
+	var err error
+	if st.Encode != nil {
+		data, err = st.Encode(msg)
+	} else if data, err = json.Marshal(msg); err == nil {
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxRecordSize {
+		return ErrRecordTooLarge
+	}
+
+	err = st.batcher.Write(msg.Context(), data)
+	if err == batcher.ErrClosed {
+		return ErrClosed
+	}
+	return err
+}
+
+// Handle puts msg as a Topic callback, failing the message if it could not
+// be put.
+func (st *Stream) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := st.Write(msg); err != nil {
+		msg.Fail(err)
+	}
+}
+
+// Close sends any pending batch and waits for the puts in progress. Later
+// Writes fail with ErrClosed.
+func (st *Stream) Close() {
+	st.init()
+	st.batcher.Close()
+}
+
+// putBatch calls PutRecordBatch with batch, returning the error of each
+// record which failed.
+func (st *Stream) putBatch(batch [][]byte) ([]error, error) {
+	in := struct {
+		DeliveryStreamName string
+		Records            []struct{ Data []byte }
+	}{DeliveryStreamName: st.Name}
+	for _, data := range batch {
+		in.Records = append(in.Records, struct{ Data []byte }{data})
+	}
+	var out struct {
+		FailedPutCount   int
+		RequestResponses []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := st.call("PutRecordBatch", in, &out); err != nil {
+		return nil, err
+	}
+	failed := make([]error, len(batch))
+	for i, resp := range out.RequestResponses {
+		if i < len(failed) && resp.ErrorCode != "" {
+			failed[i] = &Error{Operation: "PutRecordBatch", StatusCode: http.StatusOK, Type: resp.ErrorCode, Message: resp.ErrorMessage}
+		}
+	}
+	return failed, nil
+}
+
+func (st *Stream) endpoint() string {
+	if st.Endpoint != "" {
+		return st.Endpoint
+	}
+	return gosns.RegionalEndpoint("firehose", st.Region)
+}
+
+// call sends a Firehose JSON API request and decodes the response into out.
+func (st *Stream) call(op string, in interface{}, out interface{}) error {
+	if st.Region == "" {
+		return errors.New("firehose: Stream has no Region")
+	}
+	if st.credsErr != nil {
+		return st.credsErr
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, "POST", st.endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Firehose_20150804."+op)
+	c, err := st.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, body, c.AccessKeyID, c.SecretAccessKey, c.SessionToken, st.Region, "firehose", time.Now())
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		ferr := &Error{Operation: op, StatusCode: resp.StatusCode}
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &e) == nil {
+			ferr.Type, ferr.Message = e.Type[strings.LastIndex(e.Type, "#")+1:], e.Message
+		}
+		return ferr
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,148 @@
+package firehose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// fakeFirehose is a delivery stream recording the messages put in it. It
+// fails the first putFailures records it is sent.
+type fakeFirehose struct {
+	t *testing.T
+
+	putFailures int
+	messages    []string
+	batchSizes  []int
+}
+
+func (f *fakeFirehose) putRecordBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Amz-Target") != "Firehose_20150804.PutRecordBatch" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		f.t.Errorf("bad request %v", r.Header)
+	}
+	var in struct {
+		DeliveryStreamName string
+		Records            []struct{ Data []byte }
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	if in.DeliveryStreamName == "missing" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "Stream missing not found"}`)
+		return
+	}
+	f.batchSizes = append(f.batchSizes, len(in.Records))
+	type response struct{ ErrorCode, ErrorMessage, RecordId string }
+	var out struct {
+		FailedPutCount   int
+		RequestResponses []response
+	}
+	for _, rec := range in.Records {
+		if f.putFailures > 0 {
+			f.putFailures--
+			out.FailedPutCount++
+			out.RequestResponses = append(out.RequestResponses, response{ErrorCode: "ServiceUnavailableException", ErrorMessage: "Slow down."})
+			continue
+		}
+		var msg gosns.Message
+		if err := json.Unmarshal(rec.Data, &msg); err != nil || !strings.HasSuffix(string(rec.Data), "\n") {
+			f.t.Errorf("bad record %q", rec.Data)
+		}
+		f.messages = append(f.messages, msg.MessageId)
+		out.RequestResponses = append(out.RequestResponses, response{RecordId: "r-" + msg.MessageId})
+	}
+	json.NewEncoder(w).Encode(&out)
+}
+
+func newStream(t *testing.T, f *fakeFirehose) (*Stream, *gosnstest.Sink) {
+	f.t = t
+	s := gosnstest.NewSink(f.putRecordBatch)
+	t.Cleanup(s.Close)
+	return &Stream{
+		Name:         "sns-messages",
+		Region:       "us-east-1",
+		Endpoint:     s.URL,
+		Credentials:  &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		MaxRecords:   4,
+		MaxWait:      20 * time.Millisecond,
+		RetryBackoff: time.Millisecond,
+	}, s
+}
+
+func writeAll(st *Stream, n int) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = st.Write(gosnstest.NewMessage(fmt.Sprint(i), "orders"))
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestStream(t *testing.T) {
+	f := &fakeFirehose{putFailures: 2}
+	st, s := newStream(t, f)
+	s.Fail(1, http.StatusServiceUnavailable, `{"__type": "ServiceUnavailableException", "message": "Slow down."}`)
+	for i, err := range writeAll(st, 10) {
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+	st.Close()
+	s.Lock()
+	defer s.Unlock()
+	if len(f.messages) != 10 {
+		t.Errorf("put %v", f.messages)
+	}
+	for _, n := range f.batchSizes {
+		if n > 4 {
+			t.Errorf("batch sizes %v", f.batchSizes)
+		}
+	}
+	if err := st.Write(gosnstest.NewMessage("late", "orders")); err != ErrClosed {
+		t.Errorf("write after close gave %v", err)
+	}
+}
+
+func TestStreamFailures(t *testing.T) {
+	st, s := newStream(t, &fakeFirehose{putFailures: 100})
+	st.MaxAttempts = 3
+	defer st.Close()
+	var ferr *Error
+	if err := st.Write(gosnstest.NewMessage("1", "orders")); !errors.As(err, &ferr) || ferr.Type != "ServiceUnavailableException" {
+		t.Errorf("got %v", err)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("%d calls", n)
+	}
+
+	// errors which would recur are not retried
+	st, s = newStream(t, &fakeFirehose{})
+	st.Name = "missing"
+	if err := st.Write(gosnstest.NewMessage("1", "orders")); !errors.As(err, &ferr) || ferr.Type != "ResourceNotFoundException" || len(s.Requests()) != 1 {
+		t.Errorf("got %v after %d calls", err, len(s.Requests()))
+	}
+	if err := st.Write(&gosns.Message{Message: strings.Repeat("x", MaxRecordSize)}); err != ErrRecordTooLarge {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	if got := (&Stream{Region: "cn-north-1"}).endpoint(); got != "https://firehose.cn-north-1.amazonaws.com.cn/" {
+		t.Errorf("got %s", got)
+	}
+	if got := (&Stream{Region: "us-east-1", Endpoint: "http://localhost:4566/"}).endpoint(); got != "http://localhost:4566/" {
+		t.Errorf("with Endpoint, got %s", got)
+	}
+}
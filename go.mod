@@ -0,0 +1,3 @@
+module github.com/pbnjay/gosns
+
+go 1.20
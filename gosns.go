@@ -4,24 +4,233 @@
 package gosns
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
 const amzTimeFormat = "2006-01-02T15:04:05.999999999Z"
 
+// SNSTimestampLayout is the exact form of the Timestamp SNS sends, for
+// Server.TimestampLayouts.
+const SNSTimestampLayout = "2006-01-02T15:04:05.000Z"
+
+// DefaultTimestampLayouts accepts RFC 3339 timestamps with or without
+// fractional seconds, in UTC or with any offset.
+var DefaultTimestampLayouts = []string{time.RFC3339Nano}
+
+// DefaultMaxBodySize is the request body limit used when Server.MaxBodySize
+// is zero. SNS messages are at most 256KB, but JSON escaping can grow them.
+const DefaultMaxBodySize = 1 << 20
+
 type Server struct {
 	Logger *log.Logger
-	topics map[string]*topicDescription
-}
 
-type topicDescription struct {
-	TopicARN string
-	Callback func(*Message)
+	// LogLevel is the lowest severity logged. Topics may override it, and
+	// their Logger. The default, LogDefault, logs everything.
+	LogLevel LogLevel
+
+	// LogFunc, if set, receives log lines in place of Logger, for structured
+	// logging.
+	LogFunc func(LogEntry)
+
+	// Capture, if set, receives a JSON line (see CapturedRequest) for every
+	// request the server handles, for later replay.
+	Capture io.Writer
+
+	// Store, if set, persists state such as delayed messages across restarts.
+	Store Store
+
+	// MaxBodySize limits the size of request bodies, which are refused with a
+	// 413 if exceeded. Zero means DefaultMaxBodySize. Topics may set their
+	// own limit.
+	MaxBodySize int64
+
+	// ProbePath, if set, is a path answering GET and HEAD requests with a 200,
+	// for load balancer health checks. Topic endpoints also answer GET and
+	// HEAD with a 200, for balancers which probe the subscribed URL itself.
+	// Both answer with a 503 once the server is draining (see Drain), and
+	// while a topic's Init is running or has failed.
+	ProbePath string
+
+	// PingPath, if set, is a path answering GET requests by passing a
+	// synthetic notification, signed with a key generated for the purpose,
+	// through the same parsing, verification and dispatch as real ones, for
+	// uptime checks which should catch more than a listening socket; see
+	// DefaultPingPath. It answers with a 200 and a JSON PingResult if the
+	// notification reached its callback, and a 503 otherwise.
+	PingPath string
+
+	// Responses overrides the status code, body or headers the server sends
+	// in each situation. Zero fields keep their defaults.
+	Responses map[ResponseKind]Response
+
+	// OnResponse, if set, may modify each response before it is written, for
+	// example to add a Retry-After header to ResponseOverloaded.
+	OnResponse func(r *http.Request, kind ResponseKind, resp *Response)
+
+	// TopicMismatch configures the logging and alerting of requests naming
+	// a topic other than their endpoint's.
+	TopicMismatch TopicMismatchPolicy
+
+	// ContentTypeCheck selects what happens to requests whose Content-Type
+	// is not one of ContentTypes (DefaultContentTypes if empty) with a UTF-8
+	// charset, if any.
+	ContentTypeCheck ContentTypeCheck
+	ContentTypes     []string
+
+	// VerifySignatures requires every confirmation and notification to carry
+	// a valid SNS signature. Requests which fail verification, including raw
+	// message deliveries (which are unsigned), are refused with a 403.
+	VerifySignatures bool
+
+	// Strict turns on every guard against unexpected traffic: signatures
+	// are verified as with VerifySignatures, the signed TopicArn must equal
+	// both the x-amz-sns-topic-arn header and the topic's TopicARN exactly
+	// (patterns never match), notifications must carry the
+	// x-amz-sns-subscription-arn of a subscription this server confirmed, and
+	// unknown message types are refused with a 403. Confirmed subscriptions
+	// are kept in the Store, which should be set so they survive restarts.
+	Strict bool
+
+	// Certs caches signing certificates for VerifySignatures. A default
+	// cache is created if it is nil.
+	Certs *CertCache
+
+	// Regions, if not empty, pins the AWS regions messages may come from,
+	// such as us-gov-west-1 and us-gov-east-1: signing certificates and
+	// SubscribeURLs must be on SNS in one of them. Whether or not it is
+	// set, a signing certificate or SubscribeURL on SNS must be in the
+	// region and partition of the message's TopicArn.
+	Regions []string
+
+	// CheckSubscribeURL, if set, checks the SubscribeURL of each
+	// confirmation before it is visited, refusing the confirmation with a
+	// 403 if it fails; use ValidateSubscribeURL outside tests.
+	CheckSubscribeURL func(*url.URL) error
+
+	// XRay, if set, emits an AWS X-Ray segment for every notification.
+	XRay *XRay
+
+	// SharedStore declares that the Store is shared by replicas behind a
+	// load balancer, so that in Strict mode a subscription confirmed by any
+	// replica is accepted by all.
+	SharedStore bool
+
+	// Dedup, if set, remembers the MessageIds seen by topics with a
+	// DuplicateWindow, in place of each topic's own memory, and the keys of
+	// topics with an IdempotencyKey. Give replicas the same DedupStore to
+	// detect duplicates delivered to any of them.
+	Dedup DedupStore
+
+	// ManualConfirm holds subscription confirmations for approval instead
+	// of confirming them as they arrive. They are kept in the Store, or in
+	// memory without one, and can be listed with PendingConfirmations and
+	// approved with ApproveConfirmation while their tokens remain valid.
+	ManualConfirm bool
+
+	// ClockSkew is allowed for between SNS timestamps and the local clock
+	// when judging the age of messages: notifications are only stale once
+	// older than a topic's MaxMessageAge plus ClockSkew, and held
+	// confirmations are approved until ClockSkew after they appear to have
+	// expired.
+	ClockSkew time.Duration
+
+	// SkewThreshold is how far the local clock may appear to be from SNS's,
+	// judging by the timestamps of notifications, before a warning is
+	// logged, once a minute while it lasts; see ClockOffset. Zero means
+	// DefaultSkewThreshold and a negative value disables the check.
+	SkewThreshold time.Duration
+
+	// Outbox, if set, runs the actions callbacks add with Message.Enqueue
+	// once they have returned.
+	Outbox *Outbox
+
+	// SQSEnvelopes accepts notifications relayed from an SQS queue
+	// subscribed to the topic, whose bodies are the SQS message, as returned
+	// by ReceiveMessage or in a Lambda event, with the SNS envelope as its
+	// body. The envelope is unwrapped, and any x-amz-sns-* headers which the
+	// relay did not set are filled in from it, so that it is verified and
+	// handled as if SNS had delivered it directly. Requests whose bodies are
+	// not SQS messages are handled as usual.
+	SQSEnvelopes bool
+
+	// TestEvents, if set, reports whether a notification is a test message
+	// rather than a real one, such as those AWS services send when first
+	// configured to publish to a topic (see IsTestEvent). Test messages are
+	// acknowledged and logged without being passed to Callback.
+	TestEvents func(*Message) bool
+
+	// DryRun sets every topic's DryRun, so that notifications are handled
+	// up to, but not including, their callbacks.
+	DryRun bool
+
+	// HashBodies computes the SHA-256 of each notification's body as
+	// received, before any parsing, logging it with the MessageId and
+	// setting it in Message.BodySHA256, so that the payload can be checked
+	// end to end and tampering by proxies in between detected.
+	HashBodies bool
+
+	// HTTPMetrics records the rate, errors and duration of the requests
+	// the server answers, by route, method and status, for HTTPStats and
+	// the admin API. OnHTTPRequest, if set, is called with each request
+	// whether or not HTTPMetrics is, such as to record it with an
+	// OpenTelemetry meter so that the listener appears in standard HTTP
+	// server dashboards. Messages read from SQS are not counted.
+	HTTPMetrics   bool
+	OnHTTPRequest func(*HTTPRequest)
+
+	// StatsInterval, if positive, samples the throughput, failures and
+	// latency of every topic's callback once per interval, keeping the
+	// latest StatsSamples (DefaultStatsSamples if zero) in memory; see
+	// Topic.Stats and the admin API's /stats, which can be queried by
+	// Grafana's JSON datasource.
+	StatsInterval time.Duration
+	StatsSamples  int
+
+	// TimestampLayouts are the time.Parse layouts tried in turn for the
+	// Timestamp of notifications; nil means DefaultTimestampLayouts. Use
+	// []string{SNSTimestampLayout} to accept only the form SNS sends. A
+	// Timestamp which none of them parses is logged and replaced by the
+	// time the notification was received, with Message.TimestampFallback
+	// set, rather than left zero.
+	TimestampLayouts []string
+
+	// DisallowUnknownFields rejects JSON envelopes containing fields which
+	// SNS is not known to send, as a guard against forged or garbled bodies.
+	DisallowUnknownFields bool
+
+	topicsMu    sync.RWMutex
+	topics      map[string]*Topic
+	tenants     map[string]*Tenant
+	groups      []*HandlerGroup
+	captureMu   sync.Mutex
+	pending     MemoryStore // confirmations held without a Store
+	quarantined MemoryStore // quarantined messages without a Store
+	idempotent  MemoryStore // idempotency keys without a Dedup store
+	httpStats   httpMetrics
+	certsOnce   sync.Once
+	skew        clockSkew
+	pingOnce    sync.Once
+	ping        *pinger
+	pingErr     error
+	startOnce   sync.Once
+	startErr    error
+	draining    int32
+	maint       int32
+	ctxOnce     sync.Once
+	ctx         context.Context // cancelled by Drain
+	cancel      context.CancelFunc
+	srvMu       sync.Mutex
+	srv         *http.Server // set by Serve, for Drain
 }
 
 type Message struct {
@@ -29,154 +238,669 @@ type Message struct {
 	Message   string
 	MessageId string
 	Timestamp time.Time
+
+	// TopicArn is the ARN of the topic which published the message, which
+	// may differ from Topic.TopicARN if that is a pattern.
+	TopicArn string
+
+	// MessageAttributes holds any attributes the publisher attached to the
+	// message, keyed by attribute name.
+	MessageAttributes map[string]MessageAttribute
+
+	// BodySHA256 is the hex SHA-256 of the request body which delivered the
+	// message, if Server.HashBodies is set.
+	BodySHA256 string `json:",omitempty"`
+
+	// RawTimestamp is the Timestamp of the notification as SNS sent it.
+	// TimestampFallback is set if it could not be parsed (see
+	// Server.TimestampLayouts), so that Timestamp is instead when the
+	// message was received, and TimestampError is why, for callbacks which
+	// depend on the exact order of messages to handle such anomalies.
+	RawTimestamp      string `json:",omitempty"`
+	TimestampFallback bool   `json:",omitempty"`
+	TimestampError    error  `json:"-"`
+
+	ctx    context.Context
+	outbox []OutboxAction // enqueued by the callback
+	failed error          // passed to Fail by the callback
+
+	accepted time.Time // when it was dispatched to the callback, for SLOs
+	topic    *Topic    // which dispatched it, for a HandlerGroup's workers
+}
+
+// Context returns the message's context, which carries values such as the
+// RequestID of the delivery. Unlike the http.Request context it is not
+// cancelled when the delivery has been acknowledged. It is never nil.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// MessageAttribute is a single SNS message attribute. Type is one of the SNS
+// data types (String, String.Array, Number or Binary) and Value is its string
+// representation as delivered in the notification.
+type MessageAttribute struct {
+	Type  string
+	Value string
 }
 
 // AddTopic adds an http endpoint for the specified topicARN which will
 // automatically handle SNS subscription confirmation, and parse message
-// notifications which are sent to the goroutine callback.
-func (s *Server) AddTopic(topicARN, endpoint string, callback func(*Message)) {
-	t := &topicDescription{
+// notifications which are sent to the goroutine callback. The returned Topic
+// may be used to adjust per-topic options before the server is started.
+//
+// Segments of the endpoint written as {name} match any value, so that one
+// topic at "/hooks/{tenant}/orders" serves many subscription URLs; callbacks
+// find the values with PathValue. Endpoints without parameters take
+// precedence.
+//
+// AddTopic does not check its arguments beyond logging a warning; use
+// RegisterTopic to have mistakes returned as errors.
+func (s *Server) AddTopic(topicARN, endpoint string, callback func(*Message)) *Topic {
+	return s.addTopic(topicARN, endpoint, callback, nil)
+}
+
+// RegisterTopic is like AddTopic, but returns an error instead of adding the
+// topic if topicARN is neither an SNS topic ARN nor a pattern of one (see
+// Topic.TopicARN), if the endpoint is malformed or already has a topic, or
+// if callback is nil.
+func (s *Server) RegisterTopic(topicARN, endpoint string, callback func(*Message)) (*Topic, error) {
+	if err := checkTopic(topicARN, endpoint, callback); err != nil {
+		return nil, err
+	}
+	t := s.newTopic(topicARN, endpoint, callback, nil)
+	if !s.store(t, false) {
+		return nil, fmt.Errorf("%w: '%s'", ErrEndpointInUse, t.endpoint)
+	}
+	return t, nil
+}
+
+// checkTopic returns an error describing what is wrong with the arguments
+// to AddTopic, if anything.
+func checkTopic(topicARN, endpoint string, callback func(*Message)) error {
+	if err := checkTopicARN(topicARN); err != nil {
+		return err
+	}
+	if endpoint == "" || strings.ContainsAny(endpoint, "?#") {
+		return fmt.Errorf("%w: '%s'", ErrInvalidEndpoint, endpoint)
+	}
+	if callback == nil {
+		return ErrNilCallback
+	}
+	return nil
+}
+
+func (s *Server) addTopic(topicARN, endpoint string, callback func(*Message), tenant *Tenant) *Topic {
+	return s.register(s.newTopic(topicARN, endpoint, callback, tenant))
+}
+
+// newTopic creates a topic for endpoint without registering it.
+func (s *Server) newTopic(topicARN, endpoint string, callback func(*Message), tenant *Tenant) *Topic {
+	if err := checkTopic(topicARN, endpoint, callback); err != nil {
+		s.logf(LogWarn, "Adding endpoint '%s': %v\n", endpoint, err)
+	}
+	if endpoint == "" || endpoint[:1] != "/" {
+		endpoint = "/" + endpoint
+	}
+	return &Topic{
 		TopicARN: topicARN,
 		Callback: callback,
+		server:   s,
+		endpoint: endpoint,
+		pattern:  parsePattern(endpoint),
+		tenant:   tenant,
 	}
-	if endpoint[:1] != "/" {
-		endpoint = "/" + endpoint
+}
+
+// register serves t at its endpoint, replacing any topic already there.
+func (s *Server) register(t *Topic) *Topic {
+	s.store(t, true)
+	return t
+}
+
+// store serves t at its endpoint, unless another topic is already there and
+// replace is false. It reports whether t was stored.
+func (s *Server) store(t *Topic, replace bool) bool {
+	s.topicsMu.Lock()
+	old, ok := s.topics[t.endpoint]
+	if ok && !replace {
+		s.topicsMu.Unlock()
+		return false
 	}
 	if s.topics == nil {
-		s.topics = map[string]*topicDescription{
-			endpoint: t,
-		}
-	} else {
-		s.topics[endpoint] = t
+		s.topics = make(map[string]*Topic)
 	}
-	if s.Logger != nil {
-		s.Logger.Printf("Adding endpoint '%s' for topic '%s'\n", endpoint, topicARN)
+	s.topics[t.endpoint] = t
+	s.topicsMu.Unlock()
+	if ok {
+		s.logf(LogWarn, "Replacing topic '%s' at endpoint '%s'\n", old.TopicARN, t.endpoint)
 	}
+	s.logf(LogInfo, "Adding endpoint '%s' for topic '%s'\n", t.endpoint, t.TopicARN)
+	return true
+}
+
+// topic returns the topic registered at endpoint.
+func (s *Server) topic(endpoint string) (*Topic, bool) {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	t, ok := s.topics[endpoint]
+	return t, ok
+}
+
+// route returns the topic for r, and the values of its endpoint's
+// parameters, if any. Virtual host endpoints come first, then plain
+// endpoints, then those with parameters, and finally the catch-all.
+func (s *Server) route(r *http.Request) (*Topic, map[string]string) {
+	hostPath := requestHost(r) + r.URL.Path
+	if td, ok := s.topic(hostPath); ok {
+		return td, nil
+	}
+	if td, ok := s.topic(r.URL.Path); ok {
+		return td, nil
+	}
+	if td, values := s.matchTopic(hostPath); td != nil {
+		return td, values
+	}
+	if td, values := s.matchTopic(r.URL.Path); td != nil {
+		return td, values
+	}
+	td, _ := s.topic(catchAllEndpoint)
+	return td, nil
 }
 
 func simpleResponse(w http.ResponseWriter, code int, msg string) {
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		w.WriteHeader(code)
+		return
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(code)
 	fmt.Fprintln(w, msg)
 }
 
-func (s *Server) extractJsonBody(r *http.Request) map[string]interface{} {
-	var nbytes int
-	n, err := fmt.Sscanf(r.Header.Get("Content-Length"), "%d", &nbytes)
-	if n != 1 || err != nil {
-		fmt.Printf("no content-length??\n%+v", r.Header)
+// confirmSub visits the SubscribeURL of a subscription confirmation. Errors
+// are returned for requests which could not be read.
+func (s *Server) confirmSub(td *Topic, r *http.Request) error {
+	env, err := s.readEnvelope(r)
+	if err != nil {
+		td.logf(r, LogError, "error reading confirmation body: %v\n", err)
+		return err
+	}
+	if env.SubscribeURL == "" {
+		td.logf(r, LogWarn, "confirmation for topic '%s' has no SubscribeURL\n", td.TopicARN)
+		return errors.New("missing SubscribeURL")
+	}
+	if err = s.checkSubscribeURL(env); err != nil {
+		td.logf(r, LogWarn, "confirmation for topic '%s' refused: %v\n", td.TopicARN, err)
+		return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+	}
+	if s.verifies(td) {
+		if err = s.verify(td, env); err != nil {
+			td.logf(r, LogWarn, "confirmation for topic '%s' failed verification: %v\n", td.TopicARN, err)
+			return fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+		}
+	}
+	if err = s.checkTopic(td, r, env); err != nil {
+		td.logf(r, LogWarn, "confirmation for topic '%s' is from topic '%s'\n", td.TopicARN, env.TopicArn)
+		return err
+	}
+
+	if s.ManualConfirm {
+		return s.holdConfirmation(td, r, env)
+	}
+	if !td.dependenciesReady() {
+		td.logf(r, LogInfo, "Endpoint '%s' will confirm subscription for topic '%s' once its dependencies are ready\n", r.URL.Path, td.TopicARN)
+		go s.confirmWhenReady(td, env)
 		return nil
 	}
-	jsonBytes := make([]byte, nbytes, nbytes)
-	n, err = io.ReadFull(r.Body, jsonBytes)
-	if n != nbytes || err != nil {
-		fmt.Printf("error reading body (%d,%d) %v", n, nbytes, err)
+	if _, err = s.confirm(td.endpoint, env.TopicArn, env.SubscribeURL); err != nil {
+		td.logf(r, LogError, "error confirming subscription: %v\n", err)
 		return nil
 	}
+	td.logf(r, LogInfo, "Endpoint '%s' confirmed subscription for topic '%s'\n", r.URL.Path, td.TopicARN)
+	return nil
+}
 
-	data := make(map[string]interface{})
-	if r.Header.Get("x-amz-sns-rawdelivery") == "true" {
-		data["Subject"] = ""
-		data["Message"] = string(jsonBytes)
-		data["MessageId"] = r.Header.Get("x-amz-sns-message-id")
-		data["Timestamp"] = time.Now().In(time.UTC).Format(amzTimeFormat)
-		return data
+// checkSubscribeURL checks the SubscribeURL of a confirmation against
+// CheckSubscribeURL and Regions.
+func (s *Server) checkSubscribeURL(env *envelope) error {
+	u, err := url.Parse(env.SubscribeURL)
+	if err != nil {
+		return fmt.Errorf("bad SubscribeURL: %v", err)
+	}
+	if s.CheckSubscribeURL != nil {
+		if err = s.CheckSubscribeURL(u); err != nil {
+			return err
+		}
 	}
+	return s.checkRegion("SubscribeURL", env.SubscribeURL, env.TopicArn)
+}
 
-	err = json.Unmarshal(jsonBytes, &data)
+// confirm visits subscribeURL to confirm a subscription of topicARN to the
+// endpoint and records it, returning the subscription ARN if SNS sent one.
+func (s *Server) confirm(endpoint, topicARN, subscribeURL string) (string, error) {
+	resp, err := http.Get(subscribeURL)
 	if err != nil {
-		fmt.Printf("error parsing json body %v", err)
-		return nil
+		return "", &ConfirmationError{TopicArn: topicARN, Endpoint: endpoint, Err: err}
 	}
-
-	return data
+	subARN := readSubscriptionARN(resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &ConfirmationError{topicARN, endpoint, resp.StatusCode, errors.New(resp.Status)}
+	}
+	td, ok := s.topic(endpoint)
+	if !ok {
+		// approved after the topic was removed, or by another process
+		if subARN != "" && s.Store != nil {
+			if err = s.Store.Put(subscriptionKey(endpoint, subARN), []byte(topicARN)); err != nil {
+				s.logf(LogError, "error persisting subscription '%s': %v\n", subARN, err)
+			}
+		}
+		return subARN, nil
+	}
+	if subARN != "" {
+		td.addSubscription(subARN)
+	}
+	td.initialize()
+	return subARN, nil
 }
 
-func (s *Server) confirmSub(td *topicDescription, r *http.Request) {
-	data := s.extractJsonBody(r)
-	if data == nil {
-		return
+// notify answers a notification, and then ends its X-Ray segment, if it has
+// one, with the status sent.
+func (s *Server) notify(w http.ResponseWriter, r *http.Request, td *Topic) {
+	msg, err := s.readMessage(td, r)
+	var seg *xraySegment
+	if err == nil {
+		// taken now, as msg belongs to the callback once dispatched
+		seg = messageSegment(msg)
+		err = s.processMessage(td, r, msg)
+	}
+	var status int
+	if msg != nil && poisonous(err) {
+		status = s.failDelivery(w, r, td, msg, err)
+	} else {
+		if err == nil && msg != nil {
+			td.succeeded(msg.MessageId)
+		}
+		status = s.errorResponse(w, r, td, err)
+	}
+	if seg != nil {
+		seg.end(status)
 	}
+}
 
-	subURL := data["SubscribeURL"].(string)
-	_, err := http.Get(subURL)
+// readMessage reads and checks a notification, returning an error if the
+// request could not be read or should be refused.
+func (s *Server) readMessage(td *Topic, r *http.Request) (*Message, error) {
+	// messages from SQS were delivered to the queue's subscription, which
+	// is not one of the topic's
+	if s.Strict && !fromSQS(r) && !td.hasSubscription(r.Header.Get("x-amz-sns-subscription-arn")) {
+		return nil, ErrUnknownSubscription
+	}
+	env, err := s.readEnvelope(r)
 	if err != nil {
-		fmt.Printf("error confirming subscription: %v", err)
-		return
+		td.logf(r, LogError, "error reading notification body: %v\n", err)
+		return nil, err
 	}
-	r.Body.Close()
-
-	if s.Logger != nil {
-		s.Logger.Printf("Endpoint '%s' confirmed subscription for topic '%s'\n", r.URL.Path, td.TopicARN)
+	// raw messages from SQS have no signature, but were read with the
+	// queue's own credentials
+	raw := r.Header.Get("x-amz-sns-rawdelivery") == "true"
+	if s.verifies(td) && !(raw && fromSQS(r)) {
+		if err = s.verify(td, env); err != nil {
+			td.logf(r, LogWarn, "notification for topic '%s' failed verification: %v\n", td.TopicARN, err)
+			return nil, fmt.Errorf("%w: %v", ErrVerificationFailed, err)
+		}
+	}
+	if err = s.checkTopic(td, r, env); err != nil {
+		td.logf(r, LogWarn, "notification for topic '%s' is from topic '%s'\n", td.TopicARN, env.TopicArn)
+		return nil, err
 	}
-	// ping callback to allow for init
-	go td.Callback(nil)
+	msg := env.message(s.TimestampLayouts)
+	if msg.TimestampFallback {
+		td.logf(r, LogWarn, "notification %s for topic '%s', using the time received: %v\n", msg.MessageId, td.TopicARN, msg.TimestampError)
+	} else if !raw {
+		// raw deliveries are given the local time
+		s.observeClock(msg.Timestamp)
+	}
+	msg.ctx = withRequestID(context.Background(), RequestID(r.Context()))
+	msg.ctx = context.WithValue(msg.ctx, endpointPathKey, r.URL.Path)
+	if values := r.Context().Value(pathValuesKey); values != nil {
+		msg.ctx = context.WithValue(msg.ctx, pathValuesKey, values)
+	}
+	msg.ctx = td.withTenant(msg.ctx)
+	if s.XRay != nil {
+		msg.ctx = s.XRay.begin(msg.ctx, msg, env.TopicArn, r.Method, r.URL.String(), r.Header.Get("X-Amzn-Trace-Id"))
+	}
+	return msg, nil
 }
 
-func (s *Server) processMessage(td *topicDescription, r *http.Request) {
-	data := s.extractJsonBody(r)
-	if data == nil {
-		return
+// processMessage transforms and dispatches a notification. It returns
+// ErrOverloaded if the topic cannot accept the message, ErrPaused if it is
+// paused and cannot hold it, ErrNotReady if its dependencies are not ready,
+// ErrTransformFailed if a Transformer failed, ErrCallbackFailed if an
+// AtLeastOnce callback failed, or ErrDuplicate if the message was dropped as
+// a duplicate or its idempotency key seen before.
+func (s *Server) processMessage(td *Topic, r *http.Request, msg *Message) (err error) {
+	td.beat()
+	if td.refusing() {
+		return ErrPaused
+	}
+	if !td.dependenciesReady() {
+		return ErrNotReady
+	}
+	if td.Migration != nil && msg.TopicArn != "" {
+		td.Migration.observe(td, msg.TopicArn)
+	}
+	sampled := td.logSampled()
+	logf := func(level LogLevel, format string, args ...interface{}) {
+		if sampled || level >= LogWarn {
+			td.logf(r, level, format, args...)
+		}
+	}
+	if td.checkDuplicate(r, msg) && td.DropDuplicates {
+		return ErrDuplicate
+	}
+	if s.TestEvents != nil && s.TestEvents(msg) {
+		td.logf(r, LogInfo, "Endpoint '%s' got test message %s for topic '%s', skipping callback\n", r.URL.Path, msg.MessageId, td.TopicARN)
+		return nil
+	}
+	if len(td.Transformers) > 0 {
+		id := msg.MessageId
+		if msg, err = td.transform(msg); err != nil {
+			logf(LogError, "error transforming message %s for topic '%s': %v\n", id, td.TopicARN, err)
+			return fmt.Errorf("%w: %v", ErrTransformFailed, err)
+		}
+		if msg == nil {
+			logf(LogDebug, "Endpoint '%s' dropped message %s for topic '%s'\n", r.URL.Path, id, td.TopicARN)
+			return nil
+		}
 	}
 
-	timeStr := data["Timestamp"].(string)
-	tm, _ := time.Parse(amzTimeFormat, timeStr)
-	msg := &Message{
-		Message:   data["Message"].(string),
-		MessageId: data["MessageId"].(string),
-		Timestamp: tm,
+	if msg.BodySHA256 != "" {
+		logf(LogInfo, "Endpoint '%s' got message %s (sha256 %s) for topic '%s'\n", r.URL.Path, msg.MessageId, msg.BodySHA256, td.TopicARN)
+	} else {
+		logf(LogInfo, "Endpoint '%s' got message %s for topic '%s'\n", r.URL.Path, msg.MessageId, td.TopicARN)
+	}
+	if td.MaxMessageAge > 0 && !msg.Timestamp.IsZero() {
+		if age := time.Since(msg.Timestamp); age > td.MaxMessageAge+s.ClockSkew {
+			logf(LogInfo, "    Stale after %s, skipping callback\n", age.Round(time.Second))
+			if td.Stale != nil {
+				go td.Stale(msg)
+			}
+			return nil
+		}
+	}
+	if !td.sampled(msg) {
+		logf(LogDebug, "    Not in sample, skipping callback\n")
+		return nil
+	}
+	if td.dryRun() {
+		logf(LogInfo, "    Dry run, skipping callback\n")
+		return nil
+	}
+	key, dup := td.claimKey(r, msg)
+	if dup {
+		return ErrDuplicate
+	}
+	defer func() {
+		if err != nil {
+			td.releaseKey(key)
+		}
+	}()
+	if td.NotBefore != nil {
+		if due := td.NotBefore(msg); time.Until(due) > 0 {
+			logf(LogInfo, "    Delaying until %s\n", due.Format(time.RFC3339))
+			td.schedule(msg, due, true)
+			return nil
+		}
+	}
+	if held, err := td.hold(msg); held || err != nil {
+		if held {
+			logf(LogInfo, "    Topic paused, holding message %s\n", msg.MessageId)
+		}
+		return err
+	}
+	if td.Delivery == AtLeastOnce {
+		return td.handle(msg)
+	}
+	if !td.dispatch(msg) {
+		logf(LogWarn, "    Rate limit exceeded, refusing message %s\n", msg.MessageId)
+		return ErrOverloaded
 	}
-	if data["Subject"] != nil {
-		msg.Subject = data["Subject"].(string)
+	return nil
+}
+
+// methodResponse answers a request which is not an SNS delivery: GET and HEAD
+// are treated as health probes, OPTIONS lists the allowed methods and
+// anything else is refused.
+func (s *Server) methodResponse(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	switch r.Method {
+	case "GET", "HEAD":
+		if s.Draining() {
+			s.respond(w, r, ResponseDraining)
+		} else if !s.initialized() {
+			s.respond(w, r, ResponseNotReady)
+		} else {
+			s.respond(w, r, ResponseOK)
+		}
+	case "OPTIONS":
+		s.respond(w, r, ResponseNoContent)
+	default:
+		s.reject(w, r, ResponseMethodNotAllowed, ReasonMethodNotAllowed)
 	}
+}
 
-	if s.Logger != nil {
-		s.Logger.Printf("Endpoint '%s' got message for topic '%s':\n", r.URL.Path, td.TopicARN)
-		s.Logger.Println("    MessageId: " + msg.MessageId)
+// errorResponse writes the response for an error from confirmSub or
+// processMessage, and returns the status code sent.
+func (s *Server) errorResponse(w http.ResponseWriter, r *http.Request, td *Topic, err error) int {
+	var mismatch *TopicMismatchError
+	switch {
+	case err == nil:
+		return s.respond(w, r, ResponseOK)
+	case errors.Is(err, ErrDuplicate):
+		return s.respondWith(w, r, ResponseOK, "", err)
+	case errors.As(err, &mismatch):
+		return s.rejectMismatch(w, r, td, mismatch)
+	case errors.Is(err, ErrOverloaded):
+		return s.reject(w, r, ResponseOverloaded, ReasonOverloaded)
+	case errors.Is(err, ErrPaused):
+		return s.reject(w, r, ResponsePaused, ReasonPaused)
+	case errors.Is(err, ErrNotReady):
+		return s.reject(w, r, ResponseNotReady, ReasonNotReady)
+	case errors.Is(err, ErrBodyTooLarge):
+		return s.rejectTooLarge(w, r, td, nil)
+	case errors.Is(err, ErrVerificationFailed):
+		return s.rejectErr(w, r, ResponseForbidden, ReasonBadSignature, err)
+	case errors.Is(err, ErrUnknownSubscription):
+		return s.reject(w, r, ResponseForbidden, ReasonUnknownSubscription)
+	case errors.Is(err, ErrAccountNotAllowed):
+		return s.reject(w, r, ResponseForbidden, ReasonAccountNotAllowed)
+	case errors.Is(err, ErrTransformFailed):
+		return s.rejectErr(w, r, ResponseInternalError, ReasonTransformFailed, err)
+	case errors.Is(err, ErrCallbackFailed):
+		return s.rejectFailed(w, r, td, 0, err)
+	default:
+		return s.rejectErr(w, r, ResponseBadRequest, ReasonBadBody, err)
 	}
-	go td.Callback(msg)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if td, found := s.topics[r.URL.Path]; found {
-		// check that topic is configured correctly
-		amzTopic := r.Header.Get("x-amz-sns-topic-arn")
-		if td.TopicARN == amzTopic {
-			// determine message type
-			amzType := r.Header.Get("x-amz-sns-message-type")
-
-			switch amzType {
-			case "SubscriptionConfirmation":
-				s.confirmSub(td, r)
-				simpleResponse(w, http.StatusOK, "ok")
-			case "Notification":
-				s.processMessage(td, r)
-				simpleResponse(w, http.StatusOK, "ok")
-			default:
-				simpleResponse(w, http.StatusNotImplemented, "not implemented")
-			}
+	start := time.Now()
+	var sw *statusWriter
+	if (s.HTTPMetrics || s.OnHTTPRequest != nil) && !fromSQS(r) {
+		sw = &statusWriter{ResponseWriter: w}
+		w = sw
+	}
+	id := requestID(r)
+	w.Header().Set(RequestIDHeader, id)
+	r = r.WithContext(withRequestID(r.Context(), id))
+
+	td, values := s.route(r)
+	if sw != nil {
+		defer s.measure(r, sw, td, start)
+	}
+	if values != nil {
+		r = r.WithContext(context.WithValue(r.Context(), pathValuesKey, values))
+	}
+	if !s.checkLimits(w, r, td) {
+		return
+	}
+
+	if s.Capture != nil {
+		s.capture(r)
+	}
+	if s.ProbePath != "" && r.URL.Path == s.ProbePath {
+		s.methodResponse(w, r, "GET, HEAD, OPTIONS")
+		return
+	}
+	if s.PingPath != "" && r.URL.Path == s.PingPath {
+		s.servePing(w, r)
+		return
+	}
+	if td == nil {
+		// write out a 404
+		s.reject(w, r, ResponseNotFound, ReasonNotFound)
+		return
+	}
+	s.serveTopic(w, r, td)
+}
+
+// serveTopic handles a request to the topic td.
+func (s *Server) serveTopic(w http.ResponseWriter, r *http.Request, td *Topic) {
+	if td.DebugRequests > 0 {
+		td.startOnce.Do(td.start)
+		dw := td.debugRequest(w, r)
+		defer dw.finish()
+		w = dw
+	}
+	// everything below is decided from the request line and headers, so
+	// doomed requests are refused before their body is read
+	if !fromSQS(r) && !td.authorized(r) {
+		s.reject(w, r, ResponseUnauthorized, ReasonUnauthorized)
+		return
+	}
+	if r.Method != "POST" {
+		s.methodResponse(w, r, "POST, GET, HEAD, OPTIONS")
+		return
+	}
+	if !s.checkContentType(w, r, td) {
+		return
+	}
+	if s.SQSEnvelopes {
+		if err := s.unwrapSQS(r); err != nil {
+			s.errorResponse(w, r, td, err)
 			return
 		}
+	}
 
-		// write out a 400
-		simpleResponse(w, http.StatusBadRequest, "bad request")
+	// check that topic is configured correctly
+	amzTopic := r.Header.Get("x-amz-sns-topic-arn")
+	if s.topicMatches(td, amzTopic) {
+		if !td.accountAllowed(amzTopic) {
+			s.reject(w, r, ResponseForbidden, ReasonAccountNotAllowed)
+			return
+		}
+
+		// determine message type
+		amzType := r.Header.Get("x-amz-sns-message-type")
+
+		switch amzType {
+		case "SubscriptionConfirmation":
+			s.errorResponse(w, r, td, s.confirmSub(td, r))
+		case "Notification":
+			if s.InMaintenance() {
+				s.reject(w, r, ResponseMaintenance, ReasonMaintenance)
+				return
+			}
+			s.notify(w, r, td)
+		default:
+			if s.Strict {
+				s.reject(w, r, ResponseForbidden, ReasonUnknownType)
+			} else {
+				s.reject(w, r, ResponseNotImplemented, ReasonUnknownType)
+			}
+		}
 		return
 	}
 
-	// write out a 404
-	simpleResponse(w, http.StatusNotFound, "not found")
+	s.rejectMismatch(w, r, td, &TopicMismatchError{td.endpoint, td.TopicARN, amzTopic})
+}
+
+// Start resumes work persisted in the Store by a previous run, such as
+// delayed messages. ListenAndServe and Serve call it automatically; when the
+// Server is mounted as a handler elsewhere, call Start once after adding all
+// topics. Start then starts any HandlerGroups. Subsequent calls return the
+// result of the first.
+func (s *Server) Start() error {
+	s.startOnce.Do(func() {
+		if s.startErr = s.checkDependencies(); s.startErr != nil {
+			return
+		}
+		if s.startErr = s.checkDeliveries(); s.startErr != nil {
+			return
+		}
+		s.startHeartbeats()
+		s.startMigrations()
+		if s.Store != nil {
+			if s.startErr = s.restoreSubscriptions(); s.startErr == nil {
+				s.startErr = s.restoreDelayed()
+			}
+			if s.startErr == nil {
+				s.startErr = s.restoreJournal()
+			}
+			if s.startErr == nil {
+				s.startErr = s.sweepFailures()
+			}
+		}
+		s.initConfirmed()
+		if s.Outbox != nil && s.startErr == nil {
+			s.startErr = s.Outbox.start(s)
+		}
+		if s.startErr == nil {
+			s.startErr = s.startGroups()
+		}
+	})
+	return s.startErr
 }
 
 func (s *Server) ListenAndServe(address string) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	if address == "" {
+		address = ":http"
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve is like ListenAndServe, but accepts connections from ln, such as a
+// listening socket inherited from the process this one is replacing.
+func (s *Server) Serve(ln net.Listener) error {
+	if err := s.Start(); err != nil {
+		ln.Close()
+		return err
+	}
 	srv := &http.Server{
-		Addr:           address,
 		Handler:        s,
 		ReadTimeout:    15 * time.Second,
 		WriteTimeout:   15 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
-	if s.Logger != nil {
-		s.Logger.Println("Listening on " + address)
+	s.srvMu.Lock()
+	if s.Draining() {
+		s.srvMu.Unlock()
+		ln.Close()
+		return http.ErrServerClosed
 	}
-	return srv.ListenAndServe()
+	s.srv = srv
+	s.srvMu.Unlock()
+	s.logf(LogInfo, "Listening on %s\n", ln.Addr())
+	return srv.Serve(ln)
 }
@@ -0,0 +1,171 @@
+package gosnstest
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// SNSClient returns a client for a stand-in of the SNS Query API which
+// accepts Publish and PublishBatch requests for the topic and delivers them
+// as Publish does, so that a gosns.Publisher can be tested end to end. For
+// FIFO topics (whose ARN ends in ".fifo"), repeated MessageDeduplicationIds
+// are accepted but not delivered again.
+func (t *FakeTopic) SNSClient() *gosns.SNSClient {
+	region := "us-east-1"
+	if arn, err := gosns.ParseARN(t.ARN); err == nil && arn.Region != "" {
+		region = arn.Region
+	}
+	return &gosns.SNSClient{
+		Region:      region,
+		Credentials: &gosns.Credentials{AccessKeyID: "AKIDGOSNSTEST", SecretAccessKey: "gosnstest"},
+		Endpoint:    t.srv.URL + "/",
+		Client:      t.srv.Client(),
+	}
+}
+
+type apiError struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Type    string   `xml:"Error>Type"`
+	Code    string   `xml:"Error>Code"`
+	Message string   `xml:"Error>Message"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(apiError{Type: "Sender", Code: code, Message: msg})
+}
+
+type publishResponse struct {
+	XMLName        xml.Name `xml:"PublishResponse"`
+	MessageId      string   `xml:"PublishResult>MessageId"`
+	SequenceNumber string   `xml:"PublishResult>SequenceNumber,omitempty"`
+}
+
+type batchSuccess struct {
+	Id             string
+	MessageId      string
+	SequenceNumber string `xml:",omitempty"`
+}
+
+type batchFailure struct {
+	Id          string
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+type publishBatchResponse struct {
+	XMLName    xml.Name       `xml:"PublishBatchResponse"`
+	Successful []batchSuccess `xml:"PublishBatchResult>Successful>member"`
+	Failed     []batchFailure `xml:"PublishBatchResult>Failed>member"`
+}
+
+// api serves the SNS Query API requests the stand-in supports.
+func (t *FakeTopic) api(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+	if r.Form.Get("TopicArn") != t.ARN {
+		writeAPIError(w, http.StatusNotFound, "NotFound", "Topic does not exist")
+		return
+	}
+	switch action := r.Form.Get("Action"); action {
+	case "Publish":
+		id, seq, err := t.apiPublish(r, "")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		xml.NewEncoder(w).Encode(publishResponse{MessageId: id, SequenceNumber: seq})
+	case "PublishBatch":
+		var resp publishBatchResponse
+		for i := 1; ; i++ {
+			prefix := "PublishBatchRequestEntries.member." + strconv.Itoa(i) + "."
+			if _, ok := r.Form[prefix+"Id"]; !ok {
+				break
+			}
+			entryID := r.Form.Get(prefix + "Id")
+			id, seq, err := t.apiPublish(r, prefix)
+			if err != nil {
+				resp.Failed = append(resp.Failed, batchFailure{entryID, "InvalidParameter", err.Error(), true})
+				continue
+			}
+			resp.Successful = append(resp.Successful, batchSuccess{entryID, id, seq})
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		xml.NewEncoder(w).Encode(resp)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("gosnstest does not support %s", action))
+	}
+}
+
+// apiPublish publishes the message whose parameters start with prefix.
+func (t *FakeTopic) apiPublish(r *http.Request, prefix string) (string, string, error) {
+	message := r.Form.Get(prefix + "Message")
+	if message == "" {
+		return "", "", errors.New("Empty message")
+	}
+	attrs := map[string]gosns.MessageAttribute{}
+	for i := 1; ; i++ {
+		ap := prefix + "MessageAttributes.entry." + strconv.Itoa(i) + "."
+		name := r.Form.Get(ap + "Name")
+		if name == "" {
+			break
+		}
+		attr := gosns.MessageAttribute{Type: r.Form.Get(ap + "Value.DataType")}
+		if attr.Type == "Binary" {
+			attr.Value = r.Form.Get(ap + "Value.BinaryValue")
+		} else {
+			attr.Value = r.Form.Get(ap + "Value.StringValue")
+		}
+		attrs[name] = attr
+	}
+	if len(attrs) == 0 {
+		attrs = nil
+	}
+
+	seq := ""
+	if arn, _ := gosns.ParseARN(t.ARN); arn.FIFO() {
+		if r.Form.Get(prefix+"MessageGroupId") == "" {
+			return "", "", errors.New("The MessageGroupId parameter is required for FIFO topics")
+		}
+		dedup := r.Form.Get(prefix + "MessageDeduplicationId")
+		t.mu.Lock()
+		if t.dedup == nil {
+			t.dedup = make(map[string]string)
+		}
+		id, dup := t.dedup[dedup]
+		t.seq++
+		seq = fmt.Sprintf("%020d", t.seq)
+		t.mu.Unlock()
+		if dedup != "" && dup {
+			return id, seq, nil
+		}
+		id, _ = t.Publish(r.Form.Get(prefix+"Subject"), message, attrs)
+		if dedup != "" {
+			t.mu.Lock()
+			t.dedup[dedup] = id
+			t.mu.Unlock()
+			// SNS forgets deduplication IDs after five minutes
+			time.AfterFunc(5*time.Minute, func() {
+				t.mu.Lock()
+				delete(t.dedup, dedup)
+				t.mu.Unlock()
+			})
+		}
+		return id, seq, nil
+	}
+
+	// delivery failures are not the publisher's concern; see Deliveries
+	id, _ := t.Publish(r.Form.Get(prefix+"Subject"), message, attrs)
+	return id, "", nil
+}
@@ -0,0 +1,154 @@
+// Package gosnstest provides utilities for testing gosns endpoints without
+// talking to Amazon SNS. It builds correctly formed SubscriptionConfirmation
+// and Notification requests, signs them with an in-memory test certificate,
+// and runs endpoints under net/http/httptest. Sink fakes the HTTP services
+// which sinks forward messages to.
+package gosnstest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// TimeFormat is the layout SNS uses for envelope timestamps.
+const TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Envelope is the JSON document SNS posts to HTTP(S) subscribers.
+type Envelope struct {
+	Type              string
+	MessageId         string
+	Token             string `json:",omitempty"`
+	TopicArn          string
+	Subject           string `json:",omitempty"`
+	Message           string
+	Timestamp         string
+	SignatureVersion  string                            `json:",omitempty"`
+	Signature         string                            `json:",omitempty"`
+	SigningCertURL    string                            `json:",omitempty"`
+	SubscribeURL      string                            `json:",omitempty"`
+	UnsubscribeURL    string                            `json:",omitempty"`
+	MessageAttributes map[string]gosns.MessageAttribute `json:",omitempty"`
+
+	// SubscriptionArn is sent in the x-amz-sns-subscription-arn header of
+	// notifications. A random ARN under TopicArn is used if it is empty.
+	SubscriptionArn string `json:"-"`
+}
+
+// NewUUID returns a random version 4 UUID, as used for SNS message IDs.
+func NewUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// NewNotification returns an unsigned Notification envelope.
+func NewNotification(topicARN, subject, message string) *Envelope {
+	return &Envelope{
+		Type:           "Notification",
+		MessageId:      NewUUID(),
+		TopicArn:       topicARN,
+		Subject:        subject,
+		Message:        message,
+		Timestamp:      time.Now().UTC().Format(TimeFormat),
+		UnsubscribeURL: "https://sns.us-east-1.amazonaws.com/?Action=Unsubscribe",
+	}
+}
+
+// NewSubscriptionConfirmation returns an unsigned SubscriptionConfirmation
+// envelope which asks the endpoint to visit subscribeURL.
+func NewSubscriptionConfirmation(topicARN, subscribeURL string) *Envelope {
+	token := strings.Replace(NewUUID()+NewUUID(), "-", "", -1)
+	return &Envelope{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    NewUUID(),
+		Token:        token,
+		TopicArn:     topicARN,
+		Message:      "You have chosen to subscribe to the topic " + topicARN + ".\nTo confirm the subscription, visit the SubscribeURL included in this message.",
+		Timestamp:    time.Now().UTC().Format(TimeFormat),
+		SubscribeURL: subscribeURL,
+	}
+}
+
+// StringToSign returns the canonical string SNS signs for this envelope.
+func (e *Envelope) StringToSign() string {
+	var keys []string
+	if e.Type == "Notification" {
+		keys = []string{"Message", "MessageId", "Subject", "Timestamp", "TopicArn", "Type"}
+	} else {
+		keys = []string{"Message", "MessageId", "SubscribeURL", "Timestamp", "Token", "TopicArn", "Type"}
+	}
+	vals := map[string]string{
+		"Message":      e.Message,
+		"MessageId":    e.MessageId,
+		"Subject":      e.Subject,
+		"SubscribeURL": e.SubscribeURL,
+		"Timestamp":    e.Timestamp,
+		"Token":        e.Token,
+		"TopicArn":     e.TopicArn,
+		"Type":         e.Type,
+	}
+	var buf bytes.Buffer
+	for _, k := range keys {
+		if k == "Subject" && e.Subject == "" {
+			continue
+		}
+		buf.WriteString(k + "\n" + vals[k] + "\n")
+	}
+	return buf.String()
+}
+
+// Body returns the JSON encoding of the envelope.
+func (e *Envelope) Body() []byte {
+	data, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func setHeaders(req *http.Request, e *Envelope, n int) {
+	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	req.Header.Set("Content-Length", strconv.Itoa(n))
+	req.Header.Set("User-Agent", "Amazon Simple Notification Service Agent")
+	req.Header.Set("x-amz-sns-message-type", e.Type)
+	req.Header.Set("x-amz-sns-message-id", e.MessageId)
+	req.Header.Set("x-amz-sns-topic-arn", e.TopicArn)
+	if e.Type == "Notification" {
+		subARN := e.SubscriptionArn
+		if subARN == "" {
+			subARN = e.TopicArn + ":" + NewUUID()
+		}
+		req.Header.Set("x-amz-sns-subscription-arn", subARN)
+	}
+}
+
+// NewRequest returns an incoming server request delivering e to target,
+// suitable for passing directly to an http.Handler.
+func NewRequest(target string, e *Envelope) *http.Request {
+	body := e.Body()
+	req := httptest.NewRequest("POST", target, bytes.NewReader(body))
+	setHeaders(req, e, len(body))
+	return req
+}
+
+// NewClientRequest returns an outgoing request delivering e to url.
+func NewClientRequest(url string, e *Envelope) (*http.Request, error) {
+	body := e.Body()
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	setHeaders(req, e, len(body))
+	return req, nil
+}
@@ -0,0 +1,60 @@
+package gosnstest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Replay re-sends requests recorded by gosns.Server.Capture to the server at
+// baseURL (e.g. "http://localhost:8080"), preserving their paths, headers and
+// bodies. Requests are spaced out as they originally arrived, divided by
+// speed; a speed of 0 sends them back to back. The callback, if not nil, is
+// called with each response; the body is closed after it returns.
+func Replay(r io.Reader, baseURL string, speed float64, callback func(*gosns.CapturedRequest, *http.Response)) error {
+	baseURL = strings.TrimRight(baseURL, "/")
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var first time.Time
+	start := time.Now()
+	for n := 1; ; n++ {
+		var cr gosns.CapturedRequest
+		err := dec.Decode(&cr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("gosnstest: capture record %d: %v", n, err)
+		}
+
+		if first.IsZero() {
+			first = cr.Time
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(cr.Time.Sub(first)) / speed))
+			time.Sleep(time.Until(due))
+		}
+
+		req, err := http.NewRequest(cr.Method, baseURL+cr.URI, strings.NewReader(cr.Body))
+		if err != nil {
+			return err
+		}
+		for k, v := range cr.Header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		if callback != nil {
+			callback(&cr, resp)
+		}
+		resp.Body.Close()
+	}
+}
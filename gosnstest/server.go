@@ -0,0 +1,177 @@
+package gosnstest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Server runs a gosns.Server under httptest, alongside a stand-in for the AWS
+// side of the conversation which serves the signing certificate and answers
+// SubscribeURL visits.
+type Server struct {
+	*httptest.Server
+
+	SNS    *gosns.Server
+	Signer *Signer
+
+	// AWS is the stand-in for SNS itself. Its URL is used for SigningCertURL
+	// and SubscribeURL values in envelopes sent through this Server.
+	AWS *httptest.Server
+
+	*awsSide
+}
+
+// awsSide answers the requests an endpoint makes back to SNS: fetching the
+// signing certificate and visiting a SubscribeURL.
+type awsSide struct {
+	srv    *httptest.Server
+	mux    *http.ServeMux
+	signer *Signer
+
+	mu         sync.Mutex
+	confirmed  map[string]string
+	subscribed map[string]string // endpoint path -> subscription ARN
+}
+
+func newAWSSide() *awsSide {
+	signer, err := NewSigner()
+	if err != nil {
+		panic(fmt.Sprintf("gosnstest: failed to create signer: %v", err))
+	}
+	a := &awsSide{
+		signer:     signer,
+		confirmed:  make(map[string]string),
+		subscribed: make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/SimpleNotificationService-test.pem", signer.Handler())
+	mux.HandleFunc("/confirm", a.confirm)
+	a.mux = mux
+	a.srv = httptest.NewServer(mux)
+	signer.CertURL = a.srv.URL + "/SimpleNotificationService-test.pem"
+	return a
+}
+
+// NewServer starts and returns a new Server delivering to s. The caller
+// should call Close when finished, to shut it down. Like httptest.NewServer,
+// it panics if the server cannot be started.
+func NewServer(s *gosns.Server) *Server {
+	a := newAWSSide()
+	a.Trust(s)
+	return &Server{
+		Server:  httptest.NewServer(s),
+		SNS:     s,
+		Signer:  a.signer,
+		AWS:     a.srv,
+		awsSide: a,
+	}
+}
+
+func (a *awsSide) confirm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("Token")
+	topicARN := r.URL.Query().Get("TopicArn")
+	subARN := topicARN + ":" + NewUUID()
+	a.mu.Lock()
+	a.confirmed[token] = subARN
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<ConfirmSubscriptionResponse xmlns="http://sns.amazonaws.com/doc/2010-03-31/">
+  <ConfirmSubscriptionResult><SubscriptionArn>%s</SubscriptionArn></ConfirmSubscriptionResult>
+  <ResponseMetadata><RequestId>%s</RequestId></ResponseMetadata>
+</ConfirmSubscriptionResponse>`, subARN, NewUUID())
+}
+
+// Trust configures s to accept the certificate served by this stand-in, in
+// addition to genuine SNS certificates, so that s.VerifySignatures can be
+// exercised in tests. NewServer calls it automatically.
+func (a *awsSide) Trust(s *gosns.Server) {
+	if s.Certs == nil {
+		s.Certs = &gosns.CertCache{}
+	}
+	next := s.Certs.ValidateURL
+	if next == nil {
+		next = gosns.ValidateCertURL
+	}
+	certURL := a.signer.CertURL
+	s.Certs.ValidateURL = func(u *url.URL) error {
+		if u.String() == certURL {
+			return nil
+		}
+		return next(u)
+	}
+}
+
+// SubscribeURL returns the URL a SubscriptionConfirmation for topicARN should
+// ask the endpoint to visit.
+func (a *awsSide) SubscribeURL(topicARN, token string) string {
+	return a.srv.URL + "/confirm?Action=ConfirmSubscription&TopicArn=" +
+		url.QueryEscape(topicARN) + "&Token=" + url.QueryEscape(token)
+}
+
+// Confirmed reports whether the endpoint visited the SubscribeURL for token,
+// and the subscription ARN that was returned to it.
+func (a *awsSide) Confirmed(token string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	arn, ok := a.confirmed[token]
+	return arn, ok
+}
+
+// Send signs e and posts it to endpoint on the server under test. If Confirm
+// has subscribed endpoint, notifications carry the confirmed subscription ARN.
+func (ts *Server) Send(endpoint string, e *Envelope) (*http.Response, error) {
+	if err := ts.Signer.Sign(e); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(endpoint, "/") {
+		endpoint = "/" + endpoint
+	}
+	if e.SubscriptionArn == "" {
+		ts.mu.Lock()
+		e.SubscriptionArn = ts.subscribed[endpoint]
+		ts.mu.Unlock()
+	}
+	req, err := NewClientRequest(ts.URL+endpoint, e)
+	if err != nil {
+		return nil, err
+	}
+	return ts.Client().Do(req)
+}
+
+// Confirm sends a signed SubscriptionConfirmation for topicARN to endpoint and
+// returns the response and the envelope that was sent.
+func (ts *Server) Confirm(endpoint, topicARN string) (*http.Response, *Envelope, error) {
+	e := NewSubscriptionConfirmation(topicARN, "")
+	e.SubscribeURL = ts.SubscribeURL(topicARN, e.Token)
+	resp, err := ts.Send(endpoint, e)
+	if arn, ok := ts.Confirmed(e.Token); ok {
+		if !strings.HasPrefix(endpoint, "/") {
+			endpoint = "/" + endpoint
+		}
+		ts.mu.Lock()
+		ts.subscribed[endpoint] = arn
+		ts.mu.Unlock()
+	}
+	return resp, e, err
+}
+
+// Notify sends a signed Notification for topicARN to endpoint and returns the
+// response and the envelope that was sent.
+func (ts *Server) Notify(endpoint, topicARN, subject, message string) (*http.Response, *Envelope, error) {
+	e := NewNotification(topicARN, subject, message)
+	resp, err := ts.Send(endpoint, e)
+	return resp, e, err
+}
+
+// Close shuts down both the server under test and the AWS stand-in.
+func (ts *Server) Close() {
+	ts.Server.Close()
+	ts.awsSide.srv.Close()
+}
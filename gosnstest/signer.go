@@ -0,0 +1,91 @@
+package gosnstest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Signer signs envelopes the way SNS does, using an in-memory RSA key and a
+// self-signed certificate. The certificate must be served at CertURL for a
+// verifying endpoint to find it; Handler returns a handler which does so.
+type Signer struct {
+	Key     *rsa.PrivateKey
+	Cert    *x509.Certificate
+	CertPEM []byte
+
+	// CertURL is placed in the SigningCertURL field of signed envelopes.
+	CertURL string
+
+	// SignatureVersion is "1" (SHA1withRSA, the default) or "2" (SHA256withRSA).
+	SignatureVersion string
+}
+
+// NewSigner generates a new key and certificate.
+func NewSigner() (*Signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{
+		Key:              key,
+		Cert:             cert,
+		CertPEM:          pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		SignatureVersion: "1",
+	}, nil
+}
+
+// Sign sets the SignatureVersion, Signature and SigningCertURL fields of e.
+func (s *Signer) Sign(e *Envelope) error {
+	var sig []byte
+	var err error
+	if s.SignatureVersion == "2" {
+		h := sha256.Sum256([]byte(e.StringToSign()))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, h[:])
+	} else {
+		h := sha1.Sum([]byte(e.StringToSign()))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA1, h[:])
+	}
+	if err != nil {
+		return err
+	}
+	e.SignatureVersion = s.SignatureVersion
+	if e.SignatureVersion == "" {
+		e.SignatureVersion = "1"
+	}
+	e.Signature = base64.StdEncoding.EncodeToString(sig)
+	e.SigningCertURL = s.CertURL
+	return nil
+}
+
+// Handler serves the PEM encoded certificate.
+func (s *Signer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Write(s.CertPEM)
+	})
+}
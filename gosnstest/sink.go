@@ -0,0 +1,144 @@
+package gosnstest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// MessageTime is the Timestamp of messages built by NewMessage.
+var MessageTime = time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// NewMessage returns a message with the given ID, as if published to the
+// named topic in us-east-1 at MessageTime, with the body "hello". Tests set
+// any other fields they need on the result.
+func NewMessage(id, topic string) *gosns.Message {
+	return &gosns.Message{
+		MessageId: id,
+		TopicArn:  "arn:aws:sns:us-east-1:123456789012:" + topic,
+		Timestamp: MessageTime,
+		Message:   "hello",
+	}
+}
+
+// SinkRequest is a request received by a Sink.
+type SinkRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+
+	// Status is the status the request was answered with.
+	Status int
+}
+
+// Sink is a fake HTTP service for testing the sinks which forward messages
+// to one, recording every request it receives. Requests are answered by
+// the handler passed to NewSink, after any failures set with Fail.
+//
+// The handler is called with the Sink locked, so any state it keeps should
+// be read between Lock and Unlock.
+type Sink struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handler  http.HandlerFunc
+	failures int
+	status   int
+	body     string
+	requests []SinkRequest
+}
+
+// NewSink starts and returns a new Sink answering requests with handler, or
+// with an empty 200 response if it is nil. The caller should call Close when
+// finished, to shut it down.
+func NewSink(handler http.HandlerFunc) *Sink {
+	s := &Sink{handler: handler}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *Sink) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.failures > 0:
+		s.failures--
+		sw.WriteHeader(s.status)
+		io.WriteString(sw, s.body)
+	case s.handler != nil:
+		s.handler(sw, r)
+	}
+	s.requests = append(s.requests, SinkRequest{Method: r.Method, URL: r.URL, Header: r.Header, Body: body, Status: sw.status})
+}
+
+// Fail answers the next n requests with status and body, without calling
+// the handler. Fail(0, 0, "") stops any failures still pending.
+func (s *Sink) Fail(n, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures, s.status, s.body = n, status, body
+}
+
+// Failures returns the number of failures set with Fail which are still to
+// be answered.
+func (s *Sink) Failures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Sink) Requests() []SinkRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SinkRequest(nil), s.requests...)
+}
+
+// Accepted returns the requests received so far which were answered with a
+// 2xx status, in order.
+func (s *Sink) Accepted() []SinkRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []SinkRequest
+	for _, req := range s.requests {
+		if req.Status >= 200 && req.Status < 300 {
+			res = append(res, req)
+		}
+	}
+	return res
+}
+
+// Lock locks the Sink, holding off its handler.
+func (s *Sink) Lock() { s.mu.Lock() }
+
+// Unlock unlocks the Sink.
+func (s *Sink) Unlock() { s.mu.Unlock() }
+
+// statusWriter records the status a handler answers with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
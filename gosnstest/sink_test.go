@@ -0,0 +1,39 @@
+package gosnstest
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSink(t *testing.T) {
+	s := NewSink(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "refused" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	defer s.Close()
+	s.Fail(2, http.StatusServiceUnavailable, "slow down")
+	for _, body := range []string{"a", "b", "c", "refused", "d"} {
+		resp, err := http.Post(s.URL+"/put", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if n := s.Failures(); n != 0 {
+		t.Errorf("%d failures left", n)
+	}
+	var statuses []int
+	for _, req := range s.Requests() {
+		statuses = append(statuses, req.Status)
+	}
+	if len(statuses) != 5 || statuses[0] != 503 || statuses[1] != 503 || statuses[2] != 200 || statuses[3] != 400 || statuses[4] != 200 {
+		t.Errorf("statuses %v", statuses)
+	}
+	if got := s.Accepted(); len(got) != 2 || string(got[0].Body) != "c" || string(got[1].Body) != "d" || got[1].URL.Path != "/put" {
+		t.Errorf("accepted %v", got)
+	}
+}
@@ -0,0 +1,306 @@
+package gosnstest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// RetryPolicy controls how a FakeTopic redelivers failed notifications. Like
+// SNS, only network errors and responses outside the 200-499 range are
+// retried.
+type RetryPolicy struct {
+	// Attempts is the total number of delivery attempts, including the first.
+	Attempts int
+
+	// MinDelay and MaxDelay bound the linear backoff between attempts.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy mirrors the shape of the SNS default HTTP delivery
+// policy (one attempt plus three retries), scaled down for tests.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 4, MinDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+// Delivery records a single attempt to deliver a message to an endpoint.
+type Delivery struct {
+	Endpoint   string
+	MessageId  string
+	Attempt    int
+	StatusCode int
+	Err        error
+}
+
+// FakeTopic acts like an SNS topic with HTTP(S) subscriptions. Endpoints are
+// added with Register, which performs the SubscriptionConfirmation handshake,
+// and Publish delivers signed notifications to every confirmed endpoint.
+type FakeTopic struct {
+	ARN    string
+	Signer *Signer
+	Client *http.Client
+	Retry  RetryPolicy
+
+	// Faults injects real-world SNS misbehavior into deliveries.
+	Faults Faults
+
+	*awsSide
+
+	mu         sync.Mutex
+	endpoints  []subscription
+	deliveries []Delivery
+	held       []*Envelope
+	rnd        *rand.Rand
+	dedup      map[string]string // FIFO deduplication ID -> MessageId
+	seq        int64
+}
+
+type subscription struct {
+	endpoint string
+	arn      string
+}
+
+// Faults configures deliberately broken deliveries. Each rate is the
+// probability (0 to 1) that the fault is applied, rolled independently for
+// every message or delivery attempt.
+type Faults struct {
+	// Duplicate delivers a successfully delivered notification a second time.
+	Duplicate float64
+
+	// Reorder holds a published notification back so that it is delivered
+	// after the next one published (or by Flush).
+	Reorder float64
+
+	// Malformed truncates the JSON body of a delivery attempt.
+	Malformed float64
+
+	// MissingHeaders drops the x-amz-sns-* headers from a delivery attempt.
+	MissingHeaders float64
+
+	// BadSignature corrupts the signature of a delivery attempt.
+	BadSignature float64
+
+	// Delay is added before every delivery attempt, plus a random amount of
+	// up to DelayJitter.
+	Delay       time.Duration
+	DelayJitter time.Duration
+}
+
+// NewFakeTopic returns a topic with the given ARN. The caller should call
+// Close when finished.
+func NewFakeTopic(arn string) *FakeTopic {
+	a := newAWSSide()
+	t := &FakeTopic{
+		ARN:     arn,
+		Signer:  a.signer,
+		Client:  http.DefaultClient,
+		Retry:   DefaultRetryPolicy,
+		awsSide: a,
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	a.mux.HandleFunc("/", t.api)
+	return t
+}
+
+// Register subscribes endpoint (a full URL) to the topic. It sends a signed
+// SubscriptionConfirmation and returns an error if the endpoint does not
+// visit the SubscribeURL within a few seconds.
+func (t *FakeTopic) Register(endpoint string) error {
+	e := NewSubscriptionConfirmation(t.ARN, "")
+	e.SubscribeURL = t.SubscribeURL(t.ARN, e.Token)
+	if err := t.Signer.Sign(e); err != nil {
+		return err
+	}
+	if _, err := t.deliver(endpoint, e); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if arn, ok := t.Confirmed(e.Token); ok {
+			t.mu.Lock()
+			t.endpoints = append(t.endpoints, subscription{endpoint, arn})
+			t.mu.Unlock()
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("gosnstest: endpoint '%s' did not confirm the subscription", endpoint)
+}
+
+// Publish delivers a notification to every confirmed endpoint, retrying
+// according to Retry, and returns its MessageId. The returned error reports
+// endpoints which never accepted the message. If Faults.Reorder holds the
+// message back, it is delivered after the next Publish and any error is
+// reported by that call instead.
+func (t *FakeTopic) Publish(subject, message string, attrs map[string]gosns.MessageAttribute) (string, error) {
+	e := NewNotification(t.ARN, subject, message)
+	e.MessageAttributes = attrs
+	if err := t.Signer.Sign(e); err != nil {
+		return "", err
+	}
+
+	if t.chance(t.Faults.Reorder) {
+		t.mu.Lock()
+		t.held = append(t.held, e)
+		t.mu.Unlock()
+		return e.MessageId, nil
+	}
+	err := t.publish(e)
+	if ferr := t.Flush(); err == nil {
+		err = ferr
+	}
+	return e.MessageId, err
+}
+
+// Flush delivers any notifications held back by Faults.Reorder.
+func (t *FakeTopic) Flush() error {
+	t.mu.Lock()
+	held := t.held
+	t.held = nil
+	t.mu.Unlock()
+
+	var err error
+	for _, e := range held {
+		if perr := t.publish(e); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func (t *FakeTopic) publish(e *Envelope) error {
+	t.mu.Lock()
+	subs := append([]subscription(nil), t.endpoints...)
+	t.mu.Unlock()
+
+	var failed []string
+	for _, sub := range subs {
+		se := *e
+		se.SubscriptionArn = sub.arn
+		ok := t.deliverWithRetry(sub.endpoint, &se)
+		if ok && t.chance(t.Faults.Duplicate) {
+			t.deliverWithRetry(sub.endpoint, &se)
+		}
+		if !ok {
+			failed = append(failed, sub.endpoint)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("gosnstest: delivery of %s failed to %v", e.MessageId, failed)
+	}
+	return nil
+}
+
+// Deliveries returns every delivery attempt made so far, in order.
+func (t *FakeTopic) Deliveries() []Delivery {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Delivery(nil), t.deliveries...)
+}
+
+// Close shuts down the topic's AWS stand-in server.
+func (t *FakeTopic) Close() {
+	t.awsSide.srv.Close()
+}
+
+func (t *FakeTopic) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Duration(t.rnd.Int63n(int64(d)))
+}
+
+func (t *FakeTopic) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rnd.Float64() < p
+}
+
+func (t *FakeTopic) deliverWithRetry(endpoint string, e *Envelope) bool {
+	attempts := t.Retry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := t.Retry.MinDelay
+	for i := 1; i <= attempts; i++ {
+		code, err := t.deliver(endpoint, e)
+		t.mu.Lock()
+		t.deliveries = append(t.deliveries, Delivery{
+			Endpoint: endpoint, MessageId: e.MessageId, Attempt: i, StatusCode: code, Err: err,
+		})
+		t.mu.Unlock()
+		if err == nil && code >= 200 && code < 300 {
+			return true
+		}
+		if err == nil && code >= 300 && code < 500 {
+			// SNS treats these as permanent failures
+			return false
+		}
+		if i < attempts {
+			time.Sleep(delay)
+			delay += t.Retry.MinDelay
+			if t.Retry.MaxDelay > 0 && delay > t.Retry.MaxDelay {
+				delay = t.Retry.MaxDelay
+			}
+		}
+	}
+	return false
+}
+
+func (t *FakeTopic) deliver(endpoint string, e *Envelope) (int, error) {
+	if d := t.Faults.Delay + t.jitter(t.Faults.DelayJitter); d > 0 {
+		time.Sleep(d)
+	}
+
+	if t.chance(t.Faults.BadSignature) {
+		bad := *e
+		sig, _ := base64.StdEncoding.DecodeString(bad.Signature)
+		if len(sig) > 0 {
+			sig[len(sig)/2] ^= 0xff
+		}
+		bad.Signature = base64.StdEncoding.EncodeToString(sig)
+		e = &bad
+	}
+	req, err := NewClientRequest(endpoint, e)
+	if err != nil {
+		return 0, err
+	}
+	if t.chance(t.Faults.Malformed) {
+		body := e.Body()
+		body = body[:len(body)/2]
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if t.chance(t.Faults.MissingHeaders) {
+		for k := range req.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-sns-") {
+				req.Header.Del(k)
+			}
+		}
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if e.Type != "Notification" && resp.StatusCode/100 != 2 {
+		return resp.StatusCode, errors.New("gosnstest: confirmation rejected with " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
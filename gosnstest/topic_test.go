@@ -0,0 +1,117 @@
+package gosnstest
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+const faultARN = "arn:aws:sns:us-east-1:123456789012:faults"
+
+// faultEndpoint returns a FakeTopic subscribed to a verifying server, and a
+// function returning the bodies of the messages it has handled.
+func faultEndpoint(t *testing.T) (*FakeTopic, func() []string) {
+	var mu sync.Mutex
+	var handled []string
+	s := &gosns.Server{VerifySignatures: true}
+	topic := s.AddTopic(faultARN, "/faults", func(msg *gosns.Message) {
+		if msg != nil {
+			mu.Lock()
+			handled = append(handled, msg.Message)
+			mu.Unlock()
+		}
+	})
+	topic.Delivery = gosns.AtLeastOnce // so that the callback has run when Publish returns
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	ft := NewFakeTopic(faultARN)
+	t.Cleanup(ft.Close)
+	ft.Trust(s)
+	ft.Retry = RetryPolicy{Attempts: 2, MinDelay: time.Millisecond}
+	if err := ft.Register(hs.URL + "/faults"); err != nil {
+		t.Fatal(err)
+	}
+	return ft, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), handled...)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		faults   Faults
+		handled  int
+		statuses []int // of the notification's delivery attempts
+		minTime  time.Duration
+	}{
+		{"none", Faults{}, 1, []int{200}, 0},
+		{"duplicate", Faults{Duplicate: 1}, 2, []int{200, 200}, 0},
+		{"malformed", Faults{Malformed: 1}, 0, []int{400}, 0},
+		{"missing headers", Faults{MissingHeaders: 1}, 0, []int{403}, 0},
+		{"bad signature", Faults{BadSignature: 1}, 0, []int{403}, 0},
+		{"delay", Faults{Delay: 50 * time.Millisecond}, 1, []int{200}, 50 * time.Millisecond},
+		{"delay jitter", Faults{Delay: 20 * time.Millisecond, DelayJitter: 20 * time.Millisecond}, 1, []int{200}, 20 * time.Millisecond},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ft, handled := faultEndpoint(t)
+			ft.Faults = c.faults
+			start := time.Now()
+			id, err := ft.Publish("", "hello", nil)
+			if d := time.Since(start); d < c.minTime {
+				t.Errorf("published in %s, want at least %s", d, c.minTime)
+			}
+			if (err == nil) != (c.handled > 0) {
+				t.Errorf("Publish gave %v", err)
+			}
+			if got := handled(); len(got) != c.handled {
+				t.Errorf("handled %v, want %d messages", got, c.handled)
+			}
+			var statuses []int
+			for _, d := range ft.Deliveries() {
+				if d.MessageId == id {
+					statuses = append(statuses, d.StatusCode)
+				}
+			}
+			if len(statuses) != len(c.statuses) {
+				t.Fatalf("delivery statuses %v, want %v", statuses, c.statuses)
+			}
+			for i := range statuses {
+				if statuses[i] != c.statuses[i] {
+					t.Errorf("delivery statuses %v, want %v", statuses, c.statuses)
+				}
+			}
+		})
+	}
+}
+
+func TestFaultsReorder(t *testing.T) {
+	ft, handled := faultEndpoint(t)
+	ft.Faults.Reorder = 1
+	if _, err := ft.Publish("", "first", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := handled(); len(got) != 0 {
+		t.Fatalf("held message was delivered: %v", got)
+	}
+	ft.Faults.Reorder = 0
+	if _, err := ft.Publish("", "second", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := handled(); len(got) != 2 || got[0] != "second" || got[1] != "first" {
+		t.Errorf("handled %v, want [second first]", got)
+	}
+
+	ft.Faults.Reorder = 1
+	ft.Publish("", "third", nil)
+	if err := ft.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := handled(); len(got) != 3 || got[2] != "third" {
+		t.Errorf("after Flush handled %v", got)
+	}
+}
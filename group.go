@@ -0,0 +1,229 @@
+package gosns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Middleware wraps a callback, such as to recover panics or time it,
+// returning the callback to call instead. Callbacks are also pinged with nil
+// messages (see Topic.Init), which middleware should pass on.
+type Middleware func(next func(*Message)) func(*Message)
+
+// HandlerGroup bundles topics which belong together, such as those of one
+// module of a large application, so that they share middleware, a pool of
+// workers and a lifecycle:
+//
+//	billing := s.NewHandlerGroup("billing")
+//	billing.Middleware = []gosns.Middleware{recoverPanics, timeCallbacks}
+//	billing.Workers = 8
+//	billing.OnStart = func(ctx context.Context) error { return db.PingContext(ctx) }
+//	billing.AddTopic(invoicesARN, "/billing/invoices", handleInvoice)
+//	billing.AddTopic(refundsARN, "/billing/refunds", handleRefund)
+//
+// A group's topics are paused (see Topic.Pause) until the group is started,
+// so that notifications arriving first are refused or held according to
+// their PauseMode, while confirmations and health probes are answered as
+// usual. Server.Start starts groups in the order they were created, those
+// with DependsOn once their dependencies are ready, and Drain stops them in
+// the reverse order once the callbacks of every topic have returned.
+type HandlerGroup struct {
+	Name string
+
+	// Middleware wraps the callback of each topic added to the group, the
+	// first outermost. AddTopic applies it, so set it first.
+	Middleware []Middleware
+
+	// Workers, if positive, runs the callbacks of the group's topics on one
+	// pool of that many goroutines, with QueueSize messages allowed to wait
+	// for them, as Topic.Workers and Topic.QueueSize do for a single topic.
+	// Topics with Workers of their own keep their own pool. A topic's
+	// RateLimit holds up the worker its message is waiting on.
+	Workers   int
+	QueueSize int
+
+	// OnStart, if set, is called by Start before the topics are resumed,
+	// such as to open what their callbacks depend on; if it fails, the group
+	// is not started. OnStop, if set, is called by Stop once the topics are
+	// paused and their callbacks have returned.
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+
+	// DependsOn lists what the group waits for before it starts, and what
+	// each of its topics waits for as if it were in their DependsOn.
+	DependsOn []Dependency
+
+	server *Server
+
+	mu      sync.Mutex
+	topics  []*Topic
+	started bool
+
+	poolOnce sync.Once
+	queue    *msgQueue
+}
+
+// NewHandlerGroup creates a group of topics. Groups are started by
+// Server.Start in the order they were created.
+func (s *Server) NewHandlerGroup(name string) *HandlerGroup {
+	g := &HandlerGroup{Name: name, server: s}
+	s.topicsMu.Lock()
+	s.groups = append(s.groups, g)
+	s.topicsMu.Unlock()
+	return g
+}
+
+// AddTopic adds a topic to the group as Server.AddTopic does, with its
+// callback wrapped in the group's Middleware. Unless the group has been
+// started, the topic is paused until it is.
+func (g *HandlerGroup) AddTopic(topicARN, endpoint string, callback func(*Message)) *Topic {
+	if callback != nil {
+		for i := len(g.Middleware) - 1; i >= 0; i-- {
+			callback = g.Middleware[i](callback)
+		}
+	}
+	t := g.server.newTopic(topicARN, endpoint, callback, nil)
+	t.group = g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t.paused = !g.started
+	g.topics = append(g.topics, t)
+	return g.server.register(t)
+}
+
+// Topics returns the topics of the group in the order they were added.
+func (g *HandlerGroup) Topics() []*Topic {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*Topic(nil), g.topics...)
+}
+
+// Started reports whether the group has been started and not stopped since.
+func (g *HandlerGroup) Started() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.started
+}
+
+// Start waits for the group's dependencies to be ready, or for ctx to be
+// done, calls OnStart and then resumes the group's topics. Starting a group
+// which is already started does nothing.
+func (g *HandlerGroup) Start(ctx context.Context) error {
+	if err := waitReady(ctx, func() bool { return allReady(g.DependsOn) }); err != nil {
+		return fmt.Errorf("gosns: starting group '%s': %w", g.Name, err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.started {
+		return nil
+	}
+	if g.OnStart != nil {
+		if err := g.OnStart(ctx); err != nil {
+			return fmt.Errorf("gosns: starting group '%s': %w", g.Name, err)
+		}
+	}
+	g.started = true
+	for _, t := range g.topics {
+		t.Resume()
+	}
+	g.server.logf(LogInfo, "Started group '%s' with %d topics\n", g.Name, len(g.topics))
+	return nil
+}
+
+// Stop pauses the group's topics, waits for the callbacks of the messages
+// they accepted to return, or for ctx to be done, and then calls OnStop.
+// Stopping a group which is not started does nothing; it may be started
+// again.
+func (g *HandlerGroup) Stop(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.started {
+		return nil
+	}
+	g.started = false
+	for _, t := range g.topics {
+		t.Pause()
+	}
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for _, t := range g.topics {
+		for atomic.LoadInt64(&t.inflight) > 0 {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	if g.OnStop != nil {
+		if err := g.OnStop(ctx); err != nil {
+			return fmt.Errorf("gosns: stopping group '%s': %w", g.Name, err)
+		}
+	}
+	g.server.logf(LogInfo, "Stopped group '%s'\n", g.Name)
+	return nil
+}
+
+// pool returns the queue of the group's shared workers, starting them.
+func (g *HandlerGroup) pool() *msgQueue {
+	g.poolOnce.Do(func() {
+		g.queue = newMsgQueue(g.QueueSize)
+		for i := 0; i < g.Workers; i++ {
+			go g.work()
+		}
+	})
+	return g.queue
+}
+
+// work runs queued callbacks of any of the group's topics one at a time.
+func (g *HandlerGroup) work() {
+	for {
+		msg := g.queue.pop()
+		t := msg.topic
+		if t.limiter != nil {
+			t.limiter.Wait()
+		}
+		t.invoke(msg, true)
+	}
+}
+
+// startGroups starts the server's groups in the order they were created.
+// Groups whose dependencies are not ready yet are started once they are.
+func (s *Server) startGroups() error {
+	s.topicsMu.RLock()
+	groups := append([]*HandlerGroup(nil), s.groups...)
+	s.topicsMu.RUnlock()
+	for _, g := range groups {
+		if !allReady(g.DependsOn) {
+			s.logf(LogInfo, "Group '%s' will start once its dependencies are ready\n", g.Name)
+			go func(g *HandlerGroup) {
+				if err := g.Start(s.context()); err != nil {
+					s.logf(LogError, "%v\n", err)
+				}
+			}(g)
+			continue
+		}
+		if err := g.Start(s.context()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopGroups stops the server's groups in the reverse order they were
+// created.
+func (s *Server) stopGroups(ctx context.Context) error {
+	s.topicsMu.RLock()
+	groups := append([]*HandlerGroup(nil), s.groups...)
+	s.topicsMu.RUnlock()
+	for i := len(groups) - 1; i >= 0; i-- {
+		if err := groups[i].Stop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package gosns_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestHandlerGroup(t *testing.T) {
+	var mu sync.Mutex
+	var calls, events []string
+	record := func(list *[]string, s string) {
+		mu.Lock()
+		*list = append(*list, s)
+		mu.Unlock()
+	}
+	tag := func(name string) gosns.Middleware {
+		return func(next func(*gosns.Message)) func(*gosns.Message) {
+			return func(msg *gosns.Message) {
+				if msg != nil {
+					record(&calls, name)
+				}
+				next(msg)
+			}
+		}
+	}
+	got := make(chan string, 4)
+	s := &gosns.Server{}
+	g := s.NewHandlerGroup("billing")
+	g.Middleware = []gosns.Middleware{tag("outer"), tag("inner")}
+	g.Workers, g.QueueSize = 1, 4
+	g.OnStart = func(context.Context) error { record(&events, "start"); return nil }
+	g.OnStop = func(context.Context) error { record(&events, "stop"); return nil }
+	orders := g.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	if !orders.Paused() || g.Started() || len(g.Topics()) != 1 {
+		t.Fatalf("paused %v, started %v, topics %v", orders.Paused(), g.Started(), g.Topics())
+	}
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "early")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(gosns.ReasonHeader) != gosns.ReasonPaused {
+		t.Errorf("topic of an unstarted group gave %v, %v", resp, err)
+	}
+
+	if err := s.Start(); err != nil || !g.Started() || orders.Paused() {
+		t.Fatalf("start gave %v", err)
+	}
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("started group gave %v, %v", resp, err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "hello" {
+			t.Errorf("callback got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback was not called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Drain(ctx, 0); err != nil || g.Started() || !orders.Paused() {
+		t.Fatalf("drain gave %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(calls, ",") != "outer,inner" || strings.Join(events, ",") != "start,stop" {
+		t.Errorf("calls %v, events %v", calls, events)
+	}
+}
+
+func TestHandlerGroupOrder(t *testing.T) {
+	var order []string
+	s := &gosns.Server{}
+	for _, name := range []string{"db", "billing", "reports"} {
+		name := name
+		g := s.NewHandlerGroup(name)
+		g.OnStart = func(context.Context) error {
+			order = append(order, "+"+name)
+			if name == "reports" {
+				return errors.New("warehouse is down")
+			}
+			return nil
+		}
+		g.OnStop = func(context.Context) error { order = append(order, "-"+name); return nil }
+		g.AddTopic(ordersARN, "/"+name, func(*gosns.Message) {})
+	}
+
+	err := s.Start()
+	if err == nil || !strings.Contains(err.Error(), "'reports'") {
+		t.Errorf("start gave %v", err)
+	}
+	if err := s.Drain(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(order, " "); got != "+db +billing +reports -billing -db" {
+		t.Errorf("order %s", got)
+	}
+}
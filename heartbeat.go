@@ -0,0 +1,139 @@
+package gosns
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AddHeartbeatTopic adds a topic expecting a notification at least every
+// interval, such as from a scheduled job publishing to it, whose messages
+// are acknowledged and otherwise ignored. A lapse is logged as a warning,
+// passed to the returned Topic's HeartbeatLapsed hook if it is set, and
+// reported by Heartbeat and the admin API's /heartbeats. The interval is
+// timed from Start, so the Server should be started even when it is
+// mounted as a handler elsewhere.
+func (s *Server) AddHeartbeatTopic(topicARN, endpoint string, interval time.Duration) *Topic {
+	t := s.AddTopic(topicARN, endpoint, func(*Message) {})
+	t.HeartbeatInterval = interval
+	return t
+}
+
+// HeartbeatStatus is the state of a topic with a HeartbeatInterval.
+type HeartbeatStatus struct {
+	Interval time.Duration
+	Last     time.Time     // when the last notification arrived, if one has
+	Since    time.Duration // since Last, or since the topic started if none has
+	Lapsed   bool          // no notification has arrived within Interval
+}
+
+// heartbeat times the notifications to a topic.
+type heartbeat struct {
+	topic *Topic
+
+	mu      sync.Mutex
+	started time.Time
+	last    time.Time
+	lapsed  bool
+	timer   *time.Timer
+}
+
+func newHeartbeat(t *Topic) *heartbeat {
+	h := &heartbeat{topic: t, started: time.Now()}
+	h.timer = time.AfterFunc(t.HeartbeatInterval, h.lapse)
+	return h
+}
+
+func (h *heartbeat) lapse() {
+	h.mu.Lock()
+	h.lapsed = true
+	last := h.last
+	h.mu.Unlock()
+
+	t := h.topic
+	if last.IsZero() {
+		t.server.logf(LogWarn, "No heartbeat for topic '%s' since starting %s ago\n", t.TopicARN, time.Since(h.started).Round(time.Second))
+	} else {
+		t.server.logf(LogWarn, "No heartbeat for topic '%s' since %s\n", t.TopicARN, last.Format(time.RFC3339))
+	}
+	if t.HeartbeatLapsed != nil {
+		t.HeartbeatLapsed(t, last)
+	}
+}
+
+// beat records a notification to the topic, restarting its heartbeat timer.
+func (t *Topic) beat() {
+	t.startOnce.Do(t.start)
+	h := t.heartbeat
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.last = time.Now()
+	resumed := h.lapsed
+	h.lapsed = false
+	h.timer.Reset(t.HeartbeatInterval)
+	h.mu.Unlock()
+	if resumed {
+		t.server.logf(LogInfo, "Heartbeat for topic '%s' resumed\n", t.TopicARN)
+	}
+}
+
+// Heartbeat returns the topic's heartbeat status. It is zero unless
+// HeartbeatInterval is set.
+func (t *Topic) Heartbeat() HeartbeatStatus {
+	t.startOnce.Do(t.start)
+	h := t.heartbeat
+	if h == nil {
+		return HeartbeatStatus{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since := h.started
+	if !h.last.IsZero() {
+		since = h.last
+	}
+	return HeartbeatStatus{
+		Interval: t.HeartbeatInterval,
+		Last:     h.last,
+		Since:    time.Since(since),
+		Lapsed:   h.lapsed,
+	}
+}
+
+// heartbeatTopics returns the topics with a HeartbeatInterval, by endpoint.
+func (s *Server) heartbeatTopics() map[string]*Topic {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	topics := make(map[string]*Topic)
+	for endpoint, td := range s.topics {
+		if td.HeartbeatInterval > 0 {
+			topics[endpoint] = td
+		}
+	}
+	return topics
+}
+
+// startHeartbeats starts timing the heartbeat topics.
+func (s *Server) startHeartbeats() {
+	for _, td := range s.heartbeatTopics() {
+		td.startOnce.Do(td.start)
+	}
+}
+
+func (s *Server) adminHeartbeats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := make(map[string]HeartbeatStatus)
+	code := http.StatusOK
+	for endpoint, td := range s.heartbeatTopics() {
+		res[endpoint] = td.Heartbeat()
+		if res[endpoint].Lapsed {
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, res)
+}
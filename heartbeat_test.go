@@ -0,0 +1,53 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestHeartbeatTopic(t *testing.T) {
+	s := &gosns.Server{}
+	topic := s.AddHeartbeatTopic(ordersARN, "/heartbeat", 100*time.Millisecond)
+	lapsed := make(chan time.Time, 2)
+	topic.HeartbeatLapsed = func(_ *gosns.Topic, last time.Time) { lapsed <- last }
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	admin := func() int {
+		rec := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/heartbeats", nil))
+		return rec.Code
+	}
+
+	select {
+	case last := <-lapsed:
+		if !last.IsZero() {
+			t.Errorf("lapse before any heartbeat gave last %v", last)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lapse was not reported")
+	}
+	if st := topic.Heartbeat(); !st.Lapsed || st.Since < st.Interval || admin() != http.StatusServiceUnavailable {
+		t.Errorf("status after lapse %+v", st)
+	}
+
+	ts.Notify("/heartbeat", ordersARN, "", "beat")
+	if st := topic.Heartbeat(); st.Lapsed || st.Last.IsZero() || st.Since > st.Interval || admin() != http.StatusOK {
+		t.Errorf("status after heartbeat %+v", st)
+	}
+	select {
+	case last := <-lapsed:
+		if last.IsZero() {
+			t.Error("second lapse had no last heartbeat")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lapse was not reported")
+	}
+}
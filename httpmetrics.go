@@ -0,0 +1,185 @@
+package gosns
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the buckets
+// of HTTPStats.Buckets: those OpenTelemetry recommends for the
+// http.server.request.duration histogram.
+var DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// HTTPRequest describes a request the server answered, for
+// Server.OnHTTPRequest.
+type HTTPRequest struct {
+	// Method is the request method, or "_OTHER" for nonstandard ones, as
+	// OpenTelemetry's http.request.method attribute.
+	Method string
+
+	// Route is the endpoint of the topic the request was routed to (its
+	// pattern, for topics with path parameters, and "*" for the catch-all),
+	// the ProbePath or PingPath, or "" for requests to none of them, so that
+	// unmatched paths cannot add labels without limit.
+	Route string
+
+	Status   int
+	Duration time.Duration
+}
+
+// HTTPStats are the rate, errors and duration (RED) of the requests with
+// one route, method and status, since the server started. They count
+// requests as the listener saw them, whether or not they carried a message.
+type HTTPStats struct {
+	Route  string
+	Method string
+	Status int
+
+	Count       uint64
+	DurationSum float64 // seconds
+
+	// Buckets counts the requests taking at most each of the
+	// DefaultDurationBuckets, and not the one before, with a final bucket
+	// for longer durations.
+	Buckets []uint64
+}
+
+type httpKey struct {
+	route, method string
+	status        int
+}
+
+// httpMetrics accumulates a server's HTTPStats.
+type httpMetrics struct {
+	mu    sync.Mutex
+	stats map[httpKey]*HTTPStats
+}
+
+func (m *httpMetrics) observe(req *HTTPRequest) {
+	secs := req.Duration.Seconds()
+	bucket := sort.SearchFloat64s(DefaultDurationBuckets, secs)
+	k := httpKey{req.Route, req.Method, req.Status}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stats == nil {
+		m.stats = make(map[httpKey]*HTTPStats)
+	}
+	st := m.stats[k]
+	if st == nil {
+		st = &HTTPStats{Route: k.route, Method: k.method, Status: k.status, Buckets: make([]uint64, len(DefaultDurationBuckets)+1)}
+		m.stats[k] = st
+	}
+	st.Count++
+	st.DurationSum += secs
+	st.Buckets[bucket]++
+}
+
+// HTTPStats returns the RED metrics of the requests the server has answered
+// with HTTPMetrics set, ordered by route, method and status.
+func (s *Server) HTTPStats() []HTTPStats {
+	m := &s.httpStats
+	m.mu.Lock()
+	res := make([]HTTPStats, 0, len(m.stats))
+	for _, st := range m.stats {
+		c := *st
+		c.Buckets = append([]uint64(nil), st.Buckets...)
+		res = append(res, c)
+	}
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+	return res
+}
+
+// HTTPErrorRate returns the fraction of the requests in stats answered with
+// a 5xx status.
+func HTTPErrorRate(stats []HTTPStats) float64 {
+	var total, errors uint64
+	for _, st := range stats {
+		total += st.Count
+		if st.Status >= 500 {
+			errors += st.Count
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(errors) / float64(total)
+}
+
+// metricMethod returns method as a label of bounded cardinality.
+func metricMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "_OTHER"
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// measure records a request answered through sw, which began at start and
+// was routed to td, which may be nil.
+func (s *Server) measure(r *http.Request, sw *statusWriter, td *Topic, start time.Time) {
+	req := &HTTPRequest{Method: metricMethod(r.Method), Status: sw.status, Duration: time.Since(start)}
+	if req.Status == 0 {
+		req.Status = http.StatusOK
+	}
+	switch {
+	case s.ProbePath != "" && r.URL.Path == s.ProbePath:
+		req.Route = s.ProbePath
+	case s.PingPath != "" && r.URL.Path == s.PingPath:
+		req.Route = s.PingPath
+	case td != nil:
+		req.Route = td.endpoint
+	}
+	if s.HTTPMetrics {
+		s.httpStats.observe(req)
+	}
+	if s.OnHTTPRequest != nil {
+		s.OnHTTPRequest(req)
+	}
+}
+
+// adminHTTP answers GET /http with the server's HTTPStats.
+func (s *Server) adminHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	stats := s.HTTPStats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"Buckets":   DefaultDurationBuckets,
+		"ErrorRate": HTTPErrorRate(stats),
+		"Requests":  stats,
+	})
+}
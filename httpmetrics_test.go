@@ -0,0 +1,97 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	const arn = "arn:aws:sns:us-east-1:123456789012:orders"
+	var seen []gosns.HTTPRequest
+	s := &gosns.Server{HTTPMetrics: true, ProbePath: "/healthz"}
+	s.OnHTTPRequest = func(req *gosns.HTTPRequest) { seen = append(seen, *req) }
+	s.AddTopic(arn, "/orders/{region}", func(msg *gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	if _, _, err := ts.Notify("/orders/east", arn, "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/healthz", "/nowhere", "/elsewhere"} {
+		resp, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	req, _ := http.NewRequest("BREW", ts.URL+"/healthz", nil)
+	if resp, err := ts.Client().Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	if len(seen) != 5 || seen[0].Route != "/orders/{region}" || seen[0].Method != "POST" || seen[0].Status != http.StatusOK {
+		t.Fatalf("saw %+v", seen)
+	}
+	stats := s.HTTPStats()
+	want := []struct {
+		route, method string
+		status        int
+		count         uint64
+	}{
+		{"", "GET", http.StatusNotFound, 2},
+		{"/healthz", "GET", http.StatusOK, 1},
+		{"/healthz", "_OTHER", http.StatusMethodNotAllowed, 1},
+		{"/orders/{region}", "POST", http.StatusOK, 1},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %+v", stats)
+	}
+	for i, w := range want {
+		st := stats[i]
+		if st.Route != w.route || st.Method != w.method || st.Status != w.status || st.Count != w.count {
+			t.Errorf("%d: got %+v, want %+v", i, st, w)
+		}
+		var n uint64
+		for _, b := range st.Buckets {
+			n += b
+		}
+		if len(st.Buckets) != len(gosns.DefaultDurationBuckets)+1 || n != st.Count {
+			t.Errorf("%d: buckets %v", i, st.Buckets)
+		}
+	}
+	if rate := gosns.HTTPErrorRate(stats); rate != 0 {
+		t.Errorf("error rate %v", rate)
+	}
+	if rate := gosns.HTTPErrorRate([]gosns.HTTPStats{{Status: 200, Count: 3}, {Status: 503, Count: 1}}); rate != 0.25 {
+		t.Errorf("error rate %v", rate)
+	}
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/http", nil))
+	var res struct {
+		ErrorRate float64
+		Requests  []gosns.HTTPStats
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK || len(res.Requests) != len(want) {
+		t.Errorf("admin gave %d %+v, %v", rec.Code, res, err)
+	}
+}
+
+func TestHTTPMetricsOff(t *testing.T) {
+	s := &gosns.Server{}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + "/nowhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if stats := s.HTTPStats(); len(stats) != 0 {
+		t.Errorf("counted %+v", stats)
+	}
+}
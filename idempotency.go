@@ -0,0 +1,97 @@
+package gosns
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long a topic's idempotency keys are
+// remembered if its IdempotencyWindow is zero.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+func idempotencyKey(endpoint, key string) string {
+	return "idempotency/" + url.PathEscape(endpoint) + "/" + url.PathEscape(key)
+}
+
+// idempotencyStore returns the DedupStore holding idempotency keys.
+func (s *Server) idempotencyStore() DedupStore {
+	if s.Dedup != nil {
+		return s.Dedup
+	}
+	return &s.idempotent
+}
+
+// claimKey records the idempotency key of msg, returning it, and reports
+// whether it was already recorded within the topic's IdempotencyWindow.
+// Messages without a key are never duplicates.
+func (t *Topic) claimKey(r *http.Request, msg *Message) (key string, dup bool) {
+	if t.IdempotencyKey == nil {
+		return "", false
+	}
+	if key = t.IdempotencyKey(msg); key == "" {
+		return "", false
+	}
+	window := t.IdempotencyWindow
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	dup, err := t.server.idempotencyStore().Remember(idempotencyKey(t.endpoint, key), window)
+	if err != nil {
+		// handling a message twice is better than not at all
+		t.logf(r, LogError, "error checking idempotency key of message %s: %v\n", msg.MessageId, err)
+		return "", false
+	}
+	if dup {
+		t.logf(r, LogInfo, "    Idempotency key %q already handled, skipping message %s\n", key, msg.MessageId)
+	}
+	return key, dup
+}
+
+// releaseKey forgets an idempotency key recorded by claimKey, so that a
+// message which could not be handled is handled when it is retried. Keys can
+// only be released from a DedupStore which is also a Store.
+func (t *Topic) releaseKey(key string) {
+	if key == "" {
+		return
+	}
+	store, ok := t.server.idempotencyStore().(Store)
+	if !ok {
+		t.logf(nil, LogWarn, "cannot release idempotency key %q: Dedup is not a Store\n", key)
+		return
+	}
+	if err := store.Delete(idempotencyKey(t.endpoint, key)); err != nil && err != ErrNotFound {
+		t.logf(nil, LogError, "error releasing idempotency key %q: %v\n", key, err)
+	}
+}
+
+// IdempotencyKeyFields returns a Topic.IdempotencyKey function which reads
+// the key from top-level fields of a JSON message body, such as "order_id"
+// and "version". Messages missing any of the fields, or whose body is not a
+// JSON object, have no key.
+func IdempotencyKeyFields(names ...string) func(*Message) string {
+	return func(msg *Message) string {
+		var body map[string]json.RawMessage
+		if json.Unmarshal([]byte(msg.Message), &body) != nil {
+			return ""
+		}
+		values := make([]string, len(names))
+		for i, name := range names {
+			v, ok := body[name]
+			if !ok || string(v) == "null" {
+				return ""
+			}
+			var buf bytes.Buffer
+			if json.Compact(&buf, v) != nil {
+				return ""
+			}
+			values[i] = buf.String()
+		}
+		// joined like the elements of a JSON array, values cannot run
+		// together
+		return strings.Join(values, ",")
+	}
+}
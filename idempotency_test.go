@@ -0,0 +1,107 @@
+package gosns_test
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestIdempotencyKeyFields(t *testing.T) {
+	key := gosns.IdempotencyKeyFields("order_id", "version")
+	for body, want := range map[string]string{
+		`{"order_id": "o-1", "version": 2}`:       `"o-1",2`,
+		`{"version":2,"order_id":"o-1","x":true}`: `"o-1",2`,
+		`{"order_id": {"a": 1}, "version": 2}`:    `{"a":1},2`,
+		`{"order_id": "o-1"}`:                     "",
+		`{"order_id": "o-1", "version": null}`:    "",
+		`not json`:                                "",
+	} {
+		if got := key(&gosns.Message{Message: body}); got != want {
+			t.Errorf("%s: got %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	failing := true
+	s := &gosns.Server{}
+	tp := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		if msg.Message == `{"order_id":"o-2","version":1}` && failing {
+			failing = false
+			msg.Fail(errors.New("database down"))
+			return
+		}
+		handled = append(handled, msg.MessageId)
+	})
+	tp.Delivery = gosns.AtLeastOnce
+	tp.IdempotencyKey = gosns.IdempotencyKeyFields("order_id", "version")
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	steps := []struct {
+		body   string
+		status int
+		handle bool
+	}{
+		{`{"order_id":"o-1","version":1}`, http.StatusOK, true},
+		// published again, as a different message
+		{`{"version":1,"order_id":"o-1"}`, http.StatusOK, false},
+		{`{"order_id":"o-1","version":2}`, http.StatusOK, true},
+		// a failure releases the key for the retry
+		{`{"order_id":"o-2","version":1}`, http.StatusInternalServerError, false},
+		{`{"order_id":"o-2","version":1}`, http.StatusOK, true},
+		{`{"order_id":"o-2","version":1}`, http.StatusOK, false},
+		{`{"no_key":true}`, http.StatusOK, true},
+		{`{"no_key":true}`, http.StatusOK, true},
+	}
+	for i, step := range steps {
+		mu.Lock()
+		before := len(handled)
+		mu.Unlock()
+		resp, _, err := ts.Notify("/orders", ordersARN, "", step.body)
+		if err != nil || resp.StatusCode != step.status {
+			t.Fatalf("step %d gave %v, %v", i, resp, err)
+		}
+		mu.Lock()
+		if got := len(handled) > before; got != step.handle {
+			t.Errorf("step %d: handled %v", i, got)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestIdempotencySharedDedup(t *testing.T) {
+	store := &gosns.MemoryStore{}
+	var mu sync.Mutex
+	calls := 0
+	var servers []*gosnstest.Server
+	for i := 0; i < 2; i++ {
+		s := &gosns.Server{Dedup: store}
+		tp := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		})
+		tp.Delivery = gosns.AtLeastOnce
+		tp.IdempotencyKey = gosns.IdempotencyKeyFields("order_id")
+		ts := gosnstest.NewServer(s)
+		defer ts.Close()
+		servers = append(servers, ts)
+	}
+	for _, ts := range servers {
+		if resp, _, err := ts.Notify("/orders", ordersARN, "", `{"order_id":"o-1"}`); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("gave %v, %v", resp, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handled %d times across replicas", calls)
+	}
+}
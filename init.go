@@ -0,0 +1,118 @@
+package gosns
+
+import (
+	"context"
+	"time"
+)
+
+// maxInitBackoff limits the wait between attempts at a failing Init.
+const maxInitBackoff = time.Minute
+
+// context returns a context cancelled when the server drains.
+func (s *Server) context() context.Context {
+	s.ctxOnce.Do(func() {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	})
+	return s.ctx
+}
+
+// initialize runs the topic's Init, or pings its callback with a nil
+// message if it has none.
+func (t *Topic) initialize() {
+	if t.Init == nil {
+		go t.Callback(nil)
+		return
+	}
+	t.initMu.Lock()
+	defer t.initMu.Unlock()
+	if !t.initRunning {
+		t.initRunning = true
+		go t.runInit()
+	}
+}
+
+// runInit calls Init, once the topic's dependencies are ready, until it
+// succeeds or the server drains.
+func (t *Topic) runInit() {
+	s := t.server
+	ctx := s.context()
+	if err := waitReady(ctx, t.dependenciesReady); err != nil {
+		t.initMu.Lock()
+		t.initRunning = false
+		t.initMu.Unlock()
+		return
+	}
+	backoff := time.Second
+	for {
+		err := t.Init(ctx)
+		t.initMu.Lock()
+		t.initErr, t.initDone = err, err == nil
+		if err == nil || ctx.Err() != nil {
+			t.initRunning = false
+		}
+		t.initMu.Unlock()
+		if err == nil {
+			s.logf(LogInfo, "Initialized topic '%s' at endpoint '%s'\n", t.TopicARN, t.endpoint)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		s.logf(LogError, "error initializing topic '%s', retrying in %s: %v\n", t.TopicARN, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			t.initMu.Lock()
+			t.initRunning = false
+			t.initMu.Unlock()
+			return
+		}
+		if backoff *= 2; backoff > maxInitBackoff {
+			backoff = maxInitBackoff
+		}
+	}
+}
+
+// InitError returns the error from the topic's last Init, or nil if it
+// succeeded or has not run.
+func (t *Topic) InitError() error {
+	t.initMu.Lock()
+	defer t.initMu.Unlock()
+	return t.initErr
+}
+
+// initConfirmed runs Init for the topics which already have subscriptions.
+func (s *Server) initConfirmed() {
+	s.topicsMu.RLock()
+	var topics []*Topic
+	for _, td := range s.topics {
+		topics = append(topics, td)
+	}
+	s.topicsMu.RUnlock()
+	for _, td := range topics {
+		td.subMu.Lock()
+		confirmed := len(td.subscriptions) > 0
+		td.subMu.Unlock()
+		if td.Init != nil && confirmed {
+			td.initialize()
+		}
+	}
+}
+
+// initialized reports whether no topic's Init is running or has failed.
+func (s *Server) initialized() bool {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	for _, td := range s.topics {
+		if td.Init == nil {
+			continue
+		}
+		td.initMu.Lock()
+		ready := !td.initRunning && td.initErr == nil
+		td.initMu.Unlock()
+		if !ready {
+			return false
+		}
+	}
+	return true
+}
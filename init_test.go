@@ -0,0 +1,85 @@
+package gosns_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestTopicInit(t *testing.T) {
+	var calls int32
+	nilMessages := make(chan struct{}, 1)
+	s := &gosns.Server{ProbePath: "/healthz", Store: &gosns.MemoryStore{}}
+	topic := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg == nil {
+			nilMessages <- struct{}{}
+		}
+	})
+	topic.Init = func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("database unreachable")
+		}
+		return nil
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	probe := func() int {
+		resp, err := ts.Client().Get(ts.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("probe before confirmation gave %d", code)
+	}
+	if resp, _, err := ts.Confirm("/orders", ordersARN); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation gave %v, %v", resp, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); topic.InitError() == nil; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Init was not called")
+		}
+	}
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("probe after a failed Init gave %d", code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); probe() != http.StatusOK; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Init was not retried")
+		}
+	}
+	if topic.InitError() != nil || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("after retry: %v, %d calls", topic.InitError(), atomic.LoadInt32(&calls))
+	}
+	select {
+	case <-nilMessages:
+		t.Error("Callback got a nil message despite Init")
+	default:
+	}
+
+	// a restarted server initializes the topic it has a subscription for
+	restarted := &gosns.Server{Store: s.Store}
+	again := restarted.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	initialized := make(chan struct{})
+	again.Init = func(ctx context.Context) error {
+		close(initialized)
+		return nil
+	}
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-initialized:
+	case <-time.After(5 * time.Second):
+		t.Error("Init was not called on Start")
+	}
+}
@@ -0,0 +1,159 @@
+// Package batcher collects records written concurrently into batches sent
+// by one request, retrying those which fail, with each writer waiting for
+// the outcome of its own record. It serves the sinks which put messages in
+// services with bulk APIs.
+package batcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Write once the Batcher is closed.
+var ErrClosed = errors.New("closed")
+
+// Batcher batches records for Send. Its fields must not be changed once it
+// has been used.
+type Batcher struct {
+	// MaxRecords and MaxBytes bound a batch, and MaxWait how long its first
+	// record waits for others.
+	MaxRecords int
+	MaxBytes   int
+	MaxWait    time.Duration
+
+	// Send sends a batch, returning an error for the whole request, or the
+	// error of each record which failed, if any, indexed as batch.
+	Send func(batch [][]byte) ([]error, error)
+
+	// Retryable reports whether a record failing with an error may succeed
+	// if sent again. Records are sent at most MaxAttempts times, waiting
+	// Backoff before the second and doubling for each after.
+	Retryable   func(error) bool
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// Logf, if set, is called when a batch is retried.
+	Logf func(format string, args ...interface{})
+
+	mu      sync.Mutex
+	batch   []*record
+	size    int
+	timer   *time.Timer
+	sending sync.WaitGroup
+	closed  bool
+}
+
+// record is a written record waiting to be sent.
+type record struct {
+	data []byte
+	done chan error
+}
+
+// Write adds data to the pending batch, returning once it has been sent, or
+// failed for good, or ctx is done.
+func (b *Batcher) Write(ctx context.Context, data []byte) error {
+	rec := &record{data: data, done: make(chan error, 1)}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	if b.MaxBytes > 0 && b.size+len(data) > b.MaxBytes {
+		b.send()
+	}
+	b.batch = append(b.batch, rec)
+	b.size += len(data)
+	if len(b.batch) >= b.MaxRecords {
+		b.send()
+	} else if len(b.batch) == 1 {
+		b.timer = time.AfterFunc(b.MaxWait, b.expire)
+	}
+	b.mu.Unlock()
+
+	select {
+	case err := <-rec.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expire sends a batch which has waited MaxWait.
+func (b *Batcher) expire() {
+	b.mu.Lock()
+	b.send()
+	b.mu.Unlock()
+}
+
+// send sends the pending batch on a new goroutine. b.mu is held.
+func (b *Batcher) send() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.batch) == 0 {
+		return
+	}
+	batch := b.batch
+	b.batch, b.size = nil, 0
+	b.sending.Add(1)
+	go func() {
+		defer b.sending.Done()
+		b.put(batch)
+	}()
+}
+
+// Close sends any pending batch and waits for those being sent. Later
+// Writes fail with ErrClosed.
+func (b *Batcher) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.send()
+	b.mu.Unlock()
+	b.sending.Wait()
+}
+
+// put sends batch, retrying failed records, and reports each record's
+// outcome.
+func (b *Batcher) put(batch []*record) {
+	backoff := b.Backoff
+	for attempt := 1; ; attempt++ {
+		data := make([][]byte, len(batch))
+		for i, rec := range batch {
+			data[i] = rec.data
+		}
+		failed, err := b.Send(data)
+		if err != nil && (!b.Retryable(err) || attempt >= b.MaxAttempts) {
+			for _, rec := range batch {
+				rec.done <- err
+			}
+			return
+		}
+		if err == nil {
+			var retry []*record
+			for i, rec := range batch {
+				switch {
+				case i >= len(failed) || failed[i] == nil:
+					rec.done <- nil
+				case attempt >= b.MaxAttempts || !b.Retryable(failed[i]):
+					rec.done <- failed[i]
+				default:
+					retry = append(retry, rec)
+				}
+			}
+			if len(retry) == 0 {
+				return
+			}
+			batch = retry
+			err = fmt.Errorf("%d records failed", len(retry))
+		}
+		if b.Logf != nil {
+			b.Logf("retrying %d records after attempt %d: %v", len(batch), attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
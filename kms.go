@@ -0,0 +1,86 @@
+package gosns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// KMSClient issues signed requests to the AWS KMS API, such as to decrypt
+// the data key of an EncryptedStore encrypted under a KMS key, so that the
+// plaintext key never needs to be configured.
+type KMSClient struct {
+	Region string
+
+	// Credentials sign each request. If nil, LoadCredentials is called on
+	// first use.
+	Credentials *Credentials
+
+	// Endpoint overrides the regional KMS endpoint URL.
+	Endpoint string
+
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// KMSError is an error response from the KMS API.
+type KMSError struct {
+	Action     string
+	StatusCode int
+	Type       string // such as "AccessDeniedException"
+	Message    string
+}
+
+func (e *KMSError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("kms %s: unexpected status %d", e.Action, e.StatusCode)
+	}
+	return fmt.Sprintf("kms %s: %s: %s", e.Action, e.Type, e.Message)
+}
+
+func (c *KMSClient) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return RegionalEndpoint("kms", c.Region)
+}
+
+// Call performs action with the JSON request in, decoding the response into
+// out, which may be nil.
+func (c *KMSClient) Call(ctx context.Context, action string, in, out interface{}) error {
+	if c.Region == "" {
+		return errors.New("gosns: KMSClient has no Region")
+	}
+	if c.Credentials == nil {
+		creds, err := LoadCredentials()
+		if err != nil {
+			return err
+		}
+		c.Credentials = creds
+	}
+	status, data, err := awsJSON(ctx, c.Client, c.Credentials, c.Region, "kms", c.endpoint(), "TrentService."+action, in)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		kerr := &KMSError{Action: action, StatusCode: status}
+		kerr.Type, kerr.Message = jsonError(data)
+		return kerr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Decrypt returns the plaintext of a ciphertext blob produced by KMS, such as
+// the CiphertextBlob of a GenerateDataKey response.
+func (c *KMSClient) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var out struct{ Plaintext []byte }
+	if err := c.Call(ctx, "Decrypt", map[string]interface{}{"CiphertextBlob": ciphertext}, &out); err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
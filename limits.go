@@ -0,0 +1,94 @@
+package gosns
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// LimitStats counts a topic's requests refused for exceeding its
+// MaxBodySize or MaxHeaderBytes.
+type LimitStats struct {
+	Bodies  uint64
+	Headers uint64
+}
+
+// Oversized returns the number of requests to the topic refused for their
+// size since the server started.
+func (t *Topic) Oversized() LimitStats {
+	return LimitStats{
+		Bodies:  atomic.LoadUint64(&t.oversized.Bodies),
+		Headers: atomic.LoadUint64(&t.oversized.Headers),
+	}
+}
+
+// maxBodySize returns the body size limit for requests to td, which may be
+// nil.
+func (s *Server) maxBodySize(td *Topic) int64 {
+	if td != nil && td.MaxBodySize > 0 {
+		return td.MaxBodySize
+	}
+	if s.MaxBodySize > 0 {
+		return s.MaxBodySize
+	}
+	return DefaultMaxBodySize
+}
+
+// headerSize approximates the size of h as sent on the wire.
+func headerSize(h http.Header) int {
+	n := 0
+	for name, values := range h {
+		for _, v := range values {
+			n += len(name) + len(v) + 4 // ": " and CRLF
+		}
+	}
+	return n
+}
+
+// checkLimits refuses r if its headers or body are too large for td, which
+// may be nil, and otherwise limits the body that may be read from it.
+func (s *Server) checkLimits(w http.ResponseWriter, r *http.Request, td *Topic) bool {
+	if td != nil && td.MaxHeaderBytes > 0 && headerSize(r.Header) > td.MaxHeaderBytes {
+		atomic.AddUint64(&td.oversized.Headers, 1)
+		td.oversize(r, nil)
+		s.reject(w, r, ResponseHeadersTooLarge, ReasonHeadersTooLarge)
+		return false
+	}
+	maxBody := s.maxBodySize(td)
+	if r.ContentLength > maxBody {
+		var body []byte
+		if td != nil && td.Oversize != nil {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxBody))
+		}
+		s.rejectTooLarge(w, r, td, body)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+	if td != nil && td.Oversize != nil {
+		// read the body now, so that what there is of it can be passed on
+		body, err := bufferBody(r)
+		if bodyError(err) == ErrBodyTooLarge {
+			s.rejectTooLarge(w, r, td, body)
+			return false
+		}
+	}
+	return true
+}
+
+// rejectTooLarge refuses r, whose body is over td's limit, passing what was
+// read of it to td.Oversize.
+func (s *Server) rejectTooLarge(w http.ResponseWriter, r *http.Request, td *Topic, body []byte) int {
+	if td != nil {
+		atomic.AddUint64(&td.oversized.Bodies, 1)
+		td.oversize(r, body)
+	}
+	return s.reject(w, r, ResponseTooLarge, ReasonBodyTooLarge)
+}
+
+// oversize passes a refused request to the topic's Oversize hook.
+func (t *Topic) oversize(r *http.Request, body []byte) {
+	if t.Oversize == nil {
+		return
+	}
+	t.Oversize(newCapturedRequest(r, body))
+}
@@ -0,0 +1,72 @@
+package gosns_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestTopicLimits(t *testing.T) {
+	const smallARN = "arn:aws:sns:us-east-1:123456789012:small"
+	s := &gosns.Server{MaxBodySize: 2048}
+	var mu sync.Mutex
+	var dead []*gosns.CapturedRequest
+	small := s.AddTopic(smallARN, "/small", func(*gosns.Message) {})
+	small.MaxBodySize = 512
+	small.MaxHeaderBytes = 1024
+	small.Oversize = func(req *gosns.CapturedRequest) {
+		mu.Lock()
+		dead = append(dead, req)
+		mu.Unlock()
+	}
+	big := s.AddTopic(ordersARN, "/big", func(*gosns.Message) {})
+	big.MaxBodySize = 8192
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	body := strings.Repeat("x", 4096)
+	if resp, _, err := ts.Notify("/big", ordersARN, "", body); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("large message for a large topic gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Notify("/small", smallARN, "", body); err != nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("large message for a small topic gave %v, %v", resp, err)
+	}
+
+	// without a Content-Length, the limit is found while reading
+	env := gosnstest.NewNotification(smallARN, "", body)
+	if err := ts.Signer.Sign(env); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := gosnstest.NewClientRequest(ts.URL+"/small", env)
+	data, _ := io.ReadAll(req.Body)
+	req = httptest.NewRequest("POST", "/small", io.NopCloser(strings.NewReader(string(data))))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked large message gave %d", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "/small", strings.NewReader("{}"))
+	req.Header.Set("X-Padding", strings.Repeat("p", 1024))
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge || w.Header().Get(gosns.ReasonHeader) != gosns.ReasonHeadersTooLarge {
+		t.Errorf("large headers gave %d", w.Code)
+	}
+
+	if got := small.Oversized(); got.Bodies != 2 || got.Headers != 1 {
+		t.Errorf("Oversized() = %+v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dead) != 3 || len(dead[0].Body) != 512 || len(dead[1].Body) != 512 || dead[2].Header.Get("X-Padding") == "" {
+		t.Errorf("dead-lettered %d requests", len(dead))
+	}
+}
@@ -0,0 +1,96 @@
+package gosns
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LogLevel is the severity of a log line. Lines below the level set on a
+// Server or Topic are not written.
+type LogLevel int
+
+const (
+	// LogDefault is the zero LogLevel. On a Topic it means the Server's level
+	// applies, and on a Server it means LogDebug.
+	LogDefault LogLevel = iota
+	LogDebug
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = [...]string{"default", "debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the LogLevel named s: debug, info, warn or error.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames[LogDebug:] {
+		if strings.EqualFold(s, name) {
+			return LogDebug + LogLevel(i), nil
+		}
+	}
+	if strings.EqualFold(s, "warning") {
+		return LogWarn, nil
+	}
+	return LogDefault, fmt.Errorf("gosns: unknown log level '%s'", s)
+}
+
+// LogEntry is a log line passed to a Server's LogFunc.
+type LogEntry struct {
+	Level     LogLevel
+	Topic     *Topic // nil for lines which are not about a topic
+	RequestID string
+	Message   string // formatted, without a trailing newline
+}
+
+// output writes a log line at level about t, which may be nil, to t's Logger,
+// the Server's LogFunc or the Server's Logger. Lines for a request are
+// prefixed with its request ID.
+func (s *Server) output(t *Topic, r *http.Request, level LogLevel, format string, args ...interface{}) {
+	logger, min := s.Logger, s.LogLevel
+	if t != nil && t.LogLevel != LogDefault {
+		min = t.LogLevel
+	}
+	if level < min {
+		return
+	}
+	id := ""
+	if r != nil {
+		id = RequestID(r.Context())
+	}
+	if t != nil && t.Logger != nil {
+		logger = t.Logger
+	} else if s.LogFunc != nil {
+		msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+		s.LogFunc(LogEntry{Level: level, Topic: t, RequestID: id, Message: msg})
+		return
+	}
+	if logger == nil {
+		return
+	}
+	if id != "" {
+		format = "[" + id + "] " + format
+	}
+	logger.Printf(format, args...)
+}
+
+func (s *Server) logf(level LogLevel, format string, args ...interface{}) {
+	s.output(nil, nil, level, format, args...)
+}
+
+// reqLogf logs a line about r, prefixed with its request ID.
+func (s *Server) reqLogf(r *http.Request, level LogLevel, format string, args ...interface{}) {
+	s.output(nil, r, level, format, args...)
+}
+
+// logf logs a line about the topic, and r if it is not nil.
+func (t *Topic) logf(r *http.Request, level LogLevel, format string, args ...interface{}) {
+	t.server.output(t, r, level, format, args...)
+}
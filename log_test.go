@@ -0,0 +1,135 @@
+package gosns_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// logBuffer is a log destination safe for use by concurrent requests.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTopicLogLevels(t *testing.T) {
+	var serverLog, criticalLog logBuffer
+	s := &gosns.Server{Logger: log.New(&serverLog, "", 0), LogLevel: gosns.LogWarn}
+	s.AddTopic("arn:aws:sns:us-east-1:123456789012:chatty", "/chatty", func(*gosns.Message) {})
+	critical := s.AddTopic("arn:aws:sns:us-east-1:123456789012:critical", "/critical", func(*gosns.Message) {})
+	critical.Logger = log.New(&criticalLog, "", 0)
+	critical.LogLevel = gosns.LogDebug
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, name := range []string{"chatty", "critical"} {
+		resp, _, err := ts.Notify("/"+name, "arn:aws:sns:us-east-1:123456789012:"+name, "", "hello")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %v, %v", name, resp, err)
+		}
+	}
+	resp, _, err := ts.Notify("/chatty", "arn:aws:sns:us-east-1:123456789012:other", "", "hello")
+	if err != nil || resp.StatusCode == http.StatusOK {
+		t.Fatalf("mismatched topic: %v, %v", resp, err)
+	}
+
+	if got := serverLog.String(); strings.Contains(got, "got message") || !strings.Contains(got, "reason=topic_arn_mismatch") {
+		t.Errorf("server log at warn:\n%s", got)
+	}
+	if got := criticalLog.String(); !strings.Contains(got, "got message") || !strings.Contains(got, "for topic 'arn:aws:sns:us-east-1:123456789012:critical'") {
+		t.Errorf("critical topic log:\n%s", got)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, level := range []gosns.LogLevel{gosns.LogDebug, gosns.LogInfo, gosns.LogWarn, gosns.LogError} {
+		if got, err := gosns.ParseLogLevel(strings.ToUpper(level.String())); err != nil || got != level {
+			t.Errorf("ParseLogLevel(%q) = %v, %v", level, got, err)
+		}
+	}
+	if _, err := gosns.ParseLogLevel("loud"); err == nil {
+		t.Error("parsed an unknown level")
+	}
+}
+
+func TestLogSample(t *testing.T) {
+	var buf logBuffer
+	s := &gosns.Server{Logger: log.New(&buf, "", 0)}
+	topic := s.AddTopic("arn:aws:sns:us-east-1:123456789012:chatty", "/chatty", func(*gosns.Message) {})
+	topic.LogSample = 4
+	topic.Transformers = []gosns.Transformer{func(_ context.Context, msg *gosns.Message) (*gosns.Message, error) {
+		if msg.Subject == "bad" {
+			return nil, errors.New("bad message")
+		}
+		return msg, nil
+	}}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 8; i++ {
+		if _, _, err := ts.Notify("/chatty", topic.TopicARN, "", "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, _, err := ts.Notify("/chatty", topic.TopicARN, "bad", "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := buf.String()
+	if n := strings.Count(got, "got message"); n != 2 {
+		t.Errorf("logged %d of 8 messages, want 2:\n%s", n, got)
+	}
+	if n := strings.Count(got, "error transforming"); n != 3 {
+		t.Errorf("logged %d of 3 errors:\n%s", n, got)
+	}
+}
+
+func TestLogFunc(t *testing.T) {
+	var mu sync.Mutex
+	var entries []gosns.LogEntry
+	s := &gosns.Server{LogFunc: func(e gosns.LogEntry) {
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+	}}
+	topic := s.AddTopic("arn:aws:sns:us-east-1:123456789012:orders", "/orders", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	resp, env, err := ts.Notify("/orders", topic.TopicARN, "", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range entries {
+		if e.Topic == topic && e.Level == gosns.LogInfo && strings.Contains(e.Message, env.MessageId) {
+			if e.RequestID == "" || e.RequestID != resp.Header.Get(gosns.RequestIDHeader) || strings.HasSuffix(e.Message, "\n") {
+				t.Errorf("got %+v", e)
+			}
+			return
+		}
+	}
+	t.Errorf("no entry for the message in %+v", entries)
+}
@@ -0,0 +1,50 @@
+package gosns
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// SetMaintenance turns maintenance mode on or off. While it is on,
+// notifications are refused with ResponseMaintenance, a 503 which SNS
+// retries according to the subscription's delivery policy, so that nothing
+// is lost while whatever the callbacks depend on is down. Confirmations and
+// health probes are answered as usual. Maintenance windows should be
+// shorter than the delivery policy's retry period.
+func (s *Server) SetMaintenance(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&s.maint, v) != v {
+		if on {
+			s.logf(LogWarn, "Entering maintenance mode, refusing notifications\n")
+		} else {
+			s.logf(LogInfo, "Leaving maintenance mode\n")
+		}
+	}
+}
+
+// InMaintenance reports whether maintenance mode is on; see SetMaintenance.
+func (s *Server) InMaintenance() bool {
+	return atomic.LoadInt32(&s.maint) != 0
+}
+
+func (s *Server) adminMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "POST":
+		on, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			simpleResponse(w, http.StatusBadRequest, "enabled must be true or false")
+			return
+		}
+		s.SetMaintenance(on)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"Maintenance": s.InMaintenance()})
+}
@@ -0,0 +1,47 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestMaintenance(t *testing.T) {
+	got := make(chan *gosns.Message, 4)
+	s := &gosns.Server{}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/maintenance?enabled=true", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Maintenance": true`) || !s.InMaintenance() {
+		t.Fatalf("admin gave %d %s", rec.Code, rec.Body)
+	}
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "hello")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(gosns.ReasonHeader) != gosns.ReasonMaintenance {
+		t.Errorf("notification in maintenance gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Confirm("/orders", ordersARN); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("confirmation in maintenance gave %v, %v", resp, err)
+	}
+	if resp, err := ts.Client().Get(ts.URL + "/orders"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("health probe in maintenance gave %v, %v", resp, err)
+	}
+
+	s.SetMaintenance(false)
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("notification after maintenance gave %v, %v", resp, err)
+	}
+	if msg := <-got; msg.Message != "hello" || len(got) != 0 {
+		t.Errorf("got %+v", msg)
+	}
+}
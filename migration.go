@@ -0,0 +1,176 @@
+package gosns
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultQuietPeriod is how long the old topic of a Migration must go
+// without messages to be reported quiet when QuietPeriod is zero.
+const DefaultQuietPeriod = 24 * time.Hour
+
+// Migration moves a topic's endpoint from one SNS topic to another, such as
+// when a topic is renamed. Set as Topic.Migration, the endpoint accepts
+// messages from OldARN as well as TopicARN while both are subscribed,
+// counting those from each, and reports once OldARN has sent nothing for
+// QuietPeriod, when its subscription can be deleted:
+//
+//	t := s.AddTopic(newARN, "/orders", handle)
+//	t.Migration = &gosns.Migration{OldARN: oldARN, QuietPeriod: 72 * time.Hour}
+//
+// The period starts with Start and again with each message from OldARN.
+// See Status and the admin API's /migrations.
+type Migration struct {
+	OldARN      string
+	QuietPeriod time.Duration
+
+	// OnQuiet, if set, is called when OldARN is found quiet, and again if it
+	// is quiet again after sending more messages.
+	OnQuiet func(MigrationStatus)
+
+	mu       sync.Mutex
+	topic    *Topic
+	started  time.Time
+	timer    *time.Timer
+	status   MigrationStatus
+	reported bool // OnQuiet has been called since the last old message
+}
+
+// MigrationStatus is the traffic a Migration has seen since the server
+// started.
+type MigrationStatus struct {
+	OldARN      string
+	NewARN      string
+	OldMessages uint64
+	NewMessages uint64
+	OldLast     time.Time // when the last message from OldARN arrived
+	NewLast     time.Time
+
+	// Quiet is whether OldARN has sent nothing for QuietPeriod.
+	Quiet bool
+}
+
+func (m *Migration) quietPeriod() time.Duration {
+	if m.QuietPeriod > 0 {
+		return m.QuietPeriod
+	}
+	return DefaultQuietPeriod
+}
+
+// start begins the quiet period for the topic t, if it has not begun yet.
+// The caller must hold m.mu.
+func (m *Migration) start(t *Topic) {
+	if m.topic != nil {
+		return
+	}
+	m.topic, m.started = t, time.Now()
+	m.status.OldARN, m.status.NewARN = m.OldARN, t.TopicARN
+	m.timer = time.AfterFunc(m.quietPeriod(), m.check)
+}
+
+// observe counts a message which arrived from topicARN on t.
+func (m *Migration) observe(t *Topic, topicARN string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.start(t)
+	now := time.Now()
+	if topicARN == t.TopicARN || !matchARN(m.OldARN, topicARN) {
+		m.status.NewMessages++
+		m.status.NewLast = now
+		return
+	}
+	m.status.OldMessages++
+	m.status.OldLast = now
+	if m.reported {
+		t.logf(nil, LogWarn, "Old topic '%s' of endpoint '%s' is sending messages again\n", m.OldARN, t.endpoint)
+		m.reported = false
+	}
+	m.timer.Reset(m.quietPeriod())
+}
+
+// quietFor returns how long OldARN has sent nothing. The caller must hold
+// m.mu.
+func (m *Migration) quietFor() time.Duration {
+	since := m.started
+	if m.status.OldLast.After(since) {
+		since = m.status.OldLast
+	}
+	return time.Since(since)
+}
+
+// check reports the old topic quiet once the quiet period has passed
+// since its last message.
+func (m *Migration) check() {
+	m.mu.Lock()
+	wait := m.quietPeriod() - m.quietFor()
+	if wait > 0 {
+		m.timer.Reset(wait)
+		m.mu.Unlock()
+		return
+	}
+	if m.reported {
+		m.mu.Unlock()
+		return
+	}
+	m.reported = true
+	status := m.snapshot()
+	t := m.topic
+	m.mu.Unlock()
+	t.logf(nil, LogInfo, "Old topic '%s' of endpoint '%s' has been quiet for %s, after %d messages from it and %d from '%s'; its subscription can be deleted\n",
+		m.OldARN, t.endpoint, m.quietPeriod(), status.OldMessages, status.NewMessages, status.NewARN)
+	if m.OnQuiet != nil {
+		m.OnQuiet(status)
+	}
+}
+
+// snapshot returns the status. The caller must hold m.mu.
+func (m *Migration) snapshot() MigrationStatus {
+	status := m.status
+	status.OldARN = m.OldARN
+	status.Quiet = m.topic != nil && m.quietFor() >= m.quietPeriod()
+	return status
+}
+
+// Status returns the traffic the migration has seen, and whether the old
+// topic has gone quiet.
+func (m *Migration) Status() MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot()
+}
+
+// migrationTopics returns the topics with a Migration by endpoint.
+func (s *Server) migrationTopics() map[string]*Topic {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	topics := make(map[string]*Topic)
+	for endpoint, td := range s.topics {
+		if td.Migration != nil {
+			topics[endpoint] = td
+		}
+	}
+	return topics
+}
+
+// startMigrations begins the quiet periods of migrating topics.
+func (s *Server) startMigrations() {
+	for _, td := range s.migrationTopics() {
+		td.Migration.mu.Lock()
+		td.Migration.start(td)
+		td.Migration.mu.Unlock()
+	}
+}
+
+func (s *Server) adminMigrations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := make(map[string]MigrationStatus)
+	for endpoint, td := range s.migrationTopics() {
+		res[endpoint] = td.Migration.Status()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,67 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestMigration(t *testing.T) {
+	const (
+		oldARN = "arn:aws:sns:us-east-1:123456789012:orders-v1"
+		newARN = "arn:aws:sns:us-east-1:123456789012:orders-v2"
+	)
+	quiet := make(chan gosns.MigrationStatus, 2)
+	s := &gosns.Server{}
+	orders := s.AddTopic(newARN, "/orders", func(*gosns.Message) {})
+	orders.Migration = &gosns.Migration{
+		OldARN:      oldARN,
+		QuietPeriod: 100 * time.Millisecond,
+		OnQuiet:     func(st gosns.MigrationStatus) { quiet <- st },
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arn := range []string{oldARN, newARN, newARN} {
+		if resp, _, err := ts.Notify("/orders", arn, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s gave %v, %v", arn, resp, err)
+		}
+	}
+	if resp, _, err := ts.Notify("/orders", "arn:aws:sns:us-east-1:123456789012:prices", "", "1.00"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("other topic gave %v, %v", resp, err)
+	}
+	if st := orders.Migration.Status(); st.OldMessages != 1 || st.NewMessages != 2 || st.Quiet || st.NewARN != newARN {
+		t.Errorf("status %+v", st)
+	}
+
+	select {
+	case st := <-quiet:
+		if !st.Quiet || st.OldMessages != 1 || st.OldLast.IsZero() {
+			t.Errorf("quiet status %+v", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old topic not reported quiet")
+	}
+	if !orders.Migration.Status().Quiet {
+		t.Error("status not quiet")
+	}
+
+	// a late message from the old topic starts the period again
+	if resp, _, err := ts.Notify("/orders", oldARN, "", "late"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	if orders.Migration.Status().Quiet {
+		t.Error("quiet after a message from the old topic")
+	}
+	select {
+	case <-quiet:
+	case <-time.After(5 * time.Second):
+		t.Fatal("old topic not reported quiet again")
+	}
+}
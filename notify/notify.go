@@ -0,0 +1,176 @@
+// Package notify formats SNS messages, and CloudWatch alarms in particular,
+// for chat and paging services: Slack incoming webhooks, Microsoft Teams
+// workflow webhooks and the PagerDuty Events API v2. Each format is a
+// function returning the JSON body to POST, for the Encode field of a
+// fanout.Destination:
+//
+//	pd := &notify.PagerDuty{RoutingKey: os.Getenv("PAGERDUTY_ROUTING_KEY")}
+//	r := &fanout.Router{Destinations: []*fanout.Destination{
+//		{Name: "slack", URL: slackWebhookURL, Encode: notify.Slack},
+//		{Name: "pagerduty", URL: notify.PagerDutyURL, Topics: []string{"alarms-*"}, Encode: pd.Encode},
+//	}}
+//	s.AddTopic(topicARN, "/alarms", r.Handle)
+//
+// A message whose body is a CloudWatch alarm state change is shown as the
+// alarm: its name and new state, the reason, the metric and a link to the
+// alarm in the console. Any other message is shown by its subject and body.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Alarm states.
+const (
+	StateAlarm            = "ALARM"
+	StateOK               = "OK"
+	StateInsufficientData = "INSUFFICIENT_DATA"
+)
+
+// maxText bounds the text shown of a message body.
+const maxText = 3000
+
+// Alarm is the message CloudWatch publishes when an alarm changes state.
+type Alarm struct {
+	AlarmName        string
+	AlarmDescription string
+	AWSAccountId     string
+	AlarmArn         string
+	NewStateValue    string
+	NewStateReason   string
+	OldStateValue    string
+	StateChangeTime  string
+	Region           string // the region's long name, such as "US East (N. Virginia)"
+	Trigger          struct {
+		MetricName         string
+		Namespace          string
+		Statistic          string
+		Period             int
+		EvaluationPeriods  int
+		ComparisonOperator string
+		Threshold          float64
+		Dimensions         []struct{ Name, Value string }
+	}
+}
+
+// ParseAlarm returns the alarm msg's body describes, if it is one.
+func ParseAlarm(msg *gosns.Message) (*Alarm, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(msg.Message), "{") {
+		return nil, false
+	}
+	var a Alarm
+	if err := json.Unmarshal([]byte(msg.Message), &a); err != nil || a.AlarmName == "" || a.NewStateValue == "" {
+		return nil, false
+	}
+	return &a, true
+}
+
+// ConsoleURL returns a link to the alarm in the CloudWatch console.
+func (a *Alarm) ConsoleURL() string {
+	region := "us-east-1"
+	if arn, err := gosns.ParseARN(a.AlarmArn); err == nil && arn.Region != "" {
+		region = arn.Region
+	}
+	return "https://console.aws.amazon.com/cloudwatch/home?region=" + region + "#alarmsV2:alarm/" + url.PathEscape(a.AlarmName)
+}
+
+// Metric describes the alarm's metric and threshold, such as
+// "AWS/SQS ApproximateAgeOfOldestMessage Maximum > 600 (QueueName=orders)".
+func (a *Alarm) Metric() string {
+	t := a.Trigger
+	if t.MetricName == "" {
+		return ""
+	}
+	s := fmt.Sprintf("%s %s %s %s %v", t.Namespace, t.MetricName, t.Statistic, comparison(t.ComparisonOperator), t.Threshold)
+	var dims []string
+	for _, d := range t.Dimensions {
+		dims = append(dims, d.Name+"="+d.Value)
+	}
+	if len(dims) > 0 {
+		s += " (" + strings.Join(dims, ", ") + ")"
+	}
+	return strings.TrimSpace(s)
+}
+
+func comparison(op string) string {
+	switch op {
+	case "GreaterThanThreshold":
+		return ">"
+	case "GreaterThanOrEqualToThreshold":
+		return ">="
+	case "LessThanThreshold":
+		return "<"
+	case "LessThanOrEqualToThreshold":
+		return "<="
+	}
+	return op
+}
+
+// field is a labelled value shown with a notification.
+type field struct {
+	Title, Value string
+}
+
+// notification is what the formats show of a message.
+type notification struct {
+	Title  string
+	Text   string
+	State  string // an alarm's new state, or ""
+	URL    string
+	Fields []field
+}
+
+// describe returns what is shown of msg.
+func describe(msg *gosns.Message) notification {
+	if a, ok := ParseAlarm(msg); ok {
+		n := notification{
+			Title: a.NewStateValue + ": " + a.AlarmName,
+			Text:  a.NewStateReason,
+			State: a.NewStateValue,
+			URL:   a.ConsoleURL(),
+		}
+		if a.AlarmDescription != "" {
+			n.Fields = append(n.Fields, field{"Description", a.AlarmDescription})
+		}
+		if m := a.Metric(); m != "" {
+			n.Fields = append(n.Fields, field{"Metric", m})
+		}
+		if a.OldStateValue != "" {
+			n.Fields = append(n.Fields, field{"Previous state", a.OldStateValue})
+		}
+		if a.AWSAccountId != "" {
+			n.Fields = append(n.Fields, field{"Account", a.AWSAccountId})
+		}
+		return n
+	}
+	n := notification{Title: msg.Subject, Text: msg.Message}
+	topic := msg.TopicArn
+	if arn, err := gosns.ParseARN(msg.TopicArn); err == nil {
+		topic = arn.Resource
+	}
+	if n.Title == "" {
+		n.Title = "Message on " + topic
+	} else if topic != "" {
+		n.Fields = append(n.Fields, field{"Topic", topic})
+	}
+	n.Text = truncate(n.Text, maxText)
+	return n
+}
+
+// truncate shortens s to at most n bytes, on a rune boundary, marking where
+// it was cut.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "…"
+}
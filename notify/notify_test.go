@@ -0,0 +1,129 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+const alarmBody = `{
+	"AlarmName": "orders-queue-age",
+	"AlarmDescription": "Orders are not being processed",
+	"AWSAccountId": "123456789012",
+	"NewStateValue": "ALARM",
+	"NewStateReason": "Threshold Crossed: 1 datapoint [912.0] was greater than the threshold (600.0).",
+	"StateChangeTime": "2026-10-14T12:00:00.000+0000",
+	"Region": "US East (N. Virginia)",
+	"AlarmArn": "arn:aws:cloudwatch:us-east-2:123456789012:alarm:orders-queue-age",
+	"OldStateValue": "OK",
+	"Trigger": {
+		"MetricName": "ApproximateAgeOfOldestMessage",
+		"Namespace": "AWS/SQS",
+		"Statistic": "MAXIMUM",
+		"Period": 60,
+		"EvaluationPeriods": 1,
+		"ComparisonOperator": "GreaterThanThreshold",
+		"Threshold": 600,
+		"Dimensions": [{"value": "orders", "name": "QueueName"}]
+	}
+}`
+
+func alarm(state string) *gosns.Message {
+	return &gosns.Message{
+		MessageId: "m-1",
+		TopicArn:  "arn:aws:sns:us-east-2:123456789012:alarms",
+		Subject:   `ALARM: "orders-queue-age" in US East (Ohio)`,
+		Message:   strings.Replace(alarmBody, `"NewStateValue": "ALARM"`, `"NewStateValue": "`+state+`"`, 1),
+		Timestamp: time.Date(2026, 10, 14, 12, 0, 1, 0, time.UTC),
+	}
+}
+
+// decode returns the JSON object format gives for msg.
+func decode(t *testing.T, format func(*gosns.Message) ([]byte, error), msg *gosns.Message) map[string]interface{} {
+	t.Helper()
+	data, err := format(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseAlarm(t *testing.T) {
+	a, ok := ParseAlarm(alarm(StateAlarm))
+	if !ok || a.AlarmName != "orders-queue-age" {
+		t.Fatalf("got %+v, %v", a, ok)
+	}
+	if got := a.Metric(); got != "AWS/SQS ApproximateAgeOfOldestMessage MAXIMUM > 600 (QueueName=orders)" {
+		t.Errorf("metric %q", got)
+	}
+	if got := a.ConsoleURL(); got != "https://console.aws.amazon.com/cloudwatch/home?region=us-east-2#alarmsV2:alarm/orders-queue-age" {
+		t.Errorf("console URL %q", got)
+	}
+	for _, body := range []string{"hello", `{"order": 1}`, `{"AlarmName": `} {
+		if _, ok := ParseAlarm(&gosns.Message{Message: body}); ok {
+			t.Errorf("%q parsed", body)
+		}
+	}
+}
+
+func TestSlack(t *testing.T) {
+	v := decode(t, Slack, alarm(StateAlarm))
+	att := v["attachments"].([]interface{})[0].(map[string]interface{})
+	if v["text"] != "ALARM: orders-queue-age" || att["color"] != "danger" || !strings.Contains(att["title_link"].(string), "alarmsV2") {
+		t.Errorf("got %v", v)
+	}
+	if fields := att["fields"].([]interface{}); len(fields) != 4 {
+		t.Errorf("fields %v", fields)
+	}
+
+	v = decode(t, Slack, &gosns.Message{TopicArn: "arn:aws:sns:us-east-1:123456789012:deploys", Message: strings.Repeat("é", 2000)})
+	att = v["attachments"].([]interface{})[0].(map[string]interface{})
+	if text := att["text"].(string); v["text"] != "Message on deploys" || len(text) > maxText+len("…") || !strings.HasSuffix(text, "é…") {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	v := decode(t, Teams, alarm(StateOK))
+	att := v["attachments"].([]interface{})[0].(map[string]interface{})
+	card := att["content"].(map[string]interface{})
+	title := card["body"].([]interface{})[0].(map[string]interface{})
+	if att["contentType"] != "application/vnd.microsoft.card.adaptive" || title["text"] != "OK: orders-queue-age" || title["color"] != "Good" {
+		t.Errorf("got %v", v)
+	}
+	if actions := card["actions"].([]interface{}); len(actions) != 1 {
+		t.Errorf("actions %v", actions)
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	pd := &PagerDuty{RoutingKey: "key"}
+	v := decode(t, pd.Encode, alarm(StateAlarm))
+	payload := v["payload"].(map[string]interface{})
+	if v["event_action"] != "trigger" || v["dedup_key"] != "arn:aws:cloudwatch:us-east-2:123456789012:alarm:orders-queue-age" ||
+		payload["severity"] != DefaultSeverity || payload["source"] != "123456789012 US East (N. Virginia)" || payload["timestamp"] != "2026-10-14T12:00:01Z" {
+		t.Errorf("got %v", v)
+	}
+	if v = decode(t, pd.Encode, alarm(StateOK)); v["event_action"] != "resolve" {
+		t.Errorf("got %v", v)
+	}
+	if v = decode(t, pd.Encode, alarm(StateInsufficientData)); v["payload"].(map[string]interface{})["severity"] != "warning" {
+		t.Errorf("got %v", v)
+	}
+
+	v = decode(t, pd.Encode, &gosns.Message{MessageId: "m-2", Subject: "disk full", Message: "on db-1"})
+	payload = v["payload"].(map[string]interface{})
+	if v["dedup_key"] != "m-2" || payload["summary"] != "disk full" || payload["source"] != "gosns" {
+		t.Errorf("got %v", v)
+	}
+	if _, err := (&PagerDuty{}).Encode(alarm(StateAlarm)); err == nil {
+		t.Error("encoded without a routing key")
+	}
+}
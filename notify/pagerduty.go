@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// PagerDutyURL is the endpoint of the PagerDuty Events API v2.
+const PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// DefaultSeverity is the severity of PagerDuty events when
+// PagerDuty.Severity is empty.
+const DefaultSeverity = "error"
+
+// PagerDuty formats messages as events for the PagerDuty Events API v2.
+//
+// An alarm entering ALARM or INSUFFICIENT_DATA triggers an incident, and
+// one returning to OK resolves it; its ARN is the dedup key, so that the
+// incident follows the alarm. Any other message triggers an incident of
+// its own, deduplicated by MessageId.
+type PagerDuty struct {
+	// RoutingKey is the integration key of the service alerted.
+	RoutingKey string
+
+	// Severity is that of triggered incidents: critical, error, warning or
+	// info. Zero means DefaultSeverity; INSUFFICIENT_DATA is a warning.
+	Severity string
+
+	// Source names the affected system; zero means the alarm's account and
+	// region, or the message's topic.
+	Source string
+}
+
+// Encode returns the body of a PagerDuty event for msg.
+func (p *PagerDuty) Encode(msg *gosns.Message) ([]byte, error) {
+	if p.RoutingKey == "" {
+		return nil, errors.New("notify: PagerDuty routing key is not set")
+	}
+	n := describe(msg)
+	severity := p.Severity
+	if severity == "" {
+		severity = DefaultSeverity
+	}
+	event := map[string]interface{}{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    msg.MessageId,
+		"client":       "gosns",
+	}
+	source := p.Source
+	details := make(map[string]string, len(n.Fields)+2)
+	for _, f := range n.Fields {
+		details[f.Title] = f.Value
+	}
+	if a, ok := ParseAlarm(msg); ok {
+		if a.AlarmArn != "" {
+			event["dedup_key"] = a.AlarmArn
+		}
+		switch a.NewStateValue {
+		case StateOK:
+			event["event_action"] = "resolve"
+		case StateInsufficientData:
+			severity = "warning"
+		}
+		if source == "" {
+			source = strings.TrimSpace(a.AWSAccountId + " " + a.Region)
+		}
+		details["Reason"] = a.NewStateReason
+		event["client_url"] = n.URL
+		event["links"] = []interface{}{map[string]string{"href": n.URL, "text": "View in CloudWatch"}}
+	} else {
+		if source == "" {
+			source = msg.TopicArn
+		}
+		details["Message"] = n.Text
+	}
+	if source == "" {
+		source = "gosns"
+	}
+	at := msg.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	event["payload"] = map[string]interface{}{
+		"summary":        truncate(n.Title, 1024),
+		"source":         source,
+		"severity":       severity,
+		"timestamp":      at.UTC().Format(time.RFC3339),
+		"custom_details": details,
+	}
+	return json.Marshal(event)
+}
@@ -0,0 +1,51 @@
+package notify
+
+import (
+	"encoding/json"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Slack returns the body of a Slack incoming webhook request showing msg as
+// an attachment, colored by the state of an alarm.
+func Slack(msg *gosns.Message) ([]byte, error) {
+	n := describe(msg)
+	type slackField struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	fields := make([]slackField, len(n.Fields))
+	for i, f := range n.Fields {
+		fields[i] = slackField{f.Title, f.Value, len(f.Value) < 40}
+	}
+	attachment := map[string]interface{}{
+		"fallback": n.Title,
+		"color":    slackColor(n.State),
+		"title":    n.Title,
+		"text":     n.Text,
+		"fields":   fields,
+	}
+	if n.URL != "" {
+		attachment["title_link"] = n.URL
+	}
+	if !msg.Timestamp.IsZero() {
+		attachment["ts"] = msg.Timestamp.Unix()
+	}
+	return json.Marshal(map[string]interface{}{
+		"text":        n.Title,
+		"attachments": []interface{}{attachment},
+	})
+}
+
+func slackColor(state string) string {
+	switch state {
+	case StateAlarm:
+		return "danger"
+	case StateOK:
+		return "good"
+	case StateInsufficientData:
+		return "warning"
+	}
+	return "#439fe0"
+}
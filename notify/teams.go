@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"encoding/json"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Teams returns the body of a Microsoft Teams workflow webhook request
+// showing msg as an Adaptive Card, with the title of an alarm in ALARM
+// colored as a warning.
+func Teams(msg *gosns.Message) ([]byte, error) {
+	n := describe(msg)
+	title := map[string]interface{}{"type": "TextBlock", "text": n.Title, "size": "Medium", "weight": "Bolder", "wrap": true}
+	switch n.State {
+	case StateAlarm:
+		title["color"] = "Attention"
+	case StateOK:
+		title["color"] = "Good"
+	case StateInsufficientData:
+		title["color"] = "Warning"
+	}
+	body := []interface{}{title}
+	if n.Text != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": n.Text, "wrap": true})
+	}
+	if len(n.Fields) > 0 {
+		facts := make([]map[string]string, len(n.Fields))
+		for i, f := range n.Fields {
+			facts[i] = map[string]string{"title": f.Title, "value": f.Value}
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if n.URL != "" {
+		card["actions"] = []interface{}{map[string]string{"type": "Action.OpenUrl", "title": "View in CloudWatch", "url": n.URL}}
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+}
@@ -0,0 +1,365 @@
+// Package opensearch indexes the messages a gosns server receives into
+// OpenSearch or Elasticsearch, so that the history of notifications can be
+// searched from OpenSearch Dashboards or Kibana:
+//
+//	ix := &opensearch.Indexer{URL: "https://search-logs.us-east-1.es.amazonaws.com", Region: "us-east-1"}
+//	defer ix.Close()
+//	t := s.AddTopic(topicARN, "/orders", ix.Handle)
+//	t.Delivery = gosns.AtLeastOnce
+//
+// Each message is a document, with the MessageId as its ID so that
+// redeliveries replace it, in a daily index per topic named
+// <IndexPrefix><topic name>-<YYYY.MM.DD>, by its SNS Timestamp in UTC.
+// Before the first message of a topic is indexed, an index template named
+// <IndexPrefix><topic name> is put for the topic's indices, mapping the
+// fields of gosns.Message with Mappings, so that a dashboard's index
+// pattern finds the same types on every day's index.
+//
+// Documents written at about the same time are sent together to the bulk
+// API once MaxDocuments have been written or the first has waited MaxWait,
+// and Write returns once its document has been indexed. Documents the
+// cluster refuses with a 429 or a 5xx, and whole requests which are, are
+// retried with backoff up to MaxAttempts times.
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/internal/batcher"
+	"github.com/pbnjay/gosns/internal/sigv4"
+)
+
+// Defaults used when the corresponding Indexer fields are zero.
+const (
+	DefaultIndexPrefix  = "sns-"
+	DefaultMaxDocuments = 500
+	DefaultMaxWait      = 200 * time.Millisecond
+	DefaultMaxAttempts  = 5
+	DefaultRetryBackoff = 100 * time.Millisecond
+	DefaultTimeout      = 30 * time.Second
+)
+
+// DefaultMappings are the mappings of the index template put for each
+// topic when an Indexer's Mappings is nil.
+var DefaultMappings = json.RawMessage(`{
+	"properties": {
+		"MessageId": {"type": "keyword"},
+		"TopicArn": {"type": "keyword"},
+		"Subject": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+		"Message": {"type": "text"},
+		"Timestamp": {"type": "date"},
+		"BodySHA256": {"type": "keyword"},
+		"MessageAttributes": {"type": "object", "dynamic": true}
+	}
+}`)
+
+// maxBulkBytes bounds the body of a bulk request, well under the 10 MiB
+// the smallest Amazon OpenSearch Service instances accept.
+const maxBulkBytes = 5 << 20
+
+// ErrClosed is returned by Write once the Indexer is closed.
+var ErrClosed = errors.New("opensearch: indexer is closed")
+
+// Indexer indexes messages into a cluster. Its fields must not be changed
+// once it has been used.
+type Indexer struct {
+	// URL is the base URL of the cluster.
+	URL string
+
+	// IndexPrefix begins the name of every index and template. Zero means
+	// DefaultIndexPrefix. Index names are lower case.
+	IndexPrefix string
+
+	// Mappings are those of each topic's index template, or
+	// DefaultMappings if nil.
+	Mappings json.RawMessage
+
+	// Username and Password, if set, authenticate requests with HTTP basic
+	// authentication.
+	Username string
+	Password string
+
+	// Region, if set, signs requests with AWS Signature Version 4 for the
+	// Amazon OpenSearch Service in that region, using Credentials, or
+	// gosns.LoadCredentials if nil. Service is the name signed for: "es",
+	// the default, or "aoss" for OpenSearch Serverless.
+	Region      string
+	Credentials *gosns.Credentials
+	Service     string
+
+	// Client sends requests; nil means an http.Client with a timeout of
+	// Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// MaxDocuments and MaxWait bound a bulk request, and MaxAttempts the
+	// sends of a document, with RetryBackoff before the second and doubling
+	// for each after. Zero means DefaultMaxDocuments, DefaultMaxWait,
+	// DefaultMaxAttempts and DefaultRetryBackoff.
+	MaxDocuments int
+	MaxWait      time.Duration
+	MaxAttempts  int
+	RetryBackoff time.Duration
+
+	// Logger receives notices of retried requests, if set.
+	Logger *log.Logger
+
+	once     sync.Once
+	client   *http.Client
+	credsErr error
+	batcher  *batcher.Batcher
+
+	templateMu sync.Mutex
+	templates  map[string]bool // topics whose template has been put
+}
+
+// Error is an error response from the cluster, or the failure of one
+// document of a bulk request.
+type Error struct {
+	StatusCode int
+	Type       string // such as "es_rejected_execution_exception"
+	Reason     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("opensearch: status %d: %s: %s", e.StatusCode, e.Type, e.Reason)
+}
+
+// retryable reports whether a request or document failing with err may
+// succeed if sent again.
+func retryable(err error) bool {
+	var oerr *Error
+	if !errors.As(err, &oerr) {
+		return true
+	}
+	return oerr.StatusCode == http.StatusTooManyRequests || oerr.StatusCode >= 500
+}
+
+func (ix *Indexer) init() {
+	ix.once.Do(func() {
+		ix.client = ix.Client
+		if ix.client == nil {
+			timeout := ix.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			ix.client = &http.Client{Timeout: timeout}
+		}
+		if ix.Region != "" && ix.Credentials == nil {
+			ix.Credentials, ix.credsErr = gosns.LoadCredentials()
+		}
+		ix.templates = make(map[string]bool)
+		ix.batcher = &batcher.Batcher{
+			MaxRecords:  ix.MaxDocuments,
+			MaxBytes:    maxBulkBytes,
+			MaxWait:     ix.MaxWait,
+			Send:        ix.bulk,
+			Retryable:   retryable,
+			MaxAttempts: ix.MaxAttempts,
+			Backoff:     ix.RetryBackoff,
+			Logf: func(format string, args ...interface{}) {
+				ix.logf("opensearch: "+format+"\n", args...)
+			},
+		}
+		if ix.batcher.MaxRecords <= 0 {
+			ix.batcher.MaxRecords = DefaultMaxDocuments
+		}
+		if ix.batcher.MaxWait <= 0 {
+			ix.batcher.MaxWait = DefaultMaxWait
+		}
+		if ix.batcher.MaxAttempts <= 0 {
+			ix.batcher.MaxAttempts = DefaultMaxAttempts
+		}
+		if ix.batcher.Backoff <= 0 {
+			ix.batcher.Backoff = DefaultRetryBackoff
+		}
+	})
+}
+
+func (ix *Indexer) logf(format string, args ...interface{}) {
+	if ix.Logger != nil {
+		ix.Logger.Printf(format, args...)
+	}
+}
+
+func (ix *Indexer) prefix() string {
+	if ix.IndexPrefix != "" {
+		return strings.ToLower(ix.IndexPrefix)
+	}
+	return DefaultIndexPrefix
+}
+
+// topicName returns the lower case name of msg's topic.
+func topicName(msg *gosns.Message) string {
+	if arn, err := gosns.ParseARN(msg.TopicArn); err == nil && arn.Resource != "" {
+		return strings.ToLower(arn.Resource)
+	}
+	return "unknown"
+}
+
+// Index returns the name of the index msg is written to.
+func (ix *Indexer) Index(msg *gosns.Message) string {
+	at := msg.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	return ix.prefix() + topicName(msg) + "-" + at.UTC().Format("2006.01.02")
+}
+
+// Write indexes msg, returning once it has been indexed or has failed
+// MaxAttempts times, or msg's context is done.
+func (ix *Indexer) Write(msg *gosns.Message) error {
+	ix.init()
+	if err := ix.putTemplate(topicName(msg)); err != nil {
+		return err
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": ix.Index(msg), "_id": msg.MessageId}})
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data := append(append(append(action, '\n'), doc...), '\n')
+	err = ix.batcher.Write(msg.Context(), data)
+	if err == batcher.ErrClosed {
+		return ErrClosed
+	}
+	return err
+}
+
+// Handle indexes msg as a Topic callback, failing the message if it could
+// not be indexed.
+func (ix *Indexer) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := ix.Write(msg); err != nil {
+		msg.Fail(err)
+	}
+}
+
+// Close sends any pending documents and waits for the requests in
+// progress. Later Writes fail with ErrClosed.
+func (ix *Indexer) Close() {
+	ix.init()
+	ix.batcher.Close()
+}
+
+// putTemplate puts the index template of topic, if it has not been.
+func (ix *Indexer) putTemplate(topic string) error {
+	ix.templateMu.Lock()
+	defer ix.templateMu.Unlock()
+	if ix.templates[topic] {
+		return nil
+	}
+	mappings := ix.Mappings
+	if mappings == nil {
+		mappings = DefaultMappings
+	}
+	name := ix.prefix() + topic
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{name + "-*"},
+		"template":       map[string]interface{}{"mappings": mappings},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = ix.do("PUT", "/_index_template/"+url.PathEscape(name), "application/json", body); err != nil {
+		return fmt.Errorf("opensearch: putting index template '%s': %w", name, err)
+	}
+	ix.templates[topic] = true
+	return nil
+}
+
+// bulk sends batch, each an action and document, to the bulk API,
+// returning the error of each document which failed.
+func (ix *Indexer) bulk(batch [][]byte) ([]error, error) {
+	data, err := ix.do("POST", "/_bulk", "application/x-ndjson", bytes.Join(batch, nil))
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Errors bool
+		Items  []map[string]struct {
+			Status int
+			Error  *struct{ Type, Reason string }
+		}
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	if !out.Errors {
+		return nil, nil
+	}
+	failed := make([]error, len(batch))
+	for i, item := range out.Items {
+		for _, res := range item {
+			if i < len(failed) && res.Error != nil {
+				failed[i] = &Error{StatusCode: res.Status, Type: res.Error.Type, Reason: res.Error.Reason}
+			}
+		}
+	}
+	return failed, nil
+}
+
+// do sends a request to the cluster, returning the body of a 2xx response.
+func (ix *Indexer) do(method, path, contentType string, body []byte) ([]byte, error) {
+	if ix.credsErr != nil {
+		return nil, ix.credsErr
+	}
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(ix.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if ix.Username != "" || ix.Password != "" {
+		req.SetBasicAuth(ix.Username, ix.Password)
+	}
+	if ix.Region != "" {
+		service := ix.Service
+		if service == "" {
+			service = "es"
+		}
+		c, err := ix.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Amz-Content-Sha256", sigv4.HexSHA256(body))
+		sigv4.Sign(req, body, c.AccessKeyID, c.SecretAccessKey, c.SessionToken, ix.Region, service, time.Now())
+	}
+	resp, err := ix.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		oerr := &Error{StatusCode: resp.StatusCode}
+		var e struct {
+			Error struct{ Type, Reason string }
+		}
+		if json.Unmarshal(data, &e) == nil {
+			oerr.Type, oerr.Reason = e.Error.Type, e.Error.Reason
+		}
+		return nil, oerr
+	}
+	return data, nil
+}
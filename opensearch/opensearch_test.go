@@ -0,0 +1,144 @@
+package opensearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+// cluster is a fake cluster recording the templates put and the documents
+// indexed. It refuses the first rejections documents with a 429.
+type cluster struct {
+	t *testing.T
+
+	rejections int
+	templates  map[string]string // name to index pattern
+	docs       map[string]string // index/_id to MessageId
+}
+
+func (c *cluster) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, _ := r.BasicAuth(); user != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": {"type": "security_exception", "reason": "missing authentication credentials"}, "status": 401}`)
+		return
+	}
+	switch {
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/_index_template/"):
+		var tmpl struct {
+			IndexPatterns []string `json:"index_patterns"`
+			Template      struct{ Mappings map[string]interface{} }
+		}
+		if err := json.NewDecoder(r.Body).Decode(&tmpl); err != nil || tmpl.Template.Mappings["properties"] == nil {
+			c.t.Errorf("bad template %+v, %v", tmpl, err)
+		}
+		c.templates[strings.TrimPrefix(r.URL.Path, "/_index_template/")] = strings.Join(tmpl.IndexPatterns, ",")
+		fmt.Fprint(w, `{"acknowledged": true}`)
+	case r.Method == "POST" && r.URL.Path == "/_bulk":
+		if r.Header.Get("Content-Type") != "application/x-ndjson" {
+			c.t.Errorf("bulk Content-Type %q", r.Header.Get("Content-Type"))
+		}
+		type result struct {
+			Status int                    `json:"status"`
+			Error  map[string]interface{} `json:"error,omitempty"`
+		}
+		var items []map[string]result
+		errs := false
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var action struct {
+				Index struct {
+					Index string `json:"_index"`
+					ID    string `json:"_id"`
+				} `json:"index"`
+			}
+			json.Unmarshal(sc.Bytes(), &action)
+			// more than one action in a row would fail here
+			sc.Scan()
+			var msg gosns.Message
+			if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+				c.t.Errorf("bad document %q", sc.Bytes())
+			}
+			if c.rejections > 0 {
+				c.rejections--
+				errs = true
+				items = append(items, map[string]result{"index": {Status: 429, Error: map[string]interface{}{"type": "es_rejected_execution_exception", "reason": "queue full"}}})
+				continue
+			}
+			c.docs[action.Index.Index+"/"+action.Index.ID] = msg.MessageId
+			items = append(items, map[string]result{"index": {Status: 201}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": errs, "items": items})
+	default:
+		c.t.Errorf("unexpected %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newIndexer(t *testing.T, c *cluster) (*Indexer, *gosnstest.Sink) {
+	c.t, c.templates, c.docs = t, make(map[string]string), make(map[string]string)
+	s := gosnstest.NewSink(c.serveHTTP)
+	t.Cleanup(s.Close)
+	return &Indexer{URL: s.URL + "/", Username: "admin", Password: "secret", MaxDocuments: 3, MaxWait: 20 * time.Millisecond, RetryBackoff: time.Millisecond}, s
+}
+
+func TestIndexer(t *testing.T) {
+	c := &cluster{rejections: 2}
+	ix, s := newIndexer(t, c)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			topic := "Orders"
+			if i%2 == 1 {
+				topic = "refunds"
+			}
+			if err := ix.Write(gosnstest.NewMessage(fmt.Sprint(i), topic)); err != nil {
+				t.Errorf("%d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	ix.Close()
+
+	s.Lock()
+	defer s.Unlock()
+	if len(c.templates) != 2 || c.templates["sns-orders"] != "sns-orders-*" {
+		t.Errorf("templates %v", c.templates)
+	}
+	if len(c.docs) != 8 || c.docs["sns-orders-2026.10.14/0"] != "0" || c.docs["sns-refunds-2026.10.14/1"] != "1" {
+		t.Errorf("documents %v", c.docs)
+	}
+}
+
+func TestIndexerErrors(t *testing.T) {
+	c := &cluster{rejections: 100}
+	ix, s := newIndexer(t, c)
+	ix.MaxAttempts = 2
+	defer ix.Close()
+	var oerr *Error
+	if err := ix.Write(gosnstest.NewMessage("1", "orders")); !errors.As(err, &oerr) || oerr.StatusCode != 429 || oerr.Type != "es_rejected_execution_exception" {
+		t.Errorf("got %v", err)
+	}
+	s.Lock()
+	if c.rejections != 98 {
+		t.Errorf("%d rejections left", c.rejections)
+	}
+	s.Unlock()
+
+	// authentication failures are not retried
+	ix, _ = newIndexer(t, &cluster{})
+	ix.Password = "wrong"
+	if err := ix.Write(gosnstest.NewMessage("1", "orders")); !errors.As(err, &oerr) || oerr.StatusCode != 401 || oerr.Type != "security_exception" {
+		t.Errorf("got %v", err)
+	}
+}
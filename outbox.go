@@ -0,0 +1,346 @@
+package gosns
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used when the corresponding Outbox fields are zero.
+const (
+	DefaultOutboxAttempts   = 10
+	DefaultOutboxRetryDelay = time.Second
+	maxOutboxRetryDelay     = 5 * time.Minute
+)
+
+// outboxPoll is the longest the dispatcher waits before looking for actions
+// again, in case another replica sharing the Store added some.
+const outboxPoll = time.Second
+
+// OutboxIdempotencyHeader carries an outbox action's ID on its HTTP request,
+// so that the receiver can ignore the repeats of an action retried after it
+// took effect.
+const OutboxIdempotencyHeader = "Idempotency-Key"
+
+// OutboxAction is a side effect of handling a message, given to
+// Message.Enqueue. Exactly one of Request and Publication must be set.
+type OutboxAction struct {
+	Request     *OutboxRequest `json:",omitempty"`
+	Publication *Publication   `json:",omitempty"`
+}
+
+// OutboxRequest is an HTTP request made by the Outbox. It succeeds if the
+// response has a 2xx status.
+type OutboxRequest struct {
+	Method string
+	URL    string
+	Header http.Header `json:",omitempty"`
+	Body   []byte      `json:",omitempty"`
+}
+
+// OutboxEntry is an action as persisted by the Outbox.
+type OutboxEntry struct {
+	// ID is unique to the action, and is the same for the actions enqueued
+	// when a redelivered message is handled again.
+	ID       string
+	Action   OutboxAction
+	Attempts int
+	Next     time.Time // when the next attempt is due
+}
+
+// Outbox runs the side effects of handling messages once each message's
+// callback has returned, so that a handler which fails part way through
+// does not leave some of them done. Actions are saved to the Store before
+// they are run, and retried with backoff until they succeed, surviving
+// restarts, so each takes effect at least once. Receivers which ignore
+// repeated IDs (see OutboxIdempotencyHeader, and MessageDeduplicationId
+// for FIFO topics) see each exactly once.
+//
+// Actions enqueued by a batch callback are committed only if it returns
+// nil, and by other callbacks unless they panic.
+type Outbox struct {
+	// Store holds actions until they succeed. It defaults to the server's
+	// Store.
+	Store Store
+
+	// Client makes the HTTP requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Publisher publishes Publications. It must be set for those to
+	// succeed.
+	Publisher *Publisher
+
+	// MaxAttempts is the number of times an action is tried before it is
+	// given up. Zero means DefaultOutboxAttempts.
+	MaxAttempts int
+
+	// RetryDelay is the wait before the first retry, doubling for each one
+	// after up to five minutes. Zero means DefaultOutboxRetryDelay.
+	RetryDelay time.Duration
+
+	// Failed, if set, is called with each action given up and the error
+	// from its last attempt.
+	Failed func(*OutboxEntry, error)
+
+	startOnce sync.Once
+	server    *Server
+	wake      chan struct{}
+}
+
+// Enqueue adds an action to be run by the server's Outbox once the
+// message's callback has returned. It must be called from the callback,
+// and not concurrently.
+func (m *Message) Enqueue(a OutboxAction) {
+	m.outbox = append(m.outbox, a)
+}
+
+func outboxKey(id string) string {
+	return "outbox/" + id
+}
+
+func (o *Outbox) store() Store {
+	if o.Store != nil {
+		return o.Store
+	}
+	return o.server.Store
+}
+
+// start runs the dispatcher for s, retrying any actions left by a previous
+// run. It may be called more than once.
+func (o *Outbox) start(s *Server) error {
+	o.startOnce.Do(func() {
+		o.server = s
+		o.wake = make(chan struct{}, 1)
+		if o.store() != nil {
+			go o.run(s.context())
+		}
+	})
+	if o.store() == nil {
+		return errors.New("gosns: Outbox has no Store")
+	}
+	return nil
+}
+
+// commit saves the actions enqueued for msg and wakes the dispatcher.
+func (o *Outbox) commit(t *Topic, msg *Message) {
+	actions := msg.outbox
+	msg.outbox = nil
+	if len(actions) == 0 {
+		return
+	}
+	if err := o.start(t.server); err != nil {
+		t.logf(nil, LogError, "discarding %d outbox actions for message '%s': %v\n", len(actions), msg.MessageId, err)
+		return
+	}
+	for i, a := range actions {
+		e := &OutboxEntry{
+			ID:     url.PathEscape(t.endpoint) + "/" + url.PathEscape(msg.MessageId) + "/" + strconv.Itoa(i),
+			Action: a,
+			Next:   time.Now(),
+		}
+		if err := o.save(e); err != nil {
+			t.logf(nil, LogError, "error saving outbox action '%s': %v\n", e.ID, err)
+		}
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) save(e *OutboxEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return o.store().Put(outboxKey(e.ID), data)
+}
+
+// Pending returns the actions not yet done, in ID order.
+func (o *Outbox) Pending() ([]*OutboxEntry, error) {
+	store := o.Store
+	if store == nil && o.server != nil {
+		store = o.server.Store
+	}
+	if store == nil {
+		return nil, nil
+	}
+	keys, err := store.List("outbox/")
+	if err != nil {
+		return nil, err
+	}
+	var entries []*OutboxEntry
+	for _, key := range keys {
+		data, err := store.Get(key)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		e := &OutboxEntry{}
+		if err = json.Unmarshal(data, e); err != nil {
+			return nil, fmt.Errorf("outbox action '%s': %w", key, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// run dispatches actions as they are committed or fall due, until ctx is
+// done.
+func (o *Outbox) run(ctx context.Context) {
+	for {
+		wait := outboxPoll
+		if next := o.dispatch(ctx); !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-o.wake:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// dispatch runs every action which is due, returning when the next of the
+// others is.
+func (o *Outbox) dispatch(ctx context.Context) (next time.Time) {
+	s := o.server
+	entries, err := o.Pending()
+	if err != nil {
+		s.logf(LogError, "error listing outbox actions: %v\n", err)
+		return
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Now().Before(e.Next) {
+			if next.IsZero() || e.Next.Before(next) {
+				next = e.Next
+			}
+			continue
+		}
+		err := o.do(ctx, e)
+		if err == nil {
+			if err = o.store().Delete(outboxKey(e.ID)); err != nil {
+				s.logf(LogError, "error removing outbox action '%s': %v\n", e.ID, err)
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.Attempts++
+		if e.Attempts >= o.attempts() {
+			s.logf(LogError, "giving up outbox action '%s' after %d attempts: %v\n", e.ID, e.Attempts, err)
+			if o.Failed != nil {
+				o.Failed(e, err)
+			}
+			if err := o.store().Delete(outboxKey(e.ID)); err != nil {
+				s.logf(LogError, "error removing outbox action '%s': %v\n", e.ID, err)
+			}
+			continue
+		}
+		delay := o.retryDelay(e.Attempts)
+		s.logf(LogWarn, "outbox action '%s' failed, retrying in %s: %v\n", e.ID, delay, err)
+		e.Next = time.Now().Add(delay)
+		if err := o.save(e); err != nil {
+			s.logf(LogError, "error saving outbox action '%s': %v\n", e.ID, err)
+		}
+		if next.IsZero() || e.Next.Before(next) {
+			next = e.Next
+		}
+	}
+	return next
+}
+
+func (o *Outbox) attempts() int {
+	if o.MaxAttempts <= 0 {
+		return DefaultOutboxAttempts
+	}
+	return o.MaxAttempts
+}
+
+// retryDelay returns the wait after failed attempt number n (from 1).
+func (o *Outbox) retryDelay(n int) time.Duration {
+	delay := o.RetryDelay
+	if delay <= 0 {
+		delay = DefaultOutboxRetryDelay
+	}
+	for i := 1; i < n && delay < maxOutboxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxOutboxRetryDelay {
+		delay = maxOutboxRetryDelay
+	}
+	return delay
+}
+
+// do makes one attempt at the action.
+func (o *Outbox) do(ctx context.Context, e *OutboxEntry) error {
+	switch a := e.Action; {
+	case a.Request != nil && a.Publication == nil:
+		return o.request(ctx, e.ID, a.Request)
+	case a.Publication != nil && a.Request == nil:
+		if o.Publisher == nil {
+			return errors.New("Outbox has no Publisher")
+		}
+		pub := *a.Publication
+		if pub.MessageDeduplicationId == "" {
+			if arn, err := ParseARN(pub.TopicArn); err == nil && arn.FIFO() {
+				// IDs may be longer than the 128 characters SNS allows
+				sum := sha256.Sum256([]byte(e.ID))
+				pub.MessageDeduplicationId = hex.EncodeToString(sum[:])
+			}
+		}
+		_, err := o.Publisher.Send(ctx, &pub)
+		return err
+	}
+	return errors.New("action must have exactly one of Request and Publication")
+}
+
+func (o *Outbox) request(ctx context.Context, id string, or *OutboxRequest) error {
+	method := or.Method
+	if method == "" {
+		method = "POST"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, or.URL, bytes.NewReader(or.Body))
+	if err != nil {
+		return err
+	}
+	for name, values := range or.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set(OutboxIdempotencyHeader, id)
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, or.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,102 @@
+package gosns_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestOutbox(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	fails := 1
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get(gosns.OutboxIdempotencyHeader))
+		if fails > 0 {
+			fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer downstream.Close()
+
+	failed := make(chan *gosns.OutboxEntry, 1)
+	store := &gosns.MemoryStore{}
+	s := &gosns.Server{Outbox: &gosns.Outbox{Store: store, RetryDelay: 10 * time.Millisecond, MaxAttempts: 2,
+		Failed: func(e *gosns.OutboxEntry, err error) { failed <- e }}}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg == nil {
+			return
+		}
+		msg.Enqueue(gosns.OutboxAction{Request: &gosns.OutboxRequest{URL: downstream.URL, Body: []byte(msg.Message)}})
+		if msg.Message == "invalid" {
+			msg.Enqueue(gosns.OutboxAction{})
+		}
+	})
+	s.AddBatchTopic(ordersARN, "/batch", 1, time.Millisecond, func(ctx context.Context, msgs []*gosns.Message) error {
+		msgs[0].Enqueue(gosns.OutboxAction{Request: &gosns.OutboxRequest{URL: downstream.URL}})
+		return errors.New("batch failed")
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Drain(context.Background(), 0)
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, endpoint := range []string{"/batch", "/orders"} {
+		if resp, _, err := ts.Notify(endpoint, ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %v, %v", endpoint, resp, err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		mu.Lock()
+		n := len(keys)
+		mu.Unlock()
+		pending, _ := (&gosns.Outbox{Store: store}).Pending()
+		if n == 2 && len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests made, %d actions pending", n, len(pending))
+		}
+	}
+	mu.Lock()
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("idempotency keys %q", keys)
+	}
+	mu.Unlock()
+
+	// an invalid action is given up without holding back the valid one
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "invalid"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("%v, %v", resp, err)
+	}
+	select {
+	case e := <-failed:
+		if e.Attempts != 2 || e.Action.Request != nil {
+			t.Errorf("gave up %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("invalid action not given up")
+	}
+	mu.Lock()
+	if len(keys) != 3 {
+		t.Errorf("%d requests made", len(keys))
+	}
+	mu.Unlock()
+}
+
+func TestOutboxNeedsStore(t *testing.T) {
+	s := &gosns.Server{Outbox: &gosns.Outbox{}}
+	if err := s.Start(); err == nil {
+		t.Error("Start succeeded without a Store")
+	}
+}
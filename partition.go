@@ -0,0 +1,117 @@
+package gosns
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// partitions lists the AWS partitions, with the DNS suffix of their
+// endpoints and the form of their region names.
+var partitions = []struct {
+	name    string
+	suffix  string
+	regions *regexp.Regexp
+}{
+	{"aws", "amazonaws.com", regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)-[a-z]+-[0-9]+$`)},
+	{"aws-cn", "amazonaws.com.cn", regexp.MustCompile(`^cn-[a-z]+-[0-9]+$`)},
+	{"aws-us-gov", "amazonaws.com", regexp.MustCompile(`^us-gov-[a-z]+-[0-9]+$`)},
+	{"aws-iso", "c2s.ic.gov", regexp.MustCompile(`^us-iso-[a-z]+-[0-9]+$`)},
+	{"aws-iso-b", "sc2s.sgov.gov", regexp.MustCompile(`^us-isob-[a-z]+-[0-9]+$`)},
+	{"aws-iso-e", "cloud.adc-e.uk", regexp.MustCompile(`^eu-isoe-[a-z]+-[0-9]+$`)},
+	{"aws-iso-f", "csp.hci.ic.gov", regexp.MustCompile(`^us-isof-[a-z]+-[0-9]+$`)},
+}
+
+// RegionPartition returns the partition of an AWS region, such as "aws-cn"
+// for cn-north-1 or "aws-us-gov" for us-gov-west-1, or "" if the region is
+// not of a known form.
+func RegionPartition(region string) string {
+	for _, p := range partitions {
+		if p.regions.MatchString(region) {
+			return p.name
+		}
+	}
+	return ""
+}
+
+// RegionDNSSuffix returns the domain of the AWS endpoints in region, such
+// as "amazonaws.com.cn" for cn-north-1, assuming the commercial partition
+// for unknown regions.
+func RegionDNSSuffix(region string) string {
+	for _, p := range partitions {
+		if p.regions.MatchString(region) {
+			return p.suffix
+		}
+	}
+	return "amazonaws.com"
+}
+
+// RegionalEndpoint returns the URL of an AWS service's endpoint in region,
+// such as https://dynamodb.cn-north-1.amazonaws.com.cn/ for "dynamodb".
+func RegionalEndpoint(service, region string) string {
+	return "https://" + service + "." + region + "." + RegionDNSSuffix(region) + "/"
+}
+
+// snsHostRegion returns the region of an SNS endpoint hostname, such as
+// sns.us-gov-west-1.amazonaws.com, reporting whether it is one. The domain
+// must be that of the region's partition.
+func snsHostRegion(host string) (string, bool) {
+	rest := strings.TrimPrefix(host, "sns.")
+	if rest == host {
+		return "", false
+	}
+	region, suffix, ok := strings.Cut(rest, ".")
+	if !ok || RegionPartition(region) == "" || suffix != RegionDNSSuffix(region) {
+		return "", false
+	}
+	return region, true
+}
+
+// ValidateSubscribeURL checks that the SubscribeURL of a confirmation is a
+// ConfirmSubscription request to an SNS regional endpoint over HTTPS, so
+// that a forged confirmation cannot have the server request an arbitrary
+// URL. See Server.CheckSubscribeURL.
+func ValidateSubscribeURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return fmt.Errorf("SubscribeURL '%s' is not https", u)
+	}
+	if _, ok := snsHostRegion(u.Hostname()); !ok {
+		return fmt.Errorf("SubscribeURL '%s' is not an SNS host", u)
+	}
+	if u.Query().Get("Action") != "ConfirmSubscription" {
+		return fmt.Errorf("SubscribeURL '%s' is not a ConfirmSubscription request", u)
+	}
+	return nil
+}
+
+// checkRegion checks that the SNS host serving rawURL for a message from
+// topicARN is in the topic's region and partition, and in one of the
+// server's Regions, if it has any. Hosts which are not SNS endpoints, as
+// accepted by a custom CertCache.ValidateURL or CheckSubscribeURL, are only
+// allowed without Regions.
+func (s *Server) checkRegion(what, rawURL, topicARN string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	region, ok := snsHostRegion(u.Hostname())
+	if !ok {
+		if len(s.Regions) > 0 {
+			return fmt.Errorf("%s '%s' is not an SNS host", what, rawURL)
+		}
+		return nil
+	}
+	if arn, err := ParseARN(topicARN); err == nil && (arn.Region != region || arn.Partition != RegionPartition(region)) {
+		return fmt.Errorf("%s '%s' is not in the region of topic '%s'", what, rawURL, topicARN)
+	}
+	if len(s.Regions) == 0 {
+		return nil
+	}
+	for _, r := range s.Regions {
+		if r == region {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s '%s' is in region %s, not one of %s", what, rawURL, region, strings.Join(s.Regions, ", "))
+}
@@ -0,0 +1,137 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestRegionPartition(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-east-1":       "aws",
+		"eu-central-2":    "aws",
+		"il-central-1":    "aws",
+		"us-gov-west-1":   "aws-us-gov",
+		"us-gov-east-1":   "aws-us-gov",
+		"cn-northwest-1":  "aws-cn",
+		"us-iso-east-1":   "aws-iso",
+		"us-isob-east-1":  "aws-iso-b",
+		"eu-isoe-west-1":  "aws-iso-e",
+		"us-isof-south-1": "aws-iso-f",
+		"moon-base-1":     "",
+		"":                "",
+	} {
+		if got := gosns.RegionPartition(region); got != want {
+			t.Errorf("%q: got %q, want %q", region, got, want)
+		}
+	}
+}
+
+func TestValidateCertURLPartitions(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem":          true,
+		"https://sns.us-gov-west-1.amazonaws.com/SimpleNotificationService-abc.pem":      true,
+		"https://sns.cn-north-1.amazonaws.com.cn/SimpleNotificationService-abc.pem":      true,
+		"https://sns.us-iso-east-1.c2s.ic.gov/SimpleNotificationService-abc.pem":         true,
+		"https://sns.us-isob-east-1.sc2s.sgov.gov/SimpleNotificationService-abc.pem":     true,
+		"https://sns.cn-north-1.amazonaws.com/SimpleNotificationService-abc.pem":         false,
+		"https://sns.us-east-1.amazonaws.com.cn/SimpleNotificationService-abc.pem":       false,
+		"https://sns.us-gov-west-1.c2s.ic.gov/SimpleNotificationService-abc.pem":         false,
+		"https://sns.evil.amazonaws.com/SimpleNotificationService-abc.pem":               false,
+		"https://sns.us-east-1.amazonaws.com.evil.example/SimpleNotificationService.pem": false,
+		"http://sns.us-gov-west-1.amazonaws.com/SimpleNotificationService-abc.pem":       false,
+	} {
+		u, _ := url.Parse(raw)
+		if err := gosns.ValidateCertURL(u); (err == nil) != ok {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+}
+
+func TestValidateSubscribeURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://sns.us-gov-west-1.amazonaws.com/?Action=ConfirmSubscription&TopicArn=x&Token=y": true,
+		"https://sns.cn-north-1.amazonaws.com.cn/?Action=ConfirmSubscription&Token=y":            true,
+		"https://sns.us-gov-west-1.amazonaws.com/?Action=Unsubscribe":                            false,
+		"http://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription":                         false,
+		"https://169.254.169.254/?Action=ConfirmSubscription":                                    false,
+	} {
+		u, _ := url.Parse(raw)
+		if err := gosns.ValidateSubscribeURL(u); (err == nil) != ok {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+}
+
+func TestRegionChecks(t *testing.T) {
+	const gov = "arn:aws-us-gov:sns:us-gov-west-1:123456789012:orders"
+	s := &gosns.Server{}
+	confirmed := false
+	s.AddTopic(gov, "/orders", func(msg *gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	send := func(e *gosnstest.Envelope) int {
+		req, err := gosnstest.NewClientRequest(ts.URL+"/orders", e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// a SubscribeURL on SNS in another region is never visited
+	e := gosnstest.NewSubscriptionConfirmation(gov, "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=x")
+	if code := send(e); code != http.StatusForbidden {
+		t.Errorf("cross-region SubscribeURL gave %d", code)
+	}
+
+	// as is a signing certificate
+	s.VerifySignatures = true
+	e = gosnstest.NewNotification(gov, "", "hello")
+	e.Signature, e.SignatureVersion = "c2ln", "2"
+	e.SigningCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem"
+	if code := send(e); code != http.StatusForbidden {
+		t.Errorf("cross-region certificate gave %d", code)
+	}
+	s.VerifySignatures = false
+
+	// with Regions pinned, only SNS hosts in them are accepted
+	s.Regions = []string{"us-gov-east-1"}
+	if resp, _, err := ts.Confirm("/orders", gov); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("pinned confirmation gave %v, %v", resp, err)
+	}
+	s.Regions = nil
+	s.CheckSubscribeURL = func(u *url.URL) error {
+		confirmed = true
+		return nil
+	}
+	if resp, _, err := ts.Confirm("/orders", gov); err != nil || resp.StatusCode != http.StatusOK || !confirmed {
+		t.Errorf("confirmation gave %v, %v, checked %v", resp, err, confirmed)
+	}
+	s.CheckSubscribeURL = gosns.ValidateSubscribeURL
+	if resp, _, err := ts.Confirm("/orders", gov); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("local SubscribeURL gave %v, %v", resp, err)
+	}
+}
+
+func TestRegionalEndpoint(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-east-1":     "https://dynamodb.us-east-1.amazonaws.com/",
+		"us-gov-west-1": "https://dynamodb.us-gov-west-1.amazonaws.com/",
+		"cn-north-1":    "https://dynamodb.cn-north-1.amazonaws.com.cn/",
+		"us-iso-east-1": "https://dynamodb.us-iso-east-1.c2s.ic.gov/",
+		"moon-base-1":   "https://dynamodb.moon-base-1.amazonaws.com/",
+	} {
+		if got := gosns.RegionalEndpoint("dynamodb", region); got != want {
+			t.Errorf("%q: got %q, want %q", region, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gosns
+
+import (
+	"context"
+	"strings"
+)
+
+// PathValue returns the value of the named parameter in the endpoint of the
+// topic which received the message that ctx belongs to (see
+// Message.Context), or "" if there is none. A topic added at
+// "/hooks/{tenant}/orders" receives requests for "/hooks/acme/orders" with
+// the tenant parameter "acme".
+func PathValue(ctx context.Context, name string) string {
+	values, _ := ctx.Value(pathValuesKey).(map[string]string)
+	return values[name]
+}
+
+// parsePattern returns the segments of an endpoint containing parameters,
+// or nil for a plain endpoint. A parameter is a whole segment "{name}".
+func parsePattern(endpoint string) []string {
+	if !strings.Contains(endpoint, "{") {
+		return nil
+	}
+	return strings.Split(endpoint, "/")
+}
+
+func isParam(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// matchPattern returns the parameters of path if it matches pattern.
+func matchPattern(pattern []string, path string) (map[string]string, bool) {
+	segments := strings.Split(path, "/")
+	if len(segments) != len(pattern) {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for i, p := range pattern {
+		switch {
+		case isParam(p):
+			if segments[i] == "" {
+				return nil, false
+			}
+			values[p[1:len(p)-1]] = segments[i]
+		case p != segments[i]:
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// literals counts the segments of pattern which are not parameters.
+func literals(pattern []string) int {
+	n := 0
+	for _, p := range pattern {
+		if !isParam(p) {
+			n++
+		}
+	}
+	return n
+}
+
+// matchTopic returns the topic with a parameterized endpoint matching path,
+// and the parameters. Where several match, the one with the most literal
+// segments wins, then the first in sorted order.
+func (s *Server) matchTopic(path string) (*Topic, map[string]string) {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	var best *Topic
+	var bestValues map[string]string
+	for endpoint, td := range s.topics {
+		if td.pattern == nil {
+			continue
+		}
+		values, ok := matchPattern(td.pattern, path)
+		if !ok {
+			continue
+		}
+		if best == nil || literals(td.pattern) > literals(best.pattern) ||
+			literals(td.pattern) == literals(best.pattern) && endpoint < best.endpoint {
+			best, bestValues = td, values
+		}
+	}
+	return best, bestValues
+}
@@ -0,0 +1,57 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestPathParameters(t *testing.T) {
+	s := &gosns.Server{}
+	got := make(chan string, 4)
+	s.AddTopic(ordersARN, "/hooks/{tenant}/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- gosns.PathValue(msg.Context(), "tenant") + " " + gosns.EndpointPath(msg.Context())
+		}
+	})
+	s.AddTopic(ordersARN, "/hooks/{tenant}/{kind}", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- "general " + gosns.PathValue(msg.Context(), "kind")
+		}
+	})
+	s.AddTopic(ordersARN, "/hooks/internal/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- "internal"
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for path, want := range map[string]string{
+		"/hooks/acme/orders":     "acme /hooks/acme/orders",
+		"/hooks/globex/orders":   "globex /hooks/globex/orders",
+		"/hooks/acme/refunds":    "general refunds",
+		"/hooks/internal/orders": "internal",
+	} {
+		resp, _, err := ts.Notify(path, ordersARN, "", "hello")
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %v, %v", path, resp, err)
+		}
+		select {
+		case g := <-got:
+			if g != want {
+				t.Errorf("%s: got %q, want %q", path, g, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: callback not called", path)
+		}
+	}
+	for _, path := range []string{"/hooks//orders", "/hooks/acme/orders/extra"} {
+		if resp, _, err := ts.Notify(path, ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: %v, %v", path, resp, err)
+		}
+	}
+}
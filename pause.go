@@ -0,0 +1,147 @@
+package gosns
+
+import (
+	"net/http"
+	"time"
+)
+
+// PauseMode is what a paused topic does with notifications.
+type PauseMode int
+
+const (
+	// PauseRefuse refuses notifications with ResponsePaused, a 503, so that
+	// SNS retries them according to the subscription's delivery policy.
+	PauseRefuse PauseMode = iota
+	// PauseHold acknowledges notifications and holds them in memory until
+	// the topic is resumed. They are lost if the process exits first.
+	PauseHold
+)
+
+// DefaultMaxHeld is the number of messages a paused topic holds in
+// PauseHold mode when its MaxHeld is zero.
+const DefaultMaxHeld = 1000
+
+// Pause suspends the handling of the topic's notifications, such as while
+// something only its callback depends on is down, leaving other topics
+// running. What happens to notifications meanwhile depends on PauseMode.
+// Confirmations and health probes are answered as usual.
+func (t *Topic) Pause() {
+	t.pauseMu.Lock()
+	was := t.paused
+	t.paused = true
+	t.pauseMu.Unlock()
+	if !was {
+		t.server.logf(LogWarn, "Pausing topic '%s' at endpoint '%s'\n", t.TopicARN, t.endpoint)
+	}
+}
+
+// Resume ends a Pause, passing any held messages to the callback in the
+// order they arrived.
+func (t *Topic) Resume() {
+	t.pauseMu.Lock()
+	was, held := t.paused, t.held
+	t.paused, t.held = false, nil
+	t.pauseMu.Unlock()
+	if !was {
+		return
+	}
+	t.server.logf(LogInfo, "Resuming topic '%s' at endpoint '%s' with %d held messages\n", t.TopicARN, t.endpoint, len(held))
+	if len(held) > 0 {
+		go t.release(held)
+	}
+}
+
+// Paused reports whether the topic is paused.
+func (t *Topic) Paused() bool {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	return t.paused
+}
+
+// refusing reports whether the topic is paused in PauseRefuse mode.
+func (t *Topic) refusing() bool {
+	return t.PauseMode == PauseRefuse && t.Paused()
+}
+
+// hold keeps msg until Resume if the topic is paused, reporting whether it
+// did. It returns ErrPaused if the topic is paused but cannot hold msg.
+func (t *Topic) hold(msg *Message) (bool, error) {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	if !t.paused {
+		return false, nil
+	}
+	max := t.MaxHeld
+	if max <= 0 {
+		max = DefaultMaxHeld
+	}
+	if t.PauseMode != PauseHold || len(t.held) >= max {
+		return false, ErrPaused
+	}
+	t.held = append(t.held, msg)
+	return true, nil
+}
+
+// release dispatches messages held while the topic was paused, waiting
+// while the topic is overloaded, as they have already been acknowledged.
+// If the topic is paused again, the rest are held once more.
+func (t *Topic) release(msgs []*Message) {
+	for i, msg := range msgs {
+		for {
+			t.pauseMu.Lock()
+			if t.paused {
+				t.held = append(append([]*Message(nil), msgs[i:]...), t.held...)
+				t.pauseMu.Unlock()
+				return
+			}
+			t.pauseMu.Unlock()
+			if t.dispatch(msg) {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// Pause pauses the topic registered at endpoint; see Topic.Pause. It
+// returns ErrTopicNotFound if there is none.
+func (s *Server) Pause(endpoint string) error {
+	td, ok := s.topic(endpoint)
+	if !ok {
+		return ErrTopicNotFound
+	}
+	td.Pause()
+	return nil
+}
+
+// Resume resumes the topic registered at endpoint; see Topic.Resume. It
+// returns ErrTopicNotFound if there is none.
+func (s *Server) Resume(endpoint string) error {
+	td, ok := s.topic(endpoint)
+	if !ok {
+		return ErrTopicNotFound
+	}
+	td.Resume()
+	return nil
+}
+
+func (s *Server) adminPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	endpoint := r.FormValue("endpoint")
+	var err error
+	if r.URL.Path == "/resume" {
+		err = s.Resume(endpoint)
+	} else {
+		err = s.Pause(endpoint)
+	}
+	if err != nil {
+		simpleResponse(w, http.StatusNotFound, "not found")
+		return
+	}
+	td, _ := s.topic(endpoint)
+	writeJSON(w, http.StatusOK, map[string]bool{"Paused": td.Paused()})
+}
@@ -0,0 +1,78 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestPauseRefuse(t *testing.T) {
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	s.AddTopic("arn:aws:sns:us-east-1:123456789012:prices", "/prices", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/pause?endpoint=/orders", nil))
+	if rec.Code != http.StatusOK || !orders.Paused() {
+		t.Fatalf("admin gave %d %s", rec.Code, rec.Body)
+	}
+	resp, _, err := ts.Notify("/orders", ordersARN, "", "hello")
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(gosns.ReasonHeader) != gosns.ReasonPaused {
+		t.Errorf("paused topic gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Notify("/prices", "arn:aws:sns:us-east-1:123456789012:prices", "", "1.00"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("other topic gave %v, %v", resp, err)
+	}
+
+	if err := s.Resume("/orders"); err != nil || orders.Paused() {
+		t.Fatalf("resume gave %v", err)
+	}
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "hello"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("resumed topic gave %v, %v", resp, err)
+	}
+	if err := s.Pause("/missing"); err != gosns.ErrTopicNotFound {
+		t.Errorf("pausing a missing topic gave %v", err)
+	}
+}
+
+func TestPauseHold(t *testing.T) {
+	got := make(chan string, 4)
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	orders.PauseMode, orders.MaxHeld = gosns.PauseHold, 1
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	orders.Pause()
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "first"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("held message gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "second"); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("message over MaxHeld gave %v, %v", resp, err)
+	}
+	select {
+	case msg := <-got:
+		t.Fatalf("callback got %q while paused", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	orders.Resume()
+	select {
+	case msg := <-got:
+		if msg != "first" {
+			t.Errorf("released %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("held message was not released")
+	}
+}
@@ -0,0 +1,91 @@
+// Package payload renders SNS messages into the bodies forwarding sinks
+// send, so that a destination receives the shape it expects without code
+// being written for it. A payload is a text/template or, for JSON bodies, a
+// query written as an expression of package expr. Templates see the message
+// as Data does, and queries see the same fields as msg:
+//
+//	{"text": {{json .subject}}, "order": {{json .body.order.id}}, "env": "{{.attributes.env}}"}
+//	{text: msg.subject, order: msg.body.order.id, env: msg.attributes.env}
+//
+// Render is the signature of the Encode fields of the sinks, such as
+// fanout.Destination, shard.Pool and firehose.Stream:
+//
+//	f, err := payload.Parse(`expr:{id: msg.id, items: msg.body.items[*].sku}`)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	pool := &shard.Pool{Workers: workers, Encode: f.Render}
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+)
+
+// QueryPrefix marks the source of a query for Parse.
+const QueryPrefix = "expr:"
+
+// Format renders a message as a body.
+type Format interface {
+	Render(msg *gosns.Message) ([]byte, error)
+}
+
+// Parse returns the Format of src: a Query if src begins with QueryPrefix,
+// and a Template otherwise.
+func Parse(src string) (Format, error) {
+	if q, ok := strings.CutPrefix(src, QueryPrefix); ok {
+		return ParseQuery(q)
+	}
+	return NewTemplate("payload", src)
+}
+
+// Data returns what templates see of msg: the message as package expr sees
+// it, with the fields subject, message, id, topic, topicName (the name of
+// the topic), timestamp (RFC 3339), attributes (by name; Number attributes
+// are numbers) and body (the message parsed as JSON, or nil).
+func Data(msg *gosns.Message) map[string]interface{} {
+	return expr.Env(msg)["msg"].(map[string]interface{})
+}
+
+// Template is a payload rendered by a text/template.
+type Template struct {
+	tmpl *template.Template
+}
+
+// NewTemplate parses a template. Besides the text/template builtins, the
+// function json formats a value as JSON.
+func NewTemplate(name, src string) (*Template, error) {
+	t, err := template.New(name).Funcs(template.FuncMap{"json": toJSON}).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{t}, nil
+}
+
+// MustTemplate is like NewTemplate but panics if the template is invalid.
+func MustTemplate(name, src string) *Template {
+	t, err := NewTemplate(name, src)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// Render executes the template with the Data of msg.
+func (t *Template) Render(msg *gosns.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, Data(msg)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
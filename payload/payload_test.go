@@ -0,0 +1,84 @@
+package payload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+var msg = &gosns.Message{
+	MessageId: "m-1",
+	TopicArn:  "arn:aws:sns:us-east-1:123456789012:orders",
+	Subject:   "Order created",
+	Message:   `{"order": {"id": "o-1", "total": 150, "items": [{"sku": "A", "qty": 2}, {"sku": "B", "qty": 1}]}, "note": null}`,
+	Timestamp: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	MessageAttributes: map[string]gosns.MessageAttribute{
+		"env":      {Type: "String", Value: "prod"},
+		"priority": {Type: "Number", Value: "3"},
+	},
+}
+
+func TestTemplate(t *testing.T) {
+	f, err := Parse(`{"text": {{json .subject}}, "order": {{json .body.order.id}}, "topic": "{{.topicName}}", "env": "{{.attributes.env}}", "at": "{{.timestamp}}"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.Render(msg)
+	want := `{"text": "Order created", "order": "o-1", "topic": "orders", "env": "prod", "at": "2026-10-14T12:00:00Z"}`
+	if err != nil || string(got) != want {
+		t.Errorf("got %s, %v", got, err)
+	}
+	if _, err := NewTemplate("bad", "{{.subject"); err == nil {
+		t.Error("invalid template parsed")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	for _, tc := range []struct{ src, want string }{
+		{`msg.subject`, `"Order created"`},
+		{`msg.topicName`, `"orders"`},
+		{`msg.body.order.id`, `"o-1"`},
+		{`msg.body['order'].total`, `150`},
+		{`msg.attributes.priority`, `3`},
+		{`msg.body.order.items[0].sku`, `"A"`},
+		{`msg.body.order.items[5]`, `null`},
+		{`msg.body.order.items[*].sku`, `["A","B"]`},
+		{`{id: msg.id, subject: msg.subject, order: msg.body.order.id, 'total due': msg.body.order.total}`, `{"id":"m-1","order":"o-1","subject":"Order created","total due":150}`},
+		{`{text: msg.body.note || msg.subject}`, `{"text":"Order created"}`},
+		{`msg.missing.deeper || 'none'`, `"none"`},
+		{`[msg.body.order.items[0].sku, msg.id]`, `["A","m-1"]`},
+		{`len(msg.body.order.items)`, `2`},
+		{`keys(msg.body.order)`, `["id","items","total"]`},
+		{`[string(msg.body.order.total), number('12'), lower(msg.subject)]`, `["150",12,"order created"]`},
+		{`{a: 1, b: -2.5e1, c: true, d: nil, e: []}`, `{"a":1,"b":-25,"c":true,"d":null,"e":[]}`},
+	} {
+		q, err := ParseQuery(tc.src)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+			continue
+		}
+		if got, err := q.Render(msg); err != nil || string(got) != tc.want {
+			t.Errorf("%s: got %s, %v; want %s", tc.src, got, err, tc.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, src := range []string{``, `msg.`, `{a: }`, `[msg.a`, `{1: 2}`, `'open`, `nosuch(1)`, `msg.a ~ msg.b`} {
+		if _, err := ParseQuery(src); err == nil {
+			t.Errorf("%q parsed", src)
+		}
+	}
+	q, err := ParseQuery(`{total: msg.body.order.total / 0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := q.Render(msg); err == nil {
+		t.Errorf("got %s", out)
+	}
+
+	if f, err := Parse("expr:{id: msg.id}"); err != nil || f.(*Query).String() != "{id: msg.id}" {
+		t.Errorf("got %v, %v", f, err)
+	}
+}
@@ -0,0 +1,43 @@
+package payload
+
+import (
+	"encoding/json"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/expr"
+)
+
+// Query is a payload built by an expression of package expr, whose value is
+// sent as JSON. Lists and objects give the body its shape, || picks a
+// default and [*] applies what follows it to each element of a list:
+//
+//	{id: msg.id, text: msg.body.note || msg.subject, skus: msg.body.items[*].sku}
+type Query struct {
+	expr *expr.Expr
+}
+
+// ParseQuery parses a query.
+func ParseQuery(src string) (*Query, error) {
+	e, err := expr.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Query{e}, nil
+}
+
+// String returns the source of the query.
+func (q *Query) String() string { return q.expr.String() }
+
+// Eval returns the value of the query for msg.
+func (q *Query) Eval(msg *gosns.Message) (interface{}, error) {
+	return q.expr.Eval(msg)
+}
+
+// Render returns the query's value for msg as JSON.
+func (q *Query) Render(msg *gosns.Message) ([]byte, error) {
+	v, err := q.Eval(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,246 @@
+package gosns
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPingPath is a PingPath unlikely to collide with topic endpoints.
+const DefaultPingPath = "/__gosns/ping"
+
+// pingTimeout limits how long a ping waits for its callback.
+const pingTimeout = 5 * time.Second
+
+const (
+	pingTopicARN        = "arn:aws:sns:us-east-1:000000000000:gosns-ping"
+	pingSubscriptionARN = pingTopicARN + ":ping"
+	pingCertURL         = "https://gosns.invalid/ping.pem"
+)
+
+// PingResult is the outcome of a ping, as answered at PingPath.
+type PingResult struct {
+	OK        bool
+	MessageId string
+	Duration  time.Duration
+	Error     string `json:",omitempty"`
+}
+
+// pinger sends synthetic notifications to a topic of its own, which is not
+// registered and so cannot be reached from outside, and which only accepts
+// signatures from the pinger's key.
+type pinger struct {
+	key   *rsa.PrivateKey
+	topic *Topic
+
+	mu      sync.Mutex
+	waiting map[string]chan struct{} // by MessageId
+}
+
+func newPinger(s *Server) (*pinger, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gosns ping"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(100, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pinger{key: key, waiting: make(map[string]chan struct{})}
+	p.topic = s.newTopic(pingTopicARN, s.PingPath, p.delivered, nil)
+	p.topic.certs = &CertCache{
+		ValidateURL: func(u *url.URL) error {
+			if u.String() != pingCertURL {
+				return errors.New("not the ping certificate")
+			}
+			return nil
+		},
+		Client: &http.Client{Transport: pingCert(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))},
+	}
+	p.topic.subscriptions = map[string]bool{pingSubscriptionARN: true}
+	return p, nil
+}
+
+// pingCert serves the ping certificate to the ping topic's CertCache.
+type pingCert []byte
+
+func (c pingCert) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(c)),
+		Request:    r,
+	}, nil
+}
+
+// notification returns a signed notification body with the given MessageId.
+func (p *pinger) notification(id string) ([]byte, error) {
+	env := &envelope{
+		Type:             "Notification",
+		MessageId:        id,
+		TopicArn:         pingTopicARN,
+		Subject:          "gosns ping",
+		Message:          "ping",
+		Timestamp:        time.Now().UTC().Format(amzTimeFormat),
+		SignatureVersion: "2",
+		SigningCertURL:   pingCertURL,
+	}
+	h := sha256.Sum256([]byte(env.stringToSign()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		return nil, err
+	}
+	env.Signature = base64.StdEncoding.EncodeToString(sig)
+	return json.Marshal(env)
+}
+
+// wait returns a channel closed once the message with the given id reaches
+// the callback.
+func (p *pinger) wait(id string) chan struct{} {
+	ch := make(chan struct{})
+	p.mu.Lock()
+	p.waiting[id] = ch
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *pinger) cancel(id string) {
+	p.mu.Lock()
+	delete(p.waiting, id)
+	p.mu.Unlock()
+}
+
+func (p *pinger) delivered(msg *Message) {
+	if msg == nil {
+		return
+	}
+	p.mu.Lock()
+	ch := p.waiting[msg.MessageId]
+	delete(p.waiting, msg.MessageId)
+	p.mu.Unlock()
+	if ch != nil {
+		close(ch)
+	}
+}
+
+// pingRecorder collects the ping topic's response.
+type pingRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rec *pingRecorder) Header() http.Header { return rec.header }
+
+func (rec *pingRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+}
+
+func (rec *pingRecorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// Ping passes a synthetic notification through the server as PingPath
+// does, reporting whether it reached its callback. The first ping generates
+// the signing key, which takes a moment.
+func (s *Server) Ping(ctx context.Context) PingResult {
+	start := time.Now()
+	res := PingResult{MessageId: randomHex(16)}
+	if err := s.sendPing(ctx, res.MessageId); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.OK = true
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (s *Server) sendPing(ctx context.Context, id string) error {
+	if s.Draining() {
+		return errors.New("server is draining")
+	}
+	s.pingOnce.Do(func() {
+		s.ping, s.pingErr = newPinger(s)
+	})
+	if s.pingErr != nil {
+		return s.pingErr
+	}
+	p := s.ping
+	body, err := p.notification(id)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(withRequestID(ctx, RequestID(ctx)), "POST", p.topic.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	r.Header.Set("x-amz-sns-message-type", "Notification")
+	r.Header.Set("x-amz-sns-message-id", id)
+	r.Header.Set("x-amz-sns-topic-arn", pingTopicARN)
+	r.Header.Set("x-amz-sns-subscription-arn", pingSubscriptionARN)
+
+	delivered := p.wait(id)
+	defer p.cancel(id)
+	rec := &pingRecorder{header: make(http.Header)}
+	s.serveTopic(rec, r, p.topic)
+	if rec.code != http.StatusOK {
+		return fmt.Errorf("notification refused with %d: %s", rec.code, strings.TrimSpace(rec.body.String()))
+	}
+	timer := time.NewTimer(pingTimeout)
+	defer timer.Stop()
+	select {
+	case <-delivered:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("callback not reached within %s", pingTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// servePing answers a request to PingPath.
+func (s *Server) servePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.reject(w, r, ResponseMethodNotAllowed, ReasonMethodNotAllowed)
+		return
+	}
+	res := s.Ping(r.Context())
+	code := http.StatusOK
+	if !res.OK {
+		s.reqLogf(r, LogWarn, "ping failed: %s\n", res.Error)
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, res)
+}
@@ -0,0 +1,45 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func ping(t *testing.T, s *gosns.Server) (int, gosns.PingResult) {
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	resp, err := ts.Client().Get(ts.URL + gosns.DefaultPingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res gosns.PingResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, res
+}
+
+func TestPing(t *testing.T) {
+	s := &gosns.Server{Strict: true, PingPath: gosns.DefaultPingPath}
+	if code, res := ping(t, s); code != http.StatusOK || !res.OK || res.MessageId == "" {
+		t.Errorf("ping gave %d %+v", code, res)
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	resp, err := ts.Client().Post(ts.URL+gosns.DefaultPingPath, "text/plain", strings.NewReader("{}"))
+	if err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST to the ping path gave %v, %v", resp, err)
+	}
+
+	// a pipeline refusing SNS's Content-Type fails the ping
+	s = &gosns.Server{PingPath: gosns.DefaultPingPath, ContentTypeCheck: gosns.ContentTypeStrict, ContentTypes: []string{"application/json"}}
+	if code, res := ping(t, s); code != http.StatusServiceUnavailable || res.OK || !strings.Contains(res.Error, "415") {
+		t.Errorf("ping gave %d %+v", code, res)
+	}
+}
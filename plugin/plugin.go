@@ -0,0 +1,441 @@
+// Package plugin runs message processors written in any language as
+// subprocesses, speaking JSON lines over stdin and stdout.
+//
+// For each request the process reads one line from stdin and writes one line
+// to stdout, in order. Requests are
+//
+//	{"id": 1, "type": "message", "message": {...}}
+//	{"id": 2, "type": "batch", "messages": [{...}, ...]}
+//	{"id": 3, "type": "ping"}
+//
+// and responses echo the id:
+//
+//	{"id": 1, "action": "keep", "message": {...}}
+//	{"id": 1, "action": "drop"}
+//	{"id": 2, "action": "keep", "messages": [{...}, ...]}
+//	{"id": 3, "action": "pong"}
+//	{"id": 1, "error": "could not parse order"}
+//
+// A message has the fields subject, message, messageId, topicArn, timestamp
+// (RFC 3339), attributes (an object of {"type": ..., "value": ...}) and,
+// with gosns.Server.HashBodies, bodySha256. A "keep" response without a
+// message leaves the message unchanged. Anything the process writes to
+// stderr is logged. A minimal Python processor:
+//
+//	import json, sys
+//	for line in sys.stdin:
+//	    req = json.loads(line)
+//	    resp = {"id": req["id"], "action": "keep"}
+//	    if req["type"] == "ping":
+//	        resp["action"] = "pong"
+//	    elif req["type"] == "message":
+//	        req["message"]["subject"] = req["message"]["subject"].upper()
+//	        resp["message"] = req["message"]
+//	    print(json.dumps(resp), flush=True)
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// Defaults used when the corresponding Process fields are zero.
+const (
+	DefaultTimeout        = 10 * time.Second
+	DefaultHealthInterval = 30 * time.Second
+	DefaultRestartDelay   = time.Second
+)
+
+// ErrClosed is returned for requests made after Close.
+var ErrClosed = errors.New("plugin: process closed")
+
+// Process is an external processor. It is started on first use, health
+// checked while running, and restarted if it exits, stops answering within
+// Timeout, or writes something which is not a response. Requests are sent one
+// at a time.
+type Process struct {
+	// Command and Args are the program to run and its arguments. Env and Dir
+	// are passed to exec.Cmd.
+	Command string
+	Args    []string
+	Env     []string
+	Dir     string
+
+	// Timeout limits how long each request may take. Zero means
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// HealthInterval is how often an idle process is pinged. Zero means
+	// DefaultHealthInterval; a negative value disables health checks.
+	HealthInterval time.Duration
+
+	// RestartDelay is the minimum time between starts of the process, so
+	// that a crashing processor is not restarted in a tight loop. Zero means
+	// DefaultRestartDelay.
+	RestartDelay time.Duration
+
+	// Logger receives the process's stderr and restart notices, if set.
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	running   *child
+	lastStart time.Time
+	nextID    int64
+	closed    bool
+	stopPing  chan struct{}
+}
+
+type child struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan response
+	done      chan struct{} // closed when stdout ends
+	quit      chan struct{} // closed by stop
+	stopOnce  sync.Once
+}
+
+// Message is the wire form of a gosns.Message.
+type Message struct {
+	Subject    string                      `json:"subject"`
+	Message    string                      `json:"message"`
+	MessageId  string                      `json:"messageId"`
+	TopicArn   string                      `json:"topicArn,omitempty"`
+	Timestamp  string                      `json:"timestamp,omitempty"`
+	Attributes map[string]MessageAttribute `json:"attributes,omitempty"`
+	BodySHA256 string                      `json:"bodySha256,omitempty"`
+}
+
+// MessageAttribute is the wire form of a gosns.MessageAttribute.
+type MessageAttribute struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type request struct {
+	ID       int64      `json:"id"`
+	Type     string     `json:"type"`
+	Message  *Message   `json:"message,omitempty"`
+	Messages []*Message `json:"messages,omitempty"`
+}
+
+type response struct {
+	ID       int64      `json:"id"`
+	Action   string     `json:"action"`
+	Message  *Message   `json:"message"`
+	Messages []*Message `json:"messages"`
+	Error    string     `json:"error"`
+}
+
+// NewMessage returns the wire form of msg.
+func NewMessage(msg *gosns.Message) *Message {
+	m := &Message{
+		Subject:    msg.Subject,
+		Message:    msg.Message,
+		MessageId:  msg.MessageId,
+		TopicArn:   msg.TopicArn,
+		BodySHA256: msg.BodySHA256,
+	}
+	if !msg.Timestamp.IsZero() {
+		m.Timestamp = msg.Timestamp.Format(time.RFC3339Nano)
+	}
+	if len(msg.MessageAttributes) > 0 {
+		m.Attributes = make(map[string]MessageAttribute, len(msg.MessageAttributes))
+		for name, a := range msg.MessageAttributes {
+			m.Attributes[name] = MessageAttribute{a.Type, a.Value}
+		}
+	}
+	return m
+}
+
+// Apply copies the fields of m onto msg.
+func (m *Message) Apply(msg *gosns.Message) {
+	msg.Subject = m.Subject
+	msg.Message = m.Message
+	if m.MessageId != "" {
+		msg.MessageId = m.MessageId
+	}
+	if m.TopicArn != "" {
+		msg.TopicArn = m.TopicArn
+	}
+	if tm, err := time.Parse(time.RFC3339Nano, m.Timestamp); err == nil {
+		msg.Timestamp = tm
+	}
+	msg.MessageAttributes = nil
+	if len(m.Attributes) > 0 {
+		msg.MessageAttributes = make(map[string]gosns.MessageAttribute, len(m.Attributes))
+		for name, a := range m.Attributes {
+			msg.MessageAttributes[name] = gosns.MessageAttribute{Type: a.Type, Value: a.Value}
+		}
+	}
+}
+
+// Transform is a gosns.Transformer which sends msg to the process.
+func (p *Process) Transform(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+	resp, err := p.do(ctx, &request{Type: "message", Message: NewMessage(msg)})
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Action {
+	case "drop":
+		return nil, nil
+	case "keep":
+		if resp.Message != nil {
+			resp.Message.Apply(msg)
+		}
+		return msg, nil
+	}
+	return nil, fmt.Errorf("plugin: unknown action %q", resp.Action)
+}
+
+// Batch sends msgs to the process as one request, returning the messages it
+// keeps. It suits the callbacks of gosns.Server.AddBatchTopic.
+func (p *Process) Batch(ctx context.Context, msgs []*gosns.Message) ([]*gosns.Message, error) {
+	req := &request{Type: "batch", Messages: make([]*Message, len(msgs))}
+	for i, msg := range msgs {
+		req.Messages[i] = NewMessage(msg)
+	}
+	resp, err := p.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Action {
+	case "drop":
+		return nil, nil
+	case "keep":
+		if resp.Messages == nil {
+			return msgs, nil
+		}
+	default:
+		return nil, fmt.Errorf("plugin: unknown action %q", resp.Action)
+	}
+
+	byID := make(map[string]*gosns.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[msg.MessageId] = msg
+	}
+	out := make([]*gosns.Message, 0, len(resp.Messages))
+	for _, m := range resp.Messages {
+		msg, ok := byID[m.MessageId]
+		if !ok {
+			msg = &gosns.Message{}
+		}
+		m.Apply(msg)
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// Ping checks that the process is answering, starting it if necessary.
+func (p *Process) Ping(ctx context.Context) error {
+	resp, err := p.do(ctx, &request{Type: "ping"})
+	if err == nil && resp.Action != "pong" {
+		err = fmt.Errorf("plugin: unexpected ping response %q", resp.Action)
+	}
+	return err
+}
+
+// Close stops the process. Requests made afterwards fail with ErrClosed.
+func (p *Process) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.stopPing != nil {
+		close(p.stopPing)
+	}
+	if p.running != nil {
+		p.stop(p.running)
+	}
+	return nil
+}
+
+func (p *Process) logf(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, args...)
+	}
+}
+
+// do sends req and waits for its response, restarting the process as
+// needed. The process is stopped if it fails to answer properly.
+func (p *Process) do(ctx context.Context, req *request) (*response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	c, err := p.child()
+	if err != nil {
+		return nil, err
+	}
+
+	p.nextID++
+	req.ID = p.nextID
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = c.stdin.Write(append(line, '\n')); err != nil {
+		p.stop(c)
+		return nil, fmt.Errorf("plugin: writing request: %v", err)
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case resp := <-c.responses:
+		if resp.ID != req.ID {
+			p.stop(c)
+			return nil, fmt.Errorf("plugin: response for request %d, want %d", resp.ID, req.ID)
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return &resp, nil
+	case <-c.done:
+		p.stop(c)
+		return nil, errors.New("plugin: process exited")
+	case <-timer.C:
+		p.stop(c)
+		return nil, fmt.Errorf("plugin: no response within %s", timeout)
+	case <-ctx.Done():
+		p.stop(c)
+		return nil, ctx.Err()
+	}
+}
+
+// child returns the running process, starting one if there is none.
+func (p *Process) child() (*child, error) {
+	if p.running != nil {
+		return p.running, nil
+	}
+	delay := p.RestartDelay
+	if delay <= 0 {
+		delay = DefaultRestartDelay
+	}
+	if wait := time.Until(p.lastStart.Add(delay)); wait > 0 {
+		time.Sleep(wait)
+	}
+	p.lastStart = time.Now()
+
+	cmd := exec.Command(p.Command, p.Args...)
+	cmd.Env = p.Env
+	cmd.Dir = p.Dir
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin: starting %s: %v", p.Command, err)
+	}
+	c := &child{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan response),
+		done:      make(chan struct{}),
+		quit:      make(chan struct{}),
+	}
+	go c.read(stdout)
+	go p.logStderr(stderr)
+	p.running = c
+	p.logf("plugin: started %s (pid %d)\n", p.Command, cmd.Process.Pid)
+
+	if p.stopPing == nil && p.HealthInterval >= 0 {
+		p.stopPing = make(chan struct{})
+		go p.healthCheck(p.stopPing)
+	}
+	return c, nil
+}
+
+// read decodes responses until stdout is closed or unreadable.
+func (c *child) read(stdout io.Reader) {
+	defer close(c.done)
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for sc.Scan() {
+		var resp response
+		if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+			return
+		}
+		select {
+		case c.responses <- resp:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+func (p *Process) logStderr(stderr io.Reader) {
+	sc := bufio.NewScanner(stderr)
+	for sc.Scan() {
+		p.logf("plugin %s: %s\n", p.Command, sc.Text())
+	}
+}
+
+// stop kills c and forgets it, so the next request starts a new process.
+func (p *Process) stop(c *child) {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		c.stdin.Close()
+		c.cmd.Process.Kill()
+		go c.cmd.Wait()
+	})
+	if p.running == c {
+		p.running = nil
+		if !p.closed {
+			p.logf("plugin: stopped %s, it will be restarted\n", p.Command)
+		}
+	}
+}
+
+// healthCheck pings the process every HealthInterval while it is running.
+func (p *Process) healthCheck(stop chan struct{}) {
+	interval := p.HealthInterval
+	if interval == 0 {
+		interval = DefaultHealthInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		running := p.running != nil
+		p.mu.Unlock()
+		if !running {
+			continue
+		}
+		if err := p.Ping(context.Background()); err != nil && err != ErrClosed {
+			p.logf("plugin: health check of %s failed: %v\n", p.Command, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// TestMain runs the test binary as a processor when it is started by
+// helperProcess.
+func TestMain(m *testing.M) {
+	if os.Getenv("GOSNS_PLUGIN_HELPER") != "" {
+		runHelper()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runHelper upper-cases subjects, and misbehaves for messages asking it to:
+// "crash" exits, "hang" never answers and "bad id" answers with the wrong
+// id. With GOSNS_PLUGIN_HELPER=hang-ping it never answers pings.
+func runHelper() {
+	enc := json.NewEncoder(os.Stdout)
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var req request
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			os.Exit(2)
+		}
+		resp := response{ID: req.ID, Action: "keep"}
+		switch req.Type {
+		case "ping":
+			if os.Getenv("GOSNS_PLUGIN_HELPER") == "hang-ping" {
+				select {}
+			}
+			resp.Action = "pong"
+		case "message":
+			switch req.Message.Message {
+			case "crash":
+				os.Exit(1)
+			case "hang":
+				select {}
+			case "bad id":
+				resp.ID++
+			}
+			req.Message.Subject = strings.ToUpper(req.Message.Subject)
+			resp.Message = req.Message
+		}
+		enc.Encode(&resp)
+	}
+}
+
+func helperProcess(mode string) *Process {
+	return &Process{
+		Command:        os.Args[0],
+		Env:            append(os.Environ(), "GOSNS_PLUGIN_HELPER="+mode),
+		Timeout:        time.Second,
+		HealthInterval: -1,
+		RestartDelay:   time.Millisecond,
+	}
+}
+
+// pid returns the process ID of p's running process, or 0.
+func (p *Process) pid() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running == nil {
+		return 0
+	}
+	return p.running.cmd.Process.Pid
+}
+
+func transform(t *testing.T, p *Process, body string) (*gosns.Message, error) {
+	t.Helper()
+	return p.Transform(context.Background(), &gosns.Message{MessageId: "m-1", Subject: "order", Message: body})
+}
+
+func TestTransform(t *testing.T) {
+	p := helperProcess("1")
+	defer p.Close()
+	msg, err := transform(t, p, "hello")
+	if err != nil || msg.Subject != "ORDER" || msg.Message != "hello" {
+		t.Fatalf("got %+v, %v", msg, err)
+	}
+	if err = p.Ping(context.Background()); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+	kept, err := p.Batch(context.Background(), []*gosns.Message{{MessageId: "a"}, {MessageId: "b"}})
+	if err != nil || len(kept) != 2 {
+		t.Errorf("batch kept %v, %v", kept, err)
+	}
+	p.Close()
+	if _, err = transform(t, p, "hello"); err != ErrClosed {
+		t.Errorf("after Close got %v", err)
+	}
+}
+
+func TestRestartOnCrash(t *testing.T) {
+	p := helperProcess("1")
+	defer p.Close()
+	if _, err := transform(t, p, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	first := p.pid()
+	if _, err := transform(t, p, "crash"); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("crash gave %v", err)
+	}
+	if p.pid() != 0 {
+		t.Error("crashed process is still running")
+	}
+	if msg, err := transform(t, p, "hello"); err != nil || msg.Subject != "ORDER" {
+		t.Fatalf("after the crash got %+v, %v", msg, err)
+	}
+	if p.pid() == first {
+		t.Error("process was not restarted")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	p := helperProcess("1")
+	p.Timeout = 100 * time.Millisecond
+	defer p.Close()
+	start := time.Now()
+	if _, err := transform(t, p, "hang"); err == nil || !strings.Contains(err.Error(), "no response within") {
+		t.Errorf("hang gave %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("timed out after %s", d)
+	}
+	if msg, err := transform(t, p, "hello"); err != nil || msg.Subject != "ORDER" {
+		t.Errorf("after the timeout got %+v, %v", msg, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p.Timeout = time.Minute
+	if _, err := p.Transform(ctx, &gosns.Message{Message: "hang"}); err != context.DeadlineExceeded {
+		t.Errorf("with a context deadline got %v", err)
+	}
+}
+
+func TestResponseIDMismatch(t *testing.T) {
+	p := helperProcess("1")
+	defer p.Close()
+	if _, err := transform(t, p, "bad id"); err == nil || !strings.Contains(err.Error(), "response for request") {
+		t.Errorf("mismatched id gave %v", err)
+	}
+	if p.pid() != 0 {
+		t.Error("process answering out of order is still running")
+	}
+	if msg, err := transform(t, p, "hello"); err != nil || msg.Subject != "ORDER" {
+		t.Errorf("after the mismatch got %+v, %v", msg, err)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	p := helperProcess("hang-ping")
+	p.Timeout = 100 * time.Millisecond
+	p.HealthInterval = 20 * time.Millisecond
+	defer p.Close()
+	if _, err := transform(t, p, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.pid() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("process failing health checks was not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if msg, err := transform(t, p, "hello"); err != nil || msg.Subject != "ORDER" {
+		t.Errorf("after the failed health check got %+v, %v", msg, err)
+	}
+}
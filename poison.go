@@ -0,0 +1,58 @@
+package gosns
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// poisonous reports whether err is a failure to handle the message itself,
+// rather than a refusal, such as for overload, which a retry would cure.
+func poisonous(err error) bool {
+	return errors.Is(err, ErrCallbackFailed) || errors.Is(err, ErrTransformFailed)
+}
+
+// failDelivery answers a delivery of msg whose handling failed with err,
+// counting the failures of the message in a row. Once it has failed the
+// topic's MaxAttempts times, it is dead-lettered and acknowledged, so that
+// SNS stops retrying it.
+func (s *Server) failDelivery(w http.ResponseWriter, r *http.Request, td *Topic, msg *Message, err error) int {
+	attempt := td.failed(msg.MessageId)
+	if td.Quarantine && s.Store != nil {
+		if n := td.storedFailure(msg.MessageId); n > attempt {
+			attempt = n
+		}
+	}
+	if attempt > 1 {
+		td.logf(r, LogWarn, "message %s for topic '%s' has failed %d times in a row\n", msg.MessageId, td.TopicARN, attempt)
+	}
+	if td.MaxAttempts > 0 && attempt >= td.MaxAttempts {
+		td.succeeded(msg.MessageId)
+		td.deadLetter(msg, attempt, err)
+		return s.respondWith(w, r, ResponseOK, "", fmt.Errorf("%w: %w", ErrDeadLettered, err))
+	}
+	if errors.Is(err, ErrCallbackFailed) {
+		return s.rejectFailed(w, r, td, attempt, err)
+	}
+	return s.errorResponse(w, r, td, err)
+}
+
+// deadLetter passes msg, which failed with err on its last attempts
+// deliveries, to quarantine and the topic's DeadLetter hook.
+func (t *Topic) deadLetter(msg *Message, attempts int, err error) {
+	atomic.AddUint64(&t.deadLettered, 1)
+	t.logf(nil, LogError, "dead-lettering message %s for topic '%s' after %d failed deliveries: %v\n", msg.MessageId, t.TopicARN, attempts, err)
+	if t.Quarantine {
+		t.quarantine(msg, attempts, err)
+	}
+	if t.DeadLetter != nil {
+		t.DeadLetter(msg, err)
+	}
+}
+
+// DeadLettered returns the number of messages to the topic given up after
+// MaxAttempts failed deliveries since the server started.
+func (t *Topic) DeadLettered() uint64 {
+	return atomic.LoadUint64(&t.deadLettered)
+}
@@ -0,0 +1,67 @@
+package gosns_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestMaxAttempts(t *testing.T) {
+	var dead []string
+	s := &gosns.Server{}
+	tp := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil && msg.Message == "poison" {
+			msg.Fail(errors.New("cannot parse"))
+		}
+	})
+	tp.Delivery, tp.MaxAttempts = gosns.AtLeastOnce, 3
+	tp.DeadLetter = func(msg *gosns.Message, err error) {
+		if !errors.Is(err, gosns.ErrCallbackFailed) {
+			t.Errorf("dead-lettered with %v", err)
+		}
+		dead = append(dead, msg.MessageId)
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	e := gosnstest.NewNotification(ordersARN, "", "poison")
+	for i, want := range []int{500, 500, 200} {
+		resp, err := ts.Send("/orders", e)
+		if err != nil || resp.StatusCode != want {
+			t.Fatalf("delivery %d gave %v, %v", i+1, resp, err)
+		}
+	}
+	if len(dead) != 1 || dead[0] != e.MessageId || tp.DeadLettered() != 1 {
+		t.Errorf("dead-lettered %q, counted %d", dead, tp.DeadLettered())
+	}
+	if resp, _, err := ts.Notify("/orders", ordersARN, "", "fine"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("good message gave %v, %v", resp, err)
+	}
+}
+
+func TestMaxAttemptsTransform(t *testing.T) {
+	s := &gosns.Server{}
+	tp := s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+	tp.MaxAttempts = 2
+	tp.Transformers = []gosns.Transformer{func(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+		return nil, errors.New("bad schema")
+	}}
+	var got error
+	s.OnResponse = func(r *http.Request, kind gosns.ResponseKind, resp *gosns.Response) { got = resp.Err }
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	e := gosnstest.NewNotification(ordersARN, "", "bad")
+	for i, want := range []int{500, 200} {
+		if resp, err := ts.Send("/orders", e); err != nil || resp.StatusCode != want {
+			t.Fatalf("delivery %d gave %v, %v", i+1, resp, err)
+		}
+	}
+	if !errors.Is(got, gosns.ErrDeadLettered) || !errors.Is(got, gosns.ErrTransformFailed) || tp.DeadLettered() != 1 {
+		t.Errorf("last response for %v", got)
+	}
+}
@@ -0,0 +1,327 @@
+package gosns
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults used when the corresponding Publisher fields are zero.
+const (
+	DefaultPublishAttempts   = 3
+	DefaultPublishRetryDelay = 100 * time.Millisecond
+)
+
+// maxPublishBatch is the most entries SNS accepts in one PublishBatch call.
+const maxPublishBatch = 10
+
+// Publication is a message to publish to a topic.
+type Publication struct {
+	TopicArn          string
+	Subject           string
+	Message           string
+	MessageAttributes map[string]MessageAttribute
+
+	// MessageGroupId and MessageDeduplicationId are used with FIFO topics.
+	// If the topic does not have content-based deduplication, every
+	// publication needs a MessageDeduplicationId.
+	MessageGroupId         string
+	MessageDeduplicationId string
+}
+
+// Publisher publishes messages to SNS topics, retrying throttled and failed
+// requests, and optionally grouping messages into PublishBatch calls. It is
+// safe for concurrent use.
+type Publisher struct {
+	Client *SNSClient
+
+	// BatchSize, if greater than 1, sends messages to the same topic in
+	// batches of up to that many (at most 10). A partial batch is sent once
+	// its oldest message has waited BatchDelay.
+	BatchSize  int
+	BatchDelay time.Duration
+
+	// MaxAttempts is the number of times a message is tried, including the
+	// first. Zero means DefaultPublishAttempts.
+	MaxAttempts int
+
+	// RetryDelay is the wait before the first retry, doubling for each one
+	// after. Zero means DefaultPublishRetryDelay.
+	RetryDelay time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pubBatch
+}
+
+type pubEntry struct {
+	pub  *Publication
+	done chan pubResult
+}
+
+type pubResult struct {
+	messageID string
+	err       error
+}
+
+type pubBatch struct {
+	entries []*pubEntry
+	timer   *time.Timer
+}
+
+// Publish publishes a message to topicARN and returns its MessageId.
+func (p *Publisher) Publish(ctx context.Context, topicARN, subject, body string, attrs map[string]MessageAttribute) (string, error) {
+	return p.Send(ctx, &Publication{TopicArn: topicARN, Subject: subject, Message: body, MessageAttributes: attrs})
+}
+
+// Send publishes pub and returns its MessageId. When batching, Send waits for
+// the batch to be sent; if ctx is done first its error is returned, but the
+// message may still be published.
+func (p *Publisher) Send(ctx context.Context, pub *Publication) (string, error) {
+	if p.BatchSize <= 1 {
+		return p.publishOne(ctx, pub)
+	}
+
+	e := &pubEntry{pub: pub, done: make(chan pubResult, 1)}
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = make(map[string]*pubBatch)
+	}
+	b := p.pending[pub.TopicArn]
+	if b == nil {
+		b = &pubBatch{}
+		p.pending[pub.TopicArn] = b
+	}
+	b.entries = append(b.entries, e)
+	size := p.BatchSize
+	if size > maxPublishBatch {
+		size = maxPublishBatch
+	}
+	var full []*pubEntry
+	if len(b.entries) >= size {
+		full = p.take(pub.TopicArn)
+	} else if b.timer == nil {
+		topicARN := pub.TopicArn
+		b.timer = time.AfterFunc(p.BatchDelay, func() {
+			p.mu.Lock()
+			entries := p.take(topicARN)
+			p.mu.Unlock()
+			p.publishBatch(topicARN, entries)
+		})
+	}
+	p.mu.Unlock()
+	if full != nil {
+		go p.publishBatch(pub.TopicArn, full)
+	}
+
+	select {
+	case res := <-e.done:
+		return res.messageID, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Flush sends every partial batch immediately.
+func (p *Publisher) Flush() {
+	p.mu.Lock()
+	batches := make(map[string][]*pubEntry, len(p.pending))
+	for topicARN := range p.pending {
+		batches[topicARN] = p.take(topicARN)
+	}
+	p.mu.Unlock()
+	for topicARN, entries := range batches {
+		p.publishBatch(topicARN, entries)
+	}
+}
+
+// take removes and returns the pending entries for topicARN. p.mu must be
+// held.
+func (p *Publisher) take(topicARN string) []*pubEntry {
+	b := p.pending[topicARN]
+	if b == nil {
+		return nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	delete(p.pending, topicARN)
+	return b.entries
+}
+
+type publishResult struct {
+	MessageId      string `xml:"PublishResult>MessageId"`
+	SequenceNumber string `xml:"PublishResult>SequenceNumber"`
+}
+
+type publishBatchResult struct {
+	Successful []struct {
+		Id        string
+		MessageId string
+	} `xml:"PublishBatchResult>Successful>member"`
+	Failed []struct {
+		Id          string
+		Code        string
+		Message     string
+		SenderFault bool
+	} `xml:"PublishBatchResult>Failed>member"`
+}
+
+func (p *Publisher) attempts() int {
+	if p.MaxAttempts <= 0 {
+		return DefaultPublishAttempts
+	}
+	return p.MaxAttempts
+}
+
+// backoff waits before retry number n (from 1), returning false if ctx is
+// done first.
+func (p *Publisher) backoff(ctx context.Context, n int) bool {
+	delay := p.RetryDelay
+	if delay <= 0 {
+		delay = DefaultPublishRetryDelay
+	}
+	t := time.NewTimer(delay << uint(n-1))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Publisher) publishOne(ctx context.Context, pub *Publication) (string, error) {
+	var err error
+	for i := 1; i <= p.attempts(); i++ {
+		if i > 1 && !p.backoff(ctx, i-1) {
+			break
+		}
+		params := url.Values{}
+		addPublication(params, "", pub)
+		var res publishResult
+		if err = p.Client.Call(ctx, "Publish", params, &res); err == nil {
+			return res.MessageId, nil
+		}
+		if !retryable(err) {
+			return "", err
+		}
+	}
+	return "", err
+}
+
+// publishBatch sends entries with PublishBatch, retrying those which fail
+// for reasons other than a fault in the request.
+func (p *Publisher) publishBatch(topicARN string, entries []*pubEntry) {
+	ctx := context.Background()
+	for i := 1; len(entries) > 0; i++ {
+		if i > 1 {
+			p.backoff(ctx, i-1)
+		}
+		params := url.Values{"TopicArn": {topicARN}}
+		for j, e := range entries {
+			prefix := "PublishBatchRequestEntries.member." + strconv.Itoa(j+1) + "."
+			params.Set(prefix+"Id", strconv.Itoa(j))
+			addPublication(params, prefix, e.pub)
+		}
+		var res publishBatchResult
+		err := p.Client.Call(ctx, "PublishBatch", params, &res)
+		if err != nil {
+			if i >= p.attempts() || !retryable(err) {
+				for _, e := range entries {
+					e.done <- pubResult{err: err}
+				}
+				return
+			}
+			continue
+		}
+
+		for _, ok := range res.Successful {
+			if j, err := strconv.Atoi(ok.Id); err == nil && j < len(entries) && entries[j] != nil {
+				entries[j].done <- pubResult{messageID: ok.MessageId}
+				entries[j] = nil
+			}
+		}
+		for _, f := range res.Failed {
+			j, err := strconv.Atoi(f.Id)
+			if err != nil || j >= len(entries) || entries[j] == nil {
+				continue
+			}
+			if f.SenderFault || i >= p.attempts() {
+				entries[j].done <- pubResult{err: &SNSError{Action: "PublishBatch", Code: f.Code, Message: f.Message}}
+				entries[j] = nil
+			}
+		}
+		var retry []*pubEntry
+		for _, e := range entries {
+			if e == nil {
+				continue
+			}
+			if i >= p.attempts() {
+				e.done <- pubResult{err: errors.New("gosns: PublishBatch returned no result for message")}
+				continue
+			}
+			retry = append(retry, e)
+		}
+		entries = retry
+	}
+}
+
+// addPublication adds the parameters for pub, other than TopicArn in
+// batches, to params.
+func addPublication(params url.Values, prefix string, pub *Publication) {
+	if prefix == "" {
+		params.Set("TopicArn", pub.TopicArn)
+	}
+	params.Set(prefix+"Message", pub.Message)
+	if pub.Subject != "" {
+		params.Set(prefix+"Subject", pub.Subject)
+	}
+	if pub.MessageGroupId != "" {
+		params.Set(prefix+"MessageGroupId", pub.MessageGroupId)
+	}
+	if pub.MessageDeduplicationId != "" {
+		params.Set(prefix+"MessageDeduplicationId", pub.MessageDeduplicationId)
+	}
+
+	names := make([]string, 0, len(pub.MessageAttributes))
+	for name := range pub.MessageAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		attr := pub.MessageAttributes[name]
+		dataType := attr.Type
+		if dataType == "" {
+			dataType = "String"
+		}
+		ap := prefix + "MessageAttributes.entry." + strconv.Itoa(i+1) + "."
+		params.Set(ap+"Name", name)
+		params.Set(ap+"Value.DataType", dataType)
+		if dataType == "Binary" {
+			params.Set(ap+"Value.BinaryValue", attr.Value)
+		} else {
+			params.Set(ap+"Value.StringValue", attr.Value)
+		}
+	}
+}
+
+// retryable reports whether a failed SNS call may succeed if repeated.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var serr *SNSError
+	if !errors.As(err, &serr) {
+		// network errors
+		return true
+	}
+	switch serr.Code {
+	case "Throttling", "ThrottlingException", "ThrottledException", "InternalError", "InternalFailure", "ServiceUnavailable":
+		return true
+	}
+	return serr.StatusCode >= 500
+}
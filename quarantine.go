@@ -0,0 +1,302 @@
+package gosns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuarantinedMessage is a message given up after MaxAttempts failed
+// deliveries to a topic with Quarantine set, kept until it is requeued.
+type QuarantinedMessage struct {
+	Endpoint string
+	Message  *Message
+	Error    string // from the last failed delivery
+	Attempts int
+	Time     time.Time // when it was quarantined
+}
+
+// storedFailures is the persisted count of a message's failed deliveries.
+type storedFailures struct {
+	Count int
+	Last  time.Time
+}
+
+func quarantineKey(endpoint, messageID string) string {
+	return "quarantine/" + url.PathEscape(endpoint) + "/" + url.PathEscape(messageID)
+}
+
+func failuresKey(endpoint, messageID string) string {
+	return "failures/" + url.PathEscape(endpoint) + "/" + url.PathEscape(messageID)
+}
+
+// quarantineStore returns the Store holding quarantined messages.
+func (s *Server) quarantineStore() Store {
+	if s.Store != nil {
+		return s.Store
+	}
+	return &s.quarantined
+}
+
+// storedFailure counts a failed delivery of a message in the server's
+// Store, so that failures seen by other replicas and previous runs count
+// towards MaxAttempts, returning the number in a row.
+func (t *Topic) storedFailure(id string) int {
+	s := t.server
+	key := failuresKey(t.endpoint, id)
+	var f storedFailures
+	if data, err := s.Store.Get(key); err == nil {
+		json.Unmarshal(data, &f)
+	}
+	f.Count++
+	f.Last = time.Now()
+	data, _ := json.Marshal(&f)
+	if err := s.Store.Put(key, data); err != nil {
+		t.logf(nil, LogError, "error saving failures of message %s: %v\n", id, err)
+	}
+	return f.Count
+}
+
+// forgetFailures removes the stored failures of a message.
+func (t *Topic) forgetFailures(id string) {
+	if !t.Quarantine || t.server == nil || t.server.Store == nil {
+		return
+	}
+	if err := t.server.Store.Delete(failuresKey(t.endpoint, id)); err != nil && err != ErrNotFound {
+		t.logf(nil, LogError, "error removing failures of message %s: %v\n", id, err)
+	}
+}
+
+// sweepFailures removes stored failures of messages SNS has stopped
+// retrying.
+func (s *Server) sweepFailures() error {
+	keys, err := s.Store.List("failures/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		var f storedFailures
+		data, err := s.Store.Get(key)
+		if err == nil && json.Unmarshal(data, &f) == nil && time.Since(f.Last) < 2*maxRetryAfter {
+			continue
+		}
+		s.Store.Delete(key)
+	}
+	return nil
+}
+
+// quarantine saves msg, which failed with err, for Quarantined and Requeue.
+func (t *Topic) quarantine(msg *Message, attempts int, err error) {
+	qm := &QuarantinedMessage{Endpoint: t.endpoint, Message: msg, Error: err.Error(), Attempts: attempts, Time: time.Now().UTC()}
+	data, jerr := json.Marshal(qm)
+	if jerr == nil {
+		jerr = t.server.quarantineStore().Put(quarantineKey(t.endpoint, msg.MessageId), data)
+	}
+	if jerr != nil {
+		t.logf(nil, LogError, "error quarantining message %s: %v\n", msg.MessageId, jerr)
+	}
+}
+
+// Quarantined returns the messages quarantined by the topic at endpoint, or
+// by every topic if endpoint is "", oldest first.
+func (s *Server) Quarantined(endpoint string) ([]*QuarantinedMessage, error) {
+	prefix := "quarantine/"
+	if endpoint != "" {
+		prefix += url.PathEscape(endpoint) + "/"
+	}
+	store := s.quarantineStore()
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*QuarantinedMessage
+	for _, key := range keys {
+		data, err := store.Get(key)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		qm := &QuarantinedMessage{}
+		if err = json.Unmarshal(data, qm); err != nil || qm.Message == nil {
+			s.logf(LogWarn, "skipping unreadable quarantined message '%s'\n", key)
+			continue
+		}
+		msgs = append(msgs, qm)
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Time.Before(msgs[j].Time) })
+	return msgs, nil
+}
+
+// Requeue removes a quarantined message and handles it again, such as once
+// the bug it failed on is fixed. It is transformed and passed to the
+// callback as a new delivery would be, but without the checks for
+// duplicates, age or sampling. If it fails again it is put back in
+// quarantine, and the error returned; in AtMostOnce mode only the failure
+// of a Transformer can be seen.
+func (s *Server) Requeue(endpoint, messageID string) error {
+	td, ok := s.topic(endpoint)
+	if !ok {
+		return ErrTopicNotFound
+	}
+	store := s.quarantineStore()
+	key := quarantineKey(td.endpoint, messageID)
+	data, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	qm := &QuarantinedMessage{}
+	if err = json.Unmarshal(data, qm); err != nil || qm.Message == nil {
+		return errors.New("gosns: unreadable quarantined message")
+	}
+	if err = store.Delete(key); err != nil {
+		return err
+	}
+	qm.Message.ctx = td.withTenant(qm.Message.Context())
+	s.logf(LogInfo, "Requeuing quarantined message %s for topic '%s'\n", messageID, td.TopicARN)
+	if err = td.redeliver(qm.Message); err != nil && !errors.Is(err, ErrOverloaded) {
+		td.quarantine(qm.Message, qm.Attempts+1, err)
+	} else if err != nil {
+		store.Put(key, data)
+	}
+	return err
+}
+
+// RequeueResult is the outcome of requeuing quarantined messages.
+type RequeueResult struct {
+	Requeued []string          // MessageIds handled again
+	Failed   map[string]string `json:",omitempty"` // errors by MessageId
+}
+
+func (res *RequeueResult) add(id string, err error) {
+	if err == nil {
+		res.Requeued = append(res.Requeued, id)
+		return
+	}
+	if res.Failed == nil {
+		res.Failed = make(map[string]string)
+	}
+	res.Failed[id] = err.Error()
+}
+
+// RequeueSince calls Requeue for each message quarantined at or after since
+// by the topic at endpoint, or by every topic if endpoint is "", oldest
+// first.
+func (s *Server) RequeueSince(endpoint string, since time.Time) (*RequeueResult, error) {
+	if endpoint != "" {
+		if _, ok := s.topic(endpoint); !ok {
+			return nil, ErrTopicNotFound
+		}
+	}
+	msgs, err := s.Quarantined(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	res := &RequeueResult{Requeued: []string{}}
+	for _, qm := range msgs {
+		if qm.Time.Before(since) {
+			continue
+		}
+		res.add(qm.Message.MessageId, s.Requeue(qm.Endpoint, qm.Message.MessageId))
+	}
+	return res, nil
+}
+
+// redeliver handles msg again, as it would be on delivery once accepted.
+func (t *Topic) redeliver(msg *Message) error {
+	if len(t.Transformers) > 0 {
+		var err error
+		if msg, err = t.transform(msg); err != nil {
+			return fmt.Errorf("%w: %v", ErrTransformFailed, err)
+		}
+		if msg == nil {
+			return nil
+		}
+	}
+	if t.Delivery == AtLeastOnce {
+		return t.handle(msg)
+	}
+	if !t.dispatch(msg) {
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// adminQuarantine answers GET /quarantine and POST /quarantine/requeue.
+func (s *Server) adminQuarantine(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/requeue") {
+		s.adminRequeue(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	msgs, err := s.Quarantined(r.URL.Query().Get("endpoint"))
+	if err != nil {
+		simpleResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if msgs == nil {
+		msgs = []*QuarantinedMessage{}
+	}
+	writeJSON(w, http.StatusOK, msgs)
+}
+
+func (s *Server) adminRequeue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	r.ParseForm()
+	endpoint, ids := r.Form.Get("endpoint"), r.Form["id"]
+	var res *RequeueResult
+	var err error
+	switch {
+	case len(ids) > 0 && endpoint != "":
+		res = &RequeueResult{Requeued: []string{}}
+		missing := 0
+		for _, id := range ids {
+			err := s.Requeue(endpoint, id)
+			if err == ErrNotFound {
+				missing++
+			} else if err == ErrTopicNotFound {
+				simpleResponse(w, http.StatusNotFound, "not found")
+				return
+			}
+			res.add(id, err)
+		}
+		if missing == len(ids) {
+			simpleResponse(w, http.StatusNotFound, "not found")
+			return
+		}
+	case r.Form.Get("since") != "":
+		since, perr := time.Parse(time.RFC3339, r.Form.Get("since"))
+		if perr != nil {
+			simpleResponse(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+			return
+		}
+		if res, err = s.RequeueSince(endpoint, since); err == ErrTopicNotFound {
+			simpleResponse(w, http.StatusNotFound, "not found")
+			return
+		} else if err != nil {
+			simpleResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		simpleResponse(w, http.StatusBadRequest, "requeue needs an endpoint and ids, or since")
+		return
+	}
+	code := http.StatusOK
+	if len(res.Failed) > 0 {
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, res)
+}
@@ -0,0 +1,113 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestQuarantine(t *testing.T) {
+	var broken, handled int32 = 1, 0
+	store := &gosns.MemoryStore{}
+	newReplica := func() (*gosns.Server, *gosnstest.Server) {
+		s := &gosns.Server{Store: store}
+		tp := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+			if msg == nil {
+				return
+			}
+			if atomic.LoadInt32(&broken) != 0 {
+				msg.Fail(errors.New("bug"))
+				return
+			}
+			atomic.AddInt32(&handled, 1)
+		})
+		tp.Delivery, tp.MaxAttempts, tp.Quarantine = gosns.AtLeastOnce, 2, true
+		return s, gosnstest.NewServer(s)
+	}
+	a, tsA := newReplica()
+	defer tsA.Close()
+	_, tsB := newReplica()
+	defer tsB.Close()
+
+	// the failures at each replica add up
+	e := gosnstest.NewNotification(ordersARN, "", "order")
+	for i, ts := range []*gosnstest.Server{tsA, tsB} {
+		want := []int{500, 200}[i]
+		if resp, err := ts.Send("/orders", e); err != nil || resp.StatusCode != want {
+			t.Fatalf("delivery %d gave %v, %v", i+1, resp, err)
+		}
+	}
+
+	admin := httptest.NewServer(a.AdminHandler())
+	defer admin.Close()
+	resp, err := http.Get(admin.URL + "/quarantine?endpoint=/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []gosns.QuarantinedMessage
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil || len(list) != 1 || list[0].Message.MessageId != e.MessageId || list[0].Attempts != 2 || !strings.Contains(list[0].Error, "bug") {
+		t.Fatalf("quarantined %+v, %v", list, err)
+	}
+
+	requeue := func() int {
+		resp, err := http.PostForm(admin.URL+"/quarantine/requeue", url.Values{"endpoint": {"/orders"}, "id": {e.MessageId}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := requeue(); code != http.StatusConflict {
+		t.Errorf("requeue while still broken gave %d", code)
+	}
+	if msgs, _ := a.Quarantined(""); len(msgs) != 1 || msgs[0].Attempts != 3 {
+		t.Errorf("failed requeue left %+v", msgs)
+	}
+	atomic.StoreInt32(&broken, 0)
+	if code := requeue(); code != http.StatusOK || atomic.LoadInt32(&handled) != 1 {
+		t.Errorf("requeue gave %d, handled %d", code, handled)
+	}
+	if msgs, _ := a.Quarantined(""); len(msgs) != 0 {
+		t.Errorf("requeued message still quarantined")
+	}
+	if code := requeue(); code != http.StatusNotFound {
+		t.Errorf("second requeue gave %d", code)
+	}
+	if keys, _ := store.List("failures/"); len(keys) != 0 {
+		t.Errorf("failures left in store: %q", keys)
+	}
+
+	// requeue everything quarantined since a time
+	atomic.StoreInt32(&broken, 1)
+	start := time.Now().Add(-time.Second).UTC()
+	var ids []string
+	for i := 0; i < 2; i++ {
+		e := gosnstest.NewNotification(ordersARN, "", "order")
+		ids = append(ids, e.MessageId)
+		tsA.Send("/orders", e)
+		tsA.Send("/orders", e)
+	}
+	atomic.StoreInt32(&broken, 0)
+	resp, err = http.PostForm(admin.URL+"/quarantine/requeue", url.Values{"since": {start.Format(time.RFC3339)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res gosns.RequeueResult
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || !reflect.DeepEqual(res.Requeued, ids) || atomic.LoadInt32(&handled) != 3 {
+		t.Errorf("requeue since gave %s %+v, %v", resp.Status, res, err)
+	}
+}
@@ -0,0 +1,97 @@
+package gosns
+
+import (
+	"container/heap"
+	"sync"
+)
+
+type queuedMessage struct {
+	msg      *Message
+	priority int
+	seq      uint64
+}
+
+// msgHeap orders messages by descending priority, then arrival order.
+type msgHeap []queuedMessage
+
+func (h msgHeap) Len() int { return len(h) }
+func (h msgHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h msgHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *msgHeap) Push(x interface{}) { *h = append(*h, x.(queuedMessage)) }
+func (h *msgHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = queuedMessage{}
+	*h = old[:n-1]
+	return x
+}
+
+// msgQueue is a bounded, blocking priority queue of messages. A push only
+// fails when the queue holds capacity messages and no consumer is waiting.
+// Messages popped but parked elsewhere to wait their turn (see park) still
+// count against the capacity.
+type msgQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	items    msgHeap
+	capacity int
+	waiting  int
+	parked   int
+	seq      uint64
+}
+
+func newMsgQueue(capacity int) *msgQueue {
+	q := &msgQueue{capacity: capacity}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *msgQueue) push(msg *Message, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items)+q.parked >= q.capacity+q.waiting {
+		return false
+	}
+	q.seq++
+	heap.Push(&q.items, queuedMessage{msg: msg, priority: priority, seq: q.seq})
+	q.cond.Signal()
+	return true
+}
+
+func (q *msgQueue) pop() *Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 {
+		q.waiting++
+		q.cond.Wait()
+		q.waiting--
+	}
+	return heap.Pop(&q.items).(queuedMessage).msg
+}
+
+// Len returns the number of messages waiting in the queue.
+func (q *msgQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// park records that a popped message is waiting outside the queue, such as
+// behind another message with the same key, and unpark that it has stopped.
+func (q *msgQueue) park() {
+	q.mu.Lock()
+	q.parked++
+	q.mu.Unlock()
+}
+
+func (q *msgQueue) unpark() {
+	q.mu.Lock()
+	q.parked--
+	q.mu.Unlock()
+}
@@ -0,0 +1,65 @@
+package gosns
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket is a simple token bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, returning how long the caller must wait before the
+// token is actually available. If wait is false no token is taken unless one
+// is available immediately, and ok reports whether it was.
+func (b *tokenBucket) reserve(wait bool) (delay time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if !wait {
+		return 0, false
+	}
+	b.tokens--
+	return time.Duration((-b.tokens) / b.rate * float64(time.Second)), true
+}
+
+// Allow takes a token if one is available right now.
+func (b *tokenBucket) Allow() bool {
+	_, ok := b.reserve(false)
+	return ok
+}
+
+// Wait blocks until a token is available and takes it.
+func (b *tokenBucket) Wait() {
+	if d, _ := b.reserve(true); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,129 @@
+package gosns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Patterns for common kinds of personal data, for use with Redactor.
+//
+// PhonePattern matches numbers written in a phone number shape: a "+" and
+// country code followed by 8 to 15 digits, or groups of digits separated by
+// spaces, dots or dashes and ending in a group of four, such as
+// "(555) 123-4567" or "+44 20 7946 0958". Plain runs of digits and ISO dates
+// are left alone, but IDs grouped the same way, like "1234-5678-9012", are
+// redacted too.
+var (
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	PhonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{2,4}\)[ .\-]?|\b\d{2,4}[ .\-])\d{3,4}[ .\-]\d{4}\b|\+\d{8,15}\b`)
+)
+
+// DefaultRedaction replaces redacted data when Redactor.Replacement is empty.
+const DefaultRedaction = "[REDACTED]"
+
+// Redactor removes sensitive data from messages. Add its Transform method to
+// a topic's Transformers, ahead of any others, so that the data never reaches
+// the server's logs or the callback. Requests recorded by Server.Capture are
+// kept as received and are not redacted.
+type Redactor struct {
+	// Paths lists values to redact from JSON message bodies, as dotted paths
+	// such as "customer.email". A "*" element matches every key of an object
+	// or element of an array. Paths are ignored for bodies which are not JSON.
+	// Redacted bodies are re-encoded, with object keys in sorted order.
+	Paths []string
+
+	// Patterns are replaced wherever they match in the subject, the body and
+	// string attribute values.
+	Patterns []*regexp.Regexp
+
+	// Replacement is substituted for redacted data. If empty,
+	// DefaultRedaction is used.
+	Replacement string
+}
+
+// Transform is a Transformer which redacts msg in place.
+func (rd *Redactor) Transform(ctx context.Context, msg *Message) (*Message, error) {
+	if len(rd.Paths) > 0 {
+		msg.Message = rd.redactPaths(msg.Message)
+	}
+	msg.Subject = rd.redactPatterns(msg.Subject)
+	msg.Message = rd.redactPatterns(msg.Message)
+	for name, attr := range msg.MessageAttributes {
+		if strings.HasPrefix(attr.Type, "String") {
+			attr.Value = rd.redactPatterns(attr.Value)
+			msg.MessageAttributes[name] = attr
+		}
+	}
+	return msg, nil
+}
+
+func (rd *Redactor) replacement() string {
+	if rd.Replacement == "" {
+		return DefaultRedaction
+	}
+	return rd.Replacement
+}
+
+func (rd *Redactor) redactPatterns(s string) string {
+	for _, p := range rd.Patterns {
+		s = p.ReplaceAllLiteralString(s, rd.replacement())
+	}
+	return s
+}
+
+// redactPaths redacts rd.Paths from body if it is JSON.
+func (rd *Redactor) redactPaths(body string) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if dec.Decode(&doc) != nil {
+		return body
+	}
+	changed := false
+	for _, p := range rd.Paths {
+		if rd.redactPath(&doc, strings.Split(p, ".")) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if enc.Encode(doc) != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// redactPath replaces the values at path below *v, reporting whether any
+// were found.
+func (rd *Redactor) redactPath(v *interface{}, path []string) bool {
+	if len(path) == 0 {
+		*v = rd.replacement()
+		return true
+	}
+	found := false
+	switch node := (*v).(type) {
+	case map[string]interface{}:
+		for k, child := range node {
+			if path[0] == "*" || path[0] == k {
+				if rd.redactPath(&child, path[1:]) {
+					node[k] = child
+					found = true
+				}
+			}
+		}
+	case []interface{}:
+		for i := range node {
+			if path[0] == "*" {
+				found = rd.redactPath(&node[i], path[1:]) || found
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,63 @@
+package gosns_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+)
+
+func TestPhonePattern(t *testing.T) {
+	rd := &gosns.Redactor{Patterns: []*regexp.Regexp{gosns.PhonePattern}}
+	tests := []struct{ in, want string }{
+		{"call 555-123-4567", "call [REDACTED]"},
+		{"call (555) 123-4567 now", "call [REDACTED] now"},
+		{"call 555.123.4567", "call [REDACTED]"},
+		{"call +1 555-123-4567", "call [REDACTED]"},
+		{"call +44 20 7946 0958", "call [REDACTED]"},
+		{"call +15551234567", "call [REDACTED]"},
+		{"order 1234567890 placed 2024-01-15", "order 1234567890 placed 2024-01-15"},
+		{"at 2024-01-15T10:00:00Z from 192.168.100.200", "at 2024-01-15T10:00:00Z from 192.168.100.200"},
+		{"total 1,234,567.89", "total 1,234,567.89"},
+	}
+	for _, tt := range tests {
+		msg, _ := rd.Transform(nil, &gosns.Message{Message: tt.in})
+		if msg.Message != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, msg.Message, tt.want)
+		}
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	rd := &gosns.Redactor{
+		Paths:    []string{"customer.email", "items.*.card"},
+		Patterns: []*regexp.Regexp{gosns.EmailPattern, gosns.PhonePattern},
+	}
+	msg := &gosns.Message{
+		Subject: "for bob@example.com",
+		Message: `{"customer":{"email":"a@b.co","name":"n","note":"call 555-123-4567"},"items":[{"card":4111},{"card":"x"}],"id":1234567890}`,
+		MessageAttributes: map[string]gosns.MessageAttribute{
+			"contact": {Type: "String", Value: "z@example.org"},
+			"count":   {Type: "Number", Value: "5551234567"},
+		},
+	}
+	msg, _ = rd.Transform(nil, msg)
+	want := `{"customer":{"email":"[REDACTED]","name":"n","note":"call [REDACTED]"},"id":1234567890,"items":[{"card":"[REDACTED]"},{"card":"[REDACTED]"}]}`
+	if msg.Message != want {
+		t.Errorf("Message = %s\nwant      %s", msg.Message, want)
+	}
+	if msg.Subject != "for [REDACTED]" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if v := msg.MessageAttributes["contact"].Value; v != "[REDACTED]" {
+		t.Errorf("String attribute = %q", v)
+	}
+	if v := msg.MessageAttributes["count"].Value; v != "5551234567" {
+		t.Errorf("Number attribute redacted to %q", v)
+	}
+
+	plain, _ := rd.Transform(nil, &gosns.Message{Message: "not json, bob@example.com"})
+	if plain.Message != "not json, [REDACTED]" {
+		t.Errorf("plain body = %q", plain.Message)
+	}
+}
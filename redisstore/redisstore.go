@@ -0,0 +1,297 @@
+// Package redisstore provides a gosns.Store and gosns.DedupStore kept in
+// Redis, so that several gosns replicas behind a load balancer can share
+// their state:
+//
+//	store := &redisstore.Store{Addr: "redis:6379"}
+//	s := &gosns.Server{Store: store, SharedStore: true, Dedup: store}
+//
+// It speaks the Redis protocol directly, and needs no client library.
+package redisstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// DefaultPrefix is prepended to keys when Store.Prefix is empty.
+const DefaultPrefix = "gosns:"
+
+// maxIdle is the number of connections kept open between commands.
+const maxIdle = 8
+
+// Store is a gosns.Store and gosns.DedupStore kept in a Redis server.
+type Store struct {
+	Addr     string // host:port of the Redis server
+	Password string // sent with AUTH, if set
+	DB       int    // selected if not zero
+
+	// Prefix is prepended to every key, so that several applications may
+	// share a Redis database. Empty means DefaultPrefix.
+	Prefix string
+
+	// Timeout limits each command, including dialing. Zero means 5 seconds.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// Error is an error reply from the Redis server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+func (s *Store) key(k string) string {
+	if s.Prefix == "" {
+		return DefaultPrefix + k
+	}
+	return s.Prefix + k
+}
+
+func (s *Store) Put(key string, value []byte) error {
+	_, err := s.do("SET", s.key(key), string(value))
+	return err
+}
+
+func (s *Store) Get(key string) ([]byte, error) {
+	v, err := s.do("GET", s.key(key))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, gosns.ErrNotFound
+	}
+	str, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply %v", v)
+	}
+	return []byte(str), nil
+}
+
+func (s *Store) Delete(key string) error {
+	_, err := s.do("DEL", s.key(key))
+	return err
+}
+
+func (s *Store) List(prefix string) ([]string, error) {
+	full := s.key(prefix)
+	pattern := escapeGlob(full) + "*"
+	var keys []string
+	cursor := "0"
+	for {
+		v, err := s.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := v.([]interface{})
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %v", v)
+		}
+		cursor, _ = reply[0].(string)
+		batch, _ := reply[1].([]interface{})
+		for _, k := range batch {
+			if k, ok := k.(string); ok && strings.HasPrefix(k, full) {
+				keys = append(keys, strings.TrimPrefix(k, s.key("")))
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	// SCAN may return a key more than once
+	sort.Strings(keys)
+	out := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (s *Store) Remember(key string, ttl time.Duration) (bool, error) {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	v, err := s.do("SET", s.key(key), "1", "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		return false, err
+	}
+	// SET NX answers OK when it set the key, and nil when it already existed
+	return v == nil, nil
+}
+
+// escapeGlob escapes the characters special to Redis MATCH patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// do runs a command on a pooled connection and returns its reply: nil, a
+// string, an int64 or a []interface{} of replies. A command failing on an
+// idle connection, which the server may have closed, is retried once on a
+// new one.
+func (s *Store) do(args ...string) (interface{}, error) {
+	for {
+		c, idle, err := s.get()
+		if err != nil {
+			return nil, err
+		}
+		v, err := c.do(s.timeout(), args...)
+		if _, ok := err.(Error); err == nil || ok {
+			s.put(c)
+			return v, err
+		}
+		c.Close()
+		if !idle {
+			return nil, err
+		}
+	}
+}
+
+func (s *Store) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return 5 * time.Second
+}
+
+// get returns an idle connection, or a new one, and whether it was idle.
+func (s *Store) get() (*conn, bool, error) {
+	s.mu.Lock()
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, true, nil
+	}
+	s.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", s.Addr, s.timeout())
+	if err != nil {
+		return nil, false, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if s.Password != "" {
+		if _, err = c.do(s.timeout(), "AUTH", s.Password); err != nil {
+			c.Close()
+			return nil, false, err
+		}
+	}
+	if s.DB != 0 {
+		if _, err = c.do(s.timeout(), "SELECT", strconv.Itoa(s.DB)); err != nil {
+			c.Close()
+			return nil, false, err
+		}
+	}
+	return c, false, nil
+}
+
+func (s *Store) put(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= maxIdle {
+		c.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// Close closes the idle connections to the Redis server.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.idle {
+		c.Close()
+	}
+	s.idle = nil
+	return nil
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+var errProtocol = errors.New("redis: protocol error")
+
+// readReply reads one RESP reply. Error replies are returned as an Error.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				if _, ok := err.(Error); !ok {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, errProtocol
+}
@@ -0,0 +1,243 @@
+package redisstore
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// fakeRedis answers the commands Store uses, from memory.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	data    map[string]string
+	expires map[string]time.Time
+	conns   []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, password: password, data: map[string]string{}, expires: map[string]time.Time{}}
+	go f.serve()
+	t.Cleanup(func() { ln.Close(); f.dropConns() })
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, c)
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+// dropConns closes every open connection, as a server restart would.
+func (f *fakeRedis) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authed := f.password == ""
+	for {
+		v, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := v.([]interface{})
+		var args []string
+		for _, it := range items {
+			s, _ := it.(string)
+			args = append(args, s)
+		}
+		if len(args) == 0 {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if authed = args[1] == f.password; !authed {
+				fmt.Fprint(c, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			fmt.Fprint(c, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+		default:
+			fmt.Fprint(c, f.run(cmd, args[1:]))
+		}
+	}
+}
+
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+
+func (f *fakeRedis) run(cmd string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, exp := range f.expires {
+		if time.Now().After(exp) {
+			delete(f.data, k)
+			delete(f.expires, k)
+		}
+	}
+	switch cmd {
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		key, val, nx := args[0], args[1], false
+		var exp time.Time
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(args[i+1])
+				exp = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			}
+		}
+		if _, ok := f.data[key]; ok && nx {
+			return "$-1\r\n"
+		}
+		f.data[key] = val
+		delete(f.expires, key)
+		if !exp.IsZero() {
+			f.expires[key] = exp
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.data[args[0]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(v)
+	case "DEL":
+		_, ok := f.data[args[0]]
+		delete(f.data, args[0])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "SCAN":
+		// only the prefix patterns Store sends are understood; the keys are
+		// returned over two pages to exercise the cursor
+		prefix := strings.NewReplacer(`\*`, "*", `\?`, "?", `\[`, "[", `\]`, "]", `\\`, `\`).
+			Replace(strings.TrimSuffix(args[2], "*"))
+		var keys []string
+		for k := range f.data {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		half, next := len(keys)/2, "0"
+		if args[0] == "0" {
+			keys, next = keys[:half], "1"
+		} else {
+			keys = keys[half:]
+		}
+		out := "*2\r\n" + bulk(next) + "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, k := range keys {
+			out += bulk(k)
+		}
+		return out
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func TestStore(t *testing.T) {
+	f := newFakeRedis(t, "")
+	s := &Store{Addr: f.ln.Addr().String()}
+	defer s.Close()
+	other := &Store{Addr: s.Addr, Prefix: "other:"}
+	defer other.Close()
+
+	if _, err := s.Get("subscriptions/a"); err != gosns.ErrNotFound {
+		t.Errorf("missing key gave %v", err)
+	}
+	for _, k := range []string{"subscriptions/%2Forders/arn:1", "subscriptions/%2Forders/arn:2", "delayed/x", "sub*/odd"} {
+		if err := s.Put(k, []byte("value of "+k+"\r\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := other.Put("subscriptions/elsewhere", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get("delayed/x"); err != nil || string(v) != "value of delayed/x\r\n" {
+		t.Errorf("got %q, %v", v, err)
+	}
+	keys, err := s.List("subscriptions/")
+	if want := []string{"subscriptions/%2Forders/arn:1", "subscriptions/%2Forders/arn:2"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("List gave %q, %v; want %q", keys, err, want)
+	}
+	if keys, err = s.List("sub*"); err != nil || !reflect.DeepEqual(keys, []string{"sub*/odd"}) {
+		t.Errorf("List with a glob character gave %q, %v", keys, err)
+	}
+	if err = s.Delete("delayed/x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get("delayed/x"); err != gosns.ErrNotFound {
+		t.Errorf("deleted key gave %v", err)
+	}
+}
+
+func TestRemember(t *testing.T) {
+	f := newFakeRedis(t, "")
+	s := &Store{Addr: f.ln.Addr().String()}
+	defer s.Close()
+	for i, want := range []bool{false, true} {
+		if seen, err := s.Remember("dedup/orders/m-1", time.Hour); err != nil || seen != want {
+			t.Errorf("call %d: %v, %v", i, seen, err)
+		}
+	}
+	if seen, _ := s.Remember("dedup/orders/m-2", time.Millisecond); seen {
+		t.Error("new key seen")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if seen, _ := s.Remember("dedup/orders/m-2", time.Hour); seen {
+		t.Error("expired key seen")
+	}
+}
+
+func TestAuthAndReconnect(t *testing.T) {
+	f := newFakeRedis(t, "sekrit")
+	bad := &Store{Addr: f.ln.Addr().String(), Password: "wrong"}
+	if _, err := bad.Get("x"); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("wrong password gave %v", err)
+	}
+
+	s := &Store{Addr: f.ln.Addr().String(), Password: "sekrit", DB: 2}
+	defer s.Close()
+	if err := s.Put("x", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	f.dropConns()
+	if v, err := s.Get("x"); err != nil || string(v) != "1" {
+		t.Errorf("after reconnecting: %q, %v", v, err)
+	}
+}
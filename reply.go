@@ -0,0 +1,113 @@
+package gosns
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Message attributes used for request/reply exchanges over SNS. A request
+// names the topic its reply should be published to in ReplyToAttribute, and
+// the reply echoes the request's CorrelationIDAttribute.
+const (
+	ReplyToAttribute       = "gosns.ReplyTo"
+	CorrelationIDAttribute = "gosns.CorrelationId"
+)
+
+// ErrNoReplyTo is returned by Publisher.Reply for messages which do not name
+// a reply topic.
+var ErrNoReplyTo = errors.New("gosns: message has no reply topic")
+
+// ReplyTo returns the topic ARN a reply to m should be published to, or "".
+func (m *Message) ReplyTo() string {
+	return m.MessageAttributes[ReplyToAttribute].Value
+}
+
+// CorrelationID returns the ID pairing a request with its reply, or "".
+func (m *Message) CorrelationID() string {
+	return m.MessageAttributes[CorrelationIDAttribute].Value
+}
+
+// Reply publishes a reply to req on the topic named by its ReplyTo
+// attribute, carrying the request's correlation ID, and returns the reply's
+// MessageId. It returns ErrNoReplyTo if req names no reply topic.
+func (p *Publisher) Reply(ctx context.Context, req *Message, subject, body string, attrs map[string]MessageAttribute) (string, error) {
+	replyTo := req.ReplyTo()
+	if replyTo == "" {
+		return "", ErrNoReplyTo
+	}
+	a := make(map[string]MessageAttribute, len(attrs)+1)
+	for k, v := range attrs {
+		a[k] = v
+	}
+	if id := req.CorrelationID(); id != "" {
+		a[CorrelationIDAttribute] = MessageAttribute{Type: "String", Value: id}
+	}
+	return p.Publish(ctx, replyTo, subject, body, a)
+}
+
+// Caller makes requests over SNS and waits for their replies, which must be
+// delivered to its Callback from ReplyTopic:
+//
+//	c := &gosns.Caller{Publisher: pub, ReplyTopic: replyARN}
+//	s.AddTopic(replyARN, "/replies", c.Callback)
+//	reply, err := c.Call(ctx, requestARN, "", `{"sku":"A-1"}`, nil)
+//
+// Each request gets a new correlation ID. Replies which arrive after their
+// Call has returned are discarded. A Caller is safe for concurrent use.
+type Caller struct {
+	Publisher  *Publisher
+	ReplyTopic string
+
+	mu      sync.Mutex
+	pending map[string]chan *Message
+}
+
+// Call publishes a request to topicARN and returns its reply, or ctx's error
+// if it is done first.
+func (c *Caller) Call(ctx context.Context, topicARN, subject, body string, attrs map[string]MessageAttribute) (*Message, error) {
+	id := randomHex(16)
+	a := make(map[string]MessageAttribute, len(attrs)+2)
+	for k, v := range attrs {
+		a[k] = v
+	}
+	a[ReplyToAttribute] = MessageAttribute{Type: "String", Value: c.ReplyTopic}
+	a[CorrelationIDAttribute] = MessageAttribute{Type: "String", Value: id}
+
+	ch := make(chan *Message, 1)
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = make(map[string]chan *Message)
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if _, err := c.Publisher.Publish(ctx, topicARN, subject, body, a); err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Callback hands replies to the Call waiting for them.
+func (c *Caller) Callback(msg *Message) {
+	if msg == nil {
+		return
+	}
+	c.mu.Lock()
+	ch := c.pending[msg.CorrelationID()]
+	delete(c.pending, msg.CorrelationID())
+	c.mu.Unlock()
+	if ch != nil {
+		ch <- msg
+	}
+}
@@ -0,0 +1,79 @@
+package gosns_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestCallReply(t *testing.T) {
+	requests := gosnstest.NewFakeTopic("arn:aws:sns:us-east-1:123456789012:requests")
+	defer requests.Close()
+	replies := gosnstest.NewFakeTopic("arn:aws:sns:us-east-1:123456789012:replies")
+	defer replies.Close()
+
+	s := &gosns.Server{}
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	responder := &gosns.Publisher{Client: replies.SNSClient()}
+	s.AddTopic(requests.ARN, "/requests", func(msg *gosns.Message) {
+		if msg == nil {
+			return
+		}
+		if _, err := responder.Reply(context.Background(), msg, "", "re: "+msg.Message, nil); err != nil {
+			t.Error(err)
+		}
+	})
+	c := &gosns.Caller{Publisher: &gosns.Publisher{Client: requests.SNSClient()}, ReplyTopic: replies.ARN}
+	s.AddTopic(replies.ARN, "/replies", c.Callback)
+	if err := requests.Register(hs.URL + "/requests"); err != nil {
+		t.Fatal(err)
+	}
+	if err := replies.Register(hs.URL + "/replies"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			reply, err := c.Call(ctx, requests.ARN, "", body, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if reply.Message != "re: "+body || reply.CorrelationID() == "" {
+				t.Errorf("request %q got reply %+v", body, reply)
+			}
+		}("request " + strconv.Itoa(i))
+	}
+	wg.Wait()
+}
+
+func TestReplyNeedsReplyTo(t *testing.T) {
+	p := &gosns.Publisher{}
+	if _, err := p.Reply(context.Background(), &gosns.Message{}, "", "hi", nil); err != gosns.ErrNoReplyTo {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCallTimesOut(t *testing.T) {
+	requests := gosnstest.NewFakeTopic("arn:aws:sns:us-east-1:123456789012:unanswered")
+	defer requests.Close()
+	c := &gosns.Caller{Publisher: &gosns.Publisher{Client: requests.SNSClient()}, ReplyTopic: "arn:aws:sns:us-east-1:123456789012:replies"}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Call(ctx, requests.ARN, "", "hello", nil); err != context.DeadlineExceeded {
+		t.Errorf("got %v", err)
+	}
+}
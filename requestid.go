@@ -0,0 +1,50 @@
+package gosns
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	xrayKey
+	tenantKey
+	endpointPathKey
+	pathValuesKey
+	sqsQueueKey
+)
+
+// RequestIDHeader is the header used to propagate request IDs. It is honored
+// on incoming requests and always set on responses.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns the ID of the request which delivered the message that
+// ctx belongs to (see Message.Context), or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestID returns the ID for r: its X-Request-ID header, the Root of its
+// X-Amzn-Trace-Id header, or a newly generated ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	if trace := r.Header.Get("X-Amzn-Trace-Id"); trace != "" {
+		for _, part := range strings.Split(trace, ";") {
+			if strings.HasPrefix(part, "Root=") {
+				return part[len("Root="):]
+			}
+		}
+		return trace
+	}
+	return randomHex(16)
+}
@@ -0,0 +1,160 @@
+package gosns
+
+import "net/http"
+
+// ResponseKind identifies the situation an HTTP response is sent for.
+type ResponseKind int
+
+const (
+	// ResponseOK acknowledges a delivery, or answers a health probe.
+	ResponseOK ResponseKind = iota
+	// ResponseNotFound is sent for paths with no registered topic.
+	ResponseNotFound
+	// ResponseBadRequest is sent for unreadable bodies.
+	ResponseBadRequest
+	// ResponseOverloaded is sent when a topic cannot accept more messages.
+	ResponseOverloaded
+	// ResponseTooLarge is sent for bodies exceeding the size limit.
+	ResponseTooLarge
+	// ResponseMethodNotAllowed is sent for unsupported request methods.
+	ResponseMethodNotAllowed
+	// ResponseNotImplemented is sent for unknown SNS message types.
+	ResponseNotImplemented
+	// ResponseForbidden is sent for messages failing signature verification,
+	// and for unexpected traffic in strict mode.
+	ResponseForbidden
+	// ResponseUnauthorized is sent for requests lacking the credentials of
+	// a tenant or of a topic with a Username and Password. It asks for HTTP
+	// basic authentication, which SNS answers by retrying with the
+	// credentials in the subscribed URL.
+	ResponseUnauthorized
+	// ResponseInternalError is sent for messages a Transformer, or an
+	// AtLeastOnce callback, failed on, so that SNS retries them.
+	ResponseInternalError
+	// ResponseNoContent answers OPTIONS requests, alongside the Allow header.
+	ResponseNoContent
+	// ResponseDraining answers health probes once Drain has been called.
+	ResponseDraining
+	// ResponseTopicMismatch is sent for requests naming a topic other than
+	// their endpoint's. See TopicMismatchPolicy.
+	ResponseTopicMismatch
+	// ResponseHeadersTooLarge is sent for requests whose headers exceed a
+	// topic's MaxHeaderBytes.
+	ResponseHeadersTooLarge
+	// ResponseUnsupportedMediaType is sent for requests with an unexpected
+	// Content-Type under ContentTypeStrict.
+	ResponseUnsupportedMediaType
+	// ResponseMaintenance is sent for notifications while the server is in
+	// maintenance mode, so that SNS retries them later. See SetMaintenance.
+	ResponseMaintenance
+	// ResponsePaused is sent for notifications to a paused topic in
+	// PauseRefuse mode, or in PauseHold mode once it holds MaxHeld messages.
+	ResponsePaused
+	// ResponseNotReady answers health probes while a topic's Init is
+	// running or has failed, and is sent for notifications to a topic whose
+	// dependencies are not ready.
+	ResponseNotReady
+)
+
+// Response describes an HTTP response sent by the server.
+type Response struct {
+	// StatusCode is the HTTP status code to send.
+	StatusCode int
+
+	// Body is sent as text/plain.
+	Body string
+
+	// Header holds extra headers to send, such as Retry-After. Headers set
+	// in Server.Responses are added to the defaults, replacing any with the
+	// same name.
+	Header http.Header
+
+	// Reason is why the request was rejected, such as ReasonBadSignature,
+	// or "" for a response which is not a rejection. It is sent in the
+	// ReasonHeader and appended to Body, unless OnResponse clears it.
+	Reason string
+
+	// Err is the error the request was refused for, such as
+	// ErrVerificationFailed, or why it was acknowledged without being handled,
+	// such as ErrDuplicate. It is not sent.
+	Err error
+}
+
+var defaultResponses = map[ResponseKind]Response{
+	ResponseOK:                   {StatusCode: http.StatusOK, Body: "ok"},
+	ResponseNotFound:             {StatusCode: http.StatusNotFound, Body: "not found"},
+	ResponseBadRequest:           {StatusCode: http.StatusBadRequest, Body: "bad request"},
+	ResponseOverloaded:           {StatusCode: http.StatusServiceUnavailable, Body: "service unavailable"},
+	ResponseTooLarge:             {StatusCode: http.StatusRequestEntityTooLarge, Body: "request entity too large"},
+	ResponseMethodNotAllowed:     {StatusCode: http.StatusMethodNotAllowed, Body: "method not allowed"},
+	ResponseNotImplemented:       {StatusCode: http.StatusNotImplemented, Body: "not implemented"},
+	ResponseForbidden:            {StatusCode: http.StatusForbidden, Body: "forbidden"},
+	ResponseInternalError:        {StatusCode: http.StatusInternalServerError, Body: "internal server error"},
+	ResponseNoContent:            {StatusCode: http.StatusNoContent},
+	ResponseDraining:             {StatusCode: http.StatusServiceUnavailable, Body: "draining"},
+	ResponseTopicMismatch:        {StatusCode: http.StatusForbidden, Body: "forbidden"},
+	ResponseHeadersTooLarge:      {StatusCode: http.StatusRequestHeaderFieldsTooLarge, Body: "request header fields too large"},
+	ResponseUnsupportedMediaType: {StatusCode: http.StatusUnsupportedMediaType, Body: "unsupported media type"},
+	ResponseMaintenance:          {StatusCode: http.StatusServiceUnavailable, Body: "down for maintenance"},
+	ResponsePaused:               {StatusCode: http.StatusServiceUnavailable, Body: "paused"},
+	ResponseNotReady:             {StatusCode: http.StatusServiceUnavailable, Body: "not ready"},
+	ResponseUnauthorized: {
+		StatusCode: http.StatusUnauthorized,
+		Body:       "unauthorized",
+		Header:     http.Header{"Www-Authenticate": {`Basic realm="gosns"`}},
+	},
+}
+
+// respond writes the response for kind, applying Server.Responses and
+// Server.OnResponse, and returns the status code sent.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, kind ResponseKind) int {
+	return s.respondWith(w, r, kind, "", nil)
+}
+
+// respondWith is like respond, for a response rejecting r for reason, or
+// caused by err.
+func (s *Server) respondWith(w http.ResponseWriter, r *http.Request, kind ResponseKind, reason string, err error) int {
+	return s.respondStatus(w, r, kind, 0, reason, err)
+}
+
+// respondStatus is like respondWith, but sends status, if it is not zero,
+// in place of the status configured for kind.
+func (s *Server) respondStatus(w http.ResponseWriter, r *http.Request, kind ResponseKind, status int, reason string, err error) int {
+	resp := defaultResponses[kind]
+	resp.Header = cloneHeader(resp.Header)
+	resp.Reason, resp.Err = reason, err
+	if custom, ok := s.Responses[kind]; ok {
+		if custom.StatusCode != 0 {
+			resp.StatusCode = custom.StatusCode
+		}
+		if custom.Body != "" {
+			resp.Body = custom.Body
+		}
+		for k, v := range custom.Header {
+			resp.Header[k] = append([]string(nil), v...)
+		}
+	}
+	if status != 0 {
+		resp.StatusCode = status
+	}
+	if s.OnResponse != nil {
+		s.OnResponse(r, kind, &resp)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	if resp.Reason != "" {
+		w.Header().Set(ReasonHeader, resp.Reason)
+		resp.Body += ": " + resp.Reason
+	}
+	simpleResponse(w, resp.StatusCode, resp.Body)
+	return resp.StatusCode
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
@@ -0,0 +1,116 @@
+package gosns_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+)
+
+func TestMethodResponses(t *testing.T) {
+	var kinds []gosns.ResponseKind
+	s := &gosns.Server{
+		OnResponse: func(r *http.Request, kind gosns.ResponseKind, resp *gosns.Response) {
+			kinds = append(kinds, kind)
+		},
+	}
+	s.AddTopic("arn:aws:sns:us-east-1:123456789012:methods", "/m", func(*gosns.Message) {})
+
+	for _, c := range []struct {
+		method, path string
+		status       int
+		allow        string
+		kind         gosns.ResponseKind
+	}{
+		{"OPTIONS", "/m", http.StatusNoContent, "POST, GET, HEAD, OPTIONS", gosns.ResponseNoContent},
+		{"GET", "/m", http.StatusOK, "POST, GET, HEAD, OPTIONS", gosns.ResponseOK},
+		{"DELETE", "/m", http.StatusMethodNotAllowed, "POST, GET, HEAD, OPTIONS", gosns.ResponseMethodNotAllowed},
+	} {
+		kinds = nil
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		resp := w.Result()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != c.status || resp.Header.Get("Allow") != c.allow {
+			t.Errorf("%s %s: %d Allow %q, want %d Allow %q", c.method, c.path,
+				resp.StatusCode, resp.Header.Get("Allow"), c.status, c.allow)
+		}
+		if c.status == http.StatusNoContent && len(body) != 0 {
+			t.Errorf("%s %s: body %q on a 204", c.method, c.path, body)
+		}
+		if len(kinds) != 1 || kinds[0] != c.kind {
+			t.Errorf("%s %s: OnResponse saw %v, want %v", c.method, c.path, kinds, c.kind)
+		}
+	}
+}
+
+func TestRejectReasons(t *testing.T) {
+	var reasons []string
+	s := &gosns.Server{
+		OnResponse: func(r *http.Request, kind gosns.ResponseKind, resp *gosns.Response) {
+			reasons = append(reasons, resp.Reason)
+		},
+		Responses: map[gosns.ResponseKind]gosns.Response{gosns.ResponseNotFound: {Body: "nothing here"}},
+	}
+	s.AddTopic("arn:aws:sns:us-east-1:123456789012:reasons", "/r", func(*gosns.Message) {})
+
+	for _, c := range []struct {
+		method, path, topic string
+		body, reason        string
+	}{
+		{"POST", "/missing", "", "nothing here: not_found", gosns.ReasonNotFound},
+		{"POST", "/r", "arn:aws:sns:us-east-1:123456789012:other", "forbidden: topic_arn_mismatch", gosns.ReasonTopicMismatch},
+		{"PUT", "/r", "", "method not allowed: method_not_allowed", gosns.ReasonMethodNotAllowed},
+		{"GET", "/r", "", "ok", ""},
+	} {
+		reasons = nil
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set("x-amz-sns-topic-arn", c.topic)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		body, _ := io.ReadAll(w.Result().Body)
+		if got := strings.TrimSpace(string(body)); got != c.body || w.Header().Get(gosns.ReasonHeader) != c.reason {
+			t.Errorf("%s %s: body %q reason %q, want %q and %q", c.method, c.path, got, w.Header().Get(gosns.ReasonHeader), c.body, c.reason)
+		}
+		if len(reasons) != 1 || reasons[0] != c.reason {
+			t.Errorf("%s %s: OnResponse saw reasons %q", c.method, c.path, reasons)
+		}
+	}
+}
+
+func TestTopicMismatchPolicy(t *testing.T) {
+	const other = "arn:aws:sns:us-east-1:210987654321:probe"
+	var buf bytes.Buffer
+	var hooked []string
+	s := &gosns.Server{
+		Logger:    log.New(&buf, "", 0),
+		Responses: map[gosns.ResponseKind]gosns.Response{gosns.ResponseTopicMismatch: {StatusCode: http.StatusBadRequest}},
+		TopicMismatch: gosns.TopicMismatchPolicy{
+			HideARN:       true,
+			SecurityEvent: true,
+			Hook: func(r *http.Request, topic *gosns.Topic, topicARN string) {
+				hooked = append(hooked, topic.TopicARN+" "+topicARN)
+			},
+		},
+	}
+	s.AddTopic(ordersARN, "/orders", func(*gosns.Message) {})
+
+	req := httptest.NewRequest("POST", "/orders", nil)
+	req.Header.Set("x-amz-sns-topic-arn", other)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || w.Header().Get(gosns.ReasonHeader) != gosns.ReasonTopicMismatch {
+		t.Errorf("got %d %q", w.Code, w.Header().Get(gosns.ReasonHeader))
+	}
+	if len(hooked) != 1 || hooked[0] != ordersARN+" "+other {
+		t.Errorf("hook saw %q", hooked)
+	}
+	if got := buf.String(); strings.Contains(got, other) || !strings.Contains(got, "security_event=true") {
+		t.Errorf("log:\n%s", got)
+	}
+}
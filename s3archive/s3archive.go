@@ -0,0 +1,382 @@
+// Package s3archive archives the messages a gosns server receives to Amazon
+// S3, as gzipped newline-delimited JSON objects under Hive-style keys
+// partitioned by topic and date, so that every notification can be queried
+// later with Athena:
+//
+//	arch := &s3archive.Archiver{Bucket: "my-archive", Prefix: "sns/", Region: "us-east-1"}
+//	defer arch.Close()
+//	s.AddTopic(topicARN, "/orders", func(msg *gosns.Message) {
+//		arch.Handle(msg)
+//		handle(msg)
+//	})
+//
+// Messages are buffered, one batch per topic and day, until a batch holds
+// MaxMessages or MaxBytes, or has waited MaxWait, and then uploaded to
+//
+//	<Prefix>topic=<topic name>/dt=<YYYY-MM-DD>/<upload time>-<random>.json.gz
+//
+// with one JSON-encoded gosns.Message per line, dated by its SNS Timestamp
+// in UTC. A table over the archive can project its partitions rather than
+// having them added as they appear:
+//
+//	CREATE EXTERNAL TABLE sns_messages (
+//	    MessageId string, TopicArn string, Subject string, Message string,
+//	    Timestamp string, MessageAttributes map<string, struct<Type: string, Value: string>>)
+//	PARTITIONED BY (topic string, dt string)
+//	ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'
+//	LOCATION 's3://my-archive/sns/'
+//	TBLPROPERTIES (
+//	    'projection.enabled' = 'true',
+//	    'projection.topic.type' = 'injected',
+//	    'projection.dt.type' = 'date', 'projection.dt.format' = 'yyyy-MM-dd',
+//	    'projection.dt.range' = '2026-01-01,NOW',
+//	    'storage.location.template' = 's3://my-archive/sns/topic=${topic}/dt=${dt}/')
+//
+// Uploads which fail are logged and retried every MaxWait until they
+// succeed or Close gives up, so the archive is at least once: a message may
+// appear twice, but all messages Write accepted are kept while the process
+// runs.
+package s3archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/internal/sigv4"
+)
+
+// Defaults used when the corresponding Archiver fields are zero.
+const (
+	DefaultMaxMessages = 10000
+	DefaultMaxBytes    = 64 << 20
+	DefaultMaxWait     = 5 * time.Minute
+	DefaultTimeout     = time.Minute
+)
+
+// Archiver batches messages into objects in an S3 bucket. Its fields must
+// not be changed once it has been used.
+type Archiver struct {
+	Bucket string
+	Prefix string // prepended to every key, such as "sns/"
+	Region string
+
+	// Credentials sign each request. If nil, gosns.LoadCredentials is
+	// called on first use.
+	Credentials *gosns.Credentials
+
+	// Endpoint, if set, replaces the bucket's regional endpoint with a
+	// path-style base URL, such as http://localhost:9000 for MinIO, to
+	// which the bucket and key are appended.
+	Endpoint string
+
+	// Client sends requests; nil means an http.Client with a timeout of
+	// Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// MaxMessages and MaxBytes, of uncompressed JSON, bound a batch, and
+	// MaxWait how long its first message waits to be uploaded. Zero means
+	// DefaultMaxMessages, DefaultMaxBytes and DefaultMaxWait.
+	MaxMessages int
+	MaxBytes    int
+	MaxWait     time.Duration
+
+	// Logger receives notices of failed uploads, if set.
+	Logger *log.Logger
+
+	once      sync.Once
+	client    *http.Client
+	credsErr  error
+	stop      chan struct{}
+	flushDone chan struct{}
+
+	mu      sync.Mutex
+	batches map[partition]*batch
+	retry   []*object // uploads which failed
+}
+
+// partition is the topic and day of a batch.
+type partition struct {
+	topic, day string
+}
+
+type batch struct {
+	started  time.Time
+	messages int
+	size     int
+	buf      bytes.Buffer
+	gz       *gzip.Writer
+}
+
+// object is a closed batch to upload.
+type object struct {
+	key  string
+	data []byte
+}
+
+// Error is an error response from S3.
+type Error struct {
+	StatusCode int
+	Code       string // such as "NoSuchBucket"
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3archive: status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (a *Archiver) init() {
+	a.once.Do(func() {
+		a.client = a.Client
+		if a.client == nil {
+			timeout := a.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			a.client = &http.Client{Timeout: timeout}
+		}
+		if a.Credentials == nil {
+			a.Credentials, a.credsErr = gosns.LoadCredentials()
+		}
+		a.batches = make(map[partition]*batch)
+		a.stop = make(chan struct{})
+		a.flushDone = make(chan struct{})
+		go a.flusher()
+	})
+}
+
+func (a *Archiver) logf(format string, args ...interface{}) {
+	if a.Logger != nil {
+		a.Logger.Printf(format, args...)
+	}
+}
+
+func (a *Archiver) maxWait() time.Duration {
+	if a.MaxWait > 0 {
+		return a.MaxWait
+	}
+	return DefaultMaxWait
+}
+
+// partitionOf returns the partition msg is archived in: its topic's name
+// and the UTC day of its Timestamp, or of now if it has none.
+func partitionOf(msg *gosns.Message, now time.Time) partition {
+	topic := "unknown"
+	if arn, err := gosns.ParseARN(msg.TopicArn); err == nil && arn.Resource != "" {
+		topic = arn.Resource
+	}
+	at := msg.Timestamp
+	if at.IsZero() {
+		at = now
+	}
+	return partition{topic, at.UTC().Format("2006-01-02")}
+}
+
+// Write adds msg to its batch, uploading the batch if it is full. It
+// returns an error if the message cannot be encoded, or the upload of its
+// batch failed, in which case the upload is retried later and msg is still
+// archived.
+func (a *Archiver) Write(msg *gosns.Message) error {
+	a.init()
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	maxMessages, maxBytes := a.MaxMessages, a.MaxBytes
+	if maxMessages <= 0 {
+		maxMessages = DefaultMaxMessages
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+
+	now := time.Now()
+	p := partitionOf(msg, now)
+	a.mu.Lock()
+	b := a.batches[p]
+	if b == nil {
+		b = &batch{started: now}
+		b.gz = gzip.NewWriter(&b.buf)
+		a.batches[p] = b
+	}
+	b.gz.Write(line)
+	b.messages++
+	b.size += len(line)
+	var full *object
+	if b.messages >= maxMessages || b.size >= maxBytes {
+		full = a.take(p, now)
+	}
+	a.mu.Unlock()
+	if full == nil {
+		return nil
+	}
+	return a.upload(full)
+}
+
+// Handle archives msg as a Topic callback, logging any error.
+func (a *Archiver) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := a.Write(msg); err != nil {
+		a.logf("%v\n", err)
+	}
+}
+
+// take removes the batch of p, returning it as an object. a.mu is held.
+func (a *Archiver) take(p partition, now time.Time) *object {
+	b := a.batches[p]
+	delete(a.batches, p)
+	b.gz.Close()
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	key := fmt.Sprintf("%stopic=%s/dt=%s/%s-%s.json.gz", a.Prefix, p.topic, p.day, now.UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix[:]))
+	return &object{key: key, data: b.buf.Bytes()}
+}
+
+// upload puts obj in the bucket, queueing it to be retried if that fails.
+func (a *Archiver) upload(obj *object) error {
+	err := a.put(obj)
+	if err != nil {
+		a.logf("s3archive: uploading %s failed, will retry: %v\n", obj.key, err)
+		a.mu.Lock()
+		a.retry = append(a.retry, obj)
+		a.mu.Unlock()
+	}
+	return err
+}
+
+// Flush uploads every batch, and retries the uploads which failed before,
+// returning the first error.
+func (a *Archiver) Flush() error {
+	a.init()
+	now := time.Now()
+	a.mu.Lock()
+	objs := a.retry
+	a.retry = nil
+	for p := range a.batches {
+		objs = append(objs, a.take(p, now))
+	}
+	a.mu.Unlock()
+	var first error
+	for _, obj := range objs {
+		if err := a.upload(obj); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// flusher uploads batches which have waited MaxWait, and retries failed
+// uploads, until Close is called.
+func (a *Archiver) flusher() {
+	defer close(a.flushDone)
+	wait := a.maxWait()
+	tick := wait / 10
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastRetry := time.Now()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			a.mu.Lock()
+			var objs []*object
+			if now.Sub(lastRetry) >= wait {
+				objs, a.retry = a.retry, nil
+				lastRetry = now
+			}
+			for p, b := range a.batches {
+				if now.Sub(b.started) >= wait {
+					objs = append(objs, a.take(p, now))
+				}
+			}
+			a.mu.Unlock()
+			for _, obj := range objs {
+				a.upload(obj)
+			}
+		}
+	}
+}
+
+// Close uploads every batch and stops the background flushing. It returns
+// an error if any upload failed, whose messages are then lost.
+func (a *Archiver) Close() error {
+	a.init()
+	a.mu.Lock()
+	select {
+	case <-a.stop:
+		a.mu.Unlock()
+		return nil
+	default:
+		close(a.stop)
+	}
+	a.mu.Unlock()
+	<-a.flushDone
+	return a.Flush()
+}
+
+func (a *Archiver) objectURL(key string) string {
+	path := (&url.URL{Path: "/" + key}).EscapedPath()
+	if a.Endpoint != "" {
+		return strings.TrimSuffix(a.Endpoint, "/") + "/" + a.Bucket + path
+	}
+	return "https://" + a.Bucket + ".s3." + a.Region + "." + gosns.RegionDNSSuffix(a.Region) + path
+}
+
+// put uploads obj with PutObject.
+func (a *Archiver) put(obj *object) error {
+	if a.Region == "" {
+		return errors.New("s3archive: Archiver has no Region")
+	}
+	if a.credsErr != nil {
+		return a.credsErr
+	}
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, a.objectURL(obj.key), bytes.NewReader(obj.data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.HexSHA256(obj.data))
+	c, err := a.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, obj.data, c.AccessKeyID, c.SecretAccessKey, c.SessionToken, a.Region, "s3", time.Now())
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		serr := &Error{StatusCode: resp.StatusCode}
+		var e struct{ Code, Message string }
+		if xml.Unmarshal(data, &e) == nil {
+			serr.Code, serr.Message = e.Code, e.Message
+		}
+		return serr
+	}
+	return nil
+}
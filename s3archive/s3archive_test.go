@@ -0,0 +1,142 @@
+package s3archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const slowDown = `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`
+
+// newArchiver returns an Archiver putting objects in a fake S3 bucket.
+func newArchiver(t *testing.T) (*Archiver, *gosnstest.Sink) {
+	b := gosnstest.NewSink(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			r.Header.Get("X-Amz-Content-Sha256") == "" {
+			t.Errorf("bad request %s %v", r.Method, r.Header)
+		}
+	})
+	t.Cleanup(b.Close)
+	a := &Archiver{
+		Bucket:      "archive",
+		Prefix:      "sns/",
+		Region:      "us-east-1",
+		Endpoint:    b.URL,
+		Credentials: &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		MaxMessages: 3,
+		MaxWait:     time.Hour,
+	}
+	return a, b
+}
+
+// objects returns the IDs of the messages in the objects put in b, by the
+// directory of their key.
+func objects(t *testing.T, b *gosnstest.Sink) map[string][]string {
+	res := make(map[string][]string)
+	for _, req := range b.Accepted() {
+		key := req.URL.Path
+		if !strings.HasSuffix(key, ".json.gz") {
+			t.Errorf("key %s", key)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(req.Body))
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		dir := key[:strings.LastIndex(key, "/")]
+		sc := bufio.NewScanner(gz)
+		for sc.Scan() {
+			var msg gosns.Message
+			if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+				t.Fatalf("%s: %v", key, err)
+			}
+			res[dir] = append(res[dir], msg.MessageId)
+		}
+	}
+	return res
+}
+
+func message(id, topic string, day int) *gosns.Message {
+	msg := gosnstest.NewMessage(id, topic)
+	msg.Timestamp = time.Date(2026, 10, day, 23, 59, 0, 0, time.UTC)
+	return msg
+}
+
+func TestArchiver(t *testing.T) {
+	a, b := newArchiver(t)
+	for _, msg := range []*gosns.Message{
+		message("1", "orders", 13), message("2", "orders", 13), message("3", "orders", 14),
+		message("4", "refunds", 13), message("5", "orders", 13),
+	} {
+		if err := a.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first orders batch filled up
+	if got := objects(t, b); len(got) != 1 || strings.Join(got["/archive/sns/topic=orders/dt=2026-10-13"], ",") != "1,2,5" {
+		t.Errorf("after writes %v", got)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := objects(t, b)
+	if len(got) != 3 || len(got["/archive/sns/topic=orders/dt=2026-10-14"]) != 1 || len(got["/archive/sns/topic=refunds/dt=2026-10-13"]) != 1 {
+		t.Errorf("after close %v", got)
+	}
+}
+
+func TestArchiverRetry(t *testing.T) {
+	a, b := newArchiver(t)
+	a.MaxWait = 50 * time.Millisecond
+	defer a.Close()
+	b.Fail(100, http.StatusServiceUnavailable, slowDown)
+	a.Write(message("1", "orders", 13))
+	a.Write(message("2", "orders", 13))
+	err := a.Write(message("3", "orders", 13))
+	var serr *Error
+	if !errors.As(err, &serr) || serr.Code != "SlowDown" {
+		t.Fatalf("got %v", err)
+	}
+
+	b.Fail(0, 0, "")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(objects(t, b)["/archive/sns/topic=orders/dt=2026-10-13"]) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("failed upload was not retried")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a partial batch is uploaded once it has waited MaxWait
+	a.Write(message("4", "refunds", 13))
+	for len(objects(t, b)["/archive/sns/topic=refunds/dt=2026-10-13"]) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("partial batch was not uploaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-east-1":  "https://logs.s3.us-east-1.amazonaws.com/a/b%20c.json",
+		"cn-north-1": "https://logs.s3.cn-north-1.amazonaws.com.cn/a/b%20c.json",
+	} {
+		a := &Archiver{Bucket: "logs", Region: region}
+		if got := a.objectURL("a/b c.json"); got != want {
+			t.Errorf("%s: got %s, want %s", region, got, want)
+		}
+	}
+	a := &Archiver{Bucket: "logs", Region: "us-east-1", Endpoint: "http://localhost:9000/"}
+	if got := a.objectURL("a.json"); got != "http://localhost:9000/logs/a.json" {
+		t.Errorf("with Endpoint, got %s", got)
+	}
+}
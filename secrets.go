@@ -0,0 +1,158 @@
+package gosns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Prefixes of the secret references a SecretSource resolves.
+const (
+	SecretsManagerPrefix = "secretsmanager:"
+	SSMPrefix            = "ssm:"
+)
+
+// IsSecretRef reports whether value is a reference for a SecretSource to
+// resolve, rather than a literal value.
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretsManagerPrefix) || strings.HasPrefix(value, SSMPrefix)
+}
+
+// SecretSource resolves references to secrets held in AWS Secrets Manager
+// or SSM Parameter Store, so that passwords and keys need not be written in
+// configuration. References look like
+//
+//	secretsmanager:<secret id or ARN>[#<field>]
+//	ssm:<parameter name>
+//
+// where a field picks a string from a secret holding a JSON object, as
+// Secrets Manager stores database credentials. Parameters are decrypted, so
+// SecureStrings may be used.
+type SecretSource struct {
+	Region string
+
+	// Credentials sign each request. If nil, LoadCredentials is called on
+	// first use.
+	Credentials *Credentials
+
+	// SecretsManagerEndpoint and SSMEndpoint override the regional
+	// endpoint URLs of the two services.
+	SecretsManagerEndpoint string
+	SSMEndpoint            string
+
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// SecretError is an error response from Secrets Manager or SSM.
+type SecretError struct {
+	Ref        string
+	StatusCode int
+	Type       string // such as "ResourceNotFoundException"
+	Message    string
+}
+
+func (e *SecretError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("resolving %s: unexpected status %d", e.Ref, e.StatusCode)
+	}
+	return fmt.Sprintf("resolving %s: %s: %s", e.Ref, e.Type, e.Message)
+}
+
+// Resolve returns the value of the secret ref refers to, or ref itself if it
+// is not a reference (see IsSecretRef).
+func (s *SecretSource) Resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, SecretsManagerPrefix):
+		id, field, _ := strings.Cut(strings.TrimPrefix(ref, SecretsManagerPrefix), "#")
+		var out struct {
+			SecretString string
+			SecretBinary []byte
+		}
+		if err := s.call(ctx, ref, "secretsmanager", s.SecretsManagerEndpoint, "secretsmanager.GetSecretValue",
+			map[string]string{"SecretId": id}, &out); err != nil {
+			return "", err
+		}
+		value := out.SecretString
+		if value == "" {
+			value = string(out.SecretBinary)
+		}
+		if field == "" {
+			return value, nil
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(value), &fields) != nil {
+			return "", fmt.Errorf("resolving %s: secret is not a JSON object", ref)
+		}
+		v, ok := fields[field].(string)
+		if !ok {
+			return "", fmt.Errorf("resolving %s: secret has no string field '%s'", ref, field)
+		}
+		return v, nil
+
+	case strings.HasPrefix(ref, SSMPrefix):
+		var out struct{ Parameter struct{ Value string } }
+		if err := s.call(ctx, ref, "ssm", s.SSMEndpoint, "AmazonSSM.GetParameter",
+			map[string]interface{}{"Name": strings.TrimPrefix(ref, SSMPrefix), "WithDecryption": true}, &out); err != nil {
+			return "", err
+		}
+		return out.Parameter.Value, nil
+	}
+	return ref, nil
+}
+
+func (s *SecretSource) call(ctx context.Context, ref, service, endpoint, target string, in, out interface{}) error {
+	if s.Region == "" {
+		return errors.New("gosns: SecretSource has no Region")
+	}
+	if s.Credentials == nil {
+		creds, err := LoadCredentials()
+		if err != nil {
+			return err
+		}
+		s.Credentials = creds
+	}
+	if endpoint == "" {
+		endpoint = RegionalEndpoint(service, s.Region)
+	}
+	status, data, err := awsJSON(ctx, s.Client, s.Credentials, s.Region, service, endpoint, target, in)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		serr := &SecretError{Ref: ref, StatusCode: status}
+		serr.Type, serr.Message = jsonError(data)
+		return serr
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Watch resolves ref every interval until ctx is done, so that a rotated
+// secret takes effect without a restart, calling update with each value
+// which differs from current. It is called with any error instead, in which
+// case the previous value remains in effect and the next interval is tried.
+func (s *SecretSource) Watch(ctx context.Context, ref, current string, interval time.Duration, update func(value string, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		value, err := s.Resolve(ctx, ref)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			update("", err)
+		} else if value != current {
+			current = value
+			update(value, nil)
+		}
+	}
+}
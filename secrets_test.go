@@ -0,0 +1,108 @@
+package gosns_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// fakeSecrets serves GetSecretValue and GetParameter from values.
+type fakeSecrets struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeSecrets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		SecretId       string
+		Name           string
+		WithDecryption bool
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Header.Get("X-Amz-Target") {
+	case "secretsmanager.GetSecretValue":
+		if v, ok := f.values[in.SecretId]; ok {
+			json.NewEncoder(w).Encode(map[string]string{"SecretString": v})
+			return
+		}
+	case "AmazonSSM.GetParameter":
+		if v, ok := f.values[in.Name]; ok && in.WithDecryption {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Parameter": map[string]string{"Value": v}})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "not found"}`))
+}
+
+func (f *fakeSecrets) set(name, value string) {
+	f.mu.Lock()
+	f.values[name] = value
+	f.mu.Unlock()
+}
+
+func TestSecretSource(t *testing.T) {
+	fake := &fakeSecrets{values: map[string]string{
+		"prod/sns":           `{"username": "sns", "password": "s3cret"}`,
+		"prod/token":         "t0ken",
+		"/prod/sns/password": "p4ss",
+	}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	s := &gosns.SecretSource{Region: "us-gov-west-1", SecretsManagerEndpoint: ts.URL, SSMEndpoint: ts.URL,
+		Credentials: &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}}
+	ctx := context.Background()
+
+	for ref, want := range map[string]string{
+		"secretsmanager:prod/sns#password": "s3cret",
+		"secretsmanager:prod/token":        "t0ken",
+		"ssm:/prod/sns/password":           "p4ss",
+		"plain value":                      "plain value",
+	} {
+		if got, err := s.Resolve(ctx, ref); err != nil || got != want {
+			t.Errorf("%s resolved to %q, %v", ref, got, err)
+		}
+	}
+	for _, ref := range []string{"secretsmanager:prod/sns#missing", "secretsmanager:prod/token#password"} {
+		if _, err := s.Resolve(ctx, ref); err == nil {
+			t.Errorf("%s resolved", ref)
+		}
+	}
+	var serr *gosns.SecretError
+	if _, err := s.Resolve(ctx, "ssm:/nope"); !errors.As(err, &serr) || serr.Type != "ResourceNotFoundException" {
+		t.Errorf("missing parameter gave %v", err)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	updates := make(chan string, 10)
+	go s.Watch(wctx, "ssm:/prod/sns/password", "p4ss", 10*time.Millisecond, func(value string, err error) {
+		if err == nil {
+			updates <- value
+		}
+	})
+	time.Sleep(30 * time.Millisecond)
+	fake.set("/prod/sns/password", "rotated")
+	select {
+	case v := <-updates:
+		if v != "rotated" {
+			t.Errorf("updated to %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("rotation not seen")
+	}
+	select {
+	case v := <-updates:
+		t.Errorf("updated again to %q", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
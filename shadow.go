@@ -0,0 +1,68 @@
+package gosns
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ShadowStats counts the calls of a topic's Shadow callback since the
+// server started.
+type ShadowStats struct {
+	Calls       uint64
+	Failures    uint64 // calls which panicked or called Fail
+	LastError   string `json:",omitempty"`
+	LastErrorAt time.Time
+}
+
+// shadowStats is guarded by a mutex of its own so that shadow calls do not
+// contend with anything the primary callback does.
+type shadowStats struct {
+	mu    sync.Mutex
+	stats ShadowStats
+}
+
+// shadow passes a copy of msg to the topic's Shadow callback on a goroutine
+// of its own, recording whether it failed.
+func (t *Topic) shadow(msg *Message) {
+	cp := *msg
+	cp.outbox, cp.failed = nil, nil
+	if msg.MessageAttributes != nil {
+		cp.MessageAttributes = make(map[string]MessageAttribute, len(msg.MessageAttributes))
+		for k, v := range msg.MessageAttributes {
+			cp.MessageAttributes[k] = v
+		}
+	}
+	id := msg.MessageId
+	go func() {
+		var err error
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("panic: %v", p)
+				}
+			}()
+			t.Shadow(&cp)
+			err = cp.failed
+		}()
+
+		t.shadows.mu.Lock()
+		t.shadows.stats.Calls++
+		if err != nil {
+			t.shadows.stats.Failures++
+			t.shadows.stats.LastError = err.Error()
+			t.shadows.stats.LastErrorAt = time.Now()
+		}
+		t.shadows.mu.Unlock()
+		if err != nil {
+			t.logf(nil, LogWarn, "shadow callback failed for message %s on topic '%s': %v\n", id, t.TopicARN, err)
+		}
+	}()
+}
+
+// ShadowStats returns the counts of the topic's Shadow calls.
+func (t *Topic) ShadowStats() ShadowStats {
+	t.shadows.mu.Lock()
+	defer t.shadows.mu.Unlock()
+	return t.shadows.stats
+}
@@ -0,0 +1,47 @@
+package gosns_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestShadow(t *testing.T) {
+	got := make(chan string, 4)
+	s := &gosns.Server{}
+	orders := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	orders.Delivery = gosns.AtLeastOnce
+	orders.Shadow = func(msg *gosns.Message) {
+		switch msg.Message {
+		case "panic":
+			panic("rewrite not finished")
+		case "fail":
+			msg.Fail(errors.New("cannot parse order"))
+		}
+		msg.Message = "changed by the shadow"
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, body := range []string{"ok", "fail", "panic"} {
+		if resp, _, err := ts.Notify("/orders", ordersARN, "", body); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got %v, %v", body, resp, err)
+		}
+		if msg := <-got; msg != body {
+			t.Errorf("callback got %q, want %q", msg, body)
+		}
+	}
+	if !eventually(func() bool { return orders.ShadowStats().Calls == 3 }) {
+		t.Fatalf("stats %+v", orders.ShadowStats())
+	}
+	if st := orders.ShadowStats(); st.Failures != 2 || st.LastError == "" || st.LastErrorAt.IsZero() {
+		t.Errorf("stats %+v", st)
+	}
+}
@@ -0,0 +1,380 @@
+// Package shard forwards messages to a set of downstream worker processes,
+// turning a gosns server into a dispatch tier in front of horizontally
+// scaled consumers. Each message goes to the worker a consistent hash of its
+// key selects, so messages with the same key reach the same worker, and
+// adding or removing a worker moves only that worker's share of the keys:
+//
+//	pool := &shard.Pool{
+//		Workers: []string{"http://worker-1:8080/", "http://worker-2:8080/"},
+//		Key:     func(msg *gosns.Message) string { return msg.MessageAttributes["customer"].Value },
+//	}
+//	s.AddTopic(topicARN, "/orders", pool.Handle)
+//
+// Messages are POSTed to the worker's URL as a JSON object in the wire form
+// of package plugin, or as Encode renders them, with the key in the
+// KeyHeader header and the delivery's request ID in gosns.RequestIDHeader. A
+// worker accepts a message by answering with a 2xx status. If it cannot be
+// reached or answers with a 5xx, it is marked down and the message goes to
+// the next worker on the ring, until health checks or RetryAfter bring it
+// back. Workers are spoken to over HTTP only.
+package shard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/plugin"
+)
+
+// Defaults used when the corresponding Pool fields are zero.
+const (
+	DefaultReplicas       = 100
+	DefaultTimeout        = 10 * time.Second
+	DefaultHealthInterval = 10 * time.Second
+	DefaultRetryAfter     = 30 * time.Second
+)
+
+// KeyHeader carries a forwarded message's shard key.
+const KeyHeader = "X-Gosns-Shard-Key"
+
+// ErrNoWorkers is returned by Write for a Pool without Workers.
+var ErrNoWorkers = errors.New("shard: no workers")
+
+// Pool is a set of workers sharing messages by consistent hashing. Its
+// fields must not be changed once it has been used.
+type Pool struct {
+	// Workers are the URLs messages are POSTed to.
+	Workers []string
+
+	// Key returns the shard key of a message. If it is nil, or returns "",
+	// the message's MessageId is used, spreading messages evenly.
+	Key func(*gosns.Message) string
+
+	// Replicas is the number of points each worker has on the ring. More
+	// points spread keys more evenly. Zero means DefaultReplicas.
+	Replicas int
+
+	// HealthPath, if set, is requested from every worker each HealthInterval
+	// (zero means DefaultHealthInterval), and a worker is up while it answers
+	// with a 2xx status. Without it, a worker marked down by a failed
+	// delivery is tried again after RetryAfter (zero means DefaultRetryAfter).
+	HealthPath     string
+	HealthInterval time.Duration
+	RetryAfter     time.Duration
+
+	// Client sends requests to workers; nil means an http.Client with a
+	// timeout of Timeout (zero means DefaultTimeout).
+	Client  *http.Client
+	Timeout time.Duration
+
+	// Encode, if set, returns the body forwarded for a message, such as a
+	// payload.Format's Render, in place of the wire form of package plugin;
+	// ContentType is its type (default application/json).
+	Encode      func(*gosns.Message) ([]byte, error)
+	ContentType string
+
+	// Logger receives notices of workers going down and coming back up, and
+	// of messages Handle could not deliver, if set.
+	Logger *log.Logger
+
+	once   sync.Once
+	client *http.Client
+	ring   []point
+	stop   chan struct{}
+
+	mu   sync.Mutex
+	down map[string]time.Time // worker URL to when it was marked down
+}
+
+type point struct {
+	hash   uint64
+	worker string
+}
+
+// hash is FNV-1a with a final mix, since FNV alone spreads keys which
+// differ only in their last few characters, like "worker#1" and "worker#2",
+// poorly around the ring.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// init builds the ring and starts health checks on first use.
+func (p *Pool) init() {
+	p.once.Do(func() {
+		p.client = p.Client
+		if p.client == nil {
+			timeout := p.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			p.client = &http.Client{Timeout: timeout}
+		}
+		replicas := p.Replicas
+		if replicas <= 0 {
+			replicas = DefaultReplicas
+		}
+		for _, w := range p.Workers {
+			for i := 0; i < replicas; i++ {
+				p.ring = append(p.ring, point{hash(w + "#" + strconv.Itoa(i)), w})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+		p.down = make(map[string]time.Time)
+		p.stop = make(chan struct{})
+		if p.HealthPath != "" && len(p.Workers) > 0 {
+			go p.checkHealth()
+		}
+	})
+}
+
+// Close stops health checks.
+func (p *Pool) Close() {
+	p.init()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+}
+
+func (p *Pool) logf(format string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, args...)
+	}
+}
+
+func (p *Pool) key(msg *gosns.Message) string {
+	if p.Key != nil {
+		if k := p.Key(msg); k != "" {
+			return k
+		}
+	}
+	return msg.MessageId
+}
+
+// candidates returns every worker in ring order from key's point, up workers
+// first.
+func (p *Pool) candidates(key string) []string {
+	if len(p.ring) == 0 {
+		return nil
+	}
+	h := hash(key)
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	seen := make(map[string]bool, len(p.Workers))
+	var up, down []string
+	for i := 0; i < len(p.ring) && len(seen) < len(p.Workers); i++ {
+		w := p.ring[(start+i)%len(p.ring)].worker
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		if p.isUp(w) {
+			up = append(up, w)
+		} else {
+			down = append(down, w)
+		}
+	}
+	return append(up, down...)
+}
+
+// Worker returns the worker a message with the given key is sent to first:
+// its owner on the ring, or the next up worker while the owner is down.
+func (p *Pool) Worker(key string) string {
+	p.init()
+	if c := p.candidates(key); len(c) > 0 {
+		return c[0]
+	}
+	return ""
+}
+
+func (p *Pool) isUp(worker string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	since, ok := p.down[worker]
+	if !ok {
+		return true
+	}
+	if p.HealthPath != "" {
+		return false
+	}
+	retry := p.RetryAfter
+	if retry <= 0 {
+		retry = DefaultRetryAfter
+	}
+	return time.Since(since) >= retry
+}
+
+func (p *Pool) markDown(worker string, err error) {
+	p.mu.Lock()
+	_, already := p.down[worker]
+	p.down[worker] = time.Now()
+	p.mu.Unlock()
+	if !already {
+		p.logf("shard: worker '%s' is down: %v\n", worker, err)
+	}
+}
+
+func (p *Pool) markUp(worker string) {
+	p.mu.Lock()
+	_, was := p.down[worker]
+	delete(p.down, worker)
+	p.mu.Unlock()
+	if was {
+		p.logf("shard: worker '%s' is up\n", worker)
+	}
+}
+
+// Write forwards msg to its worker, failing over along the ring to the
+// other workers, down ones last. It returns an error only if no worker
+// accepted the message.
+func (p *Pool) Write(msg *gosns.Message) error {
+	p.init()
+	var body []byte
+	var err error
+	if p.Encode != nil {
+		body, err = p.Encode(msg)
+	} else {
+		body, err = json.Marshal(plugin.NewMessage(msg))
+	}
+	if err != nil {
+		return err
+	}
+	key := p.key(msg)
+	workers := p.candidates(key)
+	if len(workers) == 0 {
+		return ErrNoWorkers
+	}
+	for _, w := range workers {
+		err = p.send(msg.Context(), w, key, body)
+		if err == nil {
+			p.markUp(w)
+			return nil
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			// the worker is healthy but refused this message
+			return err
+		}
+		p.markDown(w, err)
+	}
+	return fmt.Errorf("shard: no worker accepted message %s: %w", msg.MessageId, err)
+}
+
+// Handle forwards msg as a Topic callback, logging messages which could
+// not be delivered.
+func (p *Pool) Handle(msg *gosns.Message) {
+	if msg == nil {
+		return
+	}
+	if err := p.Write(msg); err != nil {
+		p.logf("%v\n", err)
+	}
+}
+
+// rejectedError is a 4xx answer, which another worker would give too.
+type rejectedError struct {
+	worker string
+	status int
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("shard: worker '%s' refused the message with status %d", e.worker, e.status)
+}
+
+func (p *Pool) send(ctx context.Context, worker, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, worker, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(KeyHeader, key)
+	if id := gosns.RequestID(ctx); id != "" {
+		req.Header.Set(gosns.RequestIDHeader, id)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return &rejectedError{worker, resp.StatusCode}
+	}
+	return nil
+}
+
+// checkHealth requests HealthPath from every worker each HealthInterval
+// until Close is called.
+func (p *Pool) checkHealth() {
+	interval := p.HealthInterval
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, w := range p.Workers {
+			if err := p.probe(w); err != nil {
+				p.markDown(w, err)
+			} else {
+				p.markUp(w)
+			}
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) probe(worker string) error {
+	base, err := url.Parse(worker)
+	if err != nil {
+		return err
+	}
+	ref, err := url.Parse(p.HealthPath)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Get(base.ResolveReference(ref).String())
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("health check status %d", resp.StatusCode)
+	}
+	return nil
+}
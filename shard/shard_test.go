@@ -0,0 +1,183 @@
+package shard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/payload"
+	"github.com/pbnjay/gosns/plugin"
+)
+
+// worker is a downstream test worker recording the keys it received.
+type worker struct {
+	*httptest.Server
+	failing int32 // answer every request with a 503 while set
+
+	mu   sync.Mutex
+	keys map[string]int
+}
+
+func newWorker(t *testing.T) *worker {
+	w := &worker{keys: make(map[string]int)}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&w.failing) != 0 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodGet {
+			return
+		}
+		var m plugin.Message
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m.MessageId == "" {
+			t.Errorf("bad message: %v", err)
+		}
+		if m.Subject == "refuse" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.mu.Lock()
+		w.keys[r.Header.Get(KeyHeader)]++
+		w.mu.Unlock()
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *worker) count(key string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.keys[key]
+}
+
+func newMessage(id, customer string) *gosns.Message {
+	return &gosns.Message{MessageId: id, Message: "hello", MessageAttributes: map[string]gosns.MessageAttribute{
+		"customer": {Type: "String", Value: customer},
+	}}
+}
+
+func customerKey(msg *gosns.Message) string { return msg.MessageAttributes["customer"].Value }
+
+func TestPoolConsistent(t *testing.T) {
+	workers := []*worker{newWorker(t), newWorker(t), newWorker(t)}
+	p := &Pool{Key: customerKey}
+	for _, w := range workers {
+		p.Workers = append(p.Workers, w.URL)
+	}
+	defer p.Close()
+
+	owners := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("customer-%d", i%30)
+		if err := p.Write(newMessage(fmt.Sprint(i), key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("customer-%d", i)
+		for j, w := range workers {
+			if n := w.count(key); n == 10 {
+				owners[key] = j
+			} else if n != 0 {
+				t.Errorf("%s: worker %d got %d of 10 messages", key, j, n)
+			}
+		}
+	}
+	used := make(map[int]bool)
+	for _, j := range owners {
+		used[j] = true
+	}
+	if len(owners) != 30 || len(used) != 3 {
+		t.Errorf("keys were not spread across the workers: %v", owners)
+	}
+
+	// removing a worker moves only its own keys
+	smaller := &Pool{Workers: p.Workers[:2]}
+	for key, j := range owners {
+		if j < 2 && smaller.Worker(key) != p.Workers[j] {
+			t.Errorf("%s moved from worker %d", key, j)
+		}
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	a, b := newWorker(t), newWorker(t)
+	p := &Pool{Workers: []string{a.URL, b.URL}, Key: customerKey, HealthPath: "/healthz", HealthInterval: 10 * time.Millisecond}
+	defer p.Close()
+
+	key := "customer-1"
+	owner, other := a, b
+	if p.Worker(key) == b.URL {
+		owner, other = b, a
+	}
+	atomic.StoreInt32(&owner.failing, 1)
+	if err := p.Write(newMessage("1", key)); err != nil {
+		t.Fatal(err)
+	}
+	if other.count(key) != 1 || p.Worker(key) != other.URL {
+		t.Fatalf("message was not failed over to the other worker")
+	}
+
+	atomic.StoreInt32(&owner.failing, 0)
+	for deadline := time.Now().Add(5 * time.Second); p.Worker(key) != owner.URL; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("health checks did not bring the worker back")
+		}
+	}
+	if err := p.Write(newMessage("2", key)); err != nil || owner.count(key) != 1 {
+		t.Errorf("message after recovery: %v", err)
+	}
+
+	atomic.StoreInt32(&a.failing, 1)
+	atomic.StoreInt32(&b.failing, 1)
+	if err := p.Write(newMessage("3", key)); err == nil {
+		t.Error("write succeeded with every worker failing")
+	}
+}
+
+func TestPoolRejected(t *testing.T) {
+	a, b := newWorker(t), newWorker(t)
+	p := &Pool{Workers: []string{a.URL, b.URL}}
+	msg := newMessage("1", "")
+	msg.Subject = "refuse"
+	if err := p.Write(msg); err == nil {
+		t.Fatal("refused message was accepted")
+	}
+	if p.Worker("1") == "" || !p.isUp(p.Worker("1")) {
+		t.Error("refusing a message marked the worker down")
+	}
+	if a.count("1")+b.count("1") != 0 {
+		t.Error("refused message was retried on another worker")
+	}
+
+	if err := (&Pool{}).Write(msg); err != ErrNoWorkers {
+		t.Errorf("empty pool gave %v", err)
+	}
+}
+
+func TestPoolEncode(t *testing.T) {
+	var body, contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, contentType = string(data), r.Header.Get("Content-Type")
+	}))
+	defer ts.Close()
+	q, err := payload.ParseQuery(`{id: msg.id, customer: msg.attributes.customer}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Pool{Workers: []string{ts.URL}, Encode: q.Render, ContentType: "application/vnd.orders+json"}
+	if err := p.Write(newMessage("1", "acme")); err != nil {
+		t.Fatal(err)
+	}
+	if body != `{"customer":"acme","id":"1"}` || contentType != "application/vnd.orders+json" {
+		t.Errorf("got %s as %s", body, contentType)
+	}
+}
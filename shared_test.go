@@ -0,0 +1,67 @@
+package gosns_test
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestSharedState(t *testing.T) {
+	store := &gosns.MemoryStore{}
+	var handled int32
+	replica := func() *gosnstest.Server {
+		s := &gosns.Server{Strict: true, Store: store, SharedStore: true, Dedup: store}
+		topic := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+			if msg != nil {
+				atomic.AddInt32(&handled, 1)
+			}
+		})
+		topic.DuplicateWindow = time.Minute
+		topic.DropDuplicates = true
+		return gosnstest.NewServer(s)
+	}
+	a, b := replica(), replica()
+	defer a.Close()
+	defer b.Close()
+
+	_, conf, err := a.Confirm("/orders", ordersARN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subARN, ok := a.Confirmed(conf.Token)
+	if !ok {
+		t.Fatal("replica did not confirm the subscription")
+	}
+
+	env := gosnstest.NewNotification(ordersARN, "", "hello")
+	env.SubscriptionArn = subARN
+	for _, ts := range []*gosnstest.Server{b, a} {
+		if resp, err := ts.Send("/orders", env); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("delivery gave %v, %v", resp, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Errorf("handled %d times across replicas, want once", n)
+	}
+}
+
+func TestMemoryStoreRemember(t *testing.T) {
+	m := &gosns.MemoryStore{}
+	if seen, _ := m.Remember("a", time.Hour); seen {
+		t.Error("new key seen")
+	}
+	if seen, _ := m.Remember("a", time.Hour); !seen {
+		t.Error("repeated key not seen")
+	}
+	if seen, _ := m.Remember("b", -time.Second); seen {
+		t.Error("new key seen")
+	}
+	if seen, _ := m.Remember("b", time.Hour); seen {
+		t.Error("expired key seen")
+	}
+}
@@ -0,0 +1,77 @@
+package gosns
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSkewThreshold is the SkewThreshold used when it is zero.
+const DefaultSkewThreshold = 30 * time.Second
+
+// skewWindow is how long the clock offset is estimated over.
+const skewWindow = time.Minute
+
+// clockSkew estimates the offset of the local clock from SNS's. Delivery
+// takes time, so the difference between when a message is received and its
+// Timestamp is the offset plus a delay which is never negative; the smallest
+// difference seen over a window, from the message delivered quickest, is
+// the best estimate of the offset.
+type clockSkew struct {
+	mu       sync.Mutex
+	start    time.Time
+	min      time.Duration
+	offset   time.Duration
+	estimate bool
+}
+
+// observe records a message received at now with the given SNS timestamp,
+// returning the estimated offset once per window.
+func (c *clockSkew) observe(now, timestamp time.Time) (offset time.Duration, ended bool) {
+	d := now.Sub(timestamp)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.start.IsZero() {
+		c.start, c.min = now, d
+		return 0, false
+	}
+	if d < c.min {
+		c.min = d
+	}
+	if now.Sub(c.start) < skewWindow {
+		return 0, false
+	}
+	c.offset, c.estimate = c.min, true
+	c.start, c.min = now, d
+	return c.offset, true
+}
+
+// ClockOffset returns how far the local clock appeared to be ahead of SNS's
+// (or behind, if negative) over the last minute in which notifications
+// arrived, and false if there is no estimate yet. Delivery delays make it
+// an overestimate when no message arrived promptly.
+func (s *Server) ClockOffset() (time.Duration, bool) {
+	s.skew.mu.Lock()
+	defer s.skew.mu.Unlock()
+	return s.skew.offset, s.skew.estimate
+}
+
+// observeClock checks a message's SNS timestamp against the local clock,
+// warning about the offset once per window while it is over SkewThreshold.
+func (s *Server) observeClock(timestamp time.Time) {
+	if timestamp.IsZero() || s.SkewThreshold < 0 {
+		return
+	}
+	offset, ended := s.skew.observe(time.Now(), timestamp)
+	if !ended {
+		return
+	}
+	threshold := s.SkewThreshold
+	if threshold == 0 {
+		threshold = DefaultSkewThreshold
+	}
+	if offset > threshold {
+		s.logf(LogWarn, "Local clock appears to be %s ahead of SNS; check NTP\n", offset.Round(time.Millisecond))
+	} else if offset < -threshold {
+		s.logf(LogWarn, "Local clock appears to be %s behind SNS; check NTP\n", (-offset).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,46 @@
+package gosns
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClockSkewEstimate(t *testing.T) {
+	var c clockSkew
+	now := time.Now()
+	// the local clock is 45s ahead; deliveries take between 0.1s and 5s
+	for i, delay := range []time.Duration{5 * time.Second, 100 * time.Millisecond, 2 * time.Second} {
+		if _, ended := c.observe(now.Add(time.Duration(i)*10*time.Second), now.Add(time.Duration(i)*10*time.Second-45*time.Second-delay)); ended {
+			t.Fatal("window ended early")
+		}
+	}
+	offset, ended := c.observe(now.Add(skewWindow), now.Add(skewWindow-45*time.Second-3*time.Second))
+	if !ended || offset != 45*time.Second+100*time.Millisecond {
+		t.Errorf("estimated %s, %v", offset, ended)
+	}
+}
+
+func TestClockSkewWarning(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{Logger: log.New(&buf, "", 0)}
+	now := time.Now()
+	s.skew.observe(now.Add(-skewWindow), now.Add(-skewWindow+2*time.Minute))
+	s.observeClock(now.Add(2 * time.Minute))
+	if offset, ok := s.ClockOffset(); !ok || offset > -119*time.Second {
+		t.Errorf("offset %s, %v", offset, ok)
+	}
+	if !strings.Contains(buf.String(), "behind SNS") {
+		t.Errorf("no warning logged: %q", buf.String())
+	}
+
+	buf.Reset()
+	s = &Server{Logger: log.New(&buf, "", 0), SkewThreshold: time.Hour}
+	s.skew.observe(now.Add(-skewWindow), now.Add(-skewWindow+2*time.Minute))
+	s.observeClock(now.Add(2 * time.Minute))
+	if buf.Len() != 0 {
+		t.Errorf("warned below the threshold: %q", buf.String())
+	}
+}
@@ -0,0 +1,184 @@
+package gosns
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSLOWindow is the window over which a topic's SLO compliance is
+// computed when its SLOWindow is zero.
+const DefaultSLOWindow = time.Hour
+
+// DefaultSLOBurnRate is the burn rate at which SLOBurning is called when a
+// topic's SLOBurnRate is zero: at this rate, the error budget of a whole
+// SLOWindow would be spent in a sixth of it.
+const DefaultSLOBurnRate = 6
+
+// sloSlots is the number of slots an SLO window is counted in, and
+// sloShortSlots the number of the latest making up the short window for
+// burn rates.
+const (
+	sloSlots      = 60
+	sloShortSlots = sloSlots / 12
+)
+
+// minSLOBurnMessages is the fewest messages in the short window for which
+// a burn rate is reported as burning, so that one failure among a handful
+// of messages does not.
+const minSLOBurnMessages = 10
+
+// SLOStatus is the rolling compliance of a topic with its processing SLO.
+type SLOStatus struct {
+	Target  float64
+	Latency time.Duration
+	Window  time.Duration
+
+	// Messages counts those handled within the Window, and Good those among
+	// them whose callbacks succeeded within Latency.
+	Messages uint64
+	Good     uint64
+
+	// Compliance is Good as a fraction of Messages, or 1 if there were none.
+	// BudgetRemaining is the fraction of the error budget, the 1 - Target
+	// of Messages which may be bad, that is left; it is negative once the
+	// SLO is breached.
+	Compliance      float64
+	BudgetRemaining float64
+
+	// BurnRate is the rate the error budget was spent at over the latest
+	// twelfth of the Window, as a multiple of the rate which would spend it
+	// exactly over the Window. Burning is whether it is at least the
+	// topic's SLOBurnRate.
+	BurnRate float64
+	Burning  bool
+}
+
+// sloSlot counts the messages handled in one slot of an SLO window.
+type sloSlot struct {
+	n           int64 // index of the slot since the epoch
+	good, total uint64
+}
+
+// sloTracker counts the messages a topic handled within its SLO window.
+type sloTracker struct {
+	topic *Topic
+	width time.Duration // of a slot
+
+	mu      sync.Mutex
+	slots   [sloSlots]sloSlot
+	burning bool
+}
+
+func newSLOTracker(t *Topic) *sloTracker {
+	return &sloTracker{topic: t, width: t.sloWindow() / sloSlots}
+}
+
+func (t *Topic) sloWindow() time.Duration {
+	if t.SLOWindow > 0 {
+		return t.SLOWindow
+	}
+	return DefaultSLOWindow
+}
+
+// observe records a message handled in took, which failed unless ok.
+func (st *sloTracker) observe(took time.Duration, ok bool) {
+	t := st.topic
+	n := time.Now().UnixNano() / int64(st.width)
+	st.mu.Lock()
+	slot := &st.slots[n%sloSlots]
+	if slot.n != n {
+		*slot = sloSlot{n: n}
+	}
+	slot.total++
+	if ok && took <= t.SLOLatency {
+		slot.good++
+	}
+	status := st.status(n)
+	started := status.Burning && !st.burning
+	stopped := !status.Burning && st.burning
+	st.burning = status.Burning
+	st.mu.Unlock()
+
+	if started {
+		t.server.logf(LogWarn, "Topic '%s' is burning its SLO error budget %.1fx too fast, at %.2f%% compliance\n", t.TopicARN, status.BurnRate, 100*status.Compliance)
+		if t.SLOBurning != nil {
+			go t.SLOBurning(t, status)
+		}
+	} else if stopped {
+		t.server.logf(LogInfo, "Topic '%s' is no longer burning its SLO error budget\n", t.TopicARN)
+	}
+}
+
+// status sums the slots of the window ending with slot n. st.mu is held.
+func (st *sloTracker) status(n int64) SLOStatus {
+	t := st.topic
+	res := SLOStatus{Target: t.SLOTarget, Latency: t.SLOLatency, Window: t.sloWindow(), Compliance: 1, BudgetRemaining: 1}
+	var shortGood, shortTotal uint64
+	for _, slot := range st.slots {
+		if slot.n <= n-sloSlots || slot.n > n {
+			continue
+		}
+		res.Good += slot.good
+		res.Messages += slot.total
+		if slot.n > n-sloShortSlots {
+			shortGood += slot.good
+			shortTotal += slot.total
+		}
+	}
+	budget := 1 - t.SLOTarget
+	if res.Messages > 0 {
+		res.Compliance = float64(res.Good) / float64(res.Messages)
+		if budget > 0 {
+			res.BudgetRemaining = 1 - (1-res.Compliance)/budget
+		}
+	}
+	if shortTotal > 0 && budget > 0 {
+		res.BurnRate = (1 - float64(shortGood)/float64(shortTotal)) / budget
+	}
+	rate := t.SLOBurnRate
+	if rate <= 0 {
+		rate = DefaultSLOBurnRate
+	}
+	res.Burning = shortTotal >= minSLOBurnMessages && res.BurnRate >= rate
+	return res
+}
+
+// SLO returns the topic's compliance with its SLO over the latest
+// SLOWindow. It is zero unless SLOTarget is set.
+func (t *Topic) SLO() SLOStatus {
+	t.startOnce.Do(t.start)
+	st := t.slo
+	if st == nil {
+		return SLOStatus{}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.status(time.Now().UnixNano() / int64(st.width))
+}
+
+// sloTopics returns the topics with an SLOTarget, by endpoint.
+func (s *Server) sloTopics() map[string]*Topic {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	topics := make(map[string]*Topic)
+	for endpoint, td := range s.topics {
+		if td.SLOTarget > 0 {
+			topics[endpoint] = td
+		}
+	}
+	return topics
+}
+
+func (s *Server) adminSLO(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	res := make(map[string]SLOStatus)
+	for endpoint, td := range s.sloTopics() {
+		res[endpoint] = td.SLO()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,69 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestSLO(t *testing.T) {
+	s := &gosns.Server{}
+	topic := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		switch msg.Message {
+		case "fail":
+			msg.Fail(nil)
+		case "slow":
+			time.Sleep(60 * time.Millisecond)
+		}
+	})
+	topic.Delivery = gosns.AtLeastOnce
+	topic.SLOTarget, topic.SLOLatency = 0.95, 50*time.Millisecond
+	burning := make(chan gosns.SLOStatus, 1)
+	topic.SLOBurning = func(_ *gosns.Topic, st gosns.SLOStatus) { burning <- st }
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	if st := topic.SLO(); st.Messages != 0 || st.Compliance != 1 || st.BudgetRemaining != 1 || st.Window != gosns.DefaultSLOWindow {
+		t.Errorf("initial status %+v", st)
+	}
+	for i := 0; i < 38; i++ {
+		ts.Notify("/orders", ordersARN, "", "ok")
+	}
+	ts.Notify("/orders", ordersARN, "", "slow")
+	select {
+	case st := <-burning:
+		t.Fatalf("burning at %+v", st)
+	default:
+	}
+	st := topic.SLO()
+	if st.Messages != 39 || st.Good != 38 || st.BudgetRemaining < 0.4 || st.BudgetRemaining > 0.5 {
+		t.Errorf("status %+v", st)
+	}
+
+	// failures spend the budget, and burning it fast calls the hook once
+	for i := 0; i < 20; i++ {
+		ts.Notify("/orders", ordersARN, "", "fail")
+	}
+	select {
+	case st := <-burning:
+		if !st.Burning || st.BurnRate < gosns.DefaultSLOBurnRate {
+			t.Errorf("burning status %+v", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("burn was not reported")
+	}
+	if st := topic.SLO(); st.Messages != 59 || st.Good != 38 || st.BudgetRemaining >= 0 || !st.Burning {
+		t.Errorf("status %+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	s.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/slo", nil))
+	var res map[string]gosns.SLOStatus
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || res["/orders"].Messages != 59 {
+		t.Errorf("admin gave %v, %v", res, err)
+	}
+}
@@ -0,0 +1,253 @@
+package gosns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pbnjay/gosns/internal/sigv4"
+)
+
+// SNSClient issues signed requests to the SNS Query API. It covers only the
+// few actions this package needs; Call may be used for any other.
+type SNSClient struct {
+	Region string
+
+	// Credentials sign each request. If nil, LoadCredentials is called on
+	// first use.
+	Credentials *Credentials
+
+	// Endpoint overrides the regional SNS endpoint URL, for example to use
+	// a local emulator.
+	Endpoint string
+
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// SNSError is an error response from the SNS API.
+type SNSError struct {
+	Action     string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *SNSError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s: unexpected status %d", e.Action, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Action, e.Code, e.Message)
+}
+
+type snsErrorResponse struct {
+	Error struct {
+		Code    string
+		Message string
+	}
+}
+
+func (c *SNSClient) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return RegionalEndpoint("sns", c.Region)
+}
+
+// Call performs action with params and decodes the XML response into result,
+// which may be nil.
+func (c *SNSClient) Call(ctx context.Context, action string, params url.Values, result interface{}) error {
+	if c.Region == "" {
+		return errors.New("gosns: SNSClient has no Region")
+	}
+	if c.Credentials == nil {
+		creds, err := LoadCredentials()
+		if err != nil {
+			return err
+		}
+		c.Credentials = creds
+	}
+	params.Set("Action", action)
+	params.Set("Version", "2010-03-31")
+	status, data, err := awsQuery(ctx, c.Client, c.Credentials, c.Region, "sns", c.endpoint(), params)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		serr := &SNSError{Action: action, StatusCode: status}
+		var e snsErrorResponse
+		if xml.Unmarshal(data, &e) == nil {
+			serr.Code, serr.Message = e.Error.Code, e.Error.Message
+		}
+		return serr
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+// awsQuery posts an AWS Query API request to endpoint, signed with creds
+// unless they are nil, returning the status and body of the response.
+func awsQuery(ctx context.Context, client *http.Client, creds *Credentials, region, service, endpoint string, params url.Values) (int, []byte, error) {
+	body := []byte(params.Encode())
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if creds != nil {
+		if err = signRequest(ctx, req, body, creds, region, service); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// awsJSON posts a signed AWS JSON API request for target, such as
+// "TrentService.Decrypt", to endpoint, returning the status and body of the
+// response.
+func awsJSON(ctx context.Context, client *http.Client, creds *Credentials, region, service, endpoint, target string, in interface{}) (int, []byte, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	if err = signRequest(ctx, req, body, creds, region, service); err != nil {
+		return 0, nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// signRequest signs req with the current credentials of creds.
+func signRequest(ctx context.Context, req *http.Request, body []byte, creds *Credentials, region, service string) error {
+	c, err := creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sigv4.Sign(req, body, c.AccessKeyID, c.SecretAccessKey, c.SessionToken, region, service, time.Now())
+	return nil
+}
+
+// jsonError returns the type and message of an AWS JSON API error response.
+func jsonError(data []byte) (typ, message string) {
+	var e struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &e) != nil {
+		return "", ""
+	}
+	// types may be namespaced, as in com.amazonaws.kms#Name
+	return e.Type[strings.LastIndex(e.Type, "#")+1:], e.Message
+}
+
+// Subscription is an SNS subscription, as listed by the SNS API. Its
+// SubscriptionArn is "PendingConfirmation" until it has been confirmed.
+type Subscription struct {
+	SubscriptionArn string
+	TopicArn        string
+	Protocol        string
+	Endpoint        string
+	Owner           string
+}
+
+type listSubscriptionsResult struct {
+	Subscriptions []Subscription `xml:"ListSubscriptionsResult>Subscriptions>member"`
+	NextToken     string         `xml:"ListSubscriptionsResult>NextToken"`
+}
+
+type listSubscriptionsByTopicResult struct {
+	Subscriptions []Subscription `xml:"ListSubscriptionsByTopicResult>Subscriptions>member"`
+	NextToken     string         `xml:"ListSubscriptionsByTopicResult>NextToken"`
+}
+
+// ListSubscriptions returns every subscription in the client's region, or
+// those of topicARN if it is not empty.
+func (c *SNSClient) ListSubscriptions(ctx context.Context, topicARN string) ([]Subscription, error) {
+	var subs []Subscription
+	params := url.Values{}
+	for {
+		var page []Subscription
+		var next string
+		if topicARN == "" {
+			var res listSubscriptionsResult
+			if err := c.Call(ctx, "ListSubscriptions", params, &res); err != nil {
+				return nil, err
+			}
+			page, next = res.Subscriptions, res.NextToken
+		} else {
+			params.Set("TopicArn", topicARN)
+			var res listSubscriptionsByTopicResult
+			if err := c.Call(ctx, "ListSubscriptionsByTopic", params, &res); err != nil {
+				return nil, err
+			}
+			page, next = res.Subscriptions, res.NextToken
+		}
+		subs = append(subs, page...)
+		if next == "" {
+			return subs, nil
+		}
+		params = url.Values{"NextToken": {next}}
+	}
+}
+
+type subscribeResult struct {
+	SubscriptionArn string `xml:"SubscribeResult>SubscriptionArn"`
+}
+
+// Subscribe subscribes the http or https URL endpoint to topicARN, returning
+// the subscription ARN (which is "pending confirmation" until the endpoint
+// confirms it).
+func (c *SNSClient) Subscribe(ctx context.Context, topicARN, endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("gosns: endpoint must be an http or https URL: '%s'", endpoint)
+	}
+	var res subscribeResult
+	err = c.Call(ctx, "Subscribe", url.Values{
+		"TopicArn":              {topicARN},
+		"Protocol":              {u.Scheme},
+		"Endpoint":              {endpoint},
+		"ReturnSubscriptionArn": {"true"},
+	}, &res)
+	return res.SubscriptionArn, err
+}
+
+// Unsubscribe deletes a subscription.
+func (c *SNSClient) Unsubscribe(ctx context.Context, subscriptionARN string) error {
+	return c.Call(ctx, "Unsubscribe", url.Values{"SubscriptionArn": {subscriptionARN}}, nil)
+}
@@ -0,0 +1,297 @@
+package gosns
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSQSWaitTime is the longest long poll SQS allows.
+	DefaultSQSWaitTime = 20 * time.Second
+
+	// maxSQSBackoff limits the wait between failing receives.
+	maxSQSBackoff = time.Minute
+)
+
+// unwrapSQS replaces the body of r, if it is an SQS message holding an SNS
+// envelope, with the envelope, and fills in the x-amz-sns-* headers from it
+// where the relay did not set them. Other bodies are left alone.
+func (s *Server) unwrapSQS(r *http.Request) error {
+	body, err := bufferBody(r)
+	if err != nil {
+		return bodyError(err)
+	}
+	if !strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		return nil
+	}
+	inner, err := sqsBody(string(body))
+	if err != nil {
+		return err
+	}
+	if inner == string(body) {
+		return nil
+	}
+	var env envelope
+	if err = json.Unmarshal([]byte(inner), &env); err != nil || env.Type == "" {
+		return errors.New("SQS message body is not an SNS envelope")
+	}
+
+	r.Body = io.NopCloser(strings.NewReader(inner))
+	r.ContentLength = int64(len(inner))
+	for name, value := range map[string]string{
+		"x-amz-sns-message-type": env.Type,
+		"x-amz-sns-message-id":   env.MessageId,
+		"x-amz-sns-topic-arn":    env.TopicArn,
+	} {
+		if r.Header.Get(name) == "" {
+			r.Header.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// SQSConsumer polls an SQS queue subscribed to an SNS topic, passing each
+// message through the server as if SNS had posted it to Endpoint, so that
+// it is handled by the same topic, with the same limits, verification,
+// duplicate detection, Transformers and logging, as HTTP deliveries.
+//
+// Messages may be in the SNS envelope format, or sent with raw message
+// delivery enabled on the subscription. Envelopes are verified as usual if
+// signatures are checked; raw messages, which carry no signature, are
+// trusted for having been read from the queue. Raw messages are given the
+// TopicARN of the topic, and have no Subject or MessageAttributes.
+//
+// A message is deleted from the queue once the server accepts it, which for
+// a notification is a 200, and is otherwise left to be received again once
+// its visibility timeout expires, so that the queue's redrive policy
+// decides what becomes of messages which are never accepted.
+type SQSConsumer struct {
+	QueueURL string
+
+	// Endpoint is the path of the topic which handles the queue's messages.
+	Endpoint string
+
+	// TopicARN is given to raw messages. It defaults to the topic's ARN,
+	// and must be set if that is a pattern.
+	TopicARN string
+
+	// Region defaults to the one in QueueURL.
+	Region string
+
+	// Credentials sign each request. If nil, LoadCredentials is called on
+	// first use.
+	Credentials *Credentials
+
+	// Client is used to send requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// WaitTime is how long each receive waits for messages, defaulting to
+	// DefaultSQSWaitTime.
+	WaitTime time.Duration
+
+	// MaxMessages is the most messages received at once, from 1 to 10,
+	// defaulting to 10.
+	MaxMessages int
+
+	// VisibilityTimeout, if set, overrides the queue's for the messages
+	// received.
+	VisibilityTimeout time.Duration
+}
+
+// SQSError is an error response from the SQS API.
+type SQSError struct {
+	Action     string
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *SQSError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%s: unexpected status %d", e.Action, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Action, e.Code, e.Message)
+}
+
+// sqsMessage is a message as returned by ReceiveMessage.
+type sqsMessage struct {
+	MessageId     string
+	ReceiptHandle string
+	Body          string
+}
+
+type receiveMessageResult struct {
+	Messages []sqsMessage `xml:"ReceiveMessageResult>Message"`
+}
+
+// region returns the consumer's Region, or the one in its QueueURL, which
+// looks like https://sqs.us-east-1.amazonaws.com/123456789012/queue.
+func (c *SQSConsumer) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	u, err := url.Parse(c.QueueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(parts) > 2 && parts[0] == "sqs":
+		return parts[1]
+	case len(parts) > 2 && parts[1] == "queue":
+		return parts[0]
+	}
+	return ""
+}
+
+// call performs an SQS action on the queue and decodes the XML response
+// into result, which may be nil.
+func (c *SQSConsumer) call(ctx context.Context, action string, params url.Values, result interface{}) error {
+	region := c.region()
+	if region == "" {
+		return errors.New("gosns: SQSConsumer has no Region")
+	}
+	if c.Credentials == nil {
+		creds, err := LoadCredentials()
+		if err != nil {
+			return err
+		}
+		c.Credentials = creds
+	}
+	params.Set("Action", action)
+	params.Set("Version", "2012-11-05")
+	status, data, err := awsQuery(ctx, c.Client, c.Credentials, region, "sqs", c.QueueURL, params)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		serr := &SQSError{Action: action, StatusCode: status}
+		var e snsErrorResponse
+		if xml.Unmarshal(data, &e) == nil {
+			serr.Code, serr.Message = e.Error.Code, e.Error.Message
+		}
+		return serr
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+func (c *SQSConsumer) receive(ctx context.Context) ([]sqsMessage, error) {
+	wait, max := c.WaitTime, c.MaxMessages
+	if wait <= 0 {
+		wait = DefaultSQSWaitTime
+	}
+	if max <= 0 || max > 10 {
+		max = 10
+	}
+	params := url.Values{
+		"WaitTimeSeconds":     {strconv.Itoa(int(wait / time.Second))},
+		"MaxNumberOfMessages": {strconv.Itoa(max)},
+	}
+	if c.VisibilityTimeout > 0 {
+		params.Set("VisibilityTimeout", strconv.Itoa(int(c.VisibilityTimeout/time.Second)))
+	}
+	var res receiveMessageResult
+	err := c.call(ctx, "ReceiveMessage", params, &res)
+	return res.Messages, err
+}
+
+// ConsumeSQS polls the consumer's queue until ctx is done or the server
+// drains, returning the reason it stopped. Failing receives are logged and
+// retried with backoff.
+func (s *Server) ConsumeSQS(ctx context.Context, c *SQSConsumer) error {
+	td, ok := s.topic(c.Endpoint)
+	if !ok {
+		return ErrTopicNotFound
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	s.logf(LogInfo, "Consuming SQS queue '%s' for topic '%s'\n", c.QueueURL, td.TopicARN)
+	backoff := time.Second
+	for {
+		msgs, err := c.receive(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			s.logf(LogError, "error receiving from SQS queue '%s', retrying in %s: %v\n", c.QueueURL, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if backoff *= 2; backoff > maxSQSBackoff {
+				backoff = maxSQSBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		for _, m := range msgs {
+			if s.consumeSQS(ctx, c, td, m) {
+				if err := c.call(ctx, "DeleteMessage", url.Values{"ReceiptHandle": {m.ReceiptHandle}}, nil); err != nil {
+					s.logf(LogError, "error deleting message '%s' from SQS queue '%s': %v\n", m.MessageId, c.QueueURL, err)
+				}
+			}
+		}
+	}
+}
+
+// consumeSQS passes m to the server, reporting whether it was accepted.
+func (s *Server) consumeSQS(ctx context.Context, c *SQSConsumer, td *Topic, m sqsMessage) bool {
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, sqsQueueKey, c.QueueURL), "POST", c.Endpoint, strings.NewReader(m.Body))
+	if err != nil {
+		s.logf(LogError, "error building request for SQS message '%s': %v\n", m.MessageId, err)
+		return false
+	}
+	r.RequestURI = c.Endpoint
+	r.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	var env envelope
+	if json.Unmarshal([]byte(m.Body), &env) == nil && env.Type != "" && env.TopicArn != "" {
+		r.Header.Set("x-amz-sns-message-type", env.Type)
+		r.Header.Set("x-amz-sns-message-id", env.MessageId)
+		r.Header.Set("x-amz-sns-topic-arn", env.TopicArn)
+	} else {
+		topicARN := c.TopicARN
+		if topicARN == "" {
+			topicARN = td.TopicARN
+		}
+		r.Header.Set("x-amz-sns-rawdelivery", "true")
+		r.Header.Set("x-amz-sns-message-type", "Notification")
+		r.Header.Set("x-amz-sns-message-id", m.MessageId)
+		r.Header.Set("x-amz-sns-topic-arn", topicARN)
+	}
+
+	rec := &pingRecorder{header: make(http.Header)}
+	s.ServeHTTP(rec, r)
+	if rec.code != http.StatusOK {
+		s.logf(LogWarn, "SQS message '%s' from queue '%s' refused with %d, leaving it on the queue: %s\n",
+			m.MessageId, c.QueueURL, rec.code, strings.TrimSpace(rec.body.String()))
+		return false
+	}
+	return true
+}
+
+// fromSQS reports whether r was read from an SQS queue by ConsumeSQS.
+func fromSQS(r *http.Request) bool {
+	queue, _ := r.Context().Value(sqsQueueKey).(string)
+	return queue != ""
+}
@@ -0,0 +1,185 @@
+package gosns_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestSQSEnvelopes(t *testing.T) {
+	got := make(chan string, 1)
+	s := &gosns.Server{SQSEnvelopes: true, VerifySignatures: true}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		resp, err := ts.Client().Post(ts.URL+"/orders", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	wrap := func(inner []byte) string {
+		b, _ := json.Marshal(map[string]string{"MessageId": gosnstest.NewUUID(), "ReceiptHandle": "rh", "Body": string(inner)})
+		return string(b)
+	}
+
+	e := gosnstest.NewNotification(ordersARN, "", "relayed")
+	if err := ts.Signer.Sign(e); err != nil {
+		t.Fatal(err)
+	}
+	if resp := post(wrap(e.Body())); resp.StatusCode != http.StatusOK {
+		t.Fatalf("relayed notification gave %s", resp.Status)
+	}
+	select {
+	case msg := <-got:
+		if msg != "relayed" {
+			t.Errorf("callback got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("relayed notification not delivered")
+	}
+
+	// the relay cannot be used to smuggle in an unsigned envelope
+	e = gosnstest.NewNotification(ordersARN, "", "forged")
+	if resp := post(wrap(e.Body())); resp.StatusCode == http.StatusOK {
+		t.Error("unsigned relayed notification was accepted")
+	}
+	if resp := post(wrap([]byte("plain text"))); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("SQS message without an envelope gave %s", resp.Status)
+	}
+}
+
+// fakeQueue is an SQS queue answering ReceiveMessage and DeleteMessage.
+type fakeQueue struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []string // bodies not yet received
+	deleted  []string // receipt handles
+}
+
+func newFakeQueue(t *testing.T, bodies ...string) *fakeQueue {
+	q := &fakeQueue{messages: bodies}
+	q.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			t.Error("unsigned SQS request")
+		}
+		r.ParseForm()
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		switch r.PostForm.Get("Action") {
+		case "ReceiveMessage":
+			fmt.Fprint(w, "<ReceiveMessageResponse><ReceiveMessageResult>")
+			for i, body := range q.messages {
+				fmt.Fprintf(w, "<Message><MessageId>m%d</MessageId><ReceiptHandle>rh-%d</ReceiptHandle><Body>", i, i)
+				xml.EscapeText(w, []byte(body))
+				fmt.Fprint(w, "</Body></Message>")
+			}
+			fmt.Fprint(w, "</ReceiveMessageResult></ReceiveMessageResponse>")
+			if len(q.messages) == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			q.messages = nil
+		case "DeleteMessage":
+			q.deleted = append(q.deleted, r.PostForm.Get("ReceiptHandle"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<ErrorResponse><Error><Code>InvalidAction</Code><Message>bad action</Message></Error></ErrorResponse>")
+		}
+	}))
+	t.Cleanup(q.Close)
+	return q
+}
+
+func (q *fakeQueue) deletedHandles() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.deleted...)
+}
+
+func TestConsumeSQS(t *testing.T) {
+	got := make(chan *gosns.Message, 3)
+	s := &gosns.Server{VerifySignatures: true}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	signed := gosnstest.NewNotification(ordersARN, "", "enveloped")
+	if err := ts.Signer.Sign(signed); err != nil {
+		t.Fatal(err)
+	}
+	forged := gosnstest.NewNotification(ordersARN, "", "forged")
+	q := newFakeQueue(t, string(signed.Body()), "raw", string(forged.Body()))
+	c := &gosns.SQSConsumer{
+		QueueURL:    q.URL + "/123456789012/orders",
+		Endpoint:    "/orders",
+		Region:      "us-east-1",
+		Credentials: &gosns.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		WaitTime:    time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.ConsumeSQS(ctx, c) }()
+
+	bodies := map[string]string{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-got:
+			bodies[msg.Message] = msg.TopicArn
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d messages delivered", i)
+		}
+	}
+	if bodies["enveloped"] != ordersARN || bodies["raw"] != ordersARN {
+		t.Errorf("delivered %v", bodies)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(q.deletedHandles()) < 2; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("deleted %v", q.deletedHandles())
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("ConsumeSQS returned %v", err)
+	}
+	deleted := q.deletedHandles()
+	sort.Strings(deleted)
+	if want := []string{"rh-0", "rh-1"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %q", deleted)
+	}
+	select {
+	case msg := <-got:
+		t.Errorf("forged message delivered: %q", msg.Message)
+	default:
+	}
+
+	if err := s.ConsumeSQS(context.Background(), &gosns.SQSConsumer{Endpoint: "/missing"}); err != gosns.ErrTopicNotFound {
+		t.Errorf("unknown endpoint gave %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestMaxMessageAge(t *testing.T) {
+	fresh := make(chan *gosns.Message, 1)
+	stale := make(chan *gosns.Message, 1)
+	s := &gosns.Server{}
+	topic := s.AddTopic("arn:aws:sns:us-east-1:123456789012:prices", "/prices", func(msg *gosns.Message) {
+		if msg != nil {
+			fresh <- msg
+		}
+	})
+	topic.MaxMessageAge = time.Minute
+	topic.Stale = func(msg *gosns.Message) { stale <- msg }
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	old := gosnstest.NewNotification(topic.TopicARN, "", "99.50")
+	old.Timestamp = time.Now().Add(-time.Hour).UTC().Format(gosnstest.TimeFormat)
+	if resp, err := ts.Send("/prices", old); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("stale message gave %v, %v", resp, err)
+	}
+	if resp, _, err := ts.Notify("/prices", topic.TopicARN, "", "101.25"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("fresh message gave %v, %v", resp, err)
+	}
+
+	for _, c := range []struct {
+		ch   chan *gosns.Message
+		want string
+	}{{stale, "99.50"}, {fresh, "101.25"}} {
+		select {
+		case msg := <-c.ch:
+			if msg.Message != c.want {
+				t.Errorf("got %q, want %q", msg.Message, c.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not delivered", c.want)
+		}
+	}
+	select {
+	case msg := <-fresh:
+		t.Errorf("callback got %q", msg.Message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
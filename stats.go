@@ -0,0 +1,255 @@
+package gosns
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStatsSamples is the number of samples kept of each topic when the
+// server's StatsSamples is zero.
+const DefaultStatsSamples = 360
+
+// StatsSample counts the messages a topic's callback handled in one
+// StatsInterval, timed from when each was accepted as for SLOs.
+type StatsSample struct {
+	Time       time.Time // start of the interval
+	Messages   uint64
+	Failed     uint64
+	LatencySum time.Duration
+	LatencyMax time.Duration
+}
+
+// statsMetrics are the series of each topic offered by the admin API's
+// /stats, as the suffix of a target named after the topic's endpoint.
+var statsMetrics = []string{"throughput", "errors", "latency_avg", "latency_max"}
+
+// value returns the sample's value of one of statsMetrics, reporting
+// whether it has one: the rates are per second, and the latencies in
+// milliseconds of intervals with messages.
+func (ss *StatsSample) value(metric string, interval time.Duration) (float64, bool) {
+	switch metric {
+	case "throughput":
+		return float64(ss.Messages) / interval.Seconds(), true
+	case "errors":
+		return float64(ss.Failed) / interval.Seconds(), true
+	case "latency_avg":
+		if ss.Messages == 0 {
+			return 0, false
+		}
+		return float64(ss.LatencySum.Milliseconds()) / float64(ss.Messages), true
+	case "latency_max":
+		if ss.Messages == 0 {
+			return 0, false
+		}
+		return float64(ss.LatencyMax.Milliseconds()), true
+	}
+	return 0, false
+}
+
+// statsRing keeps a topic's latest samples.
+type statsRing struct {
+	interval time.Duration
+	started  int64 // index of the first interval
+
+	mu    sync.Mutex
+	slots []StatsSample
+	index []int64 // of the interval in each slot
+}
+
+func newStatsRing(interval time.Duration, samples int) *statsRing {
+	if samples <= 0 {
+		samples = DefaultStatsSamples
+	}
+	return &statsRing{
+		interval: interval,
+		started:  time.Now().UnixNano() / int64(interval),
+		slots:    make([]StatsSample, samples),
+		index:    make([]int64, samples),
+	}
+}
+
+func (sr *statsRing) observe(took time.Duration, ok bool) {
+	n := time.Now().UnixNano() / int64(sr.interval)
+	i := int(n % int64(len(sr.slots)))
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	ss := &sr.slots[i]
+	if sr.index[i] != n {
+		*ss = StatsSample{Time: time.Unix(0, n*int64(sr.interval))}
+		sr.index[i] = n
+	}
+	ss.Messages++
+	if !ok {
+		ss.Failed++
+	}
+	ss.LatencySum += took
+	if took > ss.LatencyMax {
+		ss.LatencyMax = took
+	}
+}
+
+// samples returns a sample for every interval since the topic started
+// which is still kept and overlaps from to to, in order, including those
+// without messages.
+func (sr *statsRing) samples(from, to time.Time) []StatsSample {
+	width := int64(sr.interval)
+	last := time.Now().UnixNano() / width
+	first := last - int64(len(sr.slots)) + 1
+	if first < sr.started {
+		first = sr.started
+	}
+	if n := from.UnixNano() / width; !from.IsZero() && n > first {
+		first = n
+	}
+	if n := to.UnixNano() / width; !to.IsZero() && n < last {
+		last = n
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	var res []StatsSample
+	for n := first; n <= last; n++ {
+		i := int(n % int64(len(sr.slots)))
+		if sr.index[i] == n {
+			res = append(res, sr.slots[i])
+		} else {
+			res = append(res, StatsSample{Time: time.Unix(0, n*width)})
+		}
+	}
+	return res
+}
+
+// observe records a message whose callback returned took after it was
+// accepted, failed unless ok, in the topic's SLO and stats.
+func (t *Topic) observe(took time.Duration, ok bool) {
+	if t.slo != nil {
+		t.slo.observe(took, ok)
+	}
+	if t.stats != nil {
+		t.stats.observe(took, ok)
+	}
+}
+
+// Stats returns the topic's samples still kept, oldest first. It is nil
+// unless the server's StatsInterval is set.
+func (t *Topic) Stats() []StatsSample {
+	t.startOnce.Do(t.start)
+	if t.stats == nil {
+		return nil
+	}
+	return t.stats.samples(time.Time{}, time.Time{})
+}
+
+// statsTopics returns the topics being sampled, by endpoint.
+func (s *Server) statsTopics() map[string]*Topic {
+	s.topicsMu.RLock()
+	defer s.topicsMu.RUnlock()
+	topics := make(map[string]*Topic)
+	for endpoint, td := range s.topics {
+		td.startOnce.Do(td.start)
+		if td.stats != nil {
+			topics[endpoint] = td
+		}
+	}
+	return topics
+}
+
+// statsTargets returns the names of the series of the sampled topics, as
+// "<endpoint>:<metric>", in order.
+func (s *Server) statsTargets() []string {
+	var res []string
+	for endpoint := range s.statsTopics() {
+		for _, metric := range statsMetrics {
+			res = append(res, endpoint+":"+metric)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// statsQuery is the body of a Grafana JSON datasource query.
+type statsQuery struct {
+	Range struct {
+		From, To time.Time
+	}
+	Targets []struct {
+		Target string
+	}
+}
+
+// statsSeries is a series answering a statsQuery, with its datapoints as
+// [value, milliseconds since the epoch].
+type statsSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// adminStats answers GET /stats with every sampled topic's Stats, and
+// serves the endpoints of the Grafana JSON datasource beneath it.
+func (s *Server) adminStats(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/stats":
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		res := make(map[string][]StatsSample)
+		for endpoint, td := range s.statsTopics() {
+			res[endpoint] = td.Stats()
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	case "/stats/":
+		// the datasource's connection test
+		simpleResponse(w, http.StatusOK, "ok")
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		simpleResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch r.URL.Path {
+	case "/stats/search":
+		writeJSON(w, http.StatusOK, s.statsTargets())
+	case "/stats/metrics":
+		type metric struct {
+			Label string `json:"label"`
+			Value string `json:"value"`
+		}
+		res := []metric{}
+		for _, target := range s.statsTargets() {
+			res = append(res, metric{target, target})
+		}
+		writeJSON(w, http.StatusOK, res)
+	case "/stats/query":
+		var q statsQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			simpleResponse(w, http.StatusBadRequest, "bad query: "+err.Error())
+			return
+		}
+		topics := s.statsTopics()
+		res := []statsSeries{}
+		for _, target := range q.Targets {
+			i := strings.LastIndex(target.Target, ":")
+			if i < 0 || topics[target.Target[:i]] == nil {
+				continue
+			}
+			ring, metric := topics[target.Target[:i]].stats, target.Target[i+1:]
+			series := statsSeries{Target: target.Target, Datapoints: [][2]float64{}}
+			for _, ss := range ring.samples(q.Range.From, q.Range.To) {
+				if v, ok := ss.value(metric, ring.interval); ok {
+					series.Datapoints = append(series.Datapoints, [2]float64{v, float64(ss.Time.UnixMilli())})
+				}
+			}
+			res = append(res, series)
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		simpleResponse(w, http.StatusNotFound, "not found")
+	}
+}
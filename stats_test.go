@@ -0,0 +1,88 @@
+package gosns_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestStats(t *testing.T) {
+	s := &gosns.Server{StatsInterval: time.Minute, StatsSamples: 10}
+	topic := s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg.Message == "fail" {
+			msg.Fail(nil)
+		}
+	})
+	topic.Delivery = gosns.AtLeastOnce
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, body := range []string{"ok", "ok", "fail"} {
+		ts.Notify("/orders", ordersARN, "", body)
+	}
+	samples := topic.Stats()
+	if len(samples) == 0 {
+		t.Fatal("no samples")
+	}
+	var total, failed uint64
+	for _, ss := range samples {
+		total += ss.Messages
+		failed += ss.Failed
+		if ss.LatencyMax > ss.LatencySum {
+			t.Errorf("sample %+v", ss)
+		}
+	}
+	if total != 3 || failed != 1 {
+		t.Errorf("samples %+v", samples)
+	}
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	if rec := admin("GET", "/stats/", ""); rec.Code != http.StatusOK {
+		t.Errorf("connection test gave %d", rec.Code)
+	}
+	var targets []string
+	json.NewDecoder(admin("POST", "/stats/search", `{"target": ""}`).Body).Decode(&targets)
+	if len(targets) != 4 || targets[0] != "/orders:errors" {
+		t.Errorf("targets %q", targets)
+	}
+
+	now := time.Now().UTC()
+	query := `{"range": {"from": "` + now.Add(-time.Hour).Format(time.RFC3339) + `", "to": "` + now.Add(time.Minute).Format(time.RFC3339) + `"},
+		"targets": [{"target": "/orders:throughput", "refId": "A"}, {"target": "/orders:latency_max"}, {"target": "/nowhere:throughput"}]}`
+	var series []struct {
+		Target     string
+		Datapoints [][2]float64
+	}
+	if err := json.NewDecoder(admin("POST", "/stats/query", query).Body).Decode(&series); err != nil || len(series) != 2 {
+		t.Fatalf("query gave %+v, %v", series, err)
+	}
+	var rate float64
+	for _, p := range series[0].Datapoints {
+		rate += p[0]
+	}
+	if series[0].Target != "/orders:throughput" || math.Abs(rate*60-3) > 1e-9 {
+		t.Errorf("throughput %+v", series[0])
+	}
+	if n := len(series[1].Datapoints); n == 0 || n > len(series[0].Datapoints) {
+		t.Errorf("latency %+v", series[1])
+	}
+
+	var all map[string][]gosns.StatsSample
+	if err := json.NewDecoder(admin("GET", "/stats", "").Body).Decode(&all); err != nil || len(all["/orders"]) != len(samples) {
+		t.Errorf("stats gave %v, %v", all, err)
+	}
+	if rec := admin("GET", "/stats/query", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET query gave %d", rec.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package gosns
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when a key does not exist.
+var ErrNotFound = errors.New("gosns: not found")
+
+// Store is a minimal key/value store used to persist server state across
+// restarts. Keys are slash-separated paths such as "delayed/orders/<id>".
+// Implementations must be safe for concurrent use.
+type Store interface {
+	Put(key string, value []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+
+	// List returns all keys beginning with prefix, in sorted order.
+	List(prefix string) ([]string, error)
+}
+
+// DedupStore remembers keys for a limited time. Replicas sharing one (see
+// Server.Dedup) detect duplicate deliveries made to any of them.
+// Implementations must be safe for concurrent use. One which is also a Store
+// must forget remembered keys passed to Delete.
+type DedupStore interface {
+	// Remember records key until ttl has passed, reporting whether it was
+	// already recorded. The check and the update must be atomic.
+	Remember(key string, ttl time.Duration) (seen bool, err error)
+}
+
+// MemoryStore is a Store and DedupStore held in memory, mostly useful for
+// tests.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+
+	dedup     map[string]time.Time // key expiry times
+	dedupLast int                  // size after the last sweep
+}
+
+func (m *MemoryStore) Put(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data == nil {
+		m.data = make(map[string][]byte)
+	}
+	m.data[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *MemoryStore) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	delete(m.dedup, key)
+	return nil
+}
+
+func (m *MemoryStore) List(prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for k := range m.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStore) Remember(key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.dedup == nil {
+		m.dedup = make(map[string]time.Time)
+	}
+	if len(m.dedup) >= 2*m.dedupLast && len(m.dedup) >= 64 {
+		for k, exp := range m.dedup {
+			if !now.Before(exp) {
+				delete(m.dedup, k)
+			}
+		}
+		m.dedupLast = len(m.dedup)
+	}
+	if exp, ok := m.dedup[key]; ok && now.Before(exp) {
+		return true, nil
+	}
+	m.dedup[key] = now.Add(ttl)
+	return false, nil
+}
+
+// FileStore is a Store which keeps one file per key beneath a directory.
+// Values are written to a temporary file and renamed into place, so a crash
+// never leaves a partially written value behind.
+type FileStore struct {
+	Dir string
+}
+
+func (f *FileStore) path(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+		if parts[i] == "." || parts[i] == ".." {
+			parts[i] = strings.Replace(parts[i], ".", "%2E", -1)
+		}
+	}
+	return filepath.Join(f.Dir, filepath.Join(parts...))
+}
+
+func (f *FileStore) Put(key string, value []byte) error {
+	p := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(value)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *FileStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (f *FileStore) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(f.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(f.Dir, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		for i, part := range parts {
+			if parts[i], err = url.PathUnescape(part); err != nil {
+				return nil
+			}
+		}
+		if key := strings.Join(parts, "/"); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
@@ -0,0 +1,215 @@
+package gosns
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TopicMismatchPolicy configures how a Server handles requests naming a
+// topic other than their endpoint's, which may come from a misconfigured
+// subscription or from someone probing for endpoints. They are refused with
+// the ResponseTopicMismatch response, a 403 unless changed in
+// Server.Responses.
+type TopicMismatchPolicy struct {
+	// HideARN leaves the topic ARN the request named out of the log line,
+	// for servers whose logs should not record other accounts' topics.
+	HideARN bool
+
+	// SecurityEvent logs mismatches at LogError, tagged
+	// security_event=true, rather than at LogWarn with other rejections.
+	SecurityEvent bool
+
+	// Hook, if set, is called with each mismatched request, the Topic of
+	// its endpoint and the topic ARN it named, such as to raise an alert.
+	// It must not read the request's body.
+	Hook func(r *http.Request, t *Topic, topicARN string)
+}
+
+// Reasons for rejecting a request. They are stable, machine-readable
+// identifiers intended for log pipelines, metrics labels and SIEM rules, and
+// are logged, sent in the ReasonHeader and appended to the response body,
+// which SNS delivery status logging records.
+const (
+	ReasonNotFound            = "not_found"
+	ReasonMethodNotAllowed    = "method_not_allowed"
+	ReasonTopicMismatch       = "topic_arn_mismatch"
+	ReasonAccountNotAllowed   = "account_not_allowed"
+	ReasonUnauthorized        = "unauthorized"
+	ReasonUnknownType         = "unknown_message_type"
+	ReasonUnknownSubscription = "unknown_subscription"
+	ReasonBadSignature        = "signature_invalid"
+	ReasonBodyTooLarge        = "body_too_large"
+	ReasonHeadersTooLarge     = "headers_too_large"
+	ReasonBadBody             = "bad_body"
+	ReasonBadContentType      = "content_type_invalid"
+	ReasonTransformFailed     = "transform_failed"
+	ReasonOverloaded          = "overloaded"
+	ReasonMaintenance         = "maintenance"
+	ReasonPaused              = "topic_paused"
+	ReasonNotReady            = "dependencies_not_ready"
+	ReasonCallbackFailed      = "callback_failed"
+)
+
+// ReasonHeader carries the reason a request was rejected.
+const ReasonHeader = "X-Gosns-Reason"
+
+// reject refuses r with the response for kind and logs why.
+func (s *Server) reject(w http.ResponseWriter, r *http.Request, kind ResponseKind, reason string) int {
+	return s.rejectErr(w, r, kind, reason, reasonErrors[reason])
+}
+
+// rejectErr is like reject, for a rejection caused by err.
+func (s *Server) rejectErr(w http.ResponseWriter, r *http.Request, kind ResponseKind, reason string, err error) int {
+	if dw, ok := w.(*debugWriter); ok {
+		dw.reason = reason
+	}
+	code := s.respondWith(w, r, kind, reason, err)
+	s.logReject(r, LogWarn, reason, code, r.Header.Get("x-amz-sns-topic-arn"), "")
+	return code
+}
+
+func (s *Server) logReject(r *http.Request, level LogLevel, reason string, code int, topicARN, tags string) {
+	s.reqLogf(r, level, "Rejected request reason=%s status=%d method=%s path=%q topic=%q type=%q remote=%s%s\n",
+		reason, code, r.Method, r.URL.Path, topicARN,
+		r.Header.Get("x-amz-sns-message-type"), r.RemoteAddr, tags)
+}
+
+// rejectMismatch refuses r, which named another topic than td's, following
+// s.TopicMismatch.
+func (s *Server) rejectMismatch(w http.ResponseWriter, r *http.Request, td *Topic, mismatch *TopicMismatchError) int {
+	if dw, ok := w.(*debugWriter); ok {
+		dw.reason = ReasonTopicMismatch
+	}
+	code := s.respondWith(w, r, ResponseTopicMismatch, ReasonTopicMismatch, mismatch)
+	policy := s.TopicMismatch
+	topicARN := mismatch.TopicArn
+	level, tags, shown := LogWarn, "", topicARN
+	if policy.SecurityEvent {
+		level, tags = LogError, " security_event=true"
+	}
+	if policy.HideARN {
+		shown = DefaultRedaction
+	}
+	s.logReject(r, level, ReasonTopicMismatch, code, shown, tags)
+	if policy.Hook != nil {
+		policy.Hook(r, td, topicARN)
+	}
+	return code
+}
+
+// topicMatches reports whether arn is the topic's, exactly in Strict mode
+// unless td is the catch-all.
+func (s *Server) topicMatches(td *Topic, arn string) bool {
+	if s.Strict && td.endpoint != catchAllEndpoint {
+		return arn == td.TopicARN || td.Migration != nil && arn == td.Migration.OldARN
+	}
+	return td.matches(arn)
+}
+
+// checkTopic checks the TopicArn of an envelope, which unlike the
+// x-amz-sns-topic-arn header is covered by the signature. It may only be
+// missing outside Strict mode.
+func (s *Server) checkTopic(td *Topic, r *http.Request, env *envelope) error {
+	if s.Strict && (env.TopicArn == "" || env.TopicArn != r.Header.Get("x-amz-sns-topic-arn")) {
+		return &TopicMismatchError{td.endpoint, td.TopicARN, env.TopicArn}
+	}
+	if env.TopicArn == "" {
+		return nil
+	}
+	if !s.topicMatches(td, env.TopicArn) {
+		return &TopicMismatchError{td.endpoint, td.TopicARN, env.TopicArn}
+	}
+	if !td.accountAllowed(env.TopicArn) {
+		return ErrAccountNotAllowed
+	}
+	return nil
+}
+
+type confirmSubscriptionResponse struct {
+	SubscriptionArn string `xml:"ConfirmSubscriptionResult>SubscriptionArn"`
+}
+
+// readSubscriptionARN extracts the subscription ARN from the response to a
+// SubscribeURL visit, returning "" if there is none.
+func readSubscriptionARN(resp *http.Response) string {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return ""
+	}
+	var csr confirmSubscriptionResponse
+	if xml.Unmarshal(data, &csr) != nil {
+		return ""
+	}
+	return strings.TrimSpace(csr.SubscriptionArn)
+}
+
+func subscriptionKey(endpoint, arn string) string {
+	return "subscriptions/" + url.PathEscape(endpoint) + "/" + url.PathEscape(arn)
+}
+
+// addSubscription records a confirmed subscription ARN for the topic, in
+// memory and in the server's Store if it has one.
+func (t *Topic) addSubscription(arn string) {
+	t.subMu.Lock()
+	if t.subscriptions == nil {
+		t.subscriptions = make(map[string]bool)
+	}
+	t.subscriptions[arn] = true
+	t.subMu.Unlock()
+
+	if s := t.server; s.Store != nil {
+		if err := s.Store.Put(subscriptionKey(t.endpoint, arn), []byte(t.TopicARN)); err != nil {
+			s.logf(LogError, "error persisting subscription '%s': %v\n", arn, err)
+		}
+	}
+}
+
+// hasSubscription reports whether arn was confirmed by this topic. With
+// ManualConfirm or SharedStore it also checks the Store, for subscriptions
+// confirmed since the server started by another process sharing it.
+func (t *Topic) hasSubscription(arn string) bool {
+	t.subMu.Lock()
+	ok := t.subscriptions[arn]
+	t.subMu.Unlock()
+	if ok || !(t.server.ManualConfirm || t.server.SharedStore) || !t.server.storedSubscription(t.endpoint, arn) {
+		return ok
+	}
+	t.subMu.Lock()
+	if t.subscriptions == nil {
+		t.subscriptions = make(map[string]bool)
+	}
+	t.subscriptions[arn] = true
+	t.subMu.Unlock()
+	return true
+}
+
+// restoreSubscriptions loads confirmed subscription ARNs from the Store.
+func (s *Server) restoreSubscriptions() error {
+	keys, err := s.Store.List("subscriptions/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		parts := strings.Split(key, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		endpoint, err1 := url.PathUnescape(parts[1])
+		arn, err2 := url.PathUnescape(parts[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if td, ok := s.topic(endpoint); ok {
+			td.subMu.Lock()
+			if td.subscriptions == nil {
+				td.subscriptions = make(map[string]bool)
+			}
+			td.subscriptions[arn] = true
+			td.subMu.Unlock()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package gosns
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TenantPathPrefix is the path under which tenant endpoints are created.
+const TenantPathPrefix = "/tenants/"
+
+// Tenant is a customer of a multi-tenant server. Each tenant has its own
+// unguessable endpoint path, under which its topics are registered, and a
+// secret which SNS must present using HTTP basic authentication with the
+// tenant ID as the user name. Subscribe the URLs returned by URL.
+type Tenant struct {
+	ID     string
+	Path   string
+	Secret string
+
+	server *Server
+}
+
+// TenantID returns the ID of the tenant whose topic received the message that
+// ctx belongs to (see Message.Context), or "" if there is none.
+func TenantID(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey).(string)
+	return id
+}
+
+// withTenant returns ctx carrying the ID of the topic's tenant, if it has
+// one. It is applied where a message's context is built, before the message
+// is handed to other goroutines.
+func (t *Topic) withTenant(ctx context.Context) context.Context {
+	if t.tenant == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey, t.tenant.ID)
+}
+
+func tenantStoreKey(id string) string {
+	return "tenants/" + url.PathEscape(id)
+}
+
+// RegisterTenant returns the tenant with the given ID, creating it with a new
+// endpoint path and secret if it does not exist yet. If the server has a
+// Store, tenants are kept there so that their paths and secrets survive
+// restarts; register them again, and add their topics, before calling Start.
+func (s *Server) RegisterTenant(id string) (*Tenant, error) {
+	if id == "" || strings.ContainsAny(id, ":/") {
+		return nil, errors.New("gosns: invalid tenant ID")
+	}
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	if t, ok := s.tenants[id]; ok {
+		return t, nil
+	}
+
+	t := &Tenant{ID: id, server: s}
+	if s.Store != nil {
+		data, err := s.Store.Get(tenantStoreKey(id))
+		switch err {
+		case nil:
+			if err = json.Unmarshal(data, t); err != nil {
+				return nil, err
+			}
+		case ErrNotFound:
+		default:
+			return nil, err
+		}
+	}
+	if t.Path == "" {
+		t.Path = TenantPathPrefix + randomHex(16)
+		t.Secret = randomHex(24)
+		if s.Store != nil {
+			data, _ := json.Marshal(t)
+			if err := s.Store.Put(tenantStoreKey(id), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.tenants == nil {
+		s.tenants = make(map[string]*Tenant)
+	}
+	s.tenants[id] = t
+	s.logf(LogInfo, "Registered tenant '%s' at '%s'\n", id, t.Path)
+	return t, nil
+}
+
+// RemoveTenant unregisters a tenant and all of its topics, and deletes it
+// from the Store. Messages already accepted for its topics are still handled.
+func (s *Server) RemoveTenant(id string) error {
+	s.topicsMu.Lock()
+	t, ok := s.tenants[id]
+	if ok {
+		delete(s.tenants, id)
+		for endpoint, td := range s.topics {
+			if td.tenant == t {
+				delete(s.topics, endpoint)
+			}
+		}
+	}
+	s.topicsMu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	if s.Store != nil {
+		if err := s.Store.Delete(tenantStoreKey(id)); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	s.logf(LogInfo, "Removed tenant '%s'\n", id)
+	return nil
+}
+
+// AddTopic adds a topic for the tenant at the endpoint named name under the
+// tenant's path. Callbacks can find the tenant with TenantID.
+func (t *Tenant) AddTopic(topicARN, name string, callback func(*Message)) *Topic {
+	return t.server.addTopic(topicARN, t.Path+"/"+strings.TrimPrefix(name, "/"), callback, t)
+}
+
+// URL returns the URL to subscribe for the tenant's topic name, including
+// the tenant's credentials. base is the server's public URL, such as
+// "https://hooks.example.com".
+func (t *Tenant) URL(base, name string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + t.Path + "/" + strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return "", err
+	}
+	u.User = url.UserPassword(t.ID, t.Secret)
+	return u.String(), nil
+}
+
+// authorized reports whether r carries the tenant's credentials.
+func (t *Tenant) authorized(r *http.Request) bool {
+	return basicAuthMatches(r, t.ID, t.Secret)
+}
+
+// authorized reports whether r carries the credentials required by the
+// topic and its tenant, if any.
+func (t *Topic) authorized(r *http.Request) bool {
+	if t.tenant != nil && !t.tenant.authorized(r) {
+		return false
+	}
+	t.authMu.RLock()
+	username, password := t.Username, t.Password
+	t.authMu.RUnlock()
+	if username == "" && password == "" {
+		return true
+	}
+	return basicAuthMatches(r, username, password)
+}
+
+// SetCredentials replaces the topic's Username and Password, such as when a
+// secret is rotated. It is safe to call while the server is running.
+func (t *Topic) SetCredentials(username, password string) {
+	t.authMu.Lock()
+	t.Username, t.Password = username, password
+	t.authMu.Unlock()
+}
+
+// basicAuthMatches compares r's basic authentication credentials in
+// constant time, so that their contents cannot be guessed from timing.
+func basicAuthMatches(r *http.Request, username, password string) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(username))
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(password))
+	return userOK&passOK == 1
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,164 @@
+package gosns_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const tenantTopic = "arn:aws:sns:us-east-1:123456789012:tenant-orders"
+
+func TestTenantRoundTrip(t *testing.T) {
+	s := &gosns.Server{Store: &gosns.MemoryStore{}}
+	tn, err := s.RegisterTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := s.RegisterTenant("acme"); again != tn {
+		t.Fatal("RegisterTenant returned a different tenant for the same ID")
+	}
+	got := make(chan string, 1)
+	tn.AddTopic(tenantTopic, "orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- gosns.TenantID(msg.Context())
+		}
+	})
+
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ft := gosnstest.NewFakeTopic(tenantTopic)
+	defer ft.Close()
+	ft.Trust(s)
+
+	if err := ft.Register(hs.URL + tn.Path + "/orders"); err == nil {
+		t.Fatal("registered without credentials")
+	}
+	u, err := tn.URL(hs.URL, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ft.Register(u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ft.Publish("s", "m", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-got:
+		if id != "acme" {
+			t.Errorf("TenantID = %q, want acme", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback not called")
+	}
+
+	restarted := &gosns.Server{Store: s.Store}
+	tn2, err := restarted.RegisterTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tn2.Path != tn.Path || tn2.Secret != tn.Secret {
+		t.Error("tenant path and secret not restored from the Store")
+	}
+	if err := s.RemoveTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveTenant("acme"); err != gosns.ErrNotFound {
+		t.Errorf("second RemoveTenant = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUnauthorizedKeepsChallenge(t *testing.T) {
+	s := &gosns.Server{
+		Responses: map[gosns.ResponseKind]gosns.Response{
+			gosns.ResponseUnauthorized: {StatusCode: http.StatusUnauthorized, Body: "who are you?"},
+		},
+	}
+	tn, _ := s.RegisterTenant("acme")
+	tn.AddTopic(tenantTopic, "orders", func(*gosns.Message) {})
+
+	w := httptest.NewRecorder()
+	r := gosnstest.NewRequest(tn.Path+"/orders", gosnstest.NewNotification(tenantTopic, "s", "m"))
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("custom response dropped the WWW-Authenticate challenge")
+	}
+	if !strings.Contains(w.Body.String(), "who are you?") {
+		t.Errorf("body = %q, want custom body", w.Body.String())
+	}
+}
+
+func TestCaptureOmitsCredentials(t *testing.T) {
+	var buf bytes.Buffer
+	s := &gosns.Server{Capture: &buf}
+	tn, _ := s.RegisterTenant("acme")
+	tn.AddTopic(tenantTopic, "orders", func(*gosns.Message) {})
+
+	r := gosnstest.NewRequest(tn.Path+"/orders", gosnstest.NewNotification(tenantTopic, "s", "m"))
+	r.SetBasicAuth(tn.ID, tn.Secret)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if strings.Contains(buf.String(), tn.Secret) {
+		t.Fatal("capture contains the tenant secret")
+	}
+	var cr gosns.CapturedRequest
+	if err := json.Unmarshal(buf.Bytes(), &cr); err != nil {
+		t.Fatal(err)
+	}
+	if cr.Header.Get("Authorization") != "" {
+		t.Error("capture recorded the Authorization header")
+	}
+}
+
+func TestTopicBasicAuth(t *testing.T) {
+	const arn = "arn:aws:sns:us-east-1:123456789012:private"
+	s := &gosns.Server{}
+	got := make(chan *gosns.Message, 1)
+	topic := s.AddTopic(arn, "/private", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	topic.Username, topic.Password = "sns", "s3cret"
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ft := gosnstest.NewFakeTopic(arn)
+	defer ft.Close()
+	ft.Trust(s)
+
+	for _, creds := range []string{"", "sns:wrong@", "other:s3cret@"} {
+		if err := ft.Register(strings.Replace(hs.URL, "//", "//"+creds, 1) + "/private"); err == nil {
+			t.Errorf("registered with credentials %q", creds)
+		}
+	}
+	if err := ft.Register(strings.Replace(hs.URL, "//", "//sns:s3cret@", 1) + "/private"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ft.Publish("s", "m", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback not called")
+	}
+
+	// after rotation, only the new password is accepted
+	topic.SetCredentials("sns", "rotated")
+	if err := ft.Register(strings.Replace(hs.URL, "//", "//sns:s3cret@", 1) + "/private"); err == nil {
+		t.Error("registered with the old password")
+	}
+	if err := ft.Register(strings.Replace(hs.URL, "//", "//sns:rotated@", 1) + "/private"); err != nil {
+		t.Error(err)
+	}
+}
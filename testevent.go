@@ -0,0 +1,26 @@
+package gosns
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// IsTestEvent reports whether msg is one of the test messages AWS services
+// publish when they are first configured to notify a topic: S3's
+// s3:TestEvent, Auto Scaling's autoscaling:TEST_NOTIFICATION and SES's
+// setup notification. It may be used as Server.TestEvents.
+func IsTestEvent(msg *Message) bool {
+	body := strings.TrimSpace(msg.Message)
+	if !strings.HasPrefix(body, "{") {
+		return strings.HasPrefix(body, "Successfully validated SNS topic for Amazon SES")
+	}
+	var ev struct {
+		Event            string
+		NotificationType string `json:"notificationType"`
+	}
+	if json.Unmarshal([]byte(body), &ev) != nil {
+		return false
+	}
+	return ev.Event == "s3:TestEvent" || ev.Event == "autoscaling:TEST_NOTIFICATION" ||
+		ev.NotificationType == "AMAZON_SES_SETUP_NOTIFICATION"
+}
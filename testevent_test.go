@@ -0,0 +1,52 @@
+package gosns_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestIsTestEvent(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"Service":"Amazon S3","Event":"s3:TestEvent","Time":"2026-01-01T00:00:00.000Z","Bucket":"b"}`: true,
+		`{"Event":"autoscaling:TEST_NOTIFICATION","AutoScalingGroupName":"web"}`:                        true,
+		`{"notificationType":"AMAZON_SES_SETUP_NOTIFICATION"}`:                                          true,
+		"Successfully validated SNS topic for Amazon SES event publishing.":                             true,
+		`{"Records":[{"eventName":"ObjectCreated:Put"}]}`:                                               false,
+		`{"Event":"autoscaling:EC2_INSTANCE_LAUNCH"}`:                                                   false,
+		"s3:TestEvent": false,
+	} {
+		if got := gosns.IsTestEvent(&gosns.Message{Message: body}); got != want {
+			t.Errorf("IsTestEvent(%s) = %v", body, got)
+		}
+	}
+}
+
+func TestTestEventsSkipped(t *testing.T) {
+	got := make(chan string, 2)
+	s := &gosns.Server{TestEvents: gosns.IsTestEvent}
+	s.AddTopic(ordersARN, "/orders", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	for _, body := range []string{`{"Service":"Amazon S3","Event":"s3:TestEvent"}`, "real"} {
+		if resp, _, err := ts.Notify("/orders", ordersARN, "", body); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s gave %v, %v", body, resp, err)
+		}
+	}
+	select {
+	case msg := <-got:
+		if msg != "real" {
+			t.Errorf("callback got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("real message not delivered")
+	}
+}
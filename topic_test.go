@@ -0,0 +1,219 @@
+package gosns
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gate is a callback which blocks until released, recording the order in
+// which messages were handled.
+type gate struct {
+	mu      sync.Mutex
+	order   []string
+	release chan struct{}
+	started chan string
+}
+
+func newGate() *gate {
+	return &gate{release: make(chan struct{}), started: make(chan string, 100)}
+}
+
+func (g *gate) callback(msg *Message) {
+	g.started <- msg.MessageId
+	<-g.release
+	g.mu.Lock()
+	g.order = append(g.order, msg.MessageId)
+	g.mu.Unlock()
+}
+
+func (g *gate) handled() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.order...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func msgID(i int) *Message { return &Message{MessageId: strconv.Itoa(i)} }
+
+func TestKeyFuncBacklogCountsAgainstQueue(t *testing.T) {
+	g := newGate()
+	td := &Topic{
+		Callback:  g.callback,
+		Workers:   2,
+		QueueSize: 3,
+		KeyFunc:   func(*Message) string { return "hot" },
+	}
+
+	// the first message occupies the lane, the rest wait behind it
+	if !td.dispatch(msgID(0)) {
+		t.Fatal("first message refused")
+	}
+	<-g.started
+	accepted := 1
+	for i := 1; i < 20; i++ {
+		if td.dispatch(msgID(i)) {
+			accepted++
+		}
+	}
+	// one handling, one popped by the idle worker, and QueueSize waiting
+	if accepted > 2+td.QueueSize {
+		t.Fatalf("accepted %d messages for one busy key, want at most %d", accepted, 2+td.QueueSize)
+	}
+
+	close(g.release)
+	waitFor(t, func() bool { return len(g.handled()) == accepted })
+	for i, id := range g.handled() {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("handled out of order: %v", g.handled())
+		}
+	}
+	if !td.dispatch(msgID(99)) {
+		t.Error("message refused after the backlog drained")
+	}
+}
+
+func TestQueueSize(t *testing.T) {
+	for _, size := range []int{0, 2} {
+		g := newGate()
+		td := &Topic{Callback: g.callback, Workers: 1, QueueSize: size}
+
+		// wait for the worker, so that the first message is not refused
+		td.startOnce.Do(td.start)
+		waitFor(t, func() bool {
+			td.queue.mu.Lock()
+			defer td.queue.mu.Unlock()
+			return td.queue.waiting == 1
+		})
+		if !td.dispatch(msgID(0)) {
+			t.Fatalf("QueueSize %d: message refused with an idle worker", size)
+		}
+		<-g.started
+		for i := 1; i <= size; i++ {
+			if !td.dispatch(msgID(i)) {
+				t.Fatalf("QueueSize %d: message %d refused", size, i)
+			}
+		}
+		if td.dispatch(msgID(size + 1)) {
+			t.Fatalf("QueueSize %d: message accepted with a full queue", size)
+		}
+		close(g.release)
+		waitFor(t, func() bool { return len(g.handled()) == size+1 })
+	}
+}
+
+func TestRateLimitWithoutQueue(t *testing.T) {
+	handled := make(chan struct{}, 10)
+	td := &Topic{
+		Callback:  func(*Message) { handled <- struct{}{} },
+		RateLimit: 0.001,
+		RateBurst: 2,
+	}
+	for i := 0; i < 2; i++ {
+		if !td.dispatch(msgID(i)) {
+			t.Fatalf("message %d refused within the burst", i)
+		}
+	}
+	if td.dispatch(msgID(2)) {
+		t.Fatal("message accepted over the rate limit")
+	}
+	<-handled
+	<-handled
+}
+
+func TestRateLimitQueueDrains(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	td := &Topic{
+		Callback: func(*Message) {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		},
+		RateLimit: 50,
+		QueueSize: 5,
+	}
+	for i := 0; i < 5; i++ {
+		if !td.dispatch(msgID(i)) {
+			t.Fatalf("message %d refused with queue space", i)
+		}
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(times) == 5
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	// one token in the bucket, then 20ms per message
+	if d := times[4].Sub(times[0]); d < 60*time.Millisecond {
+		t.Errorf("5 messages at 50/s took %s, want at least 60ms", d)
+	}
+}
+
+func TestPriorityOrder(t *testing.T) {
+	g := newGate()
+	td := &Topic{
+		Callback:  g.callback,
+		Workers:   1,
+		QueueSize: 10,
+		Priority: func(msg *Message) int {
+			n, _ := strconv.Atoi(msg.MessageId)
+			return n % 3
+		},
+	}
+	td.dispatch(msgID(100)) // occupies the worker
+	<-g.started
+	for i := 0; i < 6; i++ {
+		td.dispatch(msgID(i))
+	}
+	close(g.release)
+	waitFor(t, func() bool { return len(g.handled()) == 7 })
+	want := []string{"100", "2", "5", "1", "4", "0", "3"}
+	for i, id := range g.handled() {
+		if id != want[i] {
+			t.Fatalf("handled %v, want %v", g.handled(), want)
+		}
+	}
+}
+
+func TestKeyFuncOrdering(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string][]int{}
+	var wg sync.WaitGroup
+	td := &Topic{
+		Callback: func(msg *Message) {
+			defer wg.Done()
+			n, _ := strconv.Atoi(msg.MessageId)
+			time.Sleep(time.Duration(n%3) * time.Millisecond)
+			mu.Lock()
+			key := msg.Subject
+			seen[key] = append(seen[key], n)
+			mu.Unlock()
+		},
+		KeyFunc: func(msg *Message) string { return msg.Subject },
+	}
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		td.dispatch(&Message{MessageId: strconv.Itoa(i), Subject: "k" + strconv.Itoa(i%4)})
+	}
+	wg.Wait()
+	for key, ns := range seen {
+		for i := 1; i < len(ns); i++ {
+			if ns[i] < ns[i-1] {
+				t.Fatalf("key %s handled out of order: %v", key, ns)
+			}
+		}
+	}
+}
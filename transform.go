@@ -0,0 +1,59 @@
+package gosns
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Transformer rewrites a message before it is handled, for example to
+// decompress, decrypt or redact its body. It may modify msg in place and
+// return it, or return a new Message. Returning a nil Message drops the
+// notification, which is acknowledged without calling the callback.
+// Returning an error refuses the notification with a 500, so that SNS
+// retries it according to the subscription's delivery policy; a message
+// which can never be transformed is retried until that policy gives up.
+type Transformer func(ctx context.Context, msg *Message) (*Message, error)
+
+// transform runs the topic's Transformers over msg in order.
+func (t *Topic) transform(msg *Message) (*Message, error) {
+	for _, tf := range t.Transformers {
+		ctx := msg.Context()
+		out, err := tf(ctx, msg)
+		if err != nil || out == nil {
+			return nil, err
+		}
+		if out.ctx == nil {
+			out.ctx = ctx
+		}
+		msg = out
+	}
+	return msg, nil
+}
+
+// UnwrapMessageStructure is a Transformer for messages published with
+// MessageStructure set to "json", when the whole structure is delivered
+// rather than the entry for the endpoint's protocol, as happens through some
+// forwarders and emulators. A body which is a JSON object of strings with a
+// "default" entry is replaced by its "https" entry, or failing that its
+// "http" or "default" entry. Other messages are passed on unchanged.
+func UnwrapMessageStructure(ctx context.Context, msg *Message) (*Message, error) {
+	body := strings.TrimSpace(msg.Message)
+	if !strings.HasPrefix(body, "{") {
+		return msg, nil
+	}
+	var structure map[string]string
+	if json.Unmarshal([]byte(body), &structure) != nil {
+		return msg, nil
+	}
+	if _, ok := structure["default"]; !ok {
+		return msg, nil
+	}
+	for _, protocol := range []string{"https", "http", "default"} {
+		if v, ok := structure[protocol]; ok {
+			msg.Message = v
+			break
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,127 @@
+package gosns_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const transformTopic = "arn:aws:sns:us-east-1:123456789012:transformed"
+
+func TestTransformers(t *testing.T) {
+	s := &gosns.Server{}
+	got := make(chan string, 10)
+	td := s.AddTopic(transformTopic, "/t", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg.Message
+		}
+	})
+	td.Transformers = []gosns.Transformer{
+		func(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+			switch msg.Message {
+			case "fail":
+				return nil, errors.New("cannot decrypt")
+			case "drop":
+				return nil, nil
+			}
+			return &gosns.Message{Message: strings.ToUpper(msg.Message)}, nil
+		},
+		func(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+			if gosns.RequestID(ctx) == "" {
+				return nil, errors.New("context lost between transformers")
+			}
+			msg.Message += "!"
+			return msg, nil
+		},
+	}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{"hello", http.StatusOK, "HELLO!"},
+		{"drop", http.StatusOK, ""},
+		{"fail", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		resp, _, err := ts.Notify("/t", transformTopic, "s", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.body, resp.StatusCode, tt.status)
+		}
+		if tt.want == "" {
+			continue
+		}
+		select {
+		case m := <-got:
+			if m != tt.want {
+				t.Errorf("%q: callback got %q, want %q", tt.body, m, tt.want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%q: callback not called", tt.body)
+		}
+	}
+	select {
+	case m := <-got:
+		t.Errorf("unexpected callback with %q", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUnwrapMessageStructure(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"default":"plain","https":"{\"secure\":true}","sqs":"queued"}`: `{"secure":true}`,
+		`{"default":"plain","http":"insecure"}`:                          "insecure",
+		`{"default":"plain","email":"Dear customer"}`:                    "plain",
+		`{"https":"no default, so not a structure"}`:                     `{"https":"no default, so not a structure"}`,
+		`{"default":{"nested":true}}`:                                    `{"default":{"nested":true}}`,
+		"plain text":                                                     "plain text",
+	} {
+		msg, err := gosns.UnwrapMessageStructure(context.Background(), &gosns.Message{Message: body})
+		if err != nil || msg.Message != want {
+			t.Errorf("%s gave %q, %v", body, msg.Message, err)
+		}
+	}
+}
+
+func TestUnwrapper(t *testing.T) {
+	for _, c := range []struct {
+		u          gosns.Unwrapper
+		body, want string
+	}{
+		{gosns.Unwrapper{Base64: true}, "eyJvcmRlciI6MX0=", `{"order":1}`},
+		{gosns.Unwrapper{Base64: true}, "eyJvcmRlciI6MX0", `{"order":1}`},
+		{gosns.Unwrapper{JSONString: true}, `"{\"order\":1}"`, `{"order":1}`},
+		{gosns.Unwrapper{JSONString: true}, `{"order":1}`, `{"order":1}`},
+		{gosns.Unwrapper{SQSEnvelope: true, Base64: true}, `{"MessageId":"m1","Body":"eyJvcmRlciI6MX0="}`, `{"order":1}`},
+		{gosns.Unwrapper{SQSEnvelope: true}, `{"Records":[{"eventSource":"aws:sqs","body":"hi"}]}`, "hi"},
+		{gosns.Unwrapper{SQSEnvelope: true}, `{"order":1}`, `{"order":1}`},
+		{gosns.Unwrapper{SQSEnvelope: true, JSONString: true}, `{"MessageId":"m1","Body":"\"quoted\""}`, "quoted"},
+	} {
+		msg, err := c.u.Transform(context.Background(), &gosns.Message{Message: c.body})
+		if err != nil || msg.Message != c.want || c.u.Failures() != 0 {
+			t.Errorf("%+v: %s gave %q, %v", c.u, c.body, msg.Message, err)
+		}
+	}
+
+	u := &gosns.Unwrapper{Base64: true}
+	msg, err := u.Transform(context.Background(), &gosns.Message{Message: "not base64!"})
+	if err != nil || msg.Message != "not base64!" || u.Failures() != 1 {
+		t.Errorf("undecodable body gave %q, %v, %d failures", msg.Message, err, u.Failures())
+	}
+	u = &gosns.Unwrapper{JSONString: true, Strict: true}
+	if _, err := u.Transform(context.Background(), &gosns.Message{Message: `"unterminated`}); err == nil || u.Failures() != 1 {
+		t.Errorf("strict unwrapper passed an undecodable body: %d failures", u.Failures())
+	}
+}
@@ -0,0 +1,111 @@
+package gosns
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// Unwrapper decodes message bodies which a publisher has wrapped in
+// another encoding. Add its Transform method to a topic's Transformers.
+// The enabled decodings are applied once each, in the order of the fields
+// below, so that an SQS message whose body is a base64 string is unwrapped
+// in one go.
+type Unwrapper struct {
+	// Base64 decodes bodies encoded in base64, with the standard or URL
+	// alphabet, padded or not.
+	Base64 bool
+
+	// JSONString decodes bodies which are a JSON string, as produced by
+	// encoding a JSON document twice.
+	JSONString bool
+
+	// SQSEnvelope replaces an SQS message, as returned by ReceiveMessage or
+	// in a Lambda event with a single record, by its body.
+	SQSEnvelope bool
+
+	// Strict refuses messages which cannot be unwrapped, such as a body
+	// which is not valid base64, with a 500 so that SNS retries them.
+	// Otherwise they are passed on as they are. Either way they are
+	// counted; see Failures.
+	Strict bool
+
+	failures uint64
+}
+
+// errUnwrap is returned by the decoders for a body they should decode but
+// cannot.
+var errUnwrap = errors.New("cannot unwrap body")
+
+// Transform is a Transformer which unwraps msg in place.
+func (u *Unwrapper) Transform(ctx context.Context, msg *Message) (*Message, error) {
+	body, err := u.unwrap(msg.Message)
+	if err != nil {
+		atomic.AddUint64(&u.failures, 1)
+		if u.Strict {
+			return nil, err
+		}
+		return msg, nil
+	}
+	msg.Message = body
+	return msg, nil
+}
+
+// Failures returns the number of messages which could not be unwrapped.
+func (u *Unwrapper) Failures() uint64 {
+	return atomic.LoadUint64(&u.failures)
+}
+
+// unwrap removes the enabled layers of encoding from body.
+func (u *Unwrapper) unwrap(body string) (string, error) {
+	var err error
+	if u.SQSEnvelope && strings.HasPrefix(strings.TrimSpace(body), "{") {
+		if body, err = sqsBody(body); err != nil {
+			return "", err
+		}
+	}
+	if u.JSONString && strings.HasPrefix(strings.TrimSpace(body), `"`) {
+		if err = json.Unmarshal([]byte(strings.TrimSpace(body)), &body); err != nil {
+			return "", errUnwrap
+		}
+	}
+	if trimmed := strings.TrimSpace(body); u.Base64 && trimmed != "" {
+		for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if data, err := enc.DecodeString(trimmed); err == nil {
+				return string(data), nil
+			}
+		}
+		return "", errUnwrap
+	}
+	return body, nil
+}
+
+// sqsBody returns the body of an SQS message, or body itself if it is some
+// other JSON object.
+func sqsBody(body string) (string, error) {
+	var m struct {
+		MessageId     string
+		ReceiptHandle string
+		Body          *string
+		Records       []struct {
+			EventSource string  `json:"eventSource"`
+			Body        *string `json:"body"`
+		}
+	}
+	if json.Unmarshal([]byte(body), &m) != nil {
+		return body, nil
+	}
+	if m.Body != nil && (m.MessageId != "" || m.ReceiptHandle != "") {
+		return *m.Body, nil
+	}
+	if len(m.Records) > 0 && m.Records[0].EventSource == "aws:sqs" {
+		if len(m.Records) != 1 || m.Records[0].Body == nil {
+			return "", errUnwrap
+		}
+		return *m.Records[0].Body, nil
+	}
+	return body, nil
+}
@@ -0,0 +1,287 @@
+package gosns
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidateCertURL is the default CertCache.ValidateURL. It only allows
+// certificates served over HTTPS from an SNS regional endpoint, in the
+// domain of the region's partition (such as amazonaws.com.cn for China), so
+// a forged message cannot point verification at an attacker's certificate.
+func ValidateCertURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return fmt.Errorf("certificate URL '%s' is not https", u)
+	}
+	if _, ok := snsHostRegion(u.Hostname()); !ok {
+		return fmt.Errorf("certificate URL '%s' is not an SNS host", u)
+	}
+	if !strings.HasSuffix(u.Path, ".pem") {
+		return fmt.Errorf("certificate URL '%s' is not a .pem file", u)
+	}
+	return nil
+}
+
+// CertCache fetches and caches SNS signing certificates by URL. It is safe
+// for concurrent use. Concurrent requests for the same URL share one fetch,
+// failures are remembered for NegativeTTL so a bad URL cannot trigger a fetch
+// per message, and entries nearing expiry are refreshed in the background
+// while the old certificate stays in use.
+type CertCache struct {
+	// TTL is how long a fetched certificate is used. Defaults to 24 hours.
+	TTL time.Duration
+
+	// NegativeTTL is how long a failed fetch is remembered. Defaults to one
+	// minute.
+	NegativeTTL time.Duration
+
+	// RefreshBefore starts a background refresh when an entry is within this
+	// long of expiring. Defaults to a tenth of TTL.
+	RefreshBefore time.Duration
+
+	// PinnedSubjects, if not empty, lists the certificate subject common
+	// names which are accepted, such as "sns.amazonaws.com".
+	PinnedSubjects []string
+
+	// ValidateURL checks a SigningCertURL on every lookup, before the cache is
+	// consulted. Defaults to ValidateCertURL.
+	ValidateURL func(*url.URL) error
+
+	// Client is used to fetch certificates. Defaults to a client with a ten
+	// second timeout.
+	Client *http.Client
+
+	mu      sync.Mutex
+	entries map[string]*certEntry
+}
+
+type certEntry struct {
+	ready      chan struct{} // closed once the first fetch completes
+	cert       *x509.Certificate
+	err        error
+	expires    time.Time
+	refreshing bool
+}
+
+var defaultCertClient = &http.Client{Timeout: 10 * time.Second}
+
+func (c *CertCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return 24 * time.Hour
+}
+
+func (c *CertCache) negativeTTL() time.Duration {
+	if c.NegativeTTL > 0 {
+		return c.NegativeTTL
+	}
+	return time.Minute
+}
+
+// Get returns the certificate at certURL, fetching it if necessary.
+func (c *CertCache) Get(certURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(certURL)
+	if err != nil {
+		return nil, err
+	}
+	validate := c.ValidateURL
+	if validate == nil {
+		validate = ValidateCertURL
+	}
+	if err = validate(u); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*certEntry)
+	}
+	e, ok := c.entries[certURL]
+	if ok {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				ok = false
+			}
+		default:
+			// another request is fetching it
+			c.mu.Unlock()
+			<-e.ready
+			return e.cert, e.err
+		}
+	}
+	if ok {
+		refreshBefore := c.RefreshBefore
+		if refreshBefore <= 0 {
+			refreshBefore = c.ttl() / 10
+		}
+		if e.err == nil && !e.refreshing && e.expires.Sub(now) < refreshBefore {
+			e.refreshing = true
+			go c.refresh(certURL, e)
+		}
+		c.mu.Unlock()
+		return e.cert, e.err
+	}
+
+	e = &certEntry{ready: make(chan struct{})}
+	c.entries[certURL] = e
+	c.mu.Unlock()
+
+	e.cert, e.err = c.fetch(certURL)
+	c.mu.Lock()
+	if e.err != nil {
+		e.expires = time.Now().Add(c.negativeTTL())
+	} else {
+		e.expires = time.Now().Add(c.ttl())
+	}
+	c.mu.Unlock()
+	close(e.ready)
+	return e.cert, e.err
+}
+
+// refresh replaces a cached certificate which is about to expire. A failed
+// refresh leaves the old certificate in place until it expires.
+func (c *CertCache) refresh(certURL string, old *certEntry) {
+	cert, err := c.fetch(certURL)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old.refreshing = false
+	if err != nil || c.entries[certURL] != old {
+		return
+	}
+	e := &certEntry{ready: make(chan struct{}), cert: cert, expires: time.Now().Add(c.ttl())}
+	close(e.ready)
+	c.entries[certURL] = e
+}
+
+func (c *CertCache) fetch(certURL string) (*x509.Certificate, error) {
+	client := c.Client
+	if client == nil {
+		client = defaultCertClient
+	}
+	resp, err := client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching certificate '%s': %s", certURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate at '%s'", certURL)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.PinnedSubjects) > 0 {
+		pinned := false
+		for _, name := range c.PinnedSubjects {
+			if cert.Subject.CommonName == name {
+				pinned = true
+			}
+		}
+		if !pinned {
+			return nil, fmt.Errorf("certificate subject '%s' is not pinned", cert.Subject.CommonName)
+		}
+	}
+	return cert, nil
+}
+
+// stringToSign returns the canonical form of env which SNS signs.
+func (env *envelope) stringToSign() string {
+	var keys []string
+	if env.Type == "Notification" {
+		keys = []string{"Message", "MessageId", "Subject", "Timestamp", "TopicArn", "Type"}
+	} else {
+		keys = []string{"Message", "MessageId", "SubscribeURL", "Timestamp", "Token", "TopicArn", "Type"}
+	}
+	vals := map[string]string{
+		"Message":      env.Message,
+		"MessageId":    env.MessageId,
+		"Subject":      env.Subject,
+		"SubscribeURL": env.SubscribeURL,
+		"Timestamp":    env.Timestamp,
+		"Token":        env.Token,
+		"TopicArn":     env.TopicArn,
+		"Type":         env.Type,
+	}
+	var b strings.Builder
+	for _, k := range keys {
+		if k == "Subject" && env.Subject == "" {
+			continue
+		}
+		b.WriteString(k + "\n" + vals[k] + "\n")
+	}
+	return b.String()
+}
+
+// verify checks the signature on env, sent to td, against its signing
+// certificate.
+func (s *Server) verify(td *Topic, env *envelope) error {
+	if env.Signature == "" || env.SigningCertURL == "" {
+		return errors.New("message is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return fmt.Errorf("bad signature encoding: %v", err)
+	}
+
+	if err = s.checkRegion("certificate URL", env.SigningCertURL, env.TopicArn); err != nil {
+		return err
+	}
+	s.certsOnce.Do(func() {
+		if s.Certs == nil {
+			s.Certs = &CertCache{}
+		}
+	})
+	certs := s.Certs
+	if td.certs != nil {
+		certs = td.certs
+	}
+	cert, err := certs.Get(env.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("signing certificate is not currently valid")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signing certificate does not hold an RSA key")
+	}
+
+	data := []byte(env.stringToSign())
+	switch env.SignatureVersion {
+	case "1":
+		h := sha1.Sum(data)
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA1, h[:], sig)
+	case "2":
+		h := sha256.Sum256(data)
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig)
+	default:
+		return fmt.Errorf("unsupported SignatureVersion '%s'", env.SignatureVersion)
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const verifyTopic = "arn:aws:sns:us-east-1:123456789012:verified"
+
+func TestVerifySignaturesRoundTrip(t *testing.T) {
+	s := &gosns.Server{VerifySignatures: true}
+	got := make(chan *gosns.Message, 10)
+	s.AddTopic(verifyTopic, "/v", func(msg *gosns.Message) {
+		if msg != nil {
+			got <- msg
+		}
+	})
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ft := gosnstest.NewFakeTopic(verifyTopic)
+	defer ft.Close()
+
+	if err := ft.Register(hs.URL + "/v"); err == nil {
+		t.Fatal("confirmation signed by an untrusted certificate was accepted")
+	}
+	ft.Trust(s)
+	if err := ft.Register(hs.URL + "/v"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []string{"1", "2"} {
+		ft.Signer.SignatureVersion = version
+		if _, err := ft.Publish("subject", "v"+version, nil); err != nil {
+			t.Fatalf("SignatureVersion %s: %v", version, err)
+		}
+		// notifications without a subject sign a different string
+		if _, err := ft.Publish("", "v"+version, nil); err != nil {
+			t.Fatalf("SignatureVersion %s without subject: %v", version, err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-got:
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not called")
+		}
+	}
+
+	ft.Faults.BadSignature = 1
+	if _, err := ft.Publish("subject", "forged", nil); err == nil {
+		t.Fatal("bad signature accepted")
+	}
+	d := ft.Deliveries()
+	if last := d[len(d)-1]; last.StatusCode != http.StatusForbidden {
+		t.Errorf("bad signature got status %d, want 403", last.StatusCode)
+	}
+}
+
+func TestStrictRoundTrip(t *testing.T) {
+	s := &gosns.Server{Strict: true, Store: &gosns.MemoryStore{}}
+	s.AddTopic(verifyTopic, "/st", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	resp, _, _ := ts.Notify("/st", verifyTopic, "s", "before confirmation")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unconfirmed subscription got %s, want 403", resp.Status)
+	}
+	resp, _, _ = ts.Confirm("/st", verifyTopic)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmation got %s", resp.Status)
+	}
+	resp, _, _ = ts.Notify("/st", verifyTopic, "s", "after confirmation")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirmed subscription got %s, want 200", resp.Status)
+	}
+
+	// the subscription survives a restart through the Store
+	restarted := &gosns.Server{Strict: true, Store: s.Store}
+	restarted.AddTopic(verifyTopic, "/st", func(*gosns.Message) {})
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = restarted
+	ts.Trust(restarted)
+	resp, _, _ = ts.Notify("/st", verifyTopic, "s", "after restart")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restored subscription got %s, want 200", resp.Status)
+	}
+
+	// the signed TopicArn must agree with the unsigned header
+	e := gosnstest.NewNotification("arn:aws:sns:us-east-1:123456789012:other", "s", "m")
+	e.SubscriptionArn = confirmedARN(t, ts)
+	if err := ts.Signer.Sign(e); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := gosnstest.NewClientRequest(ts.URL+"/st", e)
+	req.Header.Set("x-amz-sns-topic-arn", verifyTopic)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("mismatched TopicArn got %s, want 403", resp.Status)
+	}
+
+	req = gosnstest.NewRequest("/st", gosnstest.NewNotification(verifyTopic, "s", "m"))
+	req.Header.Set("x-amz-sns-message-type", "UnsubscribeConfirmation")
+	w := httptest.NewRecorder()
+	restarted.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unknown message type got %d, want 403", w.Code)
+	}
+}
+
+func TestStrictIgnoresPatterns(t *testing.T) {
+	s := &gosns.Server{Strict: true}
+	s.AddTopic("arn:aws:sns:*:123456789012:verified", "/p", func(*gosns.Message) {})
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	resp, _, _ := ts.Confirm("/p", verifyTopic)
+	if resp.StatusCode == http.StatusOK {
+		t.Error("pattern TopicARN matched in Strict mode")
+	}
+}
+
+// confirmedARN returns the subscription ARN ts recorded for /st.
+func confirmedARN(t *testing.T, ts *gosnstest.Server) string {
+	t.Helper()
+	e := gosnstest.NewNotification(verifyTopic, "s", "m")
+	resp, err := ts.Send("/st", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return e.SubscriptionArn
+}
+
+// certServer serves a certificate at /cert.pem and a 404 elsewhere,
+// counting requests. Requests wait while hold is locked.
+type certServer struct {
+	*httptest.Server
+	signer  *gosnstest.Signer
+	fetches int32
+	hold    sync.RWMutex
+}
+
+func newCertServer(t *testing.T) *certServer {
+	signer, err := gosnstest.NewSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &certServer{signer: signer}
+	cs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&cs.fetches, 1)
+		cs.hold.RLock()
+		defer cs.hold.RUnlock()
+		if r.URL.Path != "/cert.pem" {
+			http.NotFound(w, r)
+			return
+		}
+		signer.Handler().ServeHTTP(w, r)
+	}))
+	return cs
+}
+
+func (cs *certServer) count() int32 { return atomic.LoadInt32(&cs.fetches) }
+
+func allowAnyURL(*url.URL) error { return nil }
+
+func TestCertCacheSharedFetch(t *testing.T) {
+	cs := newCertServer(t)
+	defer cs.Close()
+	c := &gosns.CertCache{ValidateURL: allowAnyURL}
+
+	cs.hold.Lock()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	get := func() {
+		defer wg.Done()
+		cert, err := c.Get(cs.URL + "/cert.pem")
+		if err == nil && !cert.Equal(cs.signer.Cert) {
+			t.Error("got the wrong certificate")
+		}
+		errs <- err
+	}
+	wg.Add(1)
+	go get()
+	if !eventually(func() bool { return cs.count() == 1 }) {
+		t.Fatal("certificate was not fetched")
+	}
+	for i := 0; i < 9; i++ {
+		wg.Add(1)
+		go get()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cs.hold.Unlock()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := cs.count(); n != 1 {
+		t.Errorf("10 concurrent lookups made %d fetches", n)
+	}
+}
+
+func TestCertCacheNegativeTTL(t *testing.T) {
+	cs := newCertServer(t)
+	defer cs.Close()
+	c := &gosns.CertCache{ValidateURL: allowAnyURL, NegativeTTL: 100 * time.Millisecond}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(cs.URL + "/missing.pem"); err == nil {
+			t.Fatal("missing certificate was found")
+		}
+	}
+	if n := cs.count(); n != 1 {
+		t.Errorf("failed lookups made %d fetches", n)
+	}
+	time.Sleep(150 * time.Millisecond)
+	c.Get(cs.URL + "/missing.pem")
+	if n := cs.count(); n != 2 {
+		t.Errorf("after NegativeTTL, %d fetches", n)
+	}
+}
+
+func TestCertCacheRefresh(t *testing.T) {
+	cs := newCertServer(t)
+	defer cs.Close()
+	c := &gosns.CertCache{ValidateURL: allowAnyURL, TTL: 300 * time.Millisecond, RefreshBefore: 200 * time.Millisecond}
+	certURL := cs.URL + "/cert.pem"
+	if _, err := c.Get(certURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(certURL); err != nil || cs.count() != 1 {
+		t.Fatalf("fresh entry: %d fetches, %v", cs.count(), err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	cs.hold.Lock()
+	start := time.Now()
+	cert, err := c.Get(certURL)
+	if err != nil || cert == nil {
+		t.Fatalf("entry nearing expiry: %v", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("lookup waited %s for the refresh", d)
+	}
+	if !eventually(func() bool { return cs.count() == 2 }) {
+		t.Fatal("entry nearing expiry was not refreshed")
+	}
+	cs.hold.Unlock()
+
+	// the refreshed entry is good for another TTL
+	time.Sleep(200 * time.Millisecond)
+	if _, err := c.Get(certURL); err != nil || cs.count() != 2 {
+		t.Errorf("after the refresh: %d fetches, %v", cs.count(), err)
+	}
+}
+
+func TestCertCachePinnedSubjects(t *testing.T) {
+	cs := newCertServer(t)
+	defer cs.Close()
+	c := &gosns.CertCache{ValidateURL: allowAnyURL, PinnedSubjects: []string{"sns.example.com"}}
+	if _, err := c.Get(cs.URL + "/cert.pem"); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("unpinned subject gave %v", err)
+	}
+	c = &gosns.CertCache{ValidateURL: allowAnyURL, PinnedSubjects: []string{"sns.example.com", "sns.amazonaws.com"}}
+	if _, err := c.Get(cs.URL + "/cert.pem"); err != nil {
+		t.Errorf("pinned subject gave %v", err)
+	}
+	c = &gosns.CertCache{}
+	if _, err := c.Get(cs.URL + "/cert.pem"); err == nil {
+		t.Error("default ValidateURL accepted a test server URL")
+	}
+}
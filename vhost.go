@@ -0,0 +1,31 @@
+package gosns
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AddHostTopic is like AddTopic, but the endpoint only receives requests
+// for the given host, such as "acme.hooks.example.com", for deployments
+// giving each customer a hostname pointing at the same server. The port and
+// case of the request's Host header are ignored. Host endpoints take
+// precedence over those added with AddTopic.
+func (s *Server) AddHostTopic(host, topicARN, endpoint string, callback func(*Message)) *Topic {
+	t := s.newTopic(topicARN, endpoint, callback, nil)
+	t.endpoint = normalizeHost(host) + t.endpoint
+	t.pattern = parsePattern(t.endpoint)
+	return s.register(t)
+}
+
+// requestHost returns the normalized host r was sent to.
+func requestHost(r *http.Request) string {
+	return normalizeHost(r.Host)
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
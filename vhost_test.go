@@ -0,0 +1,59 @@
+package gosns_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+func TestHostTopics(t *testing.T) {
+	s := &gosns.Server{}
+	got := make(chan string, 1)
+	handler := func(name string) func(*gosns.Message) {
+		return func(msg *gosns.Message) {
+			if msg != nil {
+				got <- name + " " + gosns.PathValue(msg.Context(), "kind")
+			}
+		}
+	}
+	s.AddHostTopic("acme.hooks.example.com", ordersARN, "/orders", handler("acme"))
+	s.AddHostTopic("Globex.Hooks.Example.com", ordersARN, "/orders", handler("globex"))
+	s.AddHostTopic("globex.hooks.example.com", ordersARN, "/events/{kind}", handler("globex"))
+	s.AddTopic(ordersARN, "/orders", handler("default"))
+	signer := gosnstest.NewServer(s)
+	defer signer.Close()
+
+	for _, c := range []struct{ host, path, want string }{
+		{"acme.hooks.example.com", "/orders", "acme "},
+		{"GLOBEX.hooks.example.com:8443", "/orders", "globex "},
+		{"globex.hooks.example.com", "/events/refund", "globex refund"},
+		{"other.example.com", "/orders", "default "},
+	} {
+		env := gosnstest.NewNotification(ordersARN, "", "hello")
+		if err := signer.Signer.Sign(env); err != nil {
+			t.Fatal(err)
+		}
+		req, err := gosnstest.NewClientRequest("http://"+c.host+c.path, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s%s: %d %s", c.host, c.path, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+		select {
+		case g := <-got:
+			if g != c.want {
+				t.Errorf("%s%s: got %q, want %q", c.host, c.path, g, c.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s%s: callback not called", c.host, c.path)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/pbnjay/gosns/wasm
+
+// wazero v1.12.0 declares go 1.25.0 as its floor; the wasm package is a
+// module of its own so that the root module keeps go 1.20.
+go 1.25.0
+
+require (
+	github.com/pbnjay/gosns v0.0.0-20261014161338-a71c5b38ce05
+	github.com/tetratelabs/wazero v1.12.0
+)
+
+require golang.org/x/sys v0.44.0 // indirect
@@ -0,0 +1,200 @@
+// Package wasm runs message filters and transformers compiled to
+// WebAssembly, so that logic supplied by tenants can run inside the receiver
+// without access to it. Modules are run by wazero, are given no files,
+// network, environment or clock beyond what WASI requires, and are limited
+// in memory and running time. Each message is handled by a fresh instance,
+// so nothing one message does can be seen by the next.
+//
+// A module exports its memory and two functions:
+//
+//	gosns_alloc(size i32) i32
+//	gosns_transform(ptr i32, len i32) i64
+//
+// For each message the host calls gosns_alloc for a buffer, writes the
+// message there as JSON in the form used by package plugin, and calls
+// gosns_transform with the buffer. The result points to the JSON of the
+// message to keep, packed as ptr<<32 | len; a result of zero drops the
+// message. A trap, such as from an unreachable instruction, is an error, so
+// the message is refused and SNS retries it. A module returning its input
+// unchanged keeps every message as it is.
+//
+// Modules built for WASI are supported: their _initialize function, if
+// exported, runs when each instance is created, and anything they write to
+// stderr goes to Config.Stderr.
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/plugin"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Defaults used when the corresponding Config fields are zero.
+const (
+	DefaultTimeout          = time.Second
+	DefaultMemoryLimitPages = 256 // 16 MiB
+)
+
+// Config limits what a module may use.
+type Config struct {
+	// Timeout limits how long each message may take, including creating
+	// the instance. Zero means DefaultTimeout.
+	Timeout time.Duration
+
+	// MemoryLimitPages caps each instance's memory, in 64 KiB pages. Zero
+	// means DefaultMemoryLimitPages.
+	MemoryLimitPages uint32
+
+	// Stderr receives what the module writes to stderr, if set.
+	Stderr io.Writer
+}
+
+// Module is a compiled filter or transformer. Its Transform method is safe
+// for concurrent use.
+type Module struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+	stderr   io.Writer
+}
+
+// Load compiles code, checking that it exports what a module must.
+func Load(ctx context.Context, code []byte, cfg Config) (*Module, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MemoryLimitPages == 0 {
+		cfg.MemoryLimitPages = DefaultMemoryLimitPages
+	}
+	rc := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(cfg.MemoryLimitPages).
+		WithCloseOnContextDone(true)
+	m := &Module{
+		runtime: wazero.NewRuntimeWithConfig(ctx, rc),
+		timeout: cfg.Timeout,
+		stderr:  cfg.Stderr,
+	}
+	if m.stderr == nil {
+		m.stderr = io.Discard
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, m.runtime); err != nil {
+		m.runtime.Close(ctx)
+		return nil, err
+	}
+
+	var err error
+	if m.compiled, err = m.runtime.CompileModule(ctx, code); err != nil {
+		m.runtime.Close(ctx)
+		return nil, fmt.Errorf("wasm: %w", err)
+	}
+	if err = m.check(); err != nil {
+		m.runtime.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+// check verifies the module's exports.
+func (m *Module) check() error {
+	if len(m.compiled.ExportedMemories()) == 0 {
+		return errors.New("wasm: module does not export its memory")
+	}
+	fns := m.compiled.ExportedFunctions()
+	for name, sig := range map[string]string{
+		"gosns_alloc":     "(i32)i32",
+		"gosns_transform": "(i32,i32)i64",
+	} {
+		fn, ok := fns[name]
+		if !ok {
+			return fmt.Errorf("wasm: module does not export %s", name)
+		}
+		if got := signature(fn.ParamTypes(), fn.ResultTypes()); got != sig {
+			return fmt.Errorf("wasm: %s has signature %s, want %s", name, got, sig)
+		}
+	}
+	return nil
+}
+
+func signature(params, results []api.ValueType) string {
+	s := "("
+	for i, t := range params {
+		if i > 0 {
+			s += ","
+		}
+		s += api.ValueTypeName(t)
+	}
+	s += ")"
+	for _, t := range results {
+		s += api.ValueTypeName(t)
+	}
+	return s
+}
+
+// Transform is a gosns.Transformer which runs msg through the module.
+func (m *Module) Transform(ctx context.Context, msg *gosns.Message) (*gosns.Message, error) {
+	in, err := json.Marshal(plugin.NewMessage(msg))
+	if err != nil {
+		return nil, err
+	}
+	out, err := m.run(ctx, in)
+	if err != nil || out == nil {
+		return nil, err
+	}
+	var kept plugin.Message
+	if err = json.Unmarshal(out, &kept); err != nil {
+		return nil, fmt.Errorf("wasm: bad message from module: %w", err)
+	}
+	kept.Apply(msg)
+	return msg, nil
+}
+
+// run passes in to a new instance, returning the message it keeps, or nil.
+func (m *Module) run(ctx context.Context, in []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	mc := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStderr(m.stderr)
+	mod, err := m.runtime.InstantiateModule(ctx, m.compiled, mc)
+	if err != nil {
+		return nil, fmt.Errorf("wasm: %w", err)
+	}
+	defer mod.Close(context.Background())
+
+	res, err := mod.ExportedFunction("gosns_alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("wasm: gosns_alloc: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("wasm: gosns_alloc returned %d, outside memory", ptr)
+	}
+	res, err = mod.ExportedFunction("gosns_transform").Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("wasm: gosns_transform: %w", err)
+	}
+	if res[0] == 0 {
+		return nil, nil
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("wasm: gosns_transform returned %d bytes at %d, outside memory", outLen, outPtr)
+	}
+	return append([]byte(nil), out...), nil
+}
+
+// Close releases the module.
+func (m *Module) Close(ctx context.Context) error {
+	return m.runtime.Close(ctx)
+}
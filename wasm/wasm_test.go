@@ -0,0 +1,129 @@
+package wasm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+)
+
+// module assembles a module exporting its memory, a gosns_alloc which always
+// answers offset 1024, and transform as the body of gosns_transform.
+func module(transform ...byte) []byte { return assemble(0x7e, transform...) }
+
+// assemble is module with gosns_transform returning a value of type result.
+func assemble(result byte, transform ...byte) []byte {
+	section := func(id byte, body ...byte) []byte {
+		return append([]byte{id, byte(len(body))}, body...)
+	}
+	name := func(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+	code := func(body []byte) []byte { return append([]byte{byte(len(body) + 1), 0}, body...) }
+
+	b := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	// (i32) -> i32 and (i32, i32) -> result
+	b = append(b, section(1, 2, 0x60, 1, 0x7f, 1, 0x7f, 0x60, 2, 0x7f, 0x7f, 1, result)...)
+	b = append(b, section(3, 2, 0, 1)...)
+	b = append(b, section(5, 1, 0x00, 1)...)
+	var exports []byte
+	exports = append(exports, 3)
+	exports = append(append(exports, name("memory")...), 0x02, 0)
+	exports = append(append(exports, name("gosns_alloc")...), 0x00, 0)
+	exports = append(append(exports, name("gosns_transform")...), 0x00, 1)
+	b = append(b, section(7, exports...)...)
+	var bodies []byte
+	bodies = append(bodies, 2)
+	bodies = append(bodies, code([]byte{0x41, 0x80, 0x08, 0x0b})...) // i32.const 1024
+	bodies = append(bodies, code(transform)...)
+	return append(b, section(10, bodies...)...)
+}
+
+var (
+	// (i64.extend_i32_u ptr) << 32 | (i64.extend_i32_u len)
+	identity = module(0x20, 0, 0xad, 0x42, 32, 0x86, 0x20, 1, 0xad, 0x84, 0x0b)
+	// i64.const 0
+	dropAll = module(0x42, 0, 0x0b)
+	// unreachable
+	trap = module(0x00, 0x0b)
+	// loop br 0 end, forever
+	spin = module(0x03, 0x40, 0x0c, 0, 0x0b, 0x42, 0, 0x0b)
+	// a result pointing past the end of memory
+	wild = module(0x42, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x0b)
+)
+
+func load(t *testing.T, code []byte, cfg Config) *Module {
+	t.Helper()
+	m, err := Load(context.Background(), code, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close(context.Background()) })
+	return m
+}
+
+func testMessage() *gosns.Message {
+	return &gosns.Message{
+		Subject:           "hello",
+		Message:           `{"order":1}`,
+		MessageId:         "m-1",
+		TopicArn:          "arn:aws:sns:us-east-1:123456789012:orders",
+		Timestamp:         time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		MessageAttributes: map[string]gosns.MessageAttribute{"tenant": {Type: "String", Value: "acme"}},
+	}
+}
+
+func TestKeep(t *testing.T) {
+	m := load(t, identity, Config{})
+	msg, err := m.Transform(context.Background(), testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testMessage()
+	if msg == nil || msg.Subject != want.Subject || msg.Message != want.Message || msg.MessageId != want.MessageId ||
+		!msg.Timestamp.Equal(want.Timestamp) || msg.MessageAttributes["tenant"] != want.MessageAttributes["tenant"] {
+		t.Errorf("got %+v, want %+v", msg, want)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	m := load(t, dropAll, Config{})
+	if msg, err := m.Transform(context.Background(), testMessage()); msg != nil || err != nil {
+		t.Errorf("got %v, %v", msg, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		code []byte
+		cfg  Config
+		want string
+	}{
+		{"trap", trap, Config{}, "unreachable"},
+		{"timeout", spin, Config{Timeout: 50 * time.Millisecond}, "gosns_transform"},
+		{"outside memory", wild, Config{}, "outside memory"},
+	} {
+		m := load(t, c.code, c.cfg)
+		msg, err := m.Transform(context.Background(), testMessage())
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, %v; want an error mentioning %q", c.name, msg, err, c.want)
+		}
+	}
+}
+
+func TestLoadChecksExports(t *testing.T) {
+	for name, code := range map[string][]byte{
+		"not wasm":      []byte("\x00asn"),
+		"bad signature": assemble(0x7f, 0x41, 0, 0x0b), // returns i32.const 0
+	} {
+		if m, err := Load(context.Background(), code, Config{}); err == nil {
+			m.Close(context.Background())
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestTransformer(t *testing.T) {
+	var _ gosns.Transformer = load(t, identity, Config{}).Transform
+}
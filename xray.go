@@ -0,0 +1,246 @@
+package gosns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// XRay emits AWS X-Ray segments for notifications, with a subsegment around
+// each callback, by sending them to an X-Ray daemon over UDP. A segment covers
+// the delivery request, whether or not the message reaches a callback, and
+// records the response status. Callbacks may run after SNS has been
+// answered, so their subsegments are sent separately.
+type XRay struct {
+	// Name is the service name used for segments. Defaults to "gosns".
+	Name string
+
+	// DaemonAddr is the daemon's UDP address. Defaults to the
+	// AWS_XRAY_DAEMON_ADDRESS environment variable, or 127.0.0.1:2000.
+	DaemonAddr string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// xraySegment is an in-flight segment for a single notification. It covers
+// the delivery request and is sent once the response status is known.
+type xraySegment struct {
+	xr *XRay
+
+	TraceID     string              `json:"trace_id"`
+	ID          string              `json:"id"`
+	ParentID    string              `json:"parent_id,omitempty"`
+	Name        string              `json:"name"`
+	StartTime   float64             `json:"start_time"`
+	EndTime     float64             `json:"end_time"`
+	HTTP        *xrayHTTP           `json:"http,omitempty"`
+	Error       bool                `json:"error,omitempty"`
+	Throttle    bool                `json:"throttle,omitempty"`
+	Fault       bool                `json:"fault,omitempty"`
+	Annotations map[string]string   `json:"annotations,omitempty"`
+	Metadata    map[string]xrayMeta `json:"metadata,omitempty"`
+	sampled     bool
+}
+
+type xrayMeta map[string]string
+
+type xrayHTTP struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Response struct {
+		Status int `json:"status,omitempty"`
+	} `json:"response"`
+}
+
+// xraySubsegment times a callback. Callbacks may run after the delivery
+// has been answered, so each is sent on its own as an independent subsegment
+// of the message's segment.
+type xraySubsegment struct {
+	Type      string  `json:"type"`
+	TraceID   string  `json:"trace_id"`
+	ParentID  string  `json:"parent_id"`
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Fault     bool    `json:"fault,omitempty"`
+}
+
+func xrayTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func xrayID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// parseTraceHeader extracts the root, parent and sampling decision from an
+// X-Amzn-Trace-Id style header value.
+func parseTraceHeader(h string) (root, parent string, sampled bool) {
+	sampled = true
+	for _, part := range strings.Split(h, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			root = kv[1]
+		case "Parent":
+			parent = kv[1]
+		case "Sampled":
+			sampled = kv[1] != "0"
+		}
+	}
+	return root, parent, sampled
+}
+
+// begin opens a segment for msg, continuing the trace named by its
+// AWSTraceHeader attribute or, failing that, by traceHeader.
+func (x *XRay) begin(ctx context.Context, msg *Message, topicARN, method, url, traceHeader string) context.Context {
+	if a, ok := msg.MessageAttributes["AWSTraceHeader"]; ok && a.Value != "" {
+		traceHeader = a.Value
+	}
+	root, parent, sampled := parseTraceHeader(traceHeader)
+	now := time.Now()
+	if root == "" {
+		root = fmt.Sprintf("1-%08x-%s", now.Unix(), xrayID(12))
+		parent = ""
+	}
+	name := x.Name
+	if name == "" {
+		name = "gosns"
+	}
+	seg := &xraySegment{
+		xr:          x,
+		TraceID:     root,
+		ID:          xrayID(8),
+		ParentID:    parent,
+		Name:        name,
+		StartTime:   xrayTime(now),
+		HTTP:        &xrayHTTP{},
+		Annotations: map[string]string{"message_id": msg.MessageId},
+		Metadata:    map[string]xrayMeta{"sns": {"topic_arn": topicARN}},
+		sampled:     sampled,
+	}
+	seg.HTTP.Request.Method = method
+	seg.HTTP.Request.URL = url
+	return context.WithValue(ctx, xrayKey, seg)
+}
+
+// messageSegment returns the segment begun for msg, or nil.
+func messageSegment(msg *Message) *xraySegment {
+	seg, _ := msg.Context().Value(xrayKey).(*xraySegment)
+	return seg
+}
+
+// end records the delivery's response status and sends the segment.
+func (seg *xraySegment) end(status int) {
+	seg.EndTime = xrayTime(time.Now())
+	seg.HTTP.Response.Status = status
+	switch {
+	case status == http.StatusTooManyRequests:
+		seg.Error, seg.Throttle = true, true
+	case status >= 500:
+		seg.Fault = true
+	case status >= 400:
+		seg.Error = true
+	}
+	if seg.sampled {
+		seg.xr.send(seg)
+	}
+}
+
+// subsegment opens a subsegment of seg named name.
+func (seg *xraySegment) subsegment(name string) *xraySubsegment {
+	return &xraySubsegment{
+		Type:      "subsegment",
+		TraceID:   seg.TraceID,
+		ParentID:  seg.ID,
+		ID:        xrayID(8),
+		Name:      name,
+		StartTime: xrayTime(time.Now()),
+	}
+}
+
+// close ends sub and sends it.
+func (seg *xraySegment) close(sub *xraySubsegment, fault bool) {
+	sub.EndTime = xrayTime(time.Now())
+	sub.Fault = fault
+	if seg.sampled {
+		seg.xr.send(sub)
+	}
+}
+
+// traceCallback runs fn inside a callback subsegment of the message's
+// segment, if it has one.
+func traceCallback(msg *Message, fn func()) {
+	seg := messageSegment(msg)
+	if seg == nil {
+		fn()
+		return
+	}
+	sub := seg.subsegment("callback")
+	defer func() {
+		if p := recover(); p != nil {
+			seg.close(sub, true)
+			panic(p)
+		}
+		seg.close(sub, false)
+	}()
+	fn()
+}
+
+// traceBatch runs fn inside a batch subsegment of the segment of every
+// message in msgs which has one. The subsegments are faults if fn fails.
+func traceBatch(msgs []*Message, fn func() error) error {
+	var segs []*xraySegment
+	var subs []*xraySubsegment
+	for _, msg := range msgs {
+		if seg := messageSegment(msg); seg != nil {
+			segs = append(segs, seg)
+			subs = append(subs, seg.subsegment("batch"))
+		}
+	}
+	err := fn()
+	for i, seg := range segs {
+		seg.close(subs[i], err != nil)
+	}
+	return err
+}
+
+func (x *XRay) send(doc interface{}) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.conn == nil {
+		addr := x.DaemonAddr
+		if addr == "" {
+			addr = os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+		}
+		if addr == "" {
+			addr = "127.0.0.1:2000"
+		}
+		if x.conn, err = net.Dial("udp", addr); err != nil {
+			x.conn = nil
+			return
+		}
+	}
+	x.conn.Write(append([]byte("{\"format\": \"json\", \"version\": 1}\n"), data...))
+}
@@ -0,0 +1,174 @@
+package gosns_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pbnjay/gosns"
+	"github.com/pbnjay/gosns/gosnstest"
+)
+
+const xrayTopic = "arn:aws:sns:us-east-1:123456789012:traced"
+
+// xrayDoc holds the fields of segment and subsegment documents the tests
+// look at.
+type xrayDoc struct {
+	Type     string `json:"type"`
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id"`
+	Name     string `json:"name"`
+	Fault    bool   `json:"fault"`
+	HTTP     struct {
+		Response struct {
+			Status int `json:"status"`
+		} `json:"response"`
+	} `json:"http"`
+}
+
+// xrayDaemon listens like an X-Ray daemon. Each call to next returns the
+// next document received.
+func xrayDaemon(t *testing.T) (string, func() xrayDoc) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String(), func() xrayDoc {
+		t.Helper()
+		buf := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, body, _ := bytes.Cut(buf[:n], []byte("\n"))
+		if string(header) != `{"format": "json", "version": 1}` {
+			t.Fatalf("bad header %q", header)
+		}
+		var doc xrayDoc
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+}
+
+func TestXRaySegmentOnEveryPath(t *testing.T) {
+	addr, next := xrayDaemon(t)
+	s := &gosns.Server{XRay: &gosns.XRay{DaemonAddr: addr}}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+
+	drop := s.AddTopic(xrayTopic, "/drop", func(*gosns.Message) { t.Error("dropped message delivered") })
+	drop.Transformers = []gosns.Transformer{func(context.Context, *gosns.Message) (*gosns.Message, error) {
+		return nil, nil
+	}}
+	fail := s.AddTopic(xrayTopic, "/fail", func(*gosns.Message) { t.Error("failed message delivered") })
+	fail.Transformers = []gosns.Transformer{func(context.Context, *gosns.Message) (*gosns.Message, error) {
+		return nil, errors.New("boom")
+	}}
+	skip := s.AddTopic(xrayTopic, "/skip", func(*gosns.Message) { t.Error("unsampled message delivered") })
+	skip.SampleRate = 0.0000001
+
+	// the busy topic's only worker is held by its first message, once the
+	// worker has started and the message is accepted
+	release := make(chan struct{})
+	defer close(release)
+	busy := s.AddTopic(xrayTopic, "/busy", func(*gosns.Message) { <-release })
+	busy.Workers = 1
+	for status := 0; status != http.StatusOK; {
+		if _, _, err := ts.Notify("/busy", xrayTopic, "", "first"); err != nil {
+			t.Fatal(err)
+		}
+		status = next().HTTP.Response.Status
+	}
+
+	for _, c := range []struct {
+		endpoint string
+		status   int
+		fault    bool
+	}{
+		{"/drop", http.StatusOK, false},
+		{"/fail", http.StatusInternalServerError, true},
+		{"/skip", http.StatusOK, false},
+		{"/busy", http.StatusServiceUnavailable, true},
+	} {
+		resp, _, err := ts.Notify(c.endpoint, xrayTopic, "", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.status {
+			t.Fatalf("%s: status %d, want %d", c.endpoint, resp.StatusCode, c.status)
+		}
+		seg := next()
+		if seg.Type != "" || seg.HTTP.Response.Status != c.status || seg.Fault != c.fault {
+			t.Errorf("%s: got segment %+v", c.endpoint, seg)
+		}
+	}
+}
+
+func TestXRayCallbackSubsegment(t *testing.T) {
+	addr, next := xrayDaemon(t)
+	s := &gosns.Server{XRay: &gosns.XRay{DaemonAddr: addr}}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	s.AddTopic(xrayTopic, "/ok", func(*gosns.Message) {})
+
+	if _, _, err := ts.Notify("/ok", xrayTopic, "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]xrayDoc{}
+	for i := 0; i < 2; i++ {
+		doc := next()
+		docs[doc.Name] = doc
+	}
+	seg, sub := docs["gosns"], docs["callback"]
+	if seg.HTTP.Response.Status != http.StatusOK {
+		t.Errorf("segment status %d", seg.HTTP.Response.Status)
+	}
+	if sub.Type != "subsegment" || sub.ParentID != seg.ID {
+		t.Errorf("subsegment %+v is not a child of %+v", sub, seg)
+	}
+}
+
+func TestXRayBatchSubsegment(t *testing.T) {
+	addr, next := xrayDaemon(t)
+	s := &gosns.Server{XRay: &gosns.XRay{DaemonAddr: addr}}
+	ts := gosnstest.NewServer(s)
+	defer ts.Close()
+	s.AddBatchTopic(xrayTopic, "/batch", 2, time.Hour, func(context.Context, []*gosns.Message) error {
+		return errors.New("boom")
+	})
+
+	segs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		if _, _, err := ts.Notify("/batch", xrayTopic, "", "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two segments, and a failed batch subsegment for each; nothing else
+	var subs []xrayDoc
+	for i := 0; i < 4; i++ {
+		doc := next()
+		switch doc.Name {
+		case "gosns":
+			segs[doc.ID] = true
+		case "batch":
+			subs = append(subs, doc)
+		default:
+			t.Fatalf("unexpected document %+v", doc)
+		}
+	}
+	for _, sub := range subs {
+		if !segs[sub.ParentID] || !sub.Fault {
+			t.Errorf("batch subsegment %+v", sub)
+		}
+	}
+}